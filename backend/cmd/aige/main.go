@@ -0,0 +1,141 @@
+package main
+
+import (
+	"AIGE/config"
+	"AIGE/game_engine"
+	"AIGE/models"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func usage() {
+	fmt.Println("使用方法:")
+	fmt.Println("  aige replay [-mods=../mods] [-v] <trace.json>")
+	fmt.Println("  aige rekey [-dry-run]")
+	fmt.Println("\n子命令:")
+	fmt.Println("  replay  使用记录的AI响应离线回放一个回合，并与记录的判定和状态比对")
+	fmt.Println("  rekey   用当前主密钥重新加密数据库中的API密钥和OAuth密钥（轮换主密钥或迁移明文数据后执行）")
+	fmt.Println("\n追踪文件可通过管理员接口下载: GET /api/admin/game/traces/:id?download=true")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "replay":
+		os.Exit(replay(os.Args[2:]))
+	case "rekey":
+		os.Exit(rekey(os.Args[2:]))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	modsPath := fs.String("mods", defaultModsPath(), "mod目录路径")
+	verbose := fs.Bool("v", false, "输出回放后的完整状态和判定")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		usage()
+		return 2
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Printf("❌ 读取追踪文件失败: %v\n", err)
+		return 1
+	}
+
+	var trace game_engine.TurnTrace
+	if err := json.Unmarshal(data, &trace); err != nil {
+		fmt.Printf("❌ 解析追踪文件失败: %v\n", err)
+		return 1
+	}
+
+	mod, err := game_engine.NewModLoader(*modsPath).LoadMod(trace.ModID)
+	if err != nil {
+		fmt.Printf("❌ 加载mod失败: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("回放回合: 玩家 %s / %s\n", trace.PlayerID, trace.ModID)
+	fmt.Printf("动作: %s\n", trace.Action)
+	if len(trace.Modifiers) > 0 {
+		fmt.Printf("动作修饰器: %v\n", trace.Modifiers)
+	}
+	for i, stage := range trace.Stages {
+		status := "成功"
+		if stage.Error != "" {
+			status = "失败: " + stage.Error
+		}
+		fmt.Printf("  [%d] %s阶段 %s/%s，%d条消息，%s\n", i+1, stage.Stage, stage.Provider, stage.Model, len(stage.Messages), status)
+	}
+	if trace.Error != "" {
+		fmt.Printf("原回合错误: %s\n", trace.Error)
+	}
+
+	result, err := game_engine.ReplayTrace(&trace, mod)
+	if err != nil {
+		fmt.Printf("❌ 回放失败: %v\n", err)
+		return 1
+	}
+
+	if *verbose {
+		out, _ := json.MarshalIndent(map[string]interface{}{
+			"rolls": result.Rolls,
+			"state": result.State,
+		}, "", "  ")
+		fmt.Println(string(out))
+	}
+
+	if result.Matches() {
+		fmt.Println("✅ 回放结果与记录一致")
+		return 0
+	}
+
+	fmt.Println("⚠️ 回放结果与记录不一致:")
+	for _, diff := range result.RollDiffs {
+		fmt.Printf("  - %s\n", diff)
+	}
+	for _, diff := range result.StateDiffs {
+		fmt.Printf("  - %s\n", diff)
+	}
+	return 1
+}
+
+func rekey(args []string) int {
+	fs := flag.NewFlagSet("rekey", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "只统计需要重新加密的条数，不写入数据库")
+	fs.Parse(args)
+
+	config.InitDB()
+	models.AutoMigrate()
+
+	count, err := models.RekeySecrets(*dryRun)
+	if err != nil {
+		fmt.Printf("❌ 重新加密失败（已处理 %d 条）: %v\n", count, err)
+		return 1
+	}
+
+	if *dryRun {
+		fmt.Printf("需要重新加密: %d 条\n", count)
+	} else {
+		fmt.Printf("✅ 已重新加密 %d 条\n", count)
+	}
+	return 0
+}
+
+func defaultModsPath() string {
+	if path := os.Getenv("MODS_PATH"); path != "" {
+		return path
+	}
+	return "../mods"
+}
@@ -11,17 +11,20 @@ import (
 
 var DB *gorm.DB
 
-func InitDB() {
-	// 从环境变量读取数据库路径，如果未设置则使用默认值
-	dbPath := os.Getenv("DATABASE_PATH")
-	if dbPath == "" {
-		// 检测是否在容器中运行
-		if _, err := os.Stat("/app"); err == nil {
-			dbPath = "/app/data/chat.db"
-		} else {
-			dbPath = "chat.db" // 开发环境默认路径
-		}
+// DatabasePath 从环境变量读取数据库路径，如果未设置则使用默认值
+func DatabasePath() string {
+	if dbPath := os.Getenv("DATABASE_PATH"); dbPath != "" {
+		return dbPath
+	}
+	// 检测是否在容器中运行
+	if _, err := os.Stat("/app"); err == nil {
+		return "/app/data/chat.db"
 	}
+	return "chat.db" // 开发环境默认路径
+}
+
+func InitDB() {
+	dbPath := DatabasePath()
 
 	log.Printf("使用数据库路径: %s\n", dbPath)
 
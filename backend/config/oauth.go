@@ -2,78 +2,93 @@ package config
 
 import (
 	"encoding/json"
+	"strings"
 )
 
+// OAuthConfig 单个OAuth提供商的配置，保存在 system_configs 中，键名为 oauth_<提供商>_<字段>。
+// 端点留空时使用提供商的默认值或OIDC发现文档
 type OAuthConfig struct {
-	ClientID     string `json:"client_id"`
-	ClientSecret string `json:"client_secret"`
-	RedirectURL  string `json:"redirect_url"`
-	AuthURL      string `json:"auth_url"`
-	TokenURL     string `json:"token_url"`
-	UserInfoURL  string `json:"user_info_url"`
-	Enabled      bool   `json:"enabled"`
+	Provider           string `json:"provider"`
+	ClientID           string `json:"client_id"`
+	ClientSecret       string `json:"client_secret"` // 只写，查询接口返回前会清空
+	ClientSecretMasked string `json:"client_secret_masked"`
+	RedirectURL        string `json:"redirect_url"`
+	AuthURL            string `json:"auth_url"`
+	TokenURL           string `json:"token_url"`
+	UserInfoURL        string `json:"user_info_url"`
+	IssuerURL          string `json:"issuer_url"` // OIDC签发者地址，用于获取发现文档
+	Scopes             string `json:"scopes"`     // 空格分隔
+	Enabled            bool   `json:"enabled"`
 }
 
-func GetLinuxDoOAuthConfig() (*OAuthConfig, error) {
-	var config OAuthConfig
-	
-	var clientID, clientSecret, redirectURL, authURL, tokenURL, userInfoURL, enabled string
-	
-	err := DB.Model(&SystemConfig{}).Where("key = ?", "oauth_linux_do_client_id").Select("value").Scan(&clientID).Error
-	if err == nil && clientID != "" {
-		config.ClientID = clientID
-	}
-	
-	err = DB.Model(&SystemConfig{}).Where("key = ?", "oauth_linux_do_client_secret").Select("value").Scan(&clientSecret).Error
-	if err == nil && clientSecret != "" {
-		config.ClientSecret = clientSecret
+// OAuthConfigKey 返回提供商配置项的键名，如 oauth_linux_do_client_id
+func OAuthConfigKey(provider, field string) string {
+	return "oauth_" + strings.ReplaceAll(provider, "-", "_") + "_" + field
+}
+
+func GetOAuthConfig(provider string) (*OAuthConfig, error) {
+	fields := []string{"client_id", "client_secret", "redirect_url", "auth_url", "token_url", "user_info_url", "issuer_url", "scopes", "enabled"}
+	keys := make([]string, len(fields))
+	for i, field := range fields {
+		keys[i] = OAuthConfigKey(provider, field)
 	}
-	
-	err = DB.Model(&SystemConfig{}).Where("key = ?", "oauth_linux_do_redirect_url").Select("value").Scan(&redirectURL).Error
-	if err == nil && redirectURL != "" {
-		config.RedirectURL = redirectURL
+
+	var rows []SystemConfig
+	if err := DB.Where("key IN ?", keys).Find(&rows).Error; err != nil {
+		return nil, err
 	}
-	
-	err = DB.Model(&SystemConfig{}).Where("key = ?", "oauth_linux_do_auth_url").Select("value").Scan(&authURL).Error
-	if err == nil && authURL != "" {
-		config.AuthURL = authURL
-	} else {
-		config.AuthURL = "https://connect.linux.do/oauth2/authorize"
+	values := make(map[string]string, len(rows))
+	for _, row := range rows {
+		values[row.Key] = row.Value
 	}
-	
-	err = DB.Model(&SystemConfig{}).Where("key = ?", "oauth_linux_do_token_url").Select("value").Scan(&tokenURL).Error
-	if err == nil && tokenURL != "" {
-		config.TokenURL = tokenURL
-	} else {
-		config.TokenURL = "https://connect.linux.do/oauth2/token"
+	get := func(field string) string {
+		return values[OAuthConfigKey(provider, field)]
 	}
-	
-	err = DB.Model(&SystemConfig{}).Where("key = ?", "oauth_linux_do_user_info_url").Select("value").Scan(&userInfoURL).Error
-	if err == nil && userInfoURL != "" {
-		config.UserInfoURL = userInfoURL
-	} else {
-		config.UserInfoURL = "https://connect.linux.do/api/user"
+
+	config := &OAuthConfig{
+		Provider:    provider,
+		ClientID:    get("client_id"),
+		RedirectURL: get("redirect_url"),
+		AuthURL:     get("auth_url"),
+		TokenURL:    get("token_url"),
+		UserInfoURL: get("user_info_url"),
+		IssuerURL:   get("issuer_url"),
+		Scopes:      get("scopes"),
+		Enabled:     get("enabled") == "true",
 	}
-	
-	err = DB.Model(&SystemConfig{}).Where("key = ?", "oauth_linux_do_enabled").Select("value").Scan(&enabled).Error
-	if err == nil && enabled == "true" {
-		config.Enabled = true
-	} else {
-		config.Enabled = false
+
+	if clientSecret := get("client_secret"); clientSecret != "" {
+		// 加密存储，兼容未加密的旧数据
+		plaintext, err := DecryptSecret(clientSecret)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientSecret = plaintext
+		config.ClientSecretMasked = MaskSecret(plaintext)
 	}
-	
-	return &config, nil
+
+	return config, nil
 }
 
-func SaveLinuxDoOAuthConfig(config *OAuthConfig) error {
+func SaveOAuthConfig(provider string, config *OAuthConfig) error {
 	configs := map[string]string{
-		"oauth_linux_do_client_id":      config.ClientID,
-		"oauth_linux_do_client_secret":  config.ClientSecret,
-		"oauth_linux_do_redirect_url":   config.RedirectURL,
-		"oauth_linux_do_auth_url":       config.AuthURL,
-		"oauth_linux_do_token_url":      config.TokenURL,
-		"oauth_linux_do_user_info_url":  config.UserInfoURL,
-		"oauth_linux_do_enabled":        jsonBool(config.Enabled),
+		OAuthConfigKey(provider, "client_id"):     config.ClientID,
+		OAuthConfigKey(provider, "redirect_url"):  config.RedirectURL,
+		OAuthConfigKey(provider, "auth_url"):      config.AuthURL,
+		OAuthConfigKey(provider, "token_url"):     config.TokenURL,
+		OAuthConfigKey(provider, "user_info_url"): config.UserInfoURL,
+		OAuthConfigKey(provider, "issuer_url"):    config.IssuerURL,
+		OAuthConfigKey(provider, "scopes"):        config.Scopes,
+		OAuthConfigKey(provider, "enabled"):       jsonBool(config.Enabled),
+	}
+
+	// 密钥留空表示不修改
+	if config.ClientSecret != "" {
+		encrypted, err := EncryptSecret(config.ClientSecret)
+		if err != nil {
+			return err
+		}
+		configs[OAuthConfigKey(provider, "client_secret")] = encrypted
 	}
 	
 	for key, value := range configs {
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 主密钥配置：
+//
+//	AIGE_MASTER_KEY      base64编码的32字节密钥，多个密钥用逗号分隔
+//	AIGE_MASTER_KEY_FILE 密钥文件路径，每行一个密钥，默认为数据库目录下的 master.key
+//
+// 第一个密钥用于加密，其余密钥仅用于解密旧数据。轮换时把新密钥放在最前面，
+// 运行 `aige rekey` 重新加密后即可移除旧密钥。
+const (
+	masterKeyEnv     = "AIGE_MASTER_KEY"
+	masterKeyFileEnv = "AIGE_MASTER_KEY_FILE"
+
+	// secretPrefix 加密值格式：enc:v1:<密钥ID>:<被主密钥加密的数据密钥>:<被数据密钥加密的明文>
+	secretPrefix = "enc:v1:"
+)
+
+type masterKey struct {
+	id  string
+	key []byte
+}
+
+type keyring struct {
+	keys []masterKey // 第一个为当前密钥
+}
+
+var (
+	secretKeyring *keyring
+	secretErr     error
+	secretOnce    sync.Once
+)
+
+func activeKeyring() (*keyring, error) {
+	secretOnce.Do(func() {
+		secretKeyring, secretErr = loadKeyring()
+		if secretErr == nil {
+			log.Printf("已加载 %d 个主密钥，当前密钥ID: %s\n", len(secretKeyring.keys), secretKeyring.keys[0].id)
+		}
+	})
+	return secretKeyring, secretErr
+}
+
+func loadKeyring() (*keyring, error) {
+	if value := os.Getenv(masterKeyEnv); value != "" {
+		return parseMasterKeys(strings.ReplaceAll(value, ",", "\n"))
+	}
+
+	path := os.Getenv(masterKeyFileEnv)
+	if path == "" {
+		path = filepath.Join(filepath.Dir(DatabasePath()), "master.key")
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		// 首次启动自动生成密钥文件，丢失后已加密的密钥无法恢复
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		data = []byte(base64.StdEncoding.EncodeToString(key) + "\n")
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, fmt.Errorf("创建主密钥文件失败: %w", err)
+		}
+		log.Printf("⚠️ 未配置主密钥，已生成 %s，请妥善备份\n", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("读取主密钥文件失败: %w", err)
+	}
+
+	return parseMasterKeys(string(data))
+}
+
+// parseMasterKeys 解析每行一个的base64密钥，忽略空行和#注释
+func parseMasterKeys(data string) (*keyring, error) {
+	kr := &keyring{}
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("主密钥必须是base64编码的32字节数据")
+		}
+		sum := sha256.Sum256(key)
+		kr.keys = append(kr.keys, masterKey{id: hex.EncodeToString(sum[:4]), key: key})
+	}
+	if len(kr.keys) == 0 {
+		return nil, fmt.Errorf("未配置主密钥")
+	}
+	return kr, nil
+}
+
+func (kr *keyring) find(id string) *masterKey {
+	for i := range kr.keys {
+		if kr.keys[i].id == id {
+			return &kr.keys[i]
+		}
+	}
+	return nil
+}
+
+// encrypt 为每个值生成独立的数据密钥，数据密钥再由当前主密钥加密
+func (kr *keyring) encrypt(plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(kr.keys[0].key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + kr.keys[0].id + ":" +
+		base64.RawURLEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+func (kr *keyring) decrypt(value string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(value, secretPrefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("加密数据格式错误")
+	}
+	master := kr.find(parts[0])
+	if master == nil {
+		return "", fmt.Errorf("找不到主密钥 %s，请确认密钥配置", parts[0])
+	}
+
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("加密数据格式错误")
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("加密数据格式错误")
+	}
+
+	dataKey, err := open(master.key, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("数据密钥解密失败: %w", err)
+	}
+	plaintext, err := open(dataKey, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("数据解密失败: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// seal 使用AES-256-GCM加密，随机nonce放在密文前面
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("密文过短")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsEncryptedSecret 是否为加密后的值
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// EncryptSecret 使用当前主密钥加密，空字符串保持为空
+func EncryptSecret(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	kr, err := activeKeyring()
+	if err != nil {
+		return "", err
+	}
+	return kr.encrypt(plaintext)
+}
+
+// DecryptSecret 解密，未加密的旧数据原样返回
+func DecryptSecret(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	kr, err := activeKeyring()
+	if err != nil {
+		return "", err
+	}
+	return kr.decrypt(value)
+}
+
+// SecretNeedsRekey 值是明文或不是用当前主密钥加密时返回true
+func SecretNeedsRekey(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	if !IsEncryptedSecret(value) {
+		return true, nil
+	}
+	kr, err := activeKeyring()
+	if err != nil {
+		return false, err
+	}
+	return !strings.HasPrefix(value, secretPrefix+kr.keys[0].id+":"), nil
+}
+
+// MaskSecret 返回用于展示的掩码，只保留首尾少量字符
+func MaskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 12 {
+		return "********"
+	}
+	return secret[:4] + "********" + secret[len(secret)-4:]
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func newTestKey(t *testing.T) string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+
+	oldRing, err := parseMasterKeys(oldKey)
+	if err != nil {
+		t.Fatalf("parseMasterKeys failed: %v", err)
+	}
+	encrypted, err := oldRing.encrypt("sk-test-secret")
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	if !IsEncryptedSecret(encrypted) || strings.Contains(encrypted, "sk-test") {
+		t.Fatalf("Unexpected ciphertext: %s", encrypted)
+	}
+
+	// 新密钥在前，旧密钥仍可解密
+	rotated, err := parseMasterKeys("# 当前密钥\n" + newKey + "\n" + oldKey + "\n")
+	if err != nil {
+		t.Fatalf("parseMasterKeys failed: %v", err)
+	}
+	if plaintext, err := rotated.decrypt(encrypted); err != nil || plaintext != "sk-test-secret" {
+		t.Errorf("Expected old ciphertext to decrypt after rotation, got %q, %v", plaintext, err)
+	}
+	reencrypted, _ := rotated.encrypt("sk-test-secret")
+	if !strings.HasPrefix(reencrypted, secretPrefix+rotated.keys[0].id+":") {
+		t.Errorf("Expected new ciphertext to use the current key: %s", reencrypted)
+	}
+
+	// 移除旧密钥后无法解密旧数据
+	newRing, _ := parseMasterKeys(newKey)
+	if _, err := newRing.decrypt(encrypted); err == nil {
+		t.Errorf("Expected decrypt with unknown key to fail")
+	}
+
+	if _, err := parseMasterKeys("not-a-key"); err == nil {
+		t.Errorf("Expected invalid key to be rejected")
+	}
+	if MaskSecret("sk-1234567890abcdef") != "sk-1********cdef" || MaskSecret("short") != "********" {
+		t.Errorf("Unexpected mask: %s", MaskSecret("sk-1234567890abcdef"))
+	}
+}
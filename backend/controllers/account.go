@@ -0,0 +1,332 @@
+package controllers
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"AIGE/services"
+	"AIGE/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"` // 仅第三方登录、未设置密码的用户可留空
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// appBaseURL 邮件中链接指向的前端地址，通过 APP_BASE_URL 配置
+func appBaseURL() string {
+	if base := os.Getenv("APP_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return "http://localhost:8000"
+}
+
+func sendVerificationEmail(user *models.User, email string) error {
+	token, err := utils.IssueAccountToken(user.ID, utils.PurposeVerifyEmail, email, utils.VerifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	link := fmt.Sprintf("%s/verify-email?token=%s", appBaseURL(), url.QueryEscape(token))
+	return services.DefaultMailer().Send(services.Mail{
+		To:      email,
+		Subject: "AIGE 邮箱验证",
+		Body: fmt.Sprintf("%s，你好：\n\n请在24小时内打开以下链接完成邮箱验证：\n%s\n\n如果这不是你的操作，请忽略本邮件。\n",
+			user.Username, link),
+	})
+}
+
+func sendPasswordResetEmail(user *models.User) error {
+	token, err := utils.IssueAccountToken(user.ID, utils.PurposeResetPassword, user.Email, utils.ResetPasswordTTL)
+	if err != nil {
+		return err
+	}
+	link := fmt.Sprintf("%s/reset-password?token=%s", appBaseURL(), url.QueryEscape(token))
+	return services.DefaultMailer().Send(services.Mail{
+		To:      user.Email,
+		Subject: "AIGE 重置密码",
+		Body: fmt.Sprintf("%s，你好：\n\n请在1小时内打开以下链接重置密码，链接只能使用一次：\n%s\n\n如果这不是你的操作，请忽略本邮件，你的密码不会改变。\n",
+			user.Username, link),
+	})
+}
+
+// checkCurrentPassword 敏感操作前确认当前密码，未设置密码的第三方登录用户跳过
+func checkCurrentPassword(user *models.User, password string) bool {
+	return user.Password == "" || utils.CheckPassword(password, user.Password)
+}
+
+func currentUser(c *gin.Context) (*models.User, bool) {
+	userID, _ := c.Get("user_id")
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// ForgotPassword 发送重置密码邮件。无论邮箱是否存在都返回相同结果，避免泄露注册信息
+func ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	var user models.User
+	if err := config.DB.Where("email = ?", req.Email).First(&user).Error; err == nil {
+		if err := sendPasswordResetEmail(&user); err != nil {
+			log.Printf("发送重置密码邮件失败: %v\n", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "如果该邮箱已注册，重置密码邮件已发送"})
+}
+
+// ResetPassword 使用邮件中的令牌设置新密码，成功后所有登录失效
+func ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	token, err := utils.ConsumeAccountToken(req.Token, utils.PurposeResetPassword)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "密码加密失败"})
+		return
+	}
+
+	// 能收到重置邮件说明邮箱可用
+	if err := config.DB.Model(&models.User{}).Where("id = ?", token.UserID).
+		Updates(map[string]interface{}{"password": hashedPassword, "email_verified": true}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "密码更新失败"})
+		return
+	}
+	utils.RevokeUserTokens(token.UserID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "密码已重置，请重新登录"})
+}
+
+// VerifyEmail 使用邮件中的令牌完成邮箱验证，修改邮箱时在此刻才生效
+func VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	token, err := utils.ConsumeAccountToken(req.Token, utils.PurposeVerifyEmail)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing models.User
+	if err := config.DB.Where("email = ? AND id != ?", token.Email, token.UserID).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "邮箱已被其他账号使用"})
+		return
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, token.UserID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.ErrInvalidAccountToken.Error()})
+		return
+	}
+	oldEmail := user.Email
+	if err := config.DB.Model(&user).
+		Updates(map[string]interface{}{"email": token.Email, "email_verified": true}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "邮箱验证失败"})
+		return
+	}
+
+	// 修改邮箱在验证时生效，令牌证明了操作者就是该用户
+	if oldEmail != token.Email {
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		recordAudit(c, services.AuditAccountEmail, "user", user.ID, services.AuditDiff(
+			map[string]interface{}{"email": oldEmail},
+			map[string]interface{}{"email": token.Email},
+		), "")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "邮箱验证成功", "email": token.Email})
+}
+
+// ResendVerification 重新发送当前邮箱的验证邮件
+func ResendVerification(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "邮箱已验证"})
+		return
+	}
+
+	if err := sendVerificationEmail(user, user.Email); err != nil {
+		log.Printf("发送验证邮件失败: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "验证邮件发送失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "验证邮件已发送"})
+}
+
+// ChangePassword 修改自己的密码，其他设备上的登录全部失效，当前设备返回新令牌
+func ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "新密码至少6位"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if !checkCurrentPassword(user, req.CurrentPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "当前密码错误"})
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "密码加密失败"})
+		return
+	}
+	if err := config.DB.Model(user).Update("password", hashedPassword).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "密码更新失败"})
+		return
+	}
+
+	utils.RevokeUserTokens(user.ID)
+	recordAudit(c, services.AuditAccountPassword, "user", user.ID, nil, "")
+	if err := config.DB.First(user, user.ID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	respondWithTokens(c, http.StatusOK, user)
+}
+
+// ChangeEmail 向新邮箱发送验证邮件，验证通过后才替换当前邮箱
+func ChangeEmail(c *gin.Context) {
+	var req ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的邮箱地址"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if !checkCurrentPassword(user, req.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密码错误"})
+		return
+	}
+	if req.Email == user.Email && user.EmailVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "新邮箱与当前邮箱相同"})
+		return
+	}
+
+	var existing models.User
+	if err := config.DB.Where("email = ? AND id != ?", req.Email, user.ID).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "邮箱已存在"})
+		return
+	}
+
+	if err := sendVerificationEmail(user, req.Email); err != nil {
+		log.Printf("发送验证邮件失败: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "验证邮件发送失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "验证邮件已发送到新邮箱，验证后生效"})
+}
+
+// DeleteAccount 注销自己的账号，同时删除存档和登录信息
+func DeleteAccount(c *gin.Context) {
+	var req DeleteAccountRequest
+	c.ShouldBindJSON(&req)
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if !checkCurrentPassword(user, req.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密码错误"})
+		return
+	}
+
+	if isLastAdmin(user) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能注销唯一的管理员账号"})
+		return
+	}
+
+	if err := deleteUserAccount(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "账号注销失败"})
+		return
+	}
+	recordAudit(c, services.AuditAccountDelete, "user", user.ID, nil, user.Username)
+	c.JSON(http.StatusOK, gin.H{"message": "账号已注销"})
+}
+
+// isLastAdmin 用户是否为唯一的管理员，删除后将无人拥有全部权限
+func isLastAdmin(user *models.User) bool {
+	if !user.IsAdmin {
+		return false
+	}
+	var admins int64
+	config.DB.Model(&models.User{}).Where("is_admin = ?", true).Count(&admins)
+	return admins <= 1
+}
+
+// deleteUserAccount 物理删除用户及其游戏存档、登录令牌、关联身份和角色
+func deleteUserAccount(user *models.User) error {
+	InitGameEngine()
+	if err := stateManager.DeletePlayerSessions(fmt.Sprintf("%d", user.ID)); err != nil {
+		return err
+	}
+
+	if err := config.DB.Unscoped().Delete(user).Error; err != nil {
+		return err
+	}
+	config.DB.Where("user_id = ?", user.ID).Delete(&models.RefreshToken{})
+	config.DB.Where("user_id = ?", user.ID).Delete(&models.UserIdentity{})
+	config.DB.Where("user_id = ?", user.ID).Delete(&models.AccountToken{})
+	config.DB.Where("user_id = ?", user.ID).Delete(&models.UserRole{})
+	return nil
+}
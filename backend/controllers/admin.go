@@ -3,6 +3,7 @@ package controllers
 import (
 	"AIGE/config"
 	"AIGE/models"
+	"AIGE/services"
 	"AIGE/utils"
 	"net/http"
 	"strconv"
@@ -22,13 +23,8 @@ func GetUsers(c *gin.Context) {
 	}
 
 	var userList []UserInfo
-	for _, user := range users {
-		userList = append(userList, UserInfo{
-			ID:       user.ID,
-			Username: user.Username,
-			Email:    user.Email,
-			IsAdmin:  user.IsAdmin,
-		})
+	for i := range users {
+		userList = append(userList, newUserInfo(&users[i]))
 	}
 
 	c.JSON(http.StatusOK, gin.H{"users": userList})
@@ -47,12 +43,7 @@ func GetUser(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, UserInfo{
-		ID:       user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		IsAdmin:  user.IsAdmin,
-	})
+	c.JSON(http.StatusOK, newUserInfo(&user))
 }
 
 func UpdateUserPassword(c *gin.Context) {
@@ -74,6 +65,11 @@ func UpdateUserPassword(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
 		return
 	}
+	// 重置密码后即可登录该账号，不能对权限高于自己的用户操作
+	if !canManageUser(c, &user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能重置权限高于自己的用户的密码"})
+		return
+	}
 
 	// 加密新密码
 	hashedPassword, err := utils.HashPassword(req.NewPassword)
@@ -88,6 +84,10 @@ func UpdateUserPassword(c *gin.Context) {
 		return
 	}
 
+	// 修改密码后旧的登录全部失效
+	utils.RevokeUserTokens(user.ID)
+	recordAudit(c, services.AuditUserPassword, "user", user.ID, nil, user.Username)
+
 	c.JSON(http.StatusOK, gin.H{"message": "密码更新成功"})
 }
 
@@ -111,35 +111,60 @@ func DeleteUser(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
 		return
 	}
+	if !canManageUser(c, &user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能删除权限高于自己的用户"})
+		return
+	}
+	if isLastAdmin(&user) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能删除唯一的管理员账号"})
+		return
+	}
 
-	// 删除用户（物理删除）
-	if err := config.DB.Unscoped().Delete(&user).Error; err != nil {
+	// 删除用户（物理删除），同时删除存档
+	if err := deleteUserAccount(&user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "用户删除失败"})
 		return
 	}
+	recordAudit(c, services.AuditUserDelete, "user", user.ID, services.AuditDiff(map[string]interface{}{
+		"username": user.Username,
+		"email":    user.Email,
+		"is_admin": user.IsAdmin,
+	}, nil), "")
 
 	c.JSON(http.StatusOK, gin.H{"message": "用户删除成功"})
 }
 
-func ToggleUserAdmin(c *gin.Context) {
+// SetUserCheatPermission 授予或撤销用户使用作弊类动作修饰器的权限
+func SetUserCheatPermission(c *gin.Context) {
 	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
 		return
 	}
 
-	// 查找用户
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
 	var user models.User
 	if err := config.DB.First(&user, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
 		return
 	}
 
-	// 切换管理员状态
-	if err := config.DB.Model(&user).Update("is_admin", !user.IsAdmin).Error; err != nil {
+	before := user.CanUseCheats
+	if err := config.DB.Model(&user).Update("can_use_cheats", req.Enabled).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "权限更新失败"})
 		return
 	}
+	recordAudit(c, services.AuditUserCheatPerm, "user", user.ID, services.AuditDiff(
+		map[string]interface{}{"can_use_cheats": before},
+		map[string]interface{}{"can_use_cheats": req.Enabled},
+	), user.Username)
 
 	c.JSON(http.StatusOK, gin.H{"message": "权限更新成功"})
 }
\ No newline at end of file
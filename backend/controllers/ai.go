@@ -4,7 +4,6 @@ import (
 	"AIGE/config"
 	"AIGE/models"
 	"AIGE/services"
-	"bufio"
 	"io"
 	"net/http"
 
@@ -30,7 +29,7 @@ func ChatWithAI(c *gin.Context) {
 		return
 	}
 
-	if provider.APIKey == "" {
+	if provider.APIKey == "" && services.RequiresAPIKey(provider.Type) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider API key not configured"})
 		return
 	}
@@ -48,77 +47,64 @@ func ChatWithAI(c *gin.Context) {
 		apiType = provider.Type
 	}
 
+	// 未知类型按OpenAI兼容接口处理
+	if !services.IsSupportedAPIType(apiType) {
+		apiType = "openai"
+	}
+
 	baseURL := provider.BaseURL
 	if baseURL == "" {
-		switch apiType {
-		case "openai":
-			baseURL = "https://api.openai.com/v1"
-		case "anthropic":
-			baseURL = "https://api.anthropic.com/v1"
-		case "google":
-			baseURL = "https://generativelanguage.googleapis.com/v1beta"
-		}
+		baseURL = services.DefaultBaseURL(apiType)
 	}
 
-	var result interface{}
-	var err error
-
-	switch apiType {
-	case "openai":
-		result, err = aiClient.CallOpenAI(baseURL, provider.APIKey, model.ModelID, request.Messages, request.Stream)
-	case "anthropic":
-		result, err = aiClient.CallAnthropic(baseURL, provider.APIKey, model.ModelID, request.Messages, request.Stream)
-	case "google":
-		result, err = aiClient.CallGoogle(baseURL, provider.APIKey, model.ModelID, request.Messages, request.Stream)
-	default:
-		result, err = aiClient.CallOpenAI(baseURL, provider.APIKey, model.ModelID, request.Messages, request.Stream)
-	}
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	chatRequest := services.ChatRequest{
+		APIType:  apiType,
+		BaseURL:  baseURL,
+		APIKey:   provider.APIKey,
+		Model:    model.ModelID,
+		Messages: request.Messages,
 	}
 
 	if request.Stream {
-		body, ok := result.(io.ReadCloser)
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid stream response"})
+		stream, err := aiClient.ChatStream(chatRequest)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		defer body.Close()
+		defer stream.Close()
 
 		c.Header("Content-Type", "text/event-stream")
 		c.Header("Cache-Control", "no-cache")
 		c.Header("Connection", "keep-alive")
 
-		scanner := bufio.NewScanner(body)
-		buf := make([]byte, 0, 128*1024)
-		scanner.Buffer(buf, 2*1024*1024) // 2MB最大token大小
-		
-		for scanner.Scan() {
-			line := scanner.Text()
-			if line == "" {
-				continue
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				break
 			}
-
-			chunk := aiClient.ParseStreamChunk(apiType, line)
-			if chunk != nil {
-				c.SSEvent("message", chunk)
+			if err != nil {
+				c.SSEvent("error", gin.H{"error": err.Error()})
 				c.Writer.Flush()
-
-				if done, ok := chunk["done"].(bool); ok && done {
-					break
-				}
+				break
 			}
-		}
-		
-		if err := scanner.Err(); err != nil {
-			c.SSEvent("error", gin.H{"error": err.Error()})
+
+			c.SSEvent("message", chunk)
 			c.Writer.Flush()
+
+			if chunk.Done {
+				break
+			}
 		}
-	} else {
-		c.JSON(http.StatusOK, result)
+		return
 	}
+
+	result, err := aiClient.Chat(chatRequest)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
 }
 
 func TestModelConnection(c *gin.Context) {
@@ -139,7 +125,7 @@ func TestModelConnection(c *gin.Context) {
 		return
 	}
 
-	if provider.APIKey == "" {
+	if provider.APIKey == "" && services.RequiresAPIKey(provider.Type) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider API key not configured"})
 		return
 	}
@@ -149,16 +135,14 @@ func TestModelConnection(c *gin.Context) {
 		apiType = provider.Type
 	}
 
+	// 未知类型按OpenAI兼容接口处理
+	if !services.IsSupportedAPIType(apiType) {
+		apiType = "openai"
+	}
+
 	baseURL := provider.BaseURL
 	if baseURL == "" {
-		switch apiType {
-		case "openai":
-			baseURL = "https://api.openai.com/v1"
-		case "anthropic":
-			baseURL = "https://api.anthropic.com/v1"
-		case "google":
-			baseURL = "https://generativelanguage.googleapis.com/v1beta"
-		}
+		baseURL = services.DefaultBaseURL(apiType)
 	}
 
 	testMessages := []services.Message{
@@ -170,20 +154,13 @@ func TestModelConnection(c *gin.Context) {
 
 	aiClient := services.NewAIClient()
 
-	var result interface{}
-	var err error
-
-	switch apiType {
-	case "openai":
-		result, err = aiClient.CallOpenAI(baseURL, provider.APIKey, request.ModelID, testMessages, false)
-	case "anthropic":
-		result, err = aiClient.CallAnthropic(baseURL, provider.APIKey, request.ModelID, testMessages, false)
-	case "google":
-		result, err = aiClient.CallGoogle(baseURL, provider.APIKey, request.ModelID, testMessages, false)
-	default:
-		result, err = aiClient.CallOpenAI(baseURL, provider.APIKey, request.ModelID, testMessages, false)
-	}
-
+	result, err := aiClient.Chat(services.ChatRequest{
+		APIType:  apiType,
+		BaseURL:  baseURL,
+		APIKey:   provider.APIKey,
+		Model:    request.ModelID,
+		Messages: testMessages,
+	})
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
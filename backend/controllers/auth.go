@@ -4,6 +4,7 @@ import (
 	"AIGE/config"
 	"AIGE/models"
 	"AIGE/utils"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -21,15 +22,24 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token   string `json:"token"`
-	User    UserInfo `json:"user"`
+	utils.TokenPair
+	User UserInfo `json:"user"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
 type UserInfo struct {
-	ID       uint   `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	IsAdmin  bool   `json:"is_admin"`
+	ID            uint     `json:"id"`
+	Username      string   `json:"username"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	HasPassword   bool     `json:"has_password"` // 仅第三方登录的用户没有密码
+	IsAdmin       bool     `json:"is_admin"`
+	CanUseCheats  bool     `json:"can_use_cheats"`
+	Roles         []string `json:"roles"`
+	Permissions   []string `json:"permissions"` // 角色权限合集，前端据此显示后台菜单
 }
 
 func Register(c *gin.Context) {
@@ -72,22 +82,12 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// 生成token
-	token, err := utils.GenerateJWT(user.ID, user.Username, user.IsAdmin)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "token生成失败"})
-		return
+	// 验证邮件发送失败不影响注册，用户可稍后重新发送
+	if err := sendVerificationEmail(&user, user.Email); err != nil {
+		log.Printf("发送验证邮件失败: %v\n", err)
 	}
 
-	c.JSON(http.StatusCreated, LoginResponse{
-		Token: token,
-		User: UserInfo{
-			ID:       user.ID,
-			Username: user.Username,
-			Email:    user.Email,
-			IsAdmin:  user.IsAdmin,
-		},
-	})
+	respondWithTokens(c, http.StatusCreated, &user)
 }
 
 func Login(c *gin.Context) {
@@ -110,22 +110,78 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	// 生成token
-	token, err := utils.GenerateJWT(user.ID, user.Username, user.IsAdmin)
+	respondWithTokens(c, http.StatusOK, &user)
+}
+
+// RefreshToken 使用刷新令牌换取新的令牌对，旧的刷新令牌随即失效
+func RefreshToken(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	user, pair, err := utils.RotateRefreshToken(req.RefreshToken, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已过期，请重新登录"})
+		return
+	}
+
+	c.JSON(http.StatusOK, LoginResponse{TokenPair: *pair, User: newUserInfo(user)})
+}
+
+// Logout 吊销刷新令牌；all 为 true 时吊销该用户在所有设备上的登录
+func Logout(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+		All          bool   `json:"all"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	// 令牌无效时同样视为已退出
+	utils.RevokeRefreshToken(req.RefreshToken, req.All)
+	c.JSON(http.StatusOK, gin.H{"message": "已退出登录"})
+}
+
+// respondWithTokens 签发令牌并返回登录响应
+func respondWithTokens(c *gin.Context, status int, user *models.User) {
+	pair, err := utils.IssueTokens(user, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "token生成失败"})
 		return
 	}
 
-	c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User: UserInfo{
-			ID:       user.ID,
-			Username: user.Username,
-			Email:    user.Email,
-			IsAdmin:  user.IsAdmin,
-		},
-	})
+	c.JSON(status, LoginResponse{TokenPair: *pair, User: newUserInfo(user)})
+}
+
+func newUserInfo(user *models.User) UserInfo {
+	info := UserInfo{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		HasPassword:   user.Password != "",
+		IsAdmin:       user.IsAdmin,
+		CanUseCheats:  user.CanUseCheats,
+		Roles:         []string{},
+		Permissions:   []string{},
+	}
+
+	roles, _ := models.UserRoles(user)
+	seen := make(map[string]bool)
+	for _, role := range roles {
+		info.Roles = append(info.Roles, role.Name)
+		for _, perm := range role.Permissions {
+			if !seen[perm] {
+				seen[perm] = true
+				info.Permissions = append(info.Permissions, perm)
+			}
+		}
+	}
+	return info
 }
 
 func GetProfile(c *gin.Context) {
@@ -137,10 +193,5 @@ func GetProfile(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, UserInfo{
-		ID:       user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		IsAdmin:  user.IsAdmin,
-	})
+	c.JSON(http.StatusOK, newUserInfo(&user))
 }
\ No newline at end of file
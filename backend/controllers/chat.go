@@ -3,6 +3,8 @@ package controllers
 import (
 	"AIGE/config"
 	"AIGE/models"
+	"AIGE/services"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -189,14 +191,65 @@ func UpdateChat(c *gin.Context) {
 		updates["display_history"] = updateData.DisplayHistory
 	}
 
+	before := map[string]interface{}{
+		"state":              gameSave.State,
+		"recent_history":     gameSave.RecentHistory,
+		"compressed_summary": gameSave.CompressedSummary,
+		"display_history":    gameSave.DisplayHistory,
+	}
+	for key := range before {
+		if _, ok := updates[key]; !ok {
+			delete(before, key)
+		}
+	}
+
 	if err := config.DB.Model(&gameSave).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新聊天记录失败"})
 		return
 	}
+	recordAudit(c, services.AuditChatUpdate, "game_save", gameSave.ID, services.AuditDiff(before, updates),
+		fmt.Sprintf("user_id=%d mod_id=%s", gameSave.UserID, gameSave.ModID))
 
 	c.JSON(http.StatusOK, gin.H{"message": "聊天记录更新成功"})
 }
 
+// SetChatDebugSession 开启或关闭存档的沙盒调试模式
+func SetChatDebugSession(c *gin.Context) {
+	InitGameEngine()
+
+	chatID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的聊天记录ID"})
+		return
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	var gameSave models.GameSave
+	if err := config.DB.First(&gameSave, chatID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "聊天记录不存在"})
+		return
+	}
+
+	playerID := strconv.FormatUint(uint64(gameSave.UserID), 10)
+	if err := stateManager.SetDebugSession(playerID, gameSave.ModID, req.Enabled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新调试模式失败"})
+		return
+	}
+	recordAudit(c, services.AuditChatDebug, "game_save", gameSave.ID, services.AuditDiff(
+		map[string]interface{}{"debug_session": gameSave.DebugSession},
+		map[string]interface{}{"debug_session": req.Enabled},
+	), fmt.Sprintf("user_id=%d mod_id=%s", gameSave.UserID, gameSave.ModID))
+
+	c.JSON(http.StatusOK, gin.H{"message": "调试模式已更新", "debug_session": req.Enabled})
+}
+
 // DeleteChat 删除聊天记录
 func DeleteChat(c *gin.Context) {
 	chatID, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -216,6 +269,8 @@ func DeleteChat(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除聊天记录失败"})
 		return
 	}
+	recordAudit(c, services.AuditChatDelete, "game_save", gameSave.ID, nil,
+		fmt.Sprintf("user_id=%d mod_id=%s", gameSave.UserID, gameSave.ModID))
 
 	c.JSON(http.StatusOK, gin.H{"message": "聊天记录删除成功"})
 }
@@ -236,10 +291,13 @@ func DeleteUserChats(c *gin.Context) {
 	}
 
 	// 删除该用户的所有聊天记录
-	if err := config.DB.Where("user_id = ?", userID).Delete(&models.GameSave{}).Error; err != nil {
+	result := config.DB.Where("user_id = ?", userID).Delete(&models.GameSave{})
+	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除聊天记录失败"})
 		return
 	}
+	recordAudit(c, services.AuditChatDeleteAll, "user", user.ID, nil,
+		fmt.Sprintf("%s 的 %d 条聊天记录", user.Username, result.RowsAffected))
 
 	c.JSON(http.StatusOK, gin.H{"message": "用户聊天记录已全部删除"})
 }
@@ -3,11 +3,40 @@ package controllers
 import (
 	"AIGE/config"
 	"AIGE/models"
+	"AIGE/services"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// isSecretConfigKey 密钥类配置，只能通过对应的专用接口加密保存
+func isSecretConfigKey(key string) bool {
+	return strings.Contains(key, "secret") || strings.Contains(key, "api_key")
+}
+
+// auditConfigValue 审计日志中记录的配置值，密钥类配置只记录掩码
+func auditConfigValue(key, value string) string {
+	if isSecretConfigKey(key) {
+		return config.MaskSecret(value)
+	}
+	return value
+}
+
+// displayConfigValue 查询接口返回的配置值，密钥类配置解密后只返回掩码
+func displayConfigValue(key, value string) string {
+	if !isSecretConfigKey(key) {
+		return value
+	}
+	plaintext, err := config.DecryptSecret(value)
+	if err != nil {
+		return config.MaskSecret(value)
+	}
+	return config.MaskSecret(plaintext)
+}
+
+const secretConfigError = "密钥类配置不能通过通用接口修改，请使用OAuth配置接口"
+
 // GetSystemConfig 获取系统配置
 func GetSystemConfig(c *gin.Context) {
 	key := c.Query("key")
@@ -21,6 +50,7 @@ func GetSystemConfig(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "配置不存在"})
 		return
 	}
+	conf.Value = displayConfigValue(conf.Key, conf.Value)
 
 	c.JSON(http.StatusOK, conf)
 }
@@ -36,7 +66,7 @@ func GetAllSystemConfigs(c *gin.Context) {
 	// 转换为map格式方便前端使用
 	configMap := make(map[string]string)
 	for _, conf := range configs {
-		configMap[conf.Key] = conf.Value
+		configMap[conf.Key] = displayConfigValue(conf.Key, conf.Value)
 	}
 
 	c.JSON(http.StatusOK, configMap)
@@ -53,11 +83,19 @@ func SetSystemConfig(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
 		return
 	}
+	if isSecretConfigKey(req.Key) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": secretConfigError})
+		return
+	}
 
 	// 查找或创建配置
 	var conf models.SystemConfig
 	result := config.DB.Where("key = ?", req.Key).First(&conf)
-	
+	before := map[string]interface{}{}
+	if result.Error == nil {
+		before[req.Key] = auditConfigValue(req.Key, conf.Value)
+	}
+
 	if result.Error != nil {
 		// 不存在，创建新配置
 		conf = models.SystemConfig{
@@ -76,6 +114,9 @@ func SetSystemConfig(c *gin.Context) {
 			return
 		}
 	}
+	recordAudit(c, services.AuditConfigSet, "config", req.Key, services.AuditDiff(before, map[string]interface{}{
+		req.Key: auditConfigValue(req.Key, req.Value),
+	}), "")
 
 	c.JSON(http.StatusOK, conf)
 }
@@ -88,12 +129,24 @@ func BatchSetSystemConfig(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
 		return
 	}
+	for key := range req {
+		if isSecretConfigKey(key) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": secretConfigError})
+			return
+		}
+	}
 
 	// 批量更新配置
+	before := map[string]interface{}{}
+	after := map[string]interface{}{}
 	for key, value := range req {
 		var conf models.SystemConfig
 		result := config.DB.Where("key = ?", key).First(&conf)
-		
+		if result.Error == nil {
+			before[key] = auditConfigValue(key, conf.Value)
+		}
+		after[key] = auditConfigValue(key, value)
+
 		if result.Error != nil {
 			// 不存在，创建新配置
 			conf = models.SystemConfig{
@@ -108,5 +161,7 @@ func BatchSetSystemConfig(c *gin.Context) {
 		}
 	}
 
+	recordAudit(c, services.AuditConfigSet, "config", "batch", services.AuditDiff(before, after), "")
+
 	c.JSON(http.StatusOK, gin.H{"message": "配置更新成功"})
 }
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSecretConfigKeysNeedDedicatedEndpoint(t *testing.T) {
+	setupRoleTest(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	secretKey := config.OAuthConfigKey("github", "client_secret")
+
+	if code := callAs(SetSystemConfig, admin, nil, map[string]string{"key": secretKey, "value": "plaintext-secret"}); code != http.StatusBadRequest {
+		t.Errorf("Expected secret key to be rejected, got %d", code)
+	}
+	if code := callAs(BatchSetSystemConfig, admin, nil, map[string]string{"game_model_id": "1", secretKey: "plaintext-secret"}); code != http.StatusBadRequest {
+		t.Errorf("Expected batch with secret key to be rejected, got %d", code)
+	}
+	var count int64
+	config.DB.Model(&models.SystemConfig{}).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected rejected requests to write nothing, got %d configs", count)
+	}
+
+	// 旧版本写入的明文密钥只返回掩码
+	config.DB.Create(&models.SystemConfig{Key: secretKey, Value: "legacy-plaintext-secret"})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	GetAllSystemConfigs(c)
+	var configs map[string]string
+	json.Unmarshal(w.Body.Bytes(), &configs)
+	if configs[secretKey] != config.MaskSecret("legacy-plaintext-secret") {
+		t.Errorf("Expected secret to be masked, got %q", configs[secretKey])
+	}
+}
@@ -0,0 +1,310 @@
+package controllers
+
+import (
+	"AIGE/game_engine"
+	"AIGE/services"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 实体清理：设置 ENTITY_CLEANUP_DAYS 后每天清理一次超过该天数未更新的实体，未设置或为0时不做定时清理。
+// 实体的更新时间不区分存档是否仍在游玩，暂停的存档同样会被清理，因此默认关闭
+const (
+	entityCleanupInterval    = 24 * time.Hour
+	defaultEntityCleanupDays = 30 // 手动清理未指定天数时使用
+)
+
+// entityCleanupDays 定时清理的实体保留天数，0 表示关闭
+func entityCleanupDays() int {
+	if value := os.Getenv("ENTITY_CLEANUP_DAYS"); value != "" {
+		if days, err := strconv.Atoi(value); err == nil {
+			return days
+		}
+	}
+	return 0
+}
+
+// loadEntityRegistry 载入会话，保证实体注册表已从存档恢复
+func loadEntityRegistry(c *gin.Context, playerID, modID string) bool {
+	if modID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少mod_id参数"})
+		return false
+	}
+	if _, err := stateManager.GetSession(playerID, modID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在"})
+		return false
+	}
+	return true
+}
+
+// listEntities 返回注册表中的实体和关系，可按 type 过滤
+func listEntities(c *gin.Context, playerID, modID string) {
+	entities, relations := stateManager.GetEntityManager().ListEntities(playerID, modID, game_engine.EntityType(c.Query("type")))
+	c.JSON(http.StatusOK, gin.H{
+		"entities":  entities,
+		"relations": relations,
+	})
+}
+
+// getEntity 返回单个实体及其直接关系
+func getEntity(c *gin.Context, playerID, modID string) {
+	entity, relations, err := stateManager.GetEntityManager().EntitySnapshot(playerID, modID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "实体不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"entity":    entity,
+		"relations": relations,
+	})
+}
+
+// setEntityLocked 锁定或解锁实体并写回存档，返回锁定前的状态
+func setEntityLocked(c *gin.Context, playerID, modID string, locked bool) (bool, bool) {
+	entityManager := stateManager.GetEntityManager()
+	entity, _, err := entityManager.EntitySnapshot(playerID, modID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "实体不存在"})
+		return false, false
+	}
+	if err := entityManager.SetEntityLocked(playerID, modID, entity.ID, locked); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "实体不存在"})
+		return false, false
+	}
+	if err := stateManager.SaveEntityRegistry(playerID, modID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存实体失败"})
+		return false, false
+	}
+	return entity.Locked, true
+}
+
+// GetMyEntities 获取当前玩家存档中的实体（只读）
+func GetMyEntities(c *gin.Context) {
+	InitGameEngine()
+
+	playerID := fmt.Sprintf("%v", c.GetUint("user_id"))
+	modID := c.Query("mod_id")
+	if !loadEntityRegistry(c, playerID, modID) {
+		return
+	}
+	listEntities(c, playerID, modID)
+}
+
+// GetMyEntity 获取当前玩家存档中的单个实体
+func GetMyEntity(c *gin.Context) {
+	InitGameEngine()
+
+	playerID := fmt.Sprintf("%v", c.GetUint("user_id"))
+	modID := c.Query("mod_id")
+	if !loadEntityRegistry(c, playerID, modID) {
+		return
+	}
+	getEntity(c, playerID, modID)
+}
+
+// SetMyEntityLocked 玩家锁定重要实体（如师父、仇人），锁定后AI不能修改其设定
+func SetMyEntityLocked(c *gin.Context) {
+	InitGameEngine()
+
+	var req struct {
+		ModID  string `json:"mod_id" binding:"required"`
+		Locked bool   `json:"locked"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	playerID := fmt.Sprintf("%v", c.GetUint("user_id"))
+	if !loadEntityRegistry(c, playerID, req.ModID) {
+		return
+	}
+	if _, ok := setEntityLocked(c, playerID, req.ModID, req.Locked); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "实体已更新", "locked": req.Locked})
+}
+
+// AdminGetEntities 获取指定玩家存档中的实体（管理员接口，需要 user_id 和 mod_id 参数）
+func AdminGetEntities(c *gin.Context) {
+	InitGameEngine()
+
+	playerID := c.Query("user_id")
+	modID := c.Query("mod_id")
+	if playerID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少user_id参数"})
+		return
+	}
+	if !loadEntityRegistry(c, playerID, modID) {
+		return
+	}
+	listEntities(c, playerID, modID)
+}
+
+// AdminGetEntity 获取指定玩家存档中的单个实体（管理员接口）
+func AdminGetEntity(c *gin.Context) {
+	InitGameEngine()
+
+	playerID := c.Query("user_id")
+	modID := c.Query("mod_id")
+	if playerID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少user_id参数"})
+		return
+	}
+	if !loadEntityRegistry(c, playerID, modID) {
+		return
+	}
+	getEntity(c, playerID, modID)
+}
+
+// AdminUpdateEntity 修正实体的名称、别称和属性（管理员接口，不受锁定限制）
+func AdminUpdateEntity(c *gin.Context) {
+	InitGameEngine()
+
+	playerID := c.Query("user_id")
+	modID := c.Query("mod_id")
+	if playerID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少user_id参数"})
+		return
+	}
+
+	var edit game_engine.EntityEdit
+	if err := c.ShouldBindJSON(&edit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	if !loadEntityRegistry(c, playerID, modID) {
+		return
+	}
+
+	entityManager := stateManager.GetEntityManager()
+	before, _, err := entityManager.EntitySnapshot(playerID, modID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "实体不存在"})
+		return
+	}
+	after, err := entityManager.EditEntity(playerID, modID, before.ID, edit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "实体无效: " + err.Error()})
+		return
+	}
+	if err := stateManager.SaveEntityRegistry(playerID, modID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存实体失败"})
+		return
+	}
+
+	recordAudit(c, services.AuditEntityUpdate, "entity", entityTarget(playerID, modID, before.ID), services.AuditDiff(
+		map[string]interface{}{"name": before.Name, "aliases": before.Aliases, "attributes": before.Attributes},
+		map[string]interface{}{"name": after.Name, "aliases": after.Aliases, "attributes": after.Attributes},
+	), "")
+
+	c.JSON(http.StatusOK, gin.H{"entity": after})
+}
+
+// AdminSetEntityLocked 锁定或解锁实体（管理员接口）
+func AdminSetEntityLocked(c *gin.Context) {
+	InitGameEngine()
+
+	playerID := c.Query("user_id")
+	modID := c.Query("mod_id")
+	if playerID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少user_id参数"})
+		return
+	}
+
+	var req struct {
+		Locked bool `json:"locked"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	if !loadEntityRegistry(c, playerID, modID) {
+		return
+	}
+
+	wasLocked, ok := setEntityLocked(c, playerID, modID, req.Locked)
+	if !ok {
+		return
+	}
+	recordAudit(c, services.AuditEntityLock, "entity", entityTarget(playerID, modID, c.Param("id")), services.AuditDiff(
+		map[string]interface{}{"locked": wasLocked},
+		map[string]interface{}{"locked": req.Locked},
+	), "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "实体已更新", "locked": req.Locked})
+}
+
+// AdminDeleteEntity 删除实体及其关系（管理员接口，玩家实体不能删除）
+func AdminDeleteEntity(c *gin.Context) {
+	InitGameEngine()
+
+	playerID := c.Query("user_id")
+	modID := c.Query("mod_id")
+	if playerID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少user_id参数"})
+		return
+	}
+	if !loadEntityRegistry(c, playerID, modID) {
+		return
+	}
+
+	entityManager := stateManager.GetEntityManager()
+	entity, _, err := entityManager.EntitySnapshot(playerID, modID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "实体不存在"})
+		return
+	}
+	if err := entityManager.DeleteEntity(playerID, modID, entity.ID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "删除实体失败: " + err.Error()})
+		return
+	}
+	if err := stateManager.SaveEntityRegistry(playerID, modID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存实体失败"})
+		return
+	}
+	recordAudit(c, services.AuditEntityDelete, "entity", entityTarget(playerID, modID, entity.ID), nil, entity.Name)
+
+	c.JSON(http.StatusOK, gin.H{"message": "实体已删除"})
+}
+
+// CleanupEntities 立即清理所有存档中过期的实体（管理员接口，days 默认使用定时任务的保留天数）
+func CleanupEntities(c *gin.Context) {
+	InitGameEngine()
+
+	days := entityCleanupDays()
+	if days == 0 {
+		days = defaultEntityCleanupDays
+	}
+	if value := c.Query("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的days参数"})
+			return
+		}
+		days = parsed
+	}
+	if days < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days必须大于0"})
+		return
+	}
+
+	deleted, err := stateManager.CleanupEntities(days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "清理实体失败"})
+		return
+	}
+	recordAudit(c, services.AuditEntityCleanup, "entity", "*", nil, fmt.Sprintf("days=%d deleted=%d", days, deleted))
+
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted, "days": days})
+}
+
+// entityTarget 审计日志中实体的标识
+func entityTarget(playerID, modID, entityID string) string {
+	return fmt.Sprintf("%s/%s/%s", playerID, modID, entityID)
+}
@@ -4,9 +4,12 @@ import (
 	"AIGE/config"
 	"AIGE/game_engine"
 	"AIGE/models"
+	"AIGE/services"
+	"AIGE/utils"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -49,6 +52,9 @@ func InitGameEngine() {
 
 		// 初始化状态管理器
 		stateManager = game_engine.NewStateManager(true, 5*time.Minute)
+		if days := entityCleanupDays(); days > 0 {
+			stateManager.StartEntityCleanup(entityCleanupInterval, days)
+		}
 
 		// 初始化游戏控制器
 		gameController = game_engine.NewGameController(modLoader, stateManager)
@@ -121,6 +127,15 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// IssueWSTicket 签发一次性的WebSocket连接票据
+func IssueWSTicket(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":     utils.IssueWSTicket(userID.(uint)),
+		"expires_in": int(utils.WSTicketTTL.Seconds()),
+	})
+}
+
 // GameWebSocket WebSocket连接处理
 func GameWebSocket(c *gin.Context) {
 	InitGameEngine()
@@ -189,6 +204,13 @@ func GameWebSocket(c *gin.Context) {
 					"content": chunk,
 				})
 			}
+			// 违反一致性规则后重新生成的叙事，替换已发送的内容
+			if corrected, ok := strings.CutPrefix(chunk, game_engine.NarrativeCorrectionPrefix); ok {
+				return sendMessage(conn, "narrative_replace", map[string]interface{}{
+					"stage":   "first",
+					"content": corrected,
+				})
+			}
 			return sendMessage(conn, "narrative_chunk", map[string]interface{}{
 				"content": chunk,
 			})
@@ -196,6 +218,12 @@ func GameWebSocket(c *gin.Context) {
 		
 		// 第二阶段叙事回调函数（作为新消息）
 		secondStageCallback := func(chunk string) error {
+			if corrected, ok := strings.CutPrefix(chunk, game_engine.NarrativeCorrectionPrefix); ok {
+				return sendMessage(conn, "narrative_replace", map[string]interface{}{
+					"stage":   "second",
+					"content": corrected,
+				})
+			}
 			return sendMessage(conn, "second_stage_narrative", map[string]interface{}{
 				"content": chunk,
 			})
@@ -206,6 +234,10 @@ func GameWebSocket(c *gin.Context) {
 			return sendMessage(conn, "roll_event", rollEvent)
 		}
 
+		if session, err := stateManager.GetSession(playerID, modID); err == nil {
+			session.ClientIP = c.ClientIP()
+		}
+
 		// 处理不同的动作 - 统一使用流式处理
 		err = gameController.ProcessActionStreamWithAttributes(playerID, modID, action, customAttributes, streamCallback, rollCallback, secondStageCallback)
 
@@ -277,6 +309,44 @@ func GetGameState(c *gin.Context) {
 	c.JSON(http.StatusOK, session)
 }
 
+// GetQuests 获取当前存档的任务日志，供前端展示任务列表
+func GetQuests(c *gin.Context) {
+	InitGameEngine()
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+
+	modID := c.Query("mod_id")
+	if modID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少mod_id参数"})
+		return
+	}
+
+	playerID := fmt.Sprintf("%v", userID)
+	session, err := stateManager.GetSession(playerID, modID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在"})
+		return
+	}
+
+	journal := game_engine.QuestJournal(session.State)
+	status := c.Query("status")
+	quests := make([]*game_engine.Quest, 0, len(journal.Quests))
+	for _, quest := range journal.Quests {
+		if status == "" || quest.Status == status {
+			quests = append(quests, quest)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"turn":   journal.Turn,
+		"quests": quests,
+	})
+}
+
 // ResetGame 重置游戏（用于测试）
 func ResetGame(c *gin.Context) {
 	InitGameEngine()
@@ -526,6 +596,127 @@ func SaveGameModelConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "配置已保存"})
 }
 
+// GetActionModifiers 获取各游戏声明的动作修饰器及其启用状态（管理员接口）
+func GetActionModifiers(c *gin.Context) {
+	InitGameEngine()
+
+	modFilter := c.Query("mod_id")
+	result := make(map[string][]map[string]interface{})
+
+	for _, mod := range modLoader.GetAllMods() {
+		if modFilter != "" && mod.Config.GameID != modFilter {
+			continue
+		}
+
+		modifiers := make([]map[string]interface{}, 0, len(mod.Config.ActionModifiers))
+		for i := range mod.Config.ActionModifiers {
+			modifier := &mod.Config.ActionModifiers[i]
+			modifiers = append(modifiers, map[string]interface{}{
+				"id":              modifier.ID,
+				"name":            modifier.Name,
+				"tag":             modifier.Tag,
+				"forced_outcome":  modifier.ForcedOutcome,
+				"has_penalty":     modifier.Penalty != nil,
+				"default_enabled": modifier.IsEnabledByDefault(),
+				"enabled":         gameController.IsActionModifierEnabled(mod.Config.GameID, modifier),
+			})
+		}
+		result[mod.Config.GameID] = modifiers
+	}
+
+	c.JSON(http.StatusOK, gin.H{"action_modifiers": result})
+}
+
+// SetActionModifierEnabled 启用或禁用指定游戏的动作修饰器（管理员接口）
+func SetActionModifierEnabled(c *gin.Context) {
+	InitGameEngine()
+
+	var req struct {
+		ModID      string `json:"mod_id" binding:"required"`
+		ModifierID string `json:"modifier_id" binding:"required"`
+		Enabled    bool   `json:"enabled"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	mod, err := modLoader.GetMod(req.ModID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "游戏不存在"})
+		return
+	}
+
+	found := false
+	wasEnabled := false
+	for i := range mod.Config.ActionModifiers {
+		if mod.Config.ActionModifiers[i].ID == req.ModifierID {
+			found = true
+			wasEnabled = gameController.IsActionModifierEnabled(req.ModID, &mod.Config.ActionModifiers[i])
+			break
+		}
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "动作修饰器不存在"})
+		return
+	}
+
+	if err := gameController.SetActionModifierEnabled(req.ModID, req.ModifierID, req.Enabled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存配置失败"})
+		return
+	}
+	recordAudit(c, services.AuditModifierToggle, "mod", req.ModID, services.AuditDiff(
+		map[string]interface{}{req.ModifierID: wasEnabled},
+		map[string]interface{}{req.ModifierID: req.Enabled},
+	), "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "配置已保存"})
+}
+
+// GetActionModifierLogs 获取动作修饰器使用记录（管理员接口）
+func GetActionModifierLogs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query := config.DB.Model(&models.ActionModifierLog{})
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if modID := c.Query("mod_id"); modID != "" {
+		query = query.Where("mod_id = ?", modID)
+	}
+	if modifierID := c.Query("modifier_id"); modifierID != "" {
+		query = query.Where("modifier_id = ?", modifierID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var logs []models.ActionModifierLog
+	if err := query.Order("created_at DESC").
+		Limit(pageSize).
+		Offset((page - 1) * pageSize).
+		Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取使用记录失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"logs":      logs,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
 // RestartOpportunities 重启机缘（清空指定MOD存档，重置机缘次数）
 func RestartOpportunities(c *gin.Context) {
 	userID := c.GetUint("user_id") // 修复：使用正确的键名
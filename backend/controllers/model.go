@@ -3,6 +3,9 @@ package controllers
 import (
 	"AIGE/config"
 	"AIGE/models"
+	"AIGE/services"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -52,6 +55,11 @@ func CreateModel(c *gin.Context) {
 		return
 	}
 
+	if err := model.GenerationParams.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var provider models.Provider
 	if err := config.DB.First(&provider, model.ProviderID).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
@@ -63,6 +71,11 @@ func CreateModel(c *gin.Context) {
 		return
 	}
 
+	// 后台检测模型能力，不阻塞创建
+	detected := model
+	detected.Provider = provider
+	go detectModelCapabilities(&detected)
+
 	c.JSON(http.StatusCreated, model)
 }
 
@@ -81,9 +94,38 @@ func UpdateModel(c *gin.Context) {
 		return
 	}
 
-	if err := config.DB.Model(&model).Updates(updateData).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update model"})
-		return
+	// 生成参数是JSON序列化字段，需要校验后单独按结构体更新
+	if raw, exists := updateData["generation_params"]; exists {
+		delete(updateData, "generation_params")
+
+		var params models.GenerationParams
+		data, _ := json.Marshal(raw)
+		if err := json.Unmarshal(data, &params); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid generation params"})
+			return
+		}
+		if err := params.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		model.GenerationParams = params
+		if err := config.DB.Model(&model).Select("GenerationParams").Updates(&model).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update model"})
+			return
+		}
+	}
+
+	if len(updateData) > 0 {
+		if err := config.DB.Model(&model).Updates(updateData).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update model"})
+			return
+		}
+	}
+
+	// 游戏引擎缓存了模型配置，更新后重新加载使新参数生效
+	if gameController != nil {
+		gameController.LoadAllGameModelConfigs()
 	}
 
 	c.JSON(http.StatusOK, model)
@@ -125,6 +167,7 @@ func ToggleModel(c *gin.Context) {
 	c.JSON(http.StatusOK, model)
 }
 
+// TestModel 调用模型检测其能力，检测结果写入模型的capabilities
 func TestModel(c *gin.Context) {
 	modelID := c.Param("id")
 	var model models.Model
@@ -134,12 +177,12 @@ func TestModel(c *gin.Context) {
 		return
 	}
 
-	now := time.Now()
-	model.LastTested = &now
-	model.TestStatus = "success"
-
-	if err := config.DB.Save(&model).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update test status"})
+	if err := detectModelCapabilities(&model); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+			"model":   model,
+		})
 		return
 	}
 
@@ -150,33 +193,48 @@ func TestModel(c *gin.Context) {
 	})
 }
 
-func UpdateModelCapabilities(c *gin.Context) {
+// DetectModelCapabilities 重新检测模型能力（流式、JSON模式、工具调用、上下文长度）
+func DetectModelCapabilities(c *gin.Context) {
 	modelID := c.Param("id")
 	var model models.Model
 
-	if err := config.DB.First(&model, modelID).Error; err != nil {
+	if err := config.DB.Preload("Provider").First(&model, modelID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Model not found"})
 		return
 	}
 
-	var request struct {
-		Capabilities string `json:"capabilities"`
+	if err := detectModelCapabilities(&model); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "能力检测失败: " + err.Error()})
+		return
 	}
 
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	c.JSON(http.StatusOK, model)
+}
+
+// detectModelCapabilities 检测模型能力并保存检测状态，model需预加载Provider
+func detectModelCapabilities(model *models.Model) error {
+	apiType := model.APIType
+	if apiType == "" {
+		apiType = model.Provider.Type
 	}
 
+	caps, err := services.NewModelFetcher().DetectCapabilities(apiType, model.Provider.BaseURL, model.Provider.APIKey, model.ModelID)
+
 	now := time.Now()
-	model.Capabilities = request.Capabilities
 	model.LastTested = &now
 	model.TestStatus = "success"
-
-	if err := config.DB.Save(&model).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update capabilities"})
-		return
+	if err != nil {
+		model.TestStatus = "failed"
+	} else {
+		model.Capabilities = caps.String()
 	}
 
-	c.JSON(http.StatusOK, model)
+	if dbErr := config.DB.Model(&models.Model{}).Where("id = ?", model.ID).Updates(map[string]interface{}{
+		"capabilities": model.Capabilities,
+		"last_tested":  model.LastTested,
+		"test_status":  model.TestStatus,
+	}).Error; dbErr != nil {
+		fmt.Printf("[模型] 保存能力检测结果失败: %v\n", dbErr)
+	}
+	return err
 }
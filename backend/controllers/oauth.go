@@ -3,25 +3,35 @@ package controllers
 import (
 	"AIGE/config"
 	"AIGE/models"
-	"AIGE/utils"
+	"AIGE/services"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"net/url"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-type LinuxDoUserInfo struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Avatar   string `json:"avatar_url"`
+// oauthStateTTL 授权请求的有效期
+const oauthStateTTL = 10 * time.Minute
+
+type OAuthProviderInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// OAuthProviderConfig 管理后台展示的提供商配置及默认端点
+type OAuthProviderConfig struct {
+	config.OAuthConfig
+	Name     string `json:"name"`
+	Defaults struct {
+		AuthURL     string `json:"auth_url"`
+		TokenURL    string `json:"token_url"`
+		UserInfoURL string `json:"user_info_url"`
+		IssuerURL   string `json:"issuer_url"`
+		Scopes      string `json:"scopes"`
+	} `json:"defaults"`
 }
 
 func generateState() string {
@@ -30,43 +40,98 @@ func generateState() string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
-func LinuxDoLogin(c *gin.Context) {
-	oauthConfig, err := config.GetLinuxDoOAuthConfig()
+// loadOAuthProvider 读取已启用且配置完整的提供商，失败时直接返回错误响应
+func loadOAuthProvider(c *gin.Context) (*services.OAuthProvider, *config.OAuthConfig, bool) {
+	provider, ok := services.GetOAuthProvider(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "不支持的登录方式"})
+		return nil, nil, false
+	}
+
+	oauthConfig, err := config.GetOAuthConfig(provider.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get OAuth config"})
-		return
+		return nil, nil, false
 	}
 
 	if !oauthConfig.Enabled {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Linux.Do OAuth is not enabled"})
-		return
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s OAuth is not enabled", provider.Name)})
+		return nil, nil, false
 	}
 
 	if oauthConfig.ClientID == "" || oauthConfig.ClientSecret == "" {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "OAuth not configured"})
+		return nil, nil, false
+	}
+
+	return provider, oauthConfig, true
+}
+
+// GetOAuthProviders 返回已启用的第三方登录方式
+func GetOAuthProviders(c *gin.Context) {
+	providers := []OAuthProviderInfo{}
+	for _, provider := range services.OAuthProviders() {
+		oauthConfig, err := config.GetOAuthConfig(provider.ID)
+		if err != nil || !oauthConfig.Enabled || oauthConfig.ClientID == "" {
+			continue
+		}
+		providers = append(providers, OAuthProviderInfo{ID: provider.ID, Name: provider.Name})
+	}
+	c.JSON(http.StatusOK, providers)
+}
+
+// startOAuth 保存state和PKCE校验码，返回授权地址
+func startOAuth(c *gin.Context, linkUserID uint) {
+	provider, oauthConfig, ok := loadOAuthProvider(c)
+	if !ok {
+		return
+	}
+
+	// 顺便清理过期的state
+	config.DB.Where("expires_at < ?", time.Now()).Delete(&models.OAuthState{})
+
+	oauthState := models.OAuthState{
+		State:        generateState(),
+		Provider:     provider.ID,
+		CodeVerifier: services.NewPKCEVerifier(),
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(oauthStateTTL),
+	}
+
+	authURL, err := provider.AuthCodeURL(oauthConfig, oauthState.State, oauthState.CodeVerifier)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
 		return
 	}
 
-	state := generateState()
-	
-	c.SetCookie("oauth_state", state, 600, "/", "", false, true)
+	if err := config.DB.Create(&oauthState).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存授权状态失败"})
+		return
+	}
 
-	authURL := fmt.Sprintf("%s?client_id=%s&redirect_uri=%s&response_type=code&state=%s&scope=read",
-		oauthConfig.AuthURL,
-		url.QueryEscape(oauthConfig.ClientID),
-		url.QueryEscape(oauthConfig.RedirectURL),
-		state,
-	)
+	c.SetCookie("oauth_state", oauthState.State, int(oauthStateTTL.Seconds()), "/", "", false, true)
 
 	c.JSON(http.StatusOK, gin.H{
 		"auth_url": authURL,
 	})
 }
 
-func LinuxDoCallback(c *gin.Context) {
-	oauthConfig, err := config.GetLinuxDoOAuthConfig()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get OAuth config"})
+// OAuthLogin 发起第三方登录
+func OAuthLogin(c *gin.Context) {
+	startOAuth(c, 0)
+}
+
+// LinkOAuthIdentity 为当前登录用户关联第三方身份
+func LinkOAuthIdentity(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	startOAuth(c, userID.(uint))
+}
+
+// OAuthCallback 处理授权回调：关联流程把身份绑定到发起关联的用户，
+// 登录流程按身份查找用户，找不到时按已验证邮箱关联或创建新用户
+func OAuthCallback(c *gin.Context) {
+	provider, oauthConfig, ok := loadOAuthProvider(c)
+	if !ok {
 		return
 	}
 
@@ -74,201 +139,222 @@ func LinuxDoCallback(c *gin.Context) {
 	state := c.Query("state")
 
 	cookieState, err := c.Cookie("oauth_state")
-	if err != nil || state != cookieState {
+	if err != nil || state == "" || state != cookieState {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state parameter"})
 		return
 	}
 
 	c.SetCookie("oauth_state", "", -1, "/", "", false, true)
 
-	if code == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing authorization code"})
+	// state 只能使用一次
+	var oauthState models.OAuthState
+	if err := config.DB.Where("state = ? AND provider = ?", state, provider.ID).First(&oauthState).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state parameter"})
 		return
 	}
-
-	tokenData := url.Values{}
-	tokenData.Set("grant_type", "authorization_code")
-	tokenData.Set("code", code)
-	tokenData.Set("redirect_uri", oauthConfig.RedirectURL)
-	tokenData.Set("client_id", oauthConfig.ClientID)
-	tokenData.Set("client_secret", oauthConfig.ClientSecret)
-
-	req, err := http.NewRequest("POST", oauthConfig.TokenURL, strings.NewReader(tokenData.Encode()))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token request"})
+	config.DB.Delete(&oauthState)
+	if time.Now().After(oauthState.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "授权已过期，请重新登录"})
 		return
 	}
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Accept", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange token"})
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing authorization code"})
 		return
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-	
-	if resp.StatusCode != http.StatusOK {
+	external, err := provider.Exchange(oauthConfig, code, oauthState.CodeVerifier)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get access token",
-			"details": string(body),
+			"error":   "Failed to get user info",
+			"details": err.Error(),
 		})
 		return
 	}
 
-	var tokenResponse struct {
-		AccessToken string `json:"access_token"`
-		TokenType   string `json:"token_type"`
+	var identity models.UserIdentity
+	identityExists := config.DB.Where("provider = ? AND subject = ?", provider.ID, external.Subject).First(&identity).Error == nil
+
+	var user models.User
+	switch {
+	case oauthState.LinkUserID != 0:
+		if identityExists && identity.UserID != oauthState.LinkUserID {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("该%s账号已关联其他用户", provider.Name)})
+			return
+		}
+		if err := config.DB.First(&user, oauthState.LinkUserID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+			return
+		}
+	case identityExists:
+		if err := config.DB.First(&user, identity.UserID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+			return
+		}
+	default:
+		// 只合并到已验证该邮箱的本地账号；未验证的账号可能是他人抢注，既不合并也不让新账号占用该邮箱
+		found := false
+		claimEmail := external.Email != "" && external.EmailVerified
+		if claimEmail {
+			found = config.DB.Where("email = ? AND email_verified = ?", external.Email, true).First(&user).Error == nil
+			if !found {
+				var unverified int64
+				config.DB.Model(&models.User{}).Where("email = ?", external.Email).Count(&unverified)
+				claimEmail = unverified == 0
+			}
+		}
+		if !found {
+			if err := createOAuthUser(&user, provider.ID, external, claimEmail); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error":   "创建用户失败",
+					"details": err.Error(),
+				})
+				return
+			}
+		}
 	}
 
-	if err := json.Unmarshal(body, &tokenResponse); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse token response"})
+	identity.UserID = user.ID
+	identity.Provider = provider.ID
+	identity.Subject = external.Subject
+	identity.Username = external.Username
+	identity.Email = external.Email
+	identity.Avatar = external.Avatar
+	if err := config.DB.Save(&identity).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存关联身份失败"})
 		return
 	}
 
-	userReq, err := http.NewRequest("GET", oauthConfig.UserInfoURL, nil)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user info request"})
-		return
+	if user.Avatar == "" && external.Avatar != "" {
+		config.DB.Model(&user).Update("avatar", external.Avatar)
 	}
 
-	userReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenResponse.AccessToken))
-	userReq.Header.Set("Accept", "application/json")
+	respondWithTokens(c, http.StatusOK, &user)
+}
 
-	userResp, err := client.Do(userReq)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info"})
-		return
+// createOAuthUser 使用第三方身份创建用户，用户名冲突时追加序号。
+// claimEmail 为false时使用占位邮箱
+func createOAuthUser(user *models.User, providerID string, external *services.ExternalIdentity, claimEmail bool) error {
+	email := external.Email
+	if !claimEmail {
+		email = fmt.Sprintf("%s-%s@oauth.local", providerID, external.Subject)
 	}
-	defer userResp.Body.Close()
-
-	userBody, _ := io.ReadAll(userResp.Body)
 
-	if userResp.StatusCode != http.StatusOK {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get user info",
-			"details": string(userBody),
-		})
-		return
+	base := external.Username
+	if base == "" {
+		base = fmt.Sprintf("%s_%s", providerID, external.Subject)
+	}
+	username := base
+	var existingUser models.User
+	for i := 1; config.DB.Where("username = ?", username).First(&existingUser).Error == nil; i++ {
+		username = fmt.Sprintf("%s_%d", base, i)
 	}
 
-	var userInfo LinuxDoUserInfo
-	if err := json.Unmarshal(userBody, &userInfo); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user info"})
-		return
+	*user = models.User{
+		Username:      username,
+		Email:         email,
+		EmailVerified: email == external.Email,
+		Avatar:        external.Avatar,
+		IsAdmin:       false,
 	}
+	return config.DB.Create(user).Error
+}
 
-	var user models.User
-	oauthID := fmt.Sprintf("%d", userInfo.ID)
-	
-	result := config.DB.Where("oauth_provider = ? AND oauth_id = ?", "linux-do", oauthID).First(&user)
-	
-	if result.Error != nil {
-		email := userInfo.Email
-		if email == "" {
-			email = fmt.Sprintf("linux-do-%d@oauth.local", userInfo.ID)
-		}
+// GetIdentities 当前用户关联的第三方身份
+func GetIdentities(c *gin.Context) {
+	userID, _ := c.Get("user_id")
 
-		var existingEmailUser models.User
-		if config.DB.Where("email = ?", email).First(&existingEmailUser).Error == nil {
-			existingEmailUser.OAuthProvider = "linux-do"
-			existingEmailUser.OAuthID = oauthID
-			if userInfo.Avatar != "" {
-				existingEmailUser.Avatar = userInfo.Avatar
-			}
-			config.DB.Save(&existingEmailUser)
-			user = existingEmailUser
-		} else {
-			username := userInfo.Username
-			var existingUser models.User
-			for i := 0; ; i++ {
-				checkUsername := username
-				if i > 0 {
-					checkUsername = fmt.Sprintf("%s_%d", username, i)
-				}
-				
-				if err := config.DB.Where("username = ?", checkUsername).First(&existingUser).Error; err != nil {
-					username = checkUsername
-					break
-				}
-			}
+	var identities []models.UserIdentity
+	config.DB.Where("user_id = ?", userID).Order("id").Find(&identities)
+	c.JSON(http.StatusOK, identities)
+}
 
-			user = models.User{
-				Username:      username,
-				Email:         email,
-				OAuthProvider: "linux-do",
-				OAuthID:       oauthID,
-				Avatar:        userInfo.Avatar,
-				IsAdmin:       false,
-			}
+// UnlinkIdentity 解除关联，没有设置密码时不能解除最后一个登录方式
+func UnlinkIdentity(c *gin.Context) {
+	userID, _ := c.Get("user_id")
 
-			if err := config.DB.Create(&user).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": "创建用户失败", 
-					"details": err.Error(),
-				})
-				return
-			}
-		}
-	} else {
-		if userInfo.Avatar != "" {
-			user.Avatar = userInfo.Avatar
-		}
-		if userInfo.Username != "" {
-			user.Username = userInfo.Username
-		}
-		if userInfo.Email != "" && user.Email != userInfo.Email {
-			var emailCheck models.User
-			if err := config.DB.Where("email = ? AND id != ?", userInfo.Email, user.ID).First(&emailCheck).Error; err != nil {
-				user.Email = userInfo.Email
-			}
-		}
-		config.DB.Save(&user)
+	var identity models.UserIdentity
+	if err := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&identity).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "关联身份不存在"})
+		return
 	}
 
-	token, err := utils.GenerateJWT(user.ID, user.Username, user.IsAdmin)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
 		return
 	}
 
-	c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User: UserInfo{
-			ID:       user.ID,
-			Username: user.Username,
-			Email:    user.Email,
-			IsAdmin:  user.IsAdmin,
-		},
-	})
-}
+	var count int64
+	config.DB.Model(&models.UserIdentity{}).Where("user_id = ?", userID).Count(&count)
+	if user.Password == "" && count <= 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "这是唯一的登录方式，请先设置密码"})
+		return
+	}
 
+	config.DB.Delete(&identity)
+	c.JSON(http.StatusOK, gin.H{"message": "已解除关联"})
+}
 
-func GetOAuthConfig(c *gin.Context) {
-	oauthConfig, err := config.GetLinuxDoOAuthConfig()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get OAuth config"})
-		return
+// GetOAuthConfigs 返回所有提供商的配置，客户端密钥只返回掩码
+func GetOAuthConfigs(c *gin.Context) {
+	configs := []OAuthProviderConfig{}
+	for _, provider := range services.OAuthProviders() {
+		oauthConfig, err := config.GetOAuthConfig(provider.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get OAuth config"})
+			return
+		}
+		oauthConfig.ClientSecret = ""
+
+		item := OAuthProviderConfig{OAuthConfig: *oauthConfig, Name: provider.Name}
+		item.Defaults.AuthURL = provider.AuthURL
+		item.Defaults.TokenURL = provider.TokenURL
+		item.Defaults.UserInfoURL = provider.UserInfoURL
+		item.Defaults.IssuerURL = provider.IssuerURL
+		item.Defaults.Scopes = provider.Scopes
+		configs = append(configs, item)
 	}
+	c.JSON(http.StatusOK, configs)
+}
 
-	c.JSON(http.StatusOK, oauthConfig)
+// oauthAuditFields OAuth配置的可审计字段，客户端密钥只记录掩码
+func oauthAuditFields(cfg *config.OAuthConfig) map[string]interface{} {
+	return map[string]interface{}{
+		"client_id":     cfg.ClientID,
+		"client_secret": config.MaskSecret(cfg.ClientSecret),
+		"redirect_url":  cfg.RedirectURL,
+		"auth_url":      cfg.AuthURL,
+		"token_url":     cfg.TokenURL,
+		"user_info_url": cfg.UserInfoURL,
+		"issuer_url":    cfg.IssuerURL,
+		"scopes":        cfg.Scopes,
+		"enabled":       cfg.Enabled,
+	}
 }
 
 func SaveOAuthConfig(c *gin.Context) {
+	provider, ok := services.GetOAuthProvider(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "不支持的登录方式"})
+		return
+	}
+
 	var oauthConfig config.OAuthConfig
 	if err := c.ShouldBindJSON(&oauthConfig); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
 		return
 	}
 
-	if err := config.SaveLinuxDoOAuthConfig(&oauthConfig); err != nil {
+	previous, _ := config.GetOAuthConfig(provider.ID)
+	if err := config.SaveOAuthConfig(provider.ID, &oauthConfig); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save OAuth config"})
 		return
 	}
+	if current, err := config.GetOAuthConfig(provider.ID); err == nil && previous != nil {
+		recordAudit(c, services.AuditOAuthConfig, "oauth_provider", provider.ID,
+			services.AuditDiff(oauthAuditFields(previous), oauthAuditFields(current)), "")
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "OAuth config saved successfully"})
 }
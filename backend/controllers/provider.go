@@ -10,6 +10,17 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// providerAuditFields 提供商的可审计字段（不含密钥），键与JSON字段一致
+func providerAuditFields(provider *models.Provider) map[string]interface{} {
+	return map[string]interface{}{
+		"name":             provider.Name,
+		"type":             provider.Type,
+		"base_url":         provider.BaseURL,
+		"enabled":          provider.Enabled,
+		"allow_custom_url": provider.AllowCustomURL,
+	}
+}
+
 func GetProviders(c *gin.Context) {
 	var providers []models.Provider
 	if err := config.DB.Preload("Models").Find(&providers).Error; err != nil {
@@ -32,15 +43,23 @@ func GetProvider(c *gin.Context) {
 	c.JSON(http.StatusOK, provider)
 }
 
+// createProviderRequest 创建提供商的请求，APIKey 在模型中不参与JSON序列化，需要单独接收
+type createProviderRequest struct {
+	models.Provider
+	APIKey string `json:"api_key"`
+}
+
 func CreateProvider(c *gin.Context) {
-	var provider models.Provider
+	var req createProviderRequest
 
-	if err := c.ShouldBindJSON(&provider); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	provider := req.Provider
+	provider.APIKey = req.APIKey
 
-	if provider.Name == "" || provider.Type == "" || provider.APIKey == "" {
+	if provider.Name == "" || provider.Type == "" || (provider.APIKey == "" && services.RequiresAPIKey(provider.Type)) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Name, type, and API key are required"})
 		return
 	}
@@ -49,6 +68,9 @@ func CreateProvider(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create provider"})
 		return
 	}
+	after := providerAuditFields(&provider)
+	after["api_key"] = config.MaskSecret(provider.APIKey)
+	recordAudit(c, services.AuditProviderCreate, "provider", provider.ID, services.AuditDiff(nil, after), "")
 
 	c.JSON(http.StatusCreated, provider)
 }
@@ -68,14 +90,62 @@ func UpdateProvider(c *gin.Context) {
 		return
 	}
 
+	// API密钥只能通过 PUT /providers/:id/api-key 修改
+	delete(updateData, "api_key")
+	delete(updateData, "api_key_masked")
+
+	before := providerAuditFields(&provider)
 	if err := config.DB.Model(&provider).Updates(updateData).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update provider"})
 		return
 	}
+	recordAudit(c, services.AuditProviderUpdate, "provider", provider.ID, services.AuditDiff(before, providerAuditFields(&provider)), provider.Name)
 
 	c.JSON(http.StatusOK, provider)
 }
 
+// UpdateProviderAPIKey 更新提供商API密钥（只写），响应中只包含掩码
+func UpdateProviderAPIKey(c *gin.Context) {
+	providerID := c.Param("id")
+	var provider models.Provider
+
+	if err := config.DB.First(&provider, providerID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	var req struct {
+		APIKey string `json:"api_key"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.APIKey == "" && services.RequiresAPIKey(provider.Type) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "API key is required"})
+		return
+	}
+
+	previousKey := config.MaskSecret(provider.APIKey)
+	provider.APIKey = req.APIKey
+	if err := config.DB.Model(&provider).Select("APIKey").Updates(&provider).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update API key"})
+		return
+	}
+	recordAudit(c, services.AuditProviderAPIKey, "provider", provider.ID, services.AuditDiff(
+		map[string]interface{}{"api_key": previousKey},
+		map[string]interface{}{"api_key": config.MaskSecret(provider.APIKey)},
+	), provider.Name)
+
+	// 游戏引擎缓存了提供商配置，更新后重新加载
+	if gameController != nil {
+		gameController.LoadAllGameModelConfigs()
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_key_masked": config.MaskSecret(provider.APIKey)})
+}
+
 func DeleteProvider(c *gin.Context) {
 	providerID := c.Param("id")
 	var provider models.Provider
@@ -91,6 +161,7 @@ func DeleteProvider(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete provider"})
 		return
 	}
+	recordAudit(c, services.AuditProviderDelete, "provider", provider.ID, services.AuditDiff(providerAuditFields(&provider), nil), "")
 
 	c.JSON(http.StatusOK, gin.H{"message": "Provider deleted successfully"})
 }
@@ -110,6 +181,10 @@ func ToggleProvider(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to toggle provider"})
 		return
 	}
+	recordAudit(c, services.AuditProviderToggle, "provider", provider.ID, services.AuditDiff(
+		map[string]interface{}{"enabled": !provider.Enabled},
+		map[string]interface{}{"enabled": provider.Enabled},
+	), provider.Name)
 
 	c.JSON(http.StatusOK, provider)
 }
@@ -124,7 +199,7 @@ func GetAvailableModels(c *gin.Context) {
 		return
 	}
 
-	if provider.APIKey == "" {
+	if provider.APIKey == "" && services.RequiresAPIKey(provider.Type) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider API key not configured"})
 		return
 	}
@@ -135,14 +210,7 @@ func GetAvailableModels(c *gin.Context) {
 
 	baseURL := provider.BaseURL
 	if baseURL == "" {
-		switch apiType {
-		case "openai":
-			baseURL = "https://api.openai.com/v1"
-		case "anthropic":
-			baseURL = "https://api.anthropic.com/v1"
-		case "google":
-			baseURL = "https://generativelanguage.googleapis.com/v1beta"
-		}
+		baseURL = services.DefaultBaseURL(apiType)
 	}
 
 	fetcher := services.NewModelFetcher()
@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"AIGE/services"
+	"AIGE/utils"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name" binding:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type SetUserRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+func validatePermissions(perms []string) error {
+	for _, perm := range perms {
+		if !models.IsValidPermission(perm) {
+			return fmt.Errorf("未知的权限: %s", perm)
+		}
+	}
+	return nil
+}
+
+// roleNames 返回角色标识列表
+func roleNames(roles []models.Role) []string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+// callerPermissions 当前用户的权限，RequirePermission 已查询过时直接复用
+func callerPermissions(c *gin.Context) map[string]bool {
+	if perms, ok := c.Get("permissions"); ok {
+		return perms.(map[string]bool)
+	}
+	value, ok := c.Get("user")
+	if !ok {
+		return map[string]bool{}
+	}
+	perms, err := models.UserPermissions(value.(*models.User))
+	if err != nil {
+		return map[string]bool{}
+	}
+	return perms
+}
+
+// canManageUser 当前用户的权限是否覆盖目标用户的全部权限，不能操作权限更高的用户
+func canManageUser(c *gin.Context, target *models.User) bool {
+	perms, err := models.UserPermissions(target)
+	return err == nil && models.CoversPermissions(callerPermissions(c), perms)
+}
+
+// GetPermissions 返回全部可分配的权限
+func GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, models.Permissions)
+}
+
+// GetRoles 返回全部角色及拥有该角色的用户数
+func GetRoles(c *gin.Context) {
+	var roles []models.Role
+	if err := config.DB.Order("id").Find(&roles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取角色列表失败"})
+		return
+	}
+
+	type roleWithCount struct {
+		models.Role
+		UserCount int64 `json:"user_count"`
+	}
+	result := make([]roleWithCount, 0, len(roles))
+	for _, role := range roles {
+		var count int64
+		config.DB.Model(&models.UserRole{}).Where("role_id = ?", role.ID).Count(&count)
+		result = append(result, roleWithCount{Role: role, UserCount: count})
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": result})
+}
+
+// CreateRole 创建自定义角色
+func CreateRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+	if !roleNamePattern.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "角色标识只能包含小写字母、数字、-和_，且以字母开头"})
+		return
+	}
+	if err := validatePermissions(req.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.CanGrant(callerPermissions(c), req.Permissions) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能创建超出自身权限的角色"})
+		return
+	}
+
+	var existing models.Role
+	if err := config.DB.Where("name = ?", req.Name).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "角色已存在"})
+		return
+	}
+
+	role := models.Role{
+		Name:        req.Name,
+		DisplayName: req.DisplayName,
+		Description: req.Description,
+		Permissions: req.Permissions,
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	if err := config.DB.Create(&role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "角色创建失败"})
+		return
+	}
+	recordAudit(c, services.AuditRoleCreate, "role", role.ID, services.AuditDiff(nil, map[string]interface{}{
+		"name":        role.Name,
+		"permissions": role.Permissions,
+	}), "")
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole 修改角色名称和权限，admin 角色的权限不能修改
+func UpdateRole(c *gin.Context) {
+	var role models.Role
+	if err := config.DB.First(&role, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "角色不存在"})
+		return
+	}
+
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+	// 修改前后的权限都不能超出当前用户的权限
+	perms := callerPermissions(c)
+	if !models.CanGrant(perms, role.Permissions) || (role.Name != models.RoleAdmin && !models.CanGrant(perms, req.Permissions)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能修改超出自身权限的角色"})
+		return
+	}
+
+	before := map[string]interface{}{
+		"display_name": role.DisplayName,
+		"description":  role.Description,
+		"permissions":  role.Permissions,
+	}
+
+	role.DisplayName = req.DisplayName
+	role.Description = req.Description
+	if role.Name != models.RoleAdmin {
+		if err := validatePermissions(req.Permissions); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		role.Permissions = req.Permissions
+		if role.Permissions == nil {
+			role.Permissions = []string{}
+		}
+	}
+
+	if err := config.DB.Select("DisplayName", "Description", "Permissions").Updates(&role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "角色更新失败"})
+		return
+	}
+	recordAudit(c, services.AuditRoleUpdate, "role", role.ID, services.AuditDiff(before, map[string]interface{}{
+		"display_name": role.DisplayName,
+		"description":  role.Description,
+		"permissions":  role.Permissions,
+	}), role.Name)
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole 删除自定义角色，内置角色不能删除
+func DeleteRole(c *gin.Context) {
+	var role models.Role
+	if err := config.DB.First(&role, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "角色不存在"})
+		return
+	}
+	if role.BuiltIn {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "内置角色不能删除"})
+		return
+	}
+	if !models.CanGrant(callerPermissions(c), role.Permissions) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能删除超出自身权限的角色"})
+		return
+	}
+
+	config.DB.Where("role_id = ?", role.ID).Delete(&models.UserRole{})
+	if err := config.DB.Delete(&role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "角色删除失败"})
+		return
+	}
+	recordAudit(c, services.AuditRoleDelete, "role", role.ID, services.AuditDiff(map[string]interface{}{
+		"name":        role.Name,
+		"permissions": role.Permissions,
+	}, nil), "")
+	c.JSON(http.StatusOK, gin.H{"message": "角色删除成功"})
+}
+
+// SetUserRoles 替换用户的角色
+func SetUserRoles(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+
+	var req SetUserRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+
+	if !canManageUser(c, &user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能修改权限高于自己的用户"})
+		return
+	}
+
+	roles := []models.Role{}
+	if len(req.Roles) > 0 {
+		if err := config.DB.Where("name IN ?", req.Roles).Find(&roles).Error; err != nil || len(roles) != len(req.Roles) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "包含不存在的角色"})
+			return
+		}
+	}
+	perms := callerPermissions(c)
+	for _, role := range roles {
+		if !models.CanGrant(perms, role.Permissions) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("不能分配超出自身权限的角色: %s", role.Name)})
+			return
+		}
+	}
+
+	// 防止移除最后一个管理员
+	keepsAdmin := false
+	for _, role := range roles {
+		if role.Name == models.RoleAdmin {
+			keepsAdmin = true
+		}
+	}
+	if user.IsAdmin && !keepsAdmin {
+		var admins int64
+		config.DB.Model(&models.User{}).Where("is_admin = ?", true).Count(&admins)
+		if admins <= 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不能移除唯一管理员的管理员角色"})
+			return
+		}
+	}
+
+	previous, _ := models.UserRoles(&user)
+	if err := models.SetUserRoles(user.ID, roles); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "角色更新失败"})
+		return
+	}
+
+	// 角色变更后要求重新登录，使客户端缓存的权限与数据库一致
+	utils.RevokeUserTokens(user.ID)
+	recordAudit(c, services.AuditUserRoles, "user", user.ID, services.AuditDiff(
+		map[string]interface{}{"roles": roleNames(previous)},
+		map[string]interface{}{"roles": roleNames(roles)},
+	), user.Username)
+
+	config.DB.First(&user, user.ID)
+	c.JSON(http.StatusOK, newUserInfo(&user))
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupRoleTest(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	config.DB = db
+	models.AutoMigrate()
+	gin.SetMode(gin.TestMode)
+}
+
+func createTestRole(t *testing.T, name string, perms ...string) {
+	if err := config.DB.Create(&models.Role{Name: name, DisplayName: name, Permissions: perms}).Error; err != nil {
+		t.Fatalf("Failed to create role: %v", err)
+	}
+}
+
+func createTestUser(t *testing.T, username string, roles ...string) *models.User {
+	user := &models.User{Username: username, Email: username + "@example.com"}
+	if err := config.DB.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	var assigned []models.Role
+	config.DB.Where("name IN ?", roles).Find(&assigned)
+	if err := models.SetUserRoles(user.ID, assigned); err != nil {
+		t.Fatalf("Failed to set roles: %v", err)
+	}
+	config.DB.First(user, user.ID)
+	return user
+}
+
+// callAs 以 caller 身份调用处理函数，返回状态码
+func callAs(handler gin.HandlerFunc, caller *models.User, id interface{}, body interface{}) int {
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	if id != nil {
+		c.Params = gin.Params{{Key: "id", Value: fmt.Sprintf("%v", id)}}
+	}
+	c.Set("user", caller)
+	c.Set("user_id", caller.ID)
+	c.Set("username", caller.Username)
+	handler(c)
+	return w.Code
+}
+
+func TestRoleEscalationIsRejected(t *testing.T) {
+	setupRoleTest(t)
+	createTestRole(t, "role-manager", models.PermRolesManage, models.PermUsersRead, models.PermUsersManage)
+	createTestRole(t, "reader", models.PermUsersRead)
+	manager := createTestUser(t, "manager", "role-manager")
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	player := createTestUser(t, "player", models.RolePlayer)
+
+	var reader models.Role
+	config.DB.Where("name = ?", "reader").First(&reader)
+
+	cases := []struct {
+		name    string
+		handler gin.HandlerFunc
+		id      interface{}
+		body    interface{}
+		want    int
+	}{
+		{"给自己分配admin", SetUserRoles, manager.ID, gin.H{"roles": []string{models.RoleAdmin}}, http.StatusForbidden},
+		{"分配含角色管理权限的角色", SetUserRoles, player.ID, gin.H{"roles": []string{"role-manager"}}, http.StatusForbidden},
+		{"分配自身权限内的角色", SetUserRoles, player.ID, gin.H{"roles": []string{"reader"}}, http.StatusOK},
+		{"修改管理员的角色", SetUserRoles, admin.ID, gin.H{"roles": []string{models.RolePlayer}}, http.StatusForbidden},
+		{"创建超出自身权限的角色", CreateRole, nil, gin.H{"name": "ops", "display_name": "运维", "permissions": []string{models.PermConfigManage}}, http.StatusForbidden},
+		{"创建含角色管理权限的角色", CreateRole, nil, gin.H{"name": "ops", "display_name": "运维", "permissions": []string{models.PermRolesManage}}, http.StatusForbidden},
+		{"创建自身权限内的角色", CreateRole, nil, gin.H{"name": "ops", "display_name": "运维", "permissions": []string{models.PermUsersManage}}, http.StatusCreated},
+		{"给角色增加超出自身的权限", UpdateRole, reader.ID, gin.H{"display_name": "读者", "permissions": []string{models.PermUsersRead, models.PermProvidersManage}}, http.StatusForbidden},
+		{"修改admin角色", UpdateRole, 1, gin.H{"display_name": "超级管理员"}, http.StatusForbidden},
+		{"重置管理员密码", UpdateUserPassword, admin.ID, gin.H{"new_password": "hijacked123"}, http.StatusForbidden},
+		{"重置玩家密码", UpdateUserPassword, player.ID, gin.H{"new_password": "newpassword123"}, http.StatusOK},
+		{"删除管理员", DeleteUser, admin.ID, nil, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		if got := callAs(tc.handler, manager, tc.id, tc.body); got != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, got)
+		}
+	}
+
+	// 拥有全部权限的管理员可以分配角色管理权限
+	if got := callAs(SetUserRoles, admin, player.ID, gin.H{"roles": []string{"role-manager"}}); got != http.StatusOK {
+		t.Errorf("Expected admin to grant roles.manage, got %d", got)
+	}
+}
+
+func TestLastAdminGuard(t *testing.T) {
+	setupRoleTest(t)
+	admin := createTestUser(t, "admin", models.RoleAdmin)
+	if !isLastAdmin(admin) {
+		t.Fatalf("Expected the only admin to be the last admin")
+	}
+	if got := callAs(SetUserRoles, admin, admin.ID, gin.H{"roles": []string{models.RolePlayer}}); got != http.StatusBadRequest {
+		t.Errorf("Expected removing the last admin role to be rejected, got %d", got)
+	}
+
+	second := createTestUser(t, "second", models.RoleAdmin)
+	if isLastAdmin(admin) {
+		t.Errorf("Expected two admins not to trigger the guard")
+	}
+	if got := callAs(SetUserRoles, second, admin.ID, gin.H{"roles": []string{models.RolePlayer}}); got != http.StatusOK {
+		t.Errorf("Expected demoting one of two admins to succeed, got %d", got)
+	}
+	if got := callAs(DeleteUser, admin, second.ID, nil); got != http.StatusForbidden {
+		t.Errorf("Expected demoted user not to delete an admin, got %d", got)
+	}
+}
@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"AIGE/config"
+	"AIGE/game_engine"
+	"AIGE/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RevealedSeed 已揭示的服务器种子
+type RevealedSeed struct {
+	SeedHash   string     `json:"seed_hash"`
+	Seed       string     `json:"seed"`
+	RevealedAt *time.Time `json:"revealed_at"`
+}
+
+// GetRollLog 获取当前玩家的判定记录
+func GetRollLog(c *gin.Context) {
+	InitGameEngine()
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+
+	modID := c.Query("mod_id")
+	if modID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少mod_id参数"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query := config.DB.Model(&models.RollLog{}).Where("user_id = ? AND mod_id = ?", userID, modID)
+
+	var total int64
+	query.Count(&total)
+
+	var rolls []models.RollLog
+	if err := query.Order("created_at DESC").
+		Limit(pageSize).
+		Offset((page - 1) * pageSize).
+		Find(&rolls).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取判定记录失败"})
+		return
+	}
+
+	var seeds []models.RollSeed
+	config.DB.Where("user_id = ? AND mod_id = ? AND revealed = ?", userID, modID, true).
+		Order("revealed_at DESC").
+		Find(&seeds)
+
+	revealed := make([]RevealedSeed, 0, len(seeds))
+	for _, seed := range seeds {
+		revealed = append(revealed, RevealedSeed{
+			SeedHash:   seed.SeedHash,
+			Seed:       seed.Seed,
+			RevealedAt: seed.RevealedAt,
+		})
+	}
+
+	// 当前种子的哈希（承诺值）
+	currentHash := ""
+	playerID := fmt.Sprintf("%v", userID)
+	if session, err := stateManager.GetSession(playerID, modID); err == nil {
+		currentHash = session.RollSeedCommitment()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"seed_hash":      currentHash,
+		"revealed_seeds": revealed,
+		"rolls":          rolls,
+		"total":          total,
+		"page":           page,
+		"page_size":      pageSize,
+	})
+}
+
+// RevealRollSeed 揭示当前玩家的服务器种子并更换新种子
+func RevealRollSeed(c *gin.Context) {
+	InitGameEngine()
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+
+	var req struct {
+		ModID string `json:"mod_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	playerID := fmt.Sprintf("%v", userID)
+	record, err := stateManager.RevealRollSeed(playerID, req.ModID)
+	if errors.Is(err, game_engine.ErrTurnInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "揭示种子失败: " + err.Error()})
+		return
+	}
+
+	nextHash := ""
+	if session, err := stateManager.GetSession(playerID, req.ModID); err == nil {
+		nextHash = session.RollSeedHash
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revealed": RevealedSeed{
+			SeedHash:   record.SeedHash,
+			Seed:       record.Seed,
+			RevealedAt: record.RevealedAt,
+		},
+		"next_seed_hash": nextHash,
+	})
+}
+
+// VerifyRoll 验证玩家自己的判定记录（仅限种子已揭示的判定）
+func VerifyRoll(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+
+	var entry models.RollLog
+	if err := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&entry).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "判定记录不存在"})
+		return
+	}
+
+	verifyRollEntry(c, &entry, true)
+}
+
+// GetAllRollLogs 获取所有判定记录（管理员接口）
+func GetAllRollLogs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query := config.DB.Model(&models.RollLog{})
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if modID := c.Query("mod_id"); modID != "" {
+		query = query.Where("mod_id = ?", modID)
+	}
+	if outcome := c.Query("outcome"); outcome != "" {
+		query = query.Where("outcome = ?", outcome)
+	}
+	if forced := c.Query("forced"); forced != "" {
+		query = query.Where("forced = ?", forced == "true")
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var rolls []models.RollLog
+	if err := query.Order("created_at DESC").
+		Limit(pageSize).
+		Offset((page - 1) * pageSize).
+		Find(&rolls).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取判定记录失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rolls":     rolls,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// AdminVerifyRoll 验证任意判定记录（管理员接口，在服务端使用未揭示的种子复算，但不返回种子）
+func AdminVerifyRoll(c *gin.Context) {
+	var entry models.RollLog
+	if err := config.DB.First(&entry, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "判定记录不存在"})
+		return
+	}
+
+	verifyRollEntry(c, &entry, false)
+}
+
+// verifyRollEntry 使用种子复算判定记录并返回验证结果
+func verifyRollEntry(c *gin.Context, entry *models.RollLog, requireRevealed bool) {
+	var seed models.RollSeed
+	if err := config.DB.Where("seed_hash = ?", entry.SeedHash).First(&seed).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "种子记录不存在"})
+		return
+	}
+	if requireRevealed && !seed.Revealed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "种子尚未揭示，请先申请揭示种子"})
+		return
+	}
+
+	response := gin.H{
+		"roll":      entry,
+		"seed_hash": seed.SeedHash,
+		"revealed":  seed.Revealed,
+		"valid":     true,
+	}
+	// 未揭示的种子可以推算出此后的全部判定，只在揭示后返回
+	if seed.Revealed {
+		response["seed"] = seed.Seed
+	}
+	if err := game_engine.VerifyRollLog(entry, seed.Seed); err != nil {
+		response["valid"] = false
+		response["reason"] = err.Error()
+	}
+
+	c.JSON(http.StatusOK, response)
+}
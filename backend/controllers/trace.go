@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"AIGE/config"
+	"AIGE/game_engine"
+	"AIGE/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetTurnTraces 获取回合追踪列表（管理员接口，不含完整追踪数据）
+func GetTurnTraces(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query := config.DB.Model(&models.TurnTrace{})
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if modID := c.Query("mod_id"); modID != "" {
+		query = query.Where("mod_id = ?", modID)
+	}
+	if c.Query("failed") == "true" {
+		query = query.Where("error <> ''")
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var traces []models.TurnTrace
+	if err := query.Omit("data").
+		Order("created_at DESC").
+		Limit(pageSize).
+		Offset((page - 1) * pageSize).
+		Find(&traces).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取回合追踪失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"traces":    traces,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetTurnTrace 下载单个回合的完整追踪（可直接用于 aige replay）
+func GetTurnTrace(c *gin.Context) {
+	var trace models.TurnTrace
+	if err := config.DB.First(&trace, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "回合追踪不存在"})
+		return
+	}
+
+	// 只返回已揭示的种子，未揭示时回放需等玩家揭示种子后重新下载
+	var data game_engine.TurnTrace
+	if err := json.Unmarshal([]byte(trace.Data), &data); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "回合追踪数据损坏"})
+		return
+	}
+	data.FillRevealedSeed()
+
+	if c.Query("download") == "true" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=trace_%d.json", trace.ID))
+	}
+	c.JSON(http.StatusOK, &data)
+}
@@ -0,0 +1,439 @@
+package game_engine
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"AIGE/services"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// actionModifierConfigPrefix 管理员开关在SystemConfig中的键前缀，值为 {"modifier_id": true/false}
+const actionModifierConfigPrefix = "action_modifiers_"
+
+// ActionModifierConfig 动作修饰器配置（在mod的config.json中通过action_modifiers声明）
+// 玩家在行动中附带触发标记（如 [SOUL_BURN]）即可激活对应修饰器
+type ActionModifierConfig struct {
+	ID            string           `json:"id"`
+	Name          string           `json:"name"`
+	Tag           string           `json:"tag"`            // 触发标记
+	Prompt        string           `json:"prompt"`         // 覆盖提示词名称（对应prompts中的键）
+	StateFlag     string           `json:"state_flag"`     // 激活时写入State的标志位
+	ForcedOutcome string           `json:"forced_outcome"` // 强制判定结果（大成功/成功/失败/大失败）
+	Enabled       *bool            `json:"enabled"`        // 未配置时默认启用
+	Penalty       *ModifierPenalty `json:"penalty,omitempty"`
+
+	// 权限控制：需要权限的修饰器只对管理员、被授权用户或调试存档生效
+	RequiresPermission bool   `json:"requires_permission"`
+	OnDenied           string `json:"on_denied"` // strip（默认，去除标记后按普通行动处理）或 reject（拒绝整个行动）
+}
+
+// ModifierPenalty 修饰器代价表
+type ModifierPenalty struct {
+	ContentAttribute string        `json:"content_attribute"` // 从自定义属性中读取玩家要求内容的键
+	RecordKey        string        `json:"record_key"`        // 累积代价记录在State中的键
+	Tiers            []PenaltyTier `json:"tiers"`             // 按顺序匹配关键词，没有关键词的层级作为默认层级
+	Effects          []StateEffect `json:"effects"`           // 任意代价都会附加的状态效果
+}
+
+// PenaltyTier 代价层级
+type PenaltyTier struct {
+	ID       string         `json:"id"`
+	Keywords []string       `json:"keywords"`
+	Entries  []PenaltyEntry `json:"entries"`
+}
+
+// PenaltyEntry 单条代价，按权重随机抽取
+type PenaltyEntry struct {
+	Text    string        `json:"text"`
+	Weight  int           `json:"weight"`
+	Effects []StateEffect `json:"effects"`
+}
+
+// StateEffect 声明式状态效果
+// op: add（数值相加）、multiply（数值相乘）、set（直接赋值）、step（沿ladder等级表下降value级）
+type StateEffect struct {
+	Path   string      `json:"path"`
+	Op     string      `json:"op"`
+	Value  interface{} `json:"value"`
+	Ladder []string    `json:"ladder,omitempty"`
+}
+
+// IsEnabledByDefault 返回mod配置中的默认启用状态
+func (m *ActionModifierConfig) IsEnabledByDefault() bool {
+	return m.Enabled == nil || *m.Enabled
+}
+
+// validateActionModifiers 校验mod声明的动作修饰器
+func validateActionModifiers(modifiers []ActionModifierConfig, prompts map[string]string) error {
+	seen := make(map[string]bool)
+	for _, m := range modifiers {
+		if m.ID == "" || m.Tag == "" {
+			return fmt.Errorf("action modifier requires id and tag")
+		}
+		if seen[m.ID] {
+			return fmt.Errorf("duplicate action modifier id: %s", m.ID)
+		}
+		seen[m.ID] = true
+
+		if m.Prompt != "" {
+			if _, ok := prompts[m.Prompt]; !ok {
+				return fmt.Errorf("action modifier '%s' references unknown prompt '%s'", m.ID, m.Prompt)
+			}
+		}
+		if m.ForcedOutcome != "" && !isValidOutcome(m.ForcedOutcome) {
+			return fmt.Errorf("action modifier '%s' has invalid forced_outcome '%s'", m.ID, m.ForcedOutcome)
+		}
+		if m.OnDenied != "" && m.OnDenied != "strip" && m.OnDenied != "reject" {
+			return fmt.Errorf("action modifier '%s' has invalid on_denied '%s'", m.ID, m.OnDenied)
+		}
+	}
+	return nil
+}
+
+// LoadActionModifierSettings 从数据库加载管理员对动作修饰器的开关设置
+func (gc *GameController) LoadActionModifierSettings() {
+	gc.modifierMutex.Lock()
+	defer gc.modifierMutex.Unlock()
+
+	var configs []models.SystemConfig
+	if err := config.DB.Where("key LIKE ?", actionModifierConfigPrefix+"%").Find(&configs).Error; err != nil {
+		fmt.Printf("[动作修饰器] 加载开关设置失败: %v\n", err)
+		return
+	}
+
+	gc.modifierSettings = make(map[string]map[string]bool)
+	for _, conf := range configs {
+		modID := strings.TrimPrefix(conf.Key, actionModifierConfigPrefix)
+		settings := make(map[string]bool)
+		if err := json.Unmarshal([]byte(conf.Value), &settings); err != nil {
+			fmt.Printf("[动作修饰器] 解析 %s 失败: %v\n", conf.Key, err)
+			continue
+		}
+		gc.modifierSettings[modID] = settings
+	}
+}
+
+// IsActionModifierEnabled 管理员设置优先，否则使用mod配置的默认值
+func (gc *GameController) IsActionModifierEnabled(modID string, modifier *ActionModifierConfig) bool {
+	gc.modifierMutex.RLock()
+	defer gc.modifierMutex.RUnlock()
+
+	if settings, ok := gc.modifierSettings[modID]; ok {
+		if enabled, ok := settings[modifier.ID]; ok {
+			return enabled
+		}
+	}
+	return modifier.IsEnabledByDefault()
+}
+
+// SetActionModifierEnabled 设置动作修饰器开关并持久化（由管理员API调用）
+func (gc *GameController) SetActionModifierEnabled(modID, modifierID string, enabled bool) error {
+	gc.modifierMutex.Lock()
+	defer gc.modifierMutex.Unlock()
+
+	settings := make(map[string]bool)
+	for id, value := range gc.modifierSettings[modID] {
+		settings[id] = value
+	}
+	settings[modifierID] = enabled
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	key := actionModifierConfigPrefix + modID
+	var conf models.SystemConfig
+	if err := config.DB.Where("key = ?", key).First(&conf).Error; err != nil {
+		conf = models.SystemConfig{Key: key, Value: string(data)}
+		if err := config.DB.Create(&conf).Error; err != nil {
+			return err
+		}
+	} else {
+		conf.Value = string(data)
+		if err := config.DB.Save(&conf).Error; err != nil {
+			return err
+		}
+	}
+
+	gc.modifierSettings[modID] = settings
+
+	status := "启用"
+	if !enabled {
+		status = "禁用"
+	}
+	fmt.Printf("[动作修饰器] 游戏 %s 的修饰器 %s 已%s\n", modID, modifierID, status)
+	return nil
+}
+
+// modifierPermission 检查玩家是否可以使用需要权限的修饰器，返回是否允许及原因
+func modifierPermission(session *GameSession) (bool, string) {
+	if session.DebugSession {
+		return true, "debug_session"
+	}
+
+	userID, err := strconv.ParseUint(session.PlayerID, 10, 32)
+	if err != nil {
+		return false, "denied"
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil {
+		return false, "denied"
+	}
+	if user.IsAdmin {
+		return true, "admin"
+	}
+	if user.CanUseCheats {
+		return true, "user_permission"
+	}
+	return false, "denied"
+}
+
+// recordModifierUse 记录一次修饰器使用（包括被拒绝的尝试）
+func recordModifierUse(session *GameSession, modifier *ActionModifierConfig, action string, allowed bool, reason string) {
+	userID, _ := strconv.ParseUint(session.PlayerID, 10, 32)
+	entry := models.ActionModifierLog{
+		UserID:     uint(userID),
+		ModID:      session.ModID,
+		ModifierID: modifier.ID,
+		Action:     action,
+		Allowed:    allowed,
+		Reason:     reason,
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		fmt.Printf("[动作修饰器] 记录使用日志失败: %v\n", err)
+	}
+
+	// 作弊类（需要权限）和强制判定结果（如燃魂）的修饰器同时写入审计日志
+	if !modifier.RequiresPermission && modifier.ForcedOutcome == "" {
+		return
+	}
+	auditAction := services.AuditModifierUse
+	if !allowed {
+		auditAction = services.AuditModifierDenied
+	}
+	detail, _ := json.Marshal(map[string]interface{}{
+		"modifier_id": modifier.ID,
+		"reason":      reason,
+		"action":      action,
+	})
+	services.RecordAudit(models.AuditEvent{
+		ActorID:    uint(userID),
+		Action:     auditAction,
+		TargetType: "mod",
+		TargetID:   session.ModID,
+		Detail:     string(detail),
+		IP:         session.ClientIP,
+	}, nil)
+}
+
+// activateActionModifiers 检测行动中的触发标记并激活对应修饰器，返回去除标记后的行动
+// 玩家无权使用且修饰器声明 on_denied=reject 时返回错误
+func (gc *GameController) activateActionModifiers(session *GameSession, mod *GameMod, action string, customAttributes map[string]interface{}) (string, []string, error) {
+	var activated []string
+
+	for i := range mod.Config.ActionModifiers {
+		modifier := &mod.Config.ActionModifiers[i]
+		if !strings.Contains(action, modifier.Tag) {
+			continue
+		}
+
+		action = strings.TrimSpace(strings.ReplaceAll(action, modifier.Tag, ""))
+
+		if !gc.IsActionModifierEnabled(mod.Config.GameID, modifier) {
+			fmt.Printf("[动作修饰器] %s 已被禁用，忽略标记 %s\n", modifier.ID, modifier.Tag)
+			continue
+		}
+
+		reason := "public"
+		if modifier.RequiresPermission {
+			var allowed bool
+			allowed, reason = modifierPermission(session)
+			if !allowed {
+				recordModifierUse(session, modifier, action, false, reason)
+				fmt.Printf("[动作修饰器] 玩家 %s 无权使用 %s\n", session.PlayerID, modifier.ID)
+				if modifier.OnDenied == "reject" {
+					return action, activated, fmt.Errorf("无权使用%s", modifier.Name)
+				}
+				continue
+			}
+		}
+		recordModifierUse(session, modifier, action, true, reason)
+
+		if modifier.StateFlag != "" {
+			session.State[modifier.StateFlag] = true
+		}
+		if modifier.ForcedOutcome != "" {
+			session.State["forced_outcome"] = modifier.ForcedOutcome
+		}
+
+		if modifier.Penalty != nil {
+			actionContent := action
+			if modifier.Penalty.ContentAttribute != "" && customAttributes != nil {
+				if content, ok := customAttributes[modifier.Penalty.ContentAttribute].(string); ok && content != "" {
+					actionContent = content
+				}
+			}
+
+			if entry := modifier.Penalty.pick(actionContent, session.nextRollRNG()); entry != nil {
+				modifier.Penalty.apply(session.State, entry)
+				fmt.Printf("[动作修饰器] %s 代价：%s\n", modifier.ID, entry.Text)
+			}
+		}
+
+		activated = append(activated, modifier.ID)
+		fmt.Printf("[动作修饰器] 检测到 %s 标记，已激活 %s\n", modifier.Tag, modifier.ID)
+	}
+
+	return action, activated, nil
+}
+
+// activeModifierPrompt 返回当前生效的修饰器覆盖提示词（按声明顺序取第一个）
+func activeModifierPrompt(session *GameSession, mod *GameMod) (*ActionModifierConfig, string) {
+	for i := range mod.Config.ActionModifiers {
+		modifier := &mod.Config.ActionModifiers[i]
+		if modifier.StateFlag == "" || modifier.Prompt == "" {
+			continue
+		}
+		if active, ok := session.State[modifier.StateFlag].(bool); ok && active {
+			return modifier, mod.Prompts[modifier.Prompt]
+		}
+	}
+	return nil, ""
+}
+
+// clearActionModifiers 清除所有修饰器标志（强制判定消耗后调用）
+func clearActionModifiers(session *GameSession, mod *GameMod) {
+	delete(session.State, "forced_outcome")
+	for _, modifier := range mod.Config.ActionModifiers {
+		if modifier.StateFlag != "" {
+			delete(session.State, modifier.StateFlag)
+		}
+	}
+}
+
+// pick 根据玩家要求匹配代价层级，并按权重抽取一条代价
+func (p *ModifierPenalty) pick(actionContent string, rng *rand.Rand) *PenaltyEntry {
+	content := strings.ToLower(actionContent)
+
+	var tier *PenaltyTier
+	var fallback *PenaltyTier
+	for i := range p.Tiers {
+		t := &p.Tiers[i]
+		if len(t.Keywords) == 0 {
+			if fallback == nil {
+				fallback = t
+			}
+			continue
+		}
+		for _, keyword := range t.Keywords {
+			if strings.Contains(content, keyword) {
+				tier = t
+				break
+			}
+		}
+		if tier != nil {
+			break
+		}
+	}
+	if tier == nil {
+		tier = fallback
+	}
+	if tier == nil || len(tier.Entries) == 0 {
+		return nil
+	}
+
+	total := 0
+	for _, entry := range tier.Entries {
+		total += entry.weight()
+	}
+	roll := rng.Intn(total)
+	for i := range tier.Entries {
+		roll -= tier.Entries[i].weight()
+		if roll < 0 {
+			return &tier.Entries[i]
+		}
+	}
+	return &tier.Entries[len(tier.Entries)-1]
+}
+
+// apply 记录代价并应用其状态效果
+func (p *ModifierPenalty) apply(state map[string]interface{}, entry *PenaltyEntry) {
+	if p.RecordKey != "" {
+		records, _ := state[p.RecordKey].([]interface{})
+		state[p.RecordKey] = append(records, entry.Text)
+	}
+
+	for _, effect := range entry.Effects {
+		effect.apply(state)
+	}
+	for _, effect := range p.Effects {
+		effect.apply(state)
+	}
+}
+
+func (e PenaltyEntry) weight() int {
+	if e.Weight <= 0 {
+		return 1
+	}
+	return e.Weight
+}
+
+// apply 将状态效果写入state，目标路径的父节点不存在时跳过
+func (e StateEffect) apply(state map[string]interface{}) {
+	keys := splitPath(e.Path)
+	if len(keys) == 0 {
+		return
+	}
+	if len(keys) > 1 {
+		parent, ok := getNestedValue(state, strings.Join(keys[:len(keys)-1], "."))
+		if _, isMap := parent.(map[string]interface{}); !ok || !isMap {
+			return
+		}
+	}
+
+	current, exists := getNestedValue(state, e.Path)
+
+	switch e.Op {
+	case "set":
+		setNestedValue(state, e.Path, e.Value)
+	case "add", "multiply":
+		value, ok := e.Value.(float64)
+		number, isNumber := current.(float64)
+		if !ok || !exists || !isNumber {
+			return
+		}
+		if e.Op == "add" {
+			setNestedValue(state, e.Path, number+value)
+		} else {
+			setNestedValue(state, e.Path, number*value)
+		}
+	case "step":
+		level, isString := current.(string)
+		if !exists || !isString {
+			return
+		}
+		steps := 1
+		if value, ok := e.Value.(float64); ok {
+			steps = int(value)
+		}
+		for i, name := range e.Ladder {
+			if name == level {
+				next := i + steps
+				if next < 0 {
+					next = 0
+				}
+				if next >= len(e.Ladder) {
+					next = len(e.Ladder) - 1
+				}
+				setNestedValue(state, e.Path, e.Ladder[next])
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package game_engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// 世界时钟：
+// MOD在 game_config.calendar 中启用后，世界时间保存在 State["world_clock"] 中，只由程序推进。
+// AI在回合JSON的 time_advance 中声明本回合经过的时间，在 schedule_events 中安排未来的事件；
+// 时间推进时年龄随之增长，年龄达到寿元上限时触发寿元耗尽结局。到来的事件在下一回合注入提示词。
+
+const worldClockStateKey = "world_clock"
+
+const (
+	defaultMonthsPerYear  = 12
+	defaultDaysPerMonth   = 30
+	defaultLifespanEnding = "寿元耗尽"
+	upcomingEventLimit    = 3
+)
+
+// CalendarConfig MOD的历法配置
+type CalendarConfig struct {
+	Enabled        bool                  `json:"enabled"`
+	Era            string                `json:"era"`             // 纪年名称，如 人祖历
+	StartYear      int                   `json:"start_year"`      // 游戏开始时的年份，从该年1月1日开始计时
+	MonthsPerYear  int                   `json:"months_per_year"` // 默认12
+	DaysPerMonth   int                   `json:"days_per_month"`  // 默认30
+	Seasons        []SeasonConfig        `json:"seasons"`
+	AgePath        string                `json:"age_path"`        // 年龄（岁）所在的状态路径，随时间增长
+	LifespanPath   string                `json:"lifespan_path"`   // 寿元上限（岁）所在的状态路径
+	LifespanEnding string                `json:"lifespan_ending"` // 寿元耗尽时的结局名称
+	Events         []CalendarEventConfig `json:"events"`          // 固定日期的世界事件
+}
+
+// SeasonConfig 季节及其叙事背景
+type SeasonConfig struct {
+	Name    string `json:"name"`
+	Months  []int  `json:"months"`
+	Context string `json:"context,omitempty"`
+}
+
+// CalendarEventConfig 固定日期的世界事件
+type CalendarEventConfig struct {
+	Title string `json:"title"`
+	Year  int    `json:"year"`
+	Month int    `json:"month"`
+	Day   int    `json:"day"`
+	Note  string `json:"note,omitempty"`
+}
+
+// WorldClock 会话的世界时钟
+type WorldClock struct {
+	Day      int              `json:"day"`                 // 自起始日期经过的天数
+	Date     string           `json:"date,omitempty"`      // 当前日期的显示文本，供前端展示
+	BirthDay *int             `json:"birth_day,omitempty"` // 角色出生时的 Day，由年龄推算
+	Events   []ScheduledEvent `json:"events,omitempty"`    // 尚未到来的事件
+	Due      []ScheduledEvent `json:"due,omitempty"`       // 上一次时间推进中到来的事件
+}
+
+// ScheduledEvent 安排在某一天的事件
+type ScheduledEvent struct {
+	Title string `json:"title"`
+	Day   int    `json:"day"`
+	Note  string `json:"note,omitempty"`
+}
+
+// TimeAdvance AI输出的时间推进
+type TimeAdvance struct {
+	Years  int `json:"years,omitempty"`
+	Months int `json:"months,omitempty"`
+	Days   int `json:"days,omitempty"`
+}
+
+// EventSchedule AI输出的未来事件
+type EventSchedule struct {
+	Title  string `json:"title"`
+	InDays int    `json:"in_days"`
+	Note   string `json:"note,omitempty"`
+}
+
+const calendarOutputInstruction = `【时间推进】本回合经过的时间必须在输出的JSON中用 time_advance 声明，如 "time_advance": {"days": 3}（可用 years、months、days），没有经过时间时省略；不要在 state_update 中修改 world_clock。
+需要安排未来发生的事件时附加 "schedule_events": [{"title": "事件", "in_days": 天数, "note": "说明"}]。
+`
+
+func (cfg *CalendarConfig) enabled() bool {
+	return cfg != nil && cfg.Enabled
+}
+
+func (cfg *CalendarConfig) monthsPerYear() int {
+	if cfg.MonthsPerYear > 0 {
+		return cfg.MonthsPerYear
+	}
+	return defaultMonthsPerYear
+}
+
+func (cfg *CalendarConfig) daysPerMonth() int {
+	if cfg.DaysPerMonth > 0 {
+		return cfg.DaysPerMonth
+	}
+	return defaultDaysPerMonth
+}
+
+func (cfg *CalendarConfig) daysPerYear() int {
+	return cfg.monthsPerYear() * cfg.daysPerMonth()
+}
+
+func (cfg *CalendarConfig) lifespanEnding() string {
+	if cfg.LifespanEnding != "" {
+		return cfg.LifespanEnding
+	}
+	return defaultLifespanEnding
+}
+
+// date 将天数换算为年、月、日
+func (cfg *CalendarConfig) date(day int) (year, month, dayOfMonth int) {
+	perYear, perMonth := cfg.daysPerYear(), cfg.daysPerMonth()
+	year = cfg.StartYear + day/perYear
+	month = day%perYear/perMonth + 1
+	dayOfMonth = day%perMonth + 1
+	return year, month, dayOfMonth
+}
+
+// dayOf 将年、月、日换算为天数
+func (cfg *CalendarConfig) dayOf(year, month, dayOfMonth int) int {
+	return (year-cfg.StartYear)*cfg.daysPerYear() + (month-1)*cfg.daysPerMonth() + dayOfMonth - 1
+}
+
+func (cfg *CalendarConfig) formatDate(day int) string {
+	year, month, dayOfMonth := cfg.date(day)
+	return fmt.Sprintf("%s%d年%d月%d日", cfg.Era, year, month, dayOfMonth)
+}
+
+// season 当前月份所在的季节
+func (cfg *CalendarConfig) season(day int) *SeasonConfig {
+	_, month, _ := cfg.date(day)
+	for i := range cfg.Seasons {
+		for _, m := range cfg.Seasons[i].Months {
+			if m == month {
+				return &cfg.Seasons[i]
+			}
+		}
+	}
+	return nil
+}
+
+func validateCalendarConfig(cfg CalendarConfig) error {
+	if cfg.MonthsPerYear < 0 || cfg.DaysPerMonth < 0 {
+		return fmt.Errorf("months_per_year and days_per_month must not be negative")
+	}
+	for _, season := range cfg.Seasons {
+		for _, month := range season.Months {
+			if month < 1 || month > cfg.monthsPerYear() {
+				return fmt.Errorf("season %s: invalid month %d", season.Name, month)
+			}
+		}
+	}
+	for _, event := range cfg.Events {
+		if event.Title == "" {
+			return fmt.Errorf("event title is required")
+		}
+		if event.Month < 1 || event.Month > cfg.monthsPerYear() || event.Day < 1 || event.Day > cfg.daysPerMonth() {
+			return fmt.Errorf("event %s: invalid date", event.Title)
+		}
+		if cfg.dayOf(event.Year, event.Month, event.Day) < 0 {
+			return fmt.Errorf("event %s: date is before start_year", event.Title)
+		}
+	}
+	return nil
+}
+
+// loadWorldClock 从状态中读取世界时钟，尚未开始计时时按配置创建
+func loadWorldClock(cfg *CalendarConfig, state map[string]interface{}) *WorldClock {
+	if raw, exists := state[worldClockStateKey]; exists && raw != nil {
+		if data, err := json.Marshal(raw); err == nil {
+			var clock WorldClock
+			if err := json.Unmarshal(data, &clock); err == nil {
+				return &clock
+			}
+			fmt.Printf("[世界时钟] 状态中的时钟无法解析，重新开始计时: %v\n", err)
+		}
+	}
+
+	clock := &WorldClock{}
+	for _, event := range cfg.Events {
+		clock.Events = append(clock.Events, ScheduledEvent{
+			Title: event.Title,
+			Day:   cfg.dayOf(event.Year, event.Month, event.Day),
+			Note:  event.Note,
+		})
+	}
+	clock.sortEvents()
+	return clock
+}
+
+// storeWorldClock 把时钟写回状态，保存为普通JSON值以便状态复制和比较
+func storeWorldClock(state map[string]interface{}, clock *WorldClock) {
+	data, err := json.Marshal(clock)
+	if err != nil {
+		return
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return
+	}
+	state[worldClockStateKey] = generic
+}
+
+func (c *WorldClock) sortEvents() {
+	sort.SliceStable(c.Events, func(i, j int) bool { return c.Events[i].Day < c.Events[j].Day })
+}
+
+// advance 推进时间，到来的事件移入 Due
+func (c *WorldClock) advance(days int) {
+	c.Day += days
+	var pending []ScheduledEvent
+	for _, event := range c.Events {
+		if event.Day <= c.Day {
+			c.Due = append(c.Due, event)
+		} else {
+			pending = append(pending, event)
+		}
+	}
+	c.Events = pending
+}
+
+// parseTimeAdvance 解析回合JSON中的 time_advance，返回经过的天数
+func parseTimeAdvance(cfg *CalendarConfig, raw interface{}) (int, error) {
+	if raw == nil {
+		return 0, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return 0, err
+	}
+	var advance TimeAdvance
+	if err := json.Unmarshal(data, &advance); err != nil {
+		return 0, fmt.Errorf("time_advance 格式错误，应为 {\"years\": 整数, \"months\": 整数, \"days\": 整数}")
+	}
+	if advance.Years < 0 || advance.Months < 0 || advance.Days < 0 {
+		return 0, fmt.Errorf("time_advance 不能为负数，时间不会倒流")
+	}
+	return advance.Years*cfg.daysPerYear() + advance.Months*cfg.daysPerMonth() + advance.Days, nil
+}
+
+// parseEventSchedules 解析回合JSON中的 schedule_events
+func parseEventSchedules(raw interface{}) ([]EventSchedule, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var schedules []EventSchedule
+	if err := json.Unmarshal(data, &schedules); err != nil {
+		return nil, fmt.Errorf("schedule_events 格式错误，应为事件数组且 in_days 为整数")
+	}
+	for i, schedule := range schedules {
+		if strings.TrimSpace(schedule.Title) == "" {
+			return nil, fmt.Errorf("schedule_events[%d]：缺少事件标题", i)
+		}
+		if schedule.InDays < 1 {
+			return nil, fmt.Errorf("schedule_events[%d]：in_days 必须大于0", i)
+		}
+	}
+	return schedules, nil
+}
+
+// calendarConfig MOD的历法配置，mod 为nil时返回nil
+func (mod *GameMod) calendarConfig() *CalendarConfig {
+	if mod == nil {
+		return nil
+	}
+	return &mod.Config.GameConfig.Calendar
+}
+
+// turnWorldClock 按回合JSON推进时钟并更新年龄，state 应是已应用本回合 state_update 的状态。
+// 每回合都会清空上一回合注入过的到期事件，MOD未启用历法时返回nil
+func (mod *GameMod) turnWorldClock(state, parsed map[string]interface{}) (*WorldClock, error) {
+	cfg := mod.calendarConfig()
+	if !cfg.enabled() {
+		return nil, nil
+	}
+	days, err := parseTimeAdvance(cfg, parsed["time_advance"])
+	if err != nil {
+		return nil, err
+	}
+	schedules, err := parseEventSchedules(parsed["schedule_events"])
+	if err != nil {
+		return nil, err
+	}
+
+	clock := loadWorldClock(cfg, state)
+	clock.Due = nil
+
+	// 以当前年龄反推出生日，本回合AI改写了年龄（如转世）时重新推算
+	perYear := cfg.daysPerYear()
+	var age float64
+	hasAge := false
+	if cfg.AgePath != "" {
+		if value, exists := getNestedValue(state, cfg.AgePath); exists {
+			age, hasAge = numericValue(value)
+		}
+	}
+	if hasAge && (clock.BirthDay == nil || (clock.Day-*clock.BirthDay)/perYear != int(age)) {
+		birthDay := clock.Day - int(age)*perYear
+		clock.BirthDay = &birthDay
+	}
+
+	for _, schedule := range schedules {
+		clock.Events = append(clock.Events, ScheduledEvent{
+			Title: strings.TrimSpace(schedule.Title),
+			Day:   clock.Day + schedule.InDays,
+			Note:  schedule.Note,
+		})
+	}
+	clock.sortEvents()
+	clock.advance(days)
+	clock.Date = cfg.formatDate(clock.Day)
+
+	if hasAge {
+		if newAge := (clock.Day - *clock.BirthDay) / perYear; newAge != int(age) {
+			setNestedValue(state, cfg.AgePath, float64(newAge))
+		}
+	}
+	return clock, nil
+}
+
+// lifespanExpired 年龄是否已达到寿元上限
+func (mod *GameMod) lifespanExpired(state map[string]interface{}) bool {
+	cfg := mod.calendarConfig()
+	if !cfg.enabled() || cfg.AgePath == "" || cfg.LifespanPath == "" {
+		return false
+	}
+	ageValue, hasAge := getNestedValue(state, cfg.AgePath)
+	lifespanValue, hasLifespan := getNestedValue(state, cfg.LifespanPath)
+	if !hasAge || !hasLifespan {
+		return false
+	}
+	age, ok1 := numericValue(ageValue)
+	lifespan, ok2 := numericValue(lifespanValue)
+	return ok1 && ok2 && age >= lifespan
+}
+
+// applyWorldClock 推进会话的世界时钟，年龄达到寿元上限时结束本次试炼
+func (gc *GameController) applyWorldClock(session *GameSession, parsed map[string]interface{}, mod *GameMod) {
+	clock, err := mod.turnWorldClock(session.State, parsed)
+	if err != nil {
+		// 已在一致性检查中校验过，这里只记录意外的失败
+		fmt.Printf("[世界时钟] 时间推进未应用: %v\n", err)
+		return
+	}
+	if clock == nil {
+		return
+	}
+	storeWorldClock(session.State, clock)
+
+	if inTrial, _ := session.State["is_in_trial"].(bool); inTrial && mod.lifespanExpired(session.State) {
+		cfg := mod.calendarConfig()
+		session.State["is_in_trial"] = false
+		session.State["is_processing"] = false
+		message := fmt.Sprintf("\n\n【%s】%s，大限已至，此世修行就此终结。", cfg.lifespanEnding(), cfg.formatDate(clock.Day))
+		session.DisplayHistory = append(session.DisplayHistory, message)
+		fmt.Printf("[世界时钟] 玩家 %s 寿元耗尽，试炼结束\n", session.PlayerID)
+	}
+}
+
+// calendarContext 注入提示词的当前世界时间、季节、年龄寿元和事件
+func (mod *GameMod) calendarContext(state map[string]interface{}) string {
+	cfg := mod.calendarConfig()
+	if !cfg.enabled() {
+		return ""
+	}
+	clock := loadWorldClock(cfg, state)
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("【天时】当前时间：%s", cfg.formatDate(clock.Day)))
+	if season := cfg.season(clock.Day); season != nil {
+		b.WriteString(fmt.Sprintf("，%s", season.Name))
+		if season.Context != "" {
+			b.WriteString("。" + season.Context)
+		}
+	}
+	b.WriteString("\n")
+
+	if cfg.AgePath != "" && cfg.LifespanPath != "" {
+		ageValue, hasAge := getNestedValue(state, cfg.AgePath)
+		lifespanValue, hasLifespan := getNestedValue(state, cfg.LifespanPath)
+		age, ok1 := numericValue(ageValue)
+		lifespan, ok2 := numericValue(lifespanValue)
+		if hasAge && hasLifespan && ok1 && ok2 {
+			b.WriteString(fmt.Sprintf("年龄：%v岁，寿元上限：%v岁（剩余约%v年）\n", age, lifespan, math.Max(0, lifespan-age)))
+		} else {
+			b.WriteString(fmt.Sprintf("角色的年龄和寿元尚未设定，请在 state_update 中设置 %s（岁）和 %s（寿元上限，岁）\n", cfg.AgePath, cfg.LifespanPath))
+		}
+	}
+
+	if len(clock.Due) > 0 {
+		b.WriteString("【到期事件】以下事件已经到来，请在本回合叙事中体现：\n")
+		for _, event := range clock.Due {
+			b.WriteString(describeEvent(cfg, event))
+		}
+	}
+	if len(clock.Events) > 0 {
+		b.WriteString("【即将发生】\n")
+		for i, event := range clock.Events {
+			if i >= upcomingEventLimit {
+				break
+			}
+			b.WriteString(strings.TrimSuffix(describeEvent(cfg, event), "\n"))
+			b.WriteString(fmt.Sprintf("（还有%d天）\n", event.Day-clock.Day))
+		}
+	}
+
+	b.WriteString("\n" + calendarOutputInstruction)
+	return b.String()
+}
+
+func describeEvent(cfg *CalendarConfig, event ScheduledEvent) string {
+	line := fmt.Sprintf("- %s：%s", cfg.formatDate(event.Day), event.Title)
+	if event.Note != "" {
+		line += "，" + event.Note
+	}
+	return line + "\n"
+}
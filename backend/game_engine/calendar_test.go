@@ -0,0 +1,120 @@
+package game_engine
+
+import (
+	"AIGE/services"
+	"strings"
+	"testing"
+)
+
+func testCalendarConfig() CalendarConfig {
+	return CalendarConfig{
+		Enabled:      true,
+		Era:          "人祖历",
+		StartYear:    100,
+		Seasons:      []SeasonConfig{{Name: "春", Months: []int{1, 2, 3}, Context: "万物复苏"}, {Name: "夏", Months: []int{4, 5, 6}}},
+		AgePath:      "current_life.年龄",
+		LifespanPath: "current_life.寿元",
+		Events:       []CalendarEventConfig{{Title: "开窍大典", Year: 100, Month: 2, Day: 1}},
+	}
+}
+
+func TestWorldClockAdvance(t *testing.T) {
+	mod := &GameMod{}
+	mod.Config.GameConfig.Calendar = testCalendarConfig()
+	state := map[string]interface{}{"current_life": map[string]interface{}{"年龄": float64(15), "寿元": float64(100)}}
+
+	clock, err := mod.turnWorldClock(state, map[string]interface{}{
+		"time_advance":    map[string]interface{}{"months": 1, "days": 5},
+		"schedule_events": []interface{}{map[string]interface{}{"title": "族比", "in_days": 40}},
+	})
+	if err != nil {
+		t.Fatalf("Expected time to advance, got %v", err)
+	}
+	storeWorldClock(state, clock)
+	if clock.Day != 35 || len(clock.Due) != 1 || clock.Due[0].Title != "开窍大典" {
+		t.Errorf("Expected 开窍大典 to be due on day 35, got %+v", clock)
+	}
+	if len(clock.Events) != 1 || clock.Events[0].Day != 40 {
+		t.Errorf("Expected 族比 to stay pending, got %+v", clock.Events)
+	}
+	context := mod.calendarContext(state)
+	for _, want := range []string{"人祖历100年2月6日，春。万物复苏", "开窍大典", "族比（还有5天）", "年龄：15岁，寿元上限：100岁（剩余约85年）"} {
+		if !strings.Contains(context, want) {
+			t.Errorf("Expected calendar context to contain %q, got:\n%s", want, context)
+		}
+	}
+
+	clock, err = mod.turnWorldClock(state, map[string]interface{}{"time_advance": map[string]interface{}{"years": 1}})
+	if err != nil {
+		t.Fatalf("Expected time to advance, got %v", err)
+	}
+	if len(clock.Due) != 1 || clock.Due[0].Title != "族比" {
+		t.Errorf("Expected previous due events to be cleared and 族比 to be due, got %+v", clock.Due)
+	}
+	if age, _ := getNestedValue(state, "current_life.年龄"); age != float64(16) {
+		t.Errorf("Expected age 16 after one year, got %v", age)
+	}
+
+	for _, parsed := range []map[string]interface{}{
+		{"time_advance": map[string]interface{}{"days": -3}},
+		{"time_advance": "三天"},
+		{"schedule_events": []interface{}{map[string]interface{}{"title": "拍卖会", "in_days": 0}}},
+	} {
+		if _, err := mod.turnWorldClock(state, parsed); err == nil {
+			t.Errorf("Expected %v to be rejected", parsed)
+		}
+	}
+}
+
+func TestLifespanExpiryEndsTrial(t *testing.T) {
+	mock := services.NewMockClient(services.MockConfig{
+		Responses: []services.MockResponse{{Content: `$你闭关苦修，不知岁月。$@{"time_advance":{"years":2}}@`}},
+	})
+	gc, sm := newMockGame(t, mock)
+	mod, _ := gc.modLoader.GetMod("test")
+	mod.Config.GameConfig.Calendar = testCalendarConfig()
+	session, _ := sm.GetSession("1", "test")
+	session.State["is_in_trial"] = true
+	session.State["current_life"] = map[string]interface{}{"年龄": float64(79), "寿元": float64(80)}
+
+	err := gc.ProcessActionStreamWithAttributes("1", "test", "闭关", nil,
+		func(string) error { return nil }, nil, func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("Expected turn to succeed, got %v", err)
+	}
+	if inTrial, _ := session.State["is_in_trial"].(bool); inTrial {
+		t.Errorf("Expected trial to end when lifespan is exhausted")
+	}
+	if last := session.DisplayHistory[len(session.DisplayHistory)-1]; !strings.Contains(last, "【寿元耗尽】") {
+		t.Errorf("Expected lifespan ending message, got %q", last)
+	}
+	if age, _ := getNestedValue(session.State, "current_life.年龄"); age != float64(81) {
+		t.Errorf("Expected age 81, got %v", age)
+	}
+}
+
+func TestNegativeTimeAdvanceRegenerates(t *testing.T) {
+	mock := services.NewMockClient(services.MockConfig{
+		Responses: []services.MockResponse{
+			{Content: `$岁月倒流。$@{"time_advance":{"years":-1}}@`},
+			{Content: `$你闭关一年。$@{"time_advance":{"years":1}}@`},
+		},
+	})
+	gc, sm := newMockGame(t, mock)
+	mod, _ := gc.modLoader.GetMod("test")
+	mod.Config.GameConfig.Calendar = testCalendarConfig()
+	session, _ := sm.GetSession("1", "test")
+	session.State["current_life"] = map[string]interface{}{"年龄": float64(15), "寿元": float64(100)}
+
+	err := gc.ProcessActionStreamWithAttributes("1", "test", "闭关", nil,
+		func(string) error { return nil }, nil, func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("Expected corrected response to be accepted, got %v", err)
+	}
+	if calls := mock.Calls(); len(calls) != 2 {
+		t.Fatalf("Expected negative time_advance to be regenerated, got %d calls", len(calls))
+	}
+	if age, _ := getNestedValue(session.State, "current_life.年龄"); age != float64(16) {
+		t.Errorf("Expected only the corrected advance to apply, got age %v", age)
+	}
+}
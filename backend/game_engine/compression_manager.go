@@ -2,25 +2,40 @@ package game_engine
 
 import (
 	"AIGE/services"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 )
 
 type CompressionManager struct {
-	aiClient           *services.AIClient
+	aiClient           services.LLMClient
 	stateManager       *StateManager    // 需要保存到数据库
 	gameController     *GameController  // 新增：获取AI配置
 	compressionInterval int // 15轮压缩一次
 	maxRecentHistory   int // 保留最近4条
+	arcInterval        int // 未并入梗概的章节达到该数量时重新总结梗概
+	retryAttempts      int           // 压缩失败时的最大尝试次数
+	retryBackoff       time.Duration // 首次重试等待时间，之后每次翻倍
+
+	// 每个会话同一时间只运行一个压缩任务，值表示运行期间是否又收到了压缩请求
+	jobsMu sync.Mutex
+	jobs   map[string]bool
+	wg     sync.WaitGroup
 }
 
-func NewCompressionManager(aiClient *services.AIClient, stateManager *StateManager) *CompressionManager {
+func NewCompressionManager(aiClient services.LLMClient, stateManager *StateManager) *CompressionManager {
 	return &CompressionManager{
 		aiClient:           aiClient,
 		stateManager:       stateManager,
 		gameController:     nil, // 稍后通过SetGameController设置
 		compressionInterval: 15,
 		maxRecentHistory:   4,
+		arcInterval:        4,
+		retryAttempts:      3,
+		retryBackoff:       2 * time.Second,
+		jobs:               make(map[string]bool),
 	}
 }
 
@@ -31,63 +46,143 @@ func (cm *CompressionManager) SetGameController(gc *GameController) {
 
 func (cm *CompressionManager) ProcessNewMessage(session *GameSession, userMsg, aiMsg Message) {
 	// 添加新对话到recent history
-	session.RecentHistory = append(session.RecentHistory, userMsg, aiMsg)
+	session.appendHistory(userMsg, aiMsg)
 	
-	fmt.Printf("[压缩检查] 当前历史记录数: %d, 压缩阈值: %d\n", len(session.RecentHistory), cm.compressionInterval)
+	history, _ := session.historySnapshot()
+	historyLen := len(history)
+	fmt.Printf("[压缩检查] 当前历史记录数: %d, 压缩阈值: %d\n", historyLen, cm.compressionInterval)
 	
 	// 检查是否需要压缩
-	if len(session.RecentHistory) >= cm.compressionInterval {
+	if historyLen >= cm.compressionInterval {
 		fmt.Printf("[压缩触发] 开始压缩历史记录...\n")
-		cm.compressAndCleanup(session)
+		cm.scheduleCompression(session)
 	}
 }
 
-func (cm *CompressionManager) compressAndCleanup(session *GameSession) {
-	// 准备压缩内容（保留最近4条，压缩其余）
-	toCompress := session.RecentHistory[:len(session.RecentHistory)-cm.maxRecentHistory]
-	toKeep := session.RecentHistory[len(session.RecentHistory)-cm.maxRecentHistory:]
+// Wait 等待所有压缩任务结束
+func (cm *CompressionManager) Wait() {
+	cm.wg.Wait()
+}
+
+// scheduleCompression 为会话安排压缩任务，已有任务运行时只标记需要再检查一次
+func (cm *CompressionManager) scheduleCompression(session *GameSession) {
+	key := session.PlayerID + "/" + session.ModID
+
+	cm.jobsMu.Lock()
+	if _, running := cm.jobs[key]; running {
+		cm.jobs[key] = true
+		cm.jobsMu.Unlock()
+		return
+	}
+	cm.jobs[key] = false
+	cm.wg.Add(1)
+	cm.jobsMu.Unlock()
+
+	go func() {
+		defer cm.wg.Done()
+		for {
+			if err := cm.compressAndCleanup(session); err != nil {
+				fmt.Printf("[压缩失败] %v\n", err)
+			}
+
+			cm.jobsMu.Lock()
+			if !cm.jobs[key] {
+				delete(cm.jobs, key)
+				cm.jobsMu.Unlock()
+				return
+			}
+			cm.jobs[key] = false
+			cm.jobsMu.Unlock()
+		}
+	}()
+}
+
+// compressAndCleanup 压缩保留最近几条之外的历史
+// AI调用期间不持有锁，完成后按序号水位合并，调用期间追加的消息不受影响；
+// 摘要与裁剪后的历史在同一次保存中写入，中途崩溃时存档里仍是完整的未压缩历史
+func (cm *CompressionManager) compressAndCleanup(session *GameSession) error {
+	toCompress, watermark, memory := session.compressionCandidates(cm.compressionInterval, cm.maxRecentHistory)
+	if len(toCompress) == 0 {
+		return nil
+	}
 	
-	fmt.Printf("[压缩详情] 待压缩消息数: %d, 保留消息数: %d\n", len(toCompress), len(toKeep))
+	fmt.Printf("[压缩详情] 待压缩消息数: %d, 压缩水位: %d\n", len(toCompress), watermark)
 	
 	// 构建压缩提示词
-	compressionPrompt := cm.buildCompressionPrompt(toCompress)
+	compressionPrompt := cm.buildCompressionPrompt(toCompress, memory.Facts)
+	var mod *GameMod
+	if cm.gameController != nil {
+		mod, _ = cm.gameController.modLoader.GetMod(session.ModID)
+	}
 	
-	// 异步压缩
-	go func() {
-		fmt.Printf("[压缩进行] 调用AI进行压缩...\n")
-		newSummary, err := cm.callAIForCompression(compressionPrompt)
-		if err != nil {
-			fmt.Printf("[压缩失败] %v\n", err)
-			return
+	fmt.Printf("[压缩进行] 调用AI进行压缩...\n")
+	response, err := cm.callWithRetry(mod, compressionPrompt)
+	if err != nil {
+		session.setCompressionError(err.Error())
+		return err
+	}
+	
+	chapterSummary, facts := parseCompressionResult(response, memory.Facts)
+	fmt.Printf("[压缩成功] 新章节摘要长度: %d 字符\n", len(chapterSummary))
+	memory.Chapters = append(memory.Chapters, ChapterSummary{
+		Index:     memory.nextChapterIndex(),
+		Summary:   chapterSummary,
+		FromSeq:   toCompress[0].Seq,
+		ToSeq:     watermark,
+		CreatedAt: time.Now(),
+	})
+	memory.Facts.mergeFacts(facts)
+	cm.foldChapters(mod, memory)
+	
+	// 会话已被重置或删除时丢弃结果，避免旧会话写回数据库
+	if !cm.stateManager.isCurrent(session) {
+		return fmt.Errorf("会话 %s/%s 已失效，丢弃压缩结果", session.PlayerID, session.ModID)
+	}
+	session.applyCompression(memory, watermark)
+	
+	// 重要：保存到数据库。只写历史和记忆，状态可能正被下一回合修改
+	if err := cm.stateManager.SaveHistory(session); err != nil {
+		return fmt.Errorf("保存压缩结果失败: %w", err)
+	}
+	fmt.Printf("[压缩完成] 已保存到数据库，压缩轮次: %d\n", session.CompressionRound)
+	return nil
+}
+
+// callWithRetry 调用AI压缩，失败时按指数退避重试
+func (cm *CompressionManager) callWithRetry(mod *GameMod, prompt string) (string, error) {
+	var lastErr error
+	backoff := cm.retryBackoff
+	for attempt := 1; attempt <= cm.retryAttempts; attempt++ {
+		summary, err := cm.callAIForCompression(mod, prompt)
+		if err == nil {
+			return summary, nil
 		}
-		
-		fmt.Printf("[压缩成功] 新摘要长度: %d 字符\n", len(newSummary))
-		
-		// 更新会话
-		session.CompressedSummary = cm.mergeSummaries(session.CompressedSummary, newSummary)
-		session.RecentHistory = toKeep
-		session.CompressionRound++
-		
-		// 重要：保存到数据库
-		if err := cm.stateManager.SaveSession(session); err != nil {
-			fmt.Printf("[压缩保存失败] %v\n", err)
-		} else {
-			fmt.Printf("[压缩完成] 已保存到数据库，压缩轮次: %d\n", session.CompressionRound)
+		lastErr = err
+		fmt.Printf("[压缩重试] 第%d次调用失败: %v\n", attempt, err)
+		if attempt < cm.retryAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
 		}
-	}()
+	}
+	return "", fmt.Errorf("压缩失败（已尝试%d次）: %w", cm.retryAttempts, lastErr)
 }
 
-func (cm *CompressionManager) buildCompressionPrompt(messages []Message) string {
-	return fmt.Sprintf(`你是游戏历史记录管理助手。请将以下对话历史压缩为简洁摘要：
+func (cm *CompressionManager) buildCompressionPrompt(messages []Message, facts FactsLedger) string {
+	factsJSON, _ := json.Marshal(facts)
+	return fmt.Sprintf(`你是游戏历史记录管理助手。请将以下对话历史压缩为一章摘要，并更新事实账本：
 
 🎯 压缩原则：
-- 【必须保留】角色的核心属性（姓名、性别、出身、身份、修为等）
-- 【必须保留】重要NPC的姓名和关系
 - 保留重要的游戏进展和状态变化
 - 保留关键的角色互动和决策
 - 保留影响游戏进程的重要事件
 - 保留玩家的重要成就和获得的物品/技能
-- 保留未完成的任务和目标
+
+📒 事实账本（输出更新后的完整账本）：
+- character：角色的核心属性（姓名、性别、出身、身份、修为等），键值对
+- npcs：重要NPC的姓名、与玩家的关系和备注
+- owned_gu：玩家当前拥有的蛊，失去的要移除
+- quests：未完成的任务和目标，已完成的要移除
+- locations：到过的重要地点，按到达顺序，最后一个为当前所在地
 
 ⚠️ 特别注意：
 - 绝对不要改变角色的性别描述
@@ -100,11 +195,33 @@ func (cm *CompressionManager) buildCompressionPrompt(messages []Message) string
 - 已解决的临时问题细节
 - 无关紧要的过渡性对话
 
+当前事实账本：
+%s
+
 需要压缩的对话：
 %s
 
-请用简洁的语言总结以上对话，突出关键的游戏进展（200字以内）：`,
-		cm.formatMessages(messages))
+请只输出JSON，格式如下（chapter_summary 200字以内）：
+{"chapter_summary": "...", "facts": {"character": {"姓名": "..."}, "npcs": [{"name": "...", "relation": "...", "notes": "..."}], "owned_gu": [], "quests": [], "locations": []}}`,
+		string(factsJSON), cm.formatMessages(messages))
+}
+
+// parseCompressionResult 解析压缩结果，不是合法JSON时把整段回复作为章节摘要，账本保持不变
+func parseCompressionResult(response string, current FactsLedger) (string, FactsLedger) {
+	var result struct {
+		ChapterSummary string       `json:"chapter_summary"`
+		Facts          *FactsLedger `json:"facts"`
+	}
+	if jsonStr := extractJSON(response); jsonStr != "" {
+		if err := json.Unmarshal([]byte(jsonStr), &result); err == nil && result.ChapterSummary != "" {
+			if result.Facts == nil {
+				return result.ChapterSummary, current
+			}
+			return result.ChapterSummary, *result.Facts
+		}
+	}
+	fmt.Printf("[压缩解析] 未能解析结构化结果，整段回复作为章节摘要\n")
+	return strings.TrimSpace(response), current
 }
 
 func (cm *CompressionManager) formatMessages(messages []Message) string {
@@ -123,7 +240,7 @@ func (cm *CompressionManager) formatMessages(messages []Message) string {
 	return formatted.String()
 }
 
-func (cm *CompressionManager) callAIForCompression(prompt string) (string, error) {
+func (cm *CompressionManager) callAIForCompression(mod *GameMod, prompt string) (string, error) {
 	// 构建压缩专用的消息
 	messages := []services.Message{
 		{Role: "user", Content: prompt},
@@ -135,79 +252,45 @@ func (cm *CompressionManager) callAIForCompression(prompt string) (string, error
 	}
 	
 	provider := cm.gameController.defaultProvider
-	if provider.APIKey == "" {
+	if provider.APIKey == "" && services.RequiresAPIKey(provider.APIType) {
 		return "", fmt.Errorf("AI provider not configured for compression")
 	}
 	
 	fmt.Printf("[压缩AI调用] 使用配置 - 类型:%s, 模型:%s\n", provider.APIType, provider.ModelID)
 	
 	// 调用AI进行压缩（使用与游戏相同的配置）
-	var response interface{}
-	var err error
-	
-	switch provider.APIType {
-	case "openai":
-		response, err = cm.aiClient.CallOpenAI(
-			provider.BaseURL,
-			provider.APIKey,
-			provider.ModelID, // 使用与游戏相同的模型
-			messages,
-			false, // 非流式
-		)
-	case "anthropic":
-		response, err = cm.aiClient.CallAnthropic(
-			provider.BaseURL,
-			provider.APIKey,
-			provider.ModelID,
-			messages,
-			false,
-		)
-	case "google":
-		response, err = cm.aiClient.CallGoogle(
-			provider.BaseURL,
-			provider.APIKey,
-			provider.ModelID,
-			messages,
-			false,
-		)
-	default:
-		return "", fmt.Errorf("unsupported API type for compression: %s", provider.APIType)
-	}
-	
+	response, err := cm.aiClient.Chat(provider.chatRequest(messages, mod.generationParams(PurposeCompression))) // 使用与游戏相同的模型，非流式
 	if err != nil {
 		return "", err
 	}
 	
-	if respMap, ok := response.(map[string]interface{}); ok {
-		if content, ok := respMap["content"].(string); ok {
-			return content, nil
-		}
-	}
-	
-	return "", fmt.Errorf("invalid compression response")
+	return response.Content, nil
 }
 
-func (cm *CompressionManager) mergeSummaries(oldSummary, newSummary string) string {
-	if oldSummary == "" {
-		return newSummary
+// foldChapters 未并入的章节达到 arcInterval 时与旧梗概一起重新总结，失败时保留章节等待下次
+func (cm *CompressionManager) foldChapters(mod *GameMod, memory *SessionMemory) {
+	pending := memory.pendingChapters()
+	if len(pending) < cm.arcInterval {
+		return
 	}
-	
-	// 如果已有摘要过长，进行二次压缩
-	if len(oldSummary) > 2000 {
-		mergePrompt := fmt.Sprintf(`请将以下两个历史摘要合并为一个简洁的总摘要：
 
-旧摘要：%s
+	var chapters strings.Builder
+	for _, chapter := range pending {
+		chapters.WriteString(fmt.Sprintf("第%d章：%s\n", chapter.Index, chapter.Summary))
+	}
+	arcPrompt := fmt.Sprintf(`请将以下故事梗概和新章节合并为一个新的故事梗概：
 
-新摘要：%s
+旧梗概：%s
 
-合并要求：保留最重要的信息，控制在300字以内。`, oldSummary, newSummary)
-		
-		merged, err := cm.callAIForCompression(mergePrompt)
-		if err != nil {
-			return oldSummary + "\n" + newSummary // 降级方案
-		}
-		return merged
+新章节：
+%s
+合并要求：按时间顺序保留主线剧情和重要转折，控制在400字以内，只输出梗概正文。`, memory.Arc, chapters.String())
+
+	arc, err := cm.callAIForCompression(mod, arcPrompt)
+	if err != nil {
+		fmt.Printf("[梗概更新失败] %v，保留章节摘要\n", err)
+		return
 	}
-	
-	return oldSummary + "\n" + newSummary
-}
\ No newline at end of file
+	memory.foldIntoArc(strings.TrimSpace(arc), pending[len(pending)-1].Index)
+	fmt.Printf("[梗概更新] 已并入第%d章，梗概长度: %d 字符\n", memory.ArcThrough, len(memory.Arc))
+}
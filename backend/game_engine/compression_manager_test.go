@@ -0,0 +1,155 @@
+package game_engine
+
+import (
+	"AIGE/services"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gatedClient 在 release 关闭前阻塞压缩调用，用于模拟AI调用期间有新回合写入
+type gatedClient struct {
+	*services.MockClient
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g *gatedClient) Chat(req services.ChatRequest) (*services.ChatResponse, error) {
+	g.started <- struct{}{}
+	<-g.release
+	return g.MockClient.Chat(req)
+}
+
+func turn(i int) (Message, Message) {
+	return Message{Role: "user", Content: fmt.Sprintf("行动%d", i)},
+		Message{Role: "assistant", Content: fmt.Sprintf("叙事%d", i)}
+}
+
+func TestCompressionKeepsTurnsAppendedDuringCompression(t *testing.T) {
+	mock := services.NewMockClient(services.MockConfig{Default: "摘要"})
+	gc, sm := newMockGame(t, mock)
+	gate := &gatedClient{MockClient: mock, started: make(chan struct{}, 1), release: make(chan struct{})}
+	gc.SetLLMClient(gate)
+	cm := gc.compressionManager
+	session, _ := sm.GetSession("1", "test")
+	sm.SaveSession(session)
+
+	for i := 1; i <= 8; i++ {
+		user, ai := turn(i)
+		cm.ProcessNewMessage(session, user, ai)
+	}
+	<-gate.started
+
+	// 压缩进行中追加两个回合
+	for i := 9; i <= 10; i++ {
+		user, ai := turn(i)
+		cm.ProcessNewMessage(session, user, ai)
+	}
+	// 压缩结果写入期间序列化完整会话（WebSocket full_state、GET /game/state）
+	marshalled := make(chan []byte)
+	go func() {
+		data, _ := json.Marshal(session)
+		marshalled <- data
+	}()
+	close(gate.release)
+	cm.Wait()
+	if data := <-marshalled; !strings.Contains(string(data), `"recent_history"`) || !strings.Contains(string(data), `"compressed_summary"`) {
+		t.Errorf("Expected marshalled session to include history fields, got %s", data)
+	}
+
+	history, memory := session.historySnapshot()
+	if memory.Text() != "第1章：摘要" || session.CompressionRound != 1 {
+		t.Fatalf("Expected one compression, got summary=%q round=%d", memory.Text(), session.CompressionRound)
+	}
+	if chapter := memory.Chapters[0]; chapter.FromSeq != 1 || chapter.ToSeq != 12 {
+		t.Errorf("Unexpected chapter range: %d-%d", chapter.FromSeq, chapter.ToSeq)
+	}
+	// 前16条中保留最近4条，加上压缩期间追加的4条
+	if len(history) != 8 || history[0].Content != "行动7" || history[len(history)-1].Content != "叙事10" {
+		t.Fatalf("Unexpected history after compression: %+v", history)
+	}
+
+	// 重新加载存档，摘要、水位与历史保持一致
+	reloaded, err := sm.loadFromDB("1", "test")
+	if err != nil {
+		t.Fatalf("Failed to reload session: %v", err)
+	}
+	if reloaded.CompressedThrough != 12 || len(reloaded.RecentHistory) != 8 || reloaded.HistorySeq != 20 ||
+		reloaded.Memory.Text() != memory.Text() {
+		t.Errorf("Unexpected reloaded session: through=%d history=%d seq=%d",
+			reloaded.CompressedThrough, len(reloaded.RecentHistory), reloaded.HistorySeq)
+	}
+}
+
+func TestCompressionSavesWhileTurnsRun(t *testing.T) {
+	mock := services.NewMockClient(services.MockConfig{Default: "摘要"})
+	gc, sm := newMockGame(t, mock)
+	gate := &gatedClient{MockClient: mock, started: make(chan struct{}, 1), release: make(chan struct{})}
+	gc.SetLLMClient(gate)
+	cm := gc.compressionManager
+	session, _ := sm.GetSession("1", "test")
+	sm.SaveSession(session)
+
+	for i := 1; i <= 8; i++ {
+		user, ai := turn(i)
+		cm.ProcessNewMessage(session, user, ai)
+	}
+	<-gate.started
+
+	// 压缩结果保存期间，后续回合继续追加历史并修改状态（与回合流程一样不持有会话锁）
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for i := 9; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			session.appendHistory(turn(i))
+			ApplyStateUpdate(session.State, map[string]interface{}{"current_life.位置": fmt.Sprintf("第%d处", i)})
+		}
+	}()
+	close(gate.release)
+	cm.Wait()
+	close(stop)
+	<-stopped
+
+	reloaded, err := sm.loadFromDB("1", "test")
+	if err != nil {
+		t.Fatalf("Failed to reload session: %v", err)
+	}
+	if reloaded.CompressionRound != 1 || reloaded.Memory.Text() != "第1章：摘要" {
+		t.Errorf("Expected compression result to be saved, got round %d", reloaded.CompressionRound)
+	}
+}
+
+func TestCompressionFailureRetriesAndKeepsHistory(t *testing.T) {
+	mock := services.NewMockClient(services.MockConfig{
+		Responses: []services.MockResponse{{Error: "upstream unavailable", Repeat: true}},
+	})
+	gc, sm := newMockGame(t, mock)
+	cm := gc.compressionManager
+	cm.retryBackoff = time.Millisecond
+	session, _ := sm.GetSession("1", "test")
+
+	for i := 1; i <= 8; i++ {
+		user, ai := turn(i)
+		cm.ProcessNewMessage(session, user, ai)
+	}
+	cm.Wait()
+
+	history, memory := session.historySnapshot()
+	if len(history) != 16 || !memory.IsEmpty() {
+		t.Fatalf("Expected history to be kept after failure, got %d messages, summary %q", len(history), memory.Text())
+	}
+	if session.CompressionError == "" {
+		t.Errorf("Expected compression error to be recorded")
+	}
+	if calls := len(mock.Calls()); calls != cm.retryAttempts {
+		t.Errorf("Expected %d attempts, got %d", cm.retryAttempts, calls)
+	}
+}
@@ -0,0 +1,583 @@
+package game_engine
+
+import (
+	"AIGE/services"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 一致性规则：
+// 每个MOD在 game_config.consistency.rules 中声明规则，未声明时使用 defaultConsistencyRules。
+// 回合的状态更新在提交前先应用到状态副本上检查，违反 hard 规则时要求AI重新生成，soft 规则只记录。
+// 新的规则类型通过 RegisterConsistencyRule 注册。
+
+const (
+	SeverityHard = "hard"
+	SeveritySoft = "soft"
+
+	defaultConsistencyRetries = 2
+
+	// NarrativeCorrectionPrefix 流式回调中以此开头的内容是修正后的完整叙事，用于替换已发送的内容
+	NarrativeCorrectionPrefix = "【叙事修正】"
+)
+
+// ConsistencyConfig MOD的一致性配置
+type ConsistencyConfig struct {
+	MaxRetries int                     `json:"max_retries"` // 违反 hard 规则时的最大重新生成次数，0 使用默认值
+	Rules      []ConsistencyRuleConfig `json:"rules"`
+}
+
+// ConsistencyRuleConfig 一条规则的配置，不同类型使用不同的字段
+type ConsistencyRuleConfig struct {
+	Type     string `json:"type"`
+	Severity string `json:"severity"` // hard 或 soft，默认 hard
+
+	Paths       []string `json:"paths,omitempty"`        // immutable_attribute：不可修改的状态路径
+	Path        string   `json:"path,omitempty"`         // realm_no_decrease：境界所在的状态路径
+	Levels      []string `json:"levels,omitempty"`       // realm_no_decrease：境界从低到高，如 一转、二转
+	Stages      []string `json:"stages,omitempty"`       // realm_no_decrease：小境界从低到高，如 初阶、中阶
+	AllowKey    string   `json:"allow_key,omitempty"`    // realm_no_decrease：状态更新中带有该字段时允许下降（需说明原因）
+	StatusAttr  string   `json:"status_attr,omitempty"`  // dead_npc：NPC的状态属性名，默认 status
+	DeadValues  []string `json:"dead_values,omitempty"`  // dead_npc：表示死亡的属性值
+	MemoryWords []string `json:"memory_words,omitempty"` // dead_npc：提及死者时允许出现的上下文（回忆、墓前等）
+}
+
+// defaultConsistencyRules MOD未声明规则时使用
+var defaultConsistencyRules = []ConsistencyRuleConfig{
+	{Type: "locked_entity", Severity: SeverityHard},
+	{Type: "dead_npc", Severity: SeveritySoft},
+	{Type: "gender_pronoun", Severity: SeveritySoft},
+}
+
+// ConsistencyViolation 一次规则违反
+type ConsistencyViolation struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// ConsistencyError 重新生成后仍违反 hard 规则，本回合的状态更新不会提交
+type ConsistencyError struct {
+	Violations []ConsistencyViolation
+}
+
+func (e *ConsistencyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "consistency check failed: " + strings.Join(messages, "; ")
+}
+
+// ConsistencyContext 规则检查的输入，Registry 在检查期间持有读锁
+type ConsistencyContext struct {
+	StateBefore map[string]interface{}
+	StateAfter  map[string]interface{} // 应用本回合状态更新后的状态副本
+	StateUpdate map[string]interface{}
+	Narrative   string
+	Parsed      map[string]interface{}
+	Registry    *EntityRegistry
+}
+
+// ConsistencyRule 一致性规则
+type ConsistencyRule interface {
+	Check(ctx *ConsistencyContext) []string
+}
+
+// ConsistencyRuleFactory 根据配置创建规则
+type ConsistencyRuleFactory func(cfg ConsistencyRuleConfig) (ConsistencyRule, error)
+
+var consistencyRuleFactories = map[string]ConsistencyRuleFactory{}
+
+// RegisterConsistencyRule 注册规则类型
+func RegisterConsistencyRule(ruleType string, factory ConsistencyRuleFactory) {
+	consistencyRuleFactories[ruleType] = factory
+}
+
+func init() {
+	RegisterConsistencyRule("immutable_attribute", newImmutableAttributeRule)
+	RegisterConsistencyRule("locked_entity", func(ConsistencyRuleConfig) (ConsistencyRule, error) { return lockedEntityRule{}, nil })
+	RegisterConsistencyRule("dead_npc", newDeadNPCRule)
+	RegisterConsistencyRule("realm_no_decrease", newRealmRule)
+	RegisterConsistencyRule("gender_pronoun", func(ConsistencyRuleConfig) (ConsistencyRule, error) { return genderPronounRule{}, nil })
+}
+
+type configuredRule struct {
+	ruleType string
+	severity string
+	rule     ConsistencyRule
+}
+
+// buildConsistencyRules 根据配置创建规则列表
+func buildConsistencyRules(configs []ConsistencyRuleConfig) ([]configuredRule, error) {
+	if len(configs) == 0 {
+		configs = defaultConsistencyRules
+	}
+	rules := make([]configuredRule, 0, len(configs))
+	for i, cfg := range configs {
+		factory, ok := consistencyRuleFactories[cfg.Type]
+		if !ok {
+			return nil, fmt.Errorf("rule %d: unknown type '%s'", i, cfg.Type)
+		}
+		severity := cfg.Severity
+		if severity == "" {
+			severity = SeverityHard
+		}
+		if severity != SeverityHard && severity != SeveritySoft {
+			return nil, fmt.Errorf("rule %d: invalid severity '%s'", i, cfg.Severity)
+		}
+		rule, err := factory(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i, cfg.Type, err)
+		}
+		rules = append(rules, configuredRule{ruleType: cfg.Type, severity: severity, rule: rule})
+	}
+	return rules, nil
+}
+
+// validateConsistencyConfig 检查MOD的一致性配置
+func validateConsistencyConfig(cfg ConsistencyConfig) error {
+	if cfg.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative")
+	}
+	_, err := buildConsistencyRules(cfg.Rules)
+	return err
+}
+
+// CheckConsistency 在注册表读锁内依次执行规则
+func (em *EntityManager) CheckConsistency(playerID, modID string, rules []configuredRule, ctx *ConsistencyContext) []ConsistencyViolation {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	ctx.Registry = registry
+	var violations []ConsistencyViolation
+	for _, r := range rules {
+		for _, message := range r.rule.Check(ctx) {
+			violations = append(violations, ConsistencyViolation{Rule: r.ruleType, Severity: r.severity, Message: message})
+		}
+	}
+	return violations
+}
+
+// immutableAttributeRule 已有值的状态字段不可被修改
+type immutableAttributeRule struct {
+	paths []string
+}
+
+func newImmutableAttributeRule(cfg ConsistencyRuleConfig) (ConsistencyRule, error) {
+	if len(cfg.Paths) == 0 {
+		return nil, fmt.Errorf("paths is required")
+	}
+	return immutableAttributeRule{paths: cfg.Paths}, nil
+}
+
+func (r immutableAttributeRule) Check(ctx *ConsistencyContext) []string {
+	var violations []string
+	for _, path := range r.paths {
+		before, ok := getNestedValue(ctx.StateBefore, path)
+		if !ok || before == nil || before == "" {
+			continue
+		}
+		after, _ := getNestedValue(ctx.StateAfter, path)
+		if fmt.Sprint(before) != fmt.Sprint(after) {
+			violations = append(violations, fmt.Sprintf("%s 不可修改（%v → %v）", path, before, after))
+		}
+	}
+	return violations
+}
+
+// lockedEntityRule 锁定的实体不可被修改，玩家实体的锁定字段不可被状态更新改变
+type lockedEntityRule struct{}
+
+// playerStateFields 玩家实体字段在状态中对应的路径
+var playerStateFields = map[string]string{
+	"gender": "current_life.gender",
+	"name":   "current_life.name",
+}
+
+func (lockedEntityRule) Check(ctx *ConsistencyContext) []string {
+	var violations []string
+	if player := ctx.Registry.PlayerEntity; player != nil {
+		for _, locked := range ctx.Registry.LockedFields {
+			field, ok := strings.CutPrefix(locked, "player.")
+			if !ok {
+				continue
+			}
+			current, exists := player.Attributes[field]
+			path, mapped := playerStateFields[field]
+			if !exists || !mapped {
+				continue
+			}
+			// 只检查本回合改动过的字段
+			before, _ := getNestedValue(ctx.StateBefore, path)
+			after, ok := getNestedValue(ctx.StateAfter, path)
+			if ok && after != "" && fmt.Sprint(after) != fmt.Sprint(before) && fmt.Sprint(after) != fmt.Sprint(current) {
+				violations = append(violations, fmt.Sprintf("玩家的%s已锁定为%v，不能改为%v", field, current, after))
+			}
+		}
+	}
+
+	relations := parseExtractedRelations(ctx.Parsed["relationships"])
+	for _, item := range parseExtractedEntities(ctx.Parsed["entities"]) {
+		for _, rel := range item.Relations {
+			if rel.From == "" {
+				rel.From = item.Name
+			}
+			relations = append(relations, rel)
+		}
+		entity := ctx.Registry.resolve(item.Type, item.Name, item.Aliases)
+		if entity == nil || !entity.Locked {
+			continue
+		}
+		for key, value := range item.Attributes {
+			if fmt.Sprint(entity.Attributes[key]) != fmt.Sprint(value) {
+				violations = append(violations, fmt.Sprintf("%s 已锁定，不能修改%s", entity.Name, key))
+			}
+		}
+	}
+
+	// 锁定实体的关系同样不可增删
+	for _, rel := range relations {
+		for _, name := range []string{rel.From, rel.To} {
+			if entity := ctx.Registry.resolveByName(name); entity != nil && entity.Locked {
+				violations = append(violations, fmt.Sprintf("%s 已锁定，不能修改其与%s的关系", entity.Name, otherName(rel, name)))
+				break
+			}
+		}
+	}
+	return violations
+}
+
+// otherName 关系中另一端的名称
+func otherName(rel ExtractedRelation, name string) string {
+	if rel.From == name {
+		return rel.To
+	}
+	return rel.From
+}
+
+// deadNPCRule 已死亡的NPC不能再次出场，回忆、祭拜等提及除外
+type deadNPCRule struct {
+	statusAttr  string
+	deadValues  []string
+	memoryWords []string
+}
+
+func newDeadNPCRule(cfg ConsistencyRuleConfig) (ConsistencyRule, error) {
+	rule := deadNPCRule{statusAttr: cfg.StatusAttr, deadValues: cfg.DeadValues, memoryWords: cfg.MemoryWords}
+	if rule.statusAttr == "" {
+		rule.statusAttr = "status"
+	}
+	if len(rule.deadValues) == 0 {
+		rule.deadValues = []string{"dead", "死亡", "已死", "陨落"}
+	}
+	if len(rule.memoryWords) == 0 {
+		rule.memoryWords = []string{"死", "亡", "尸", "墓", "坟", "遗", "生前", "灵位", "回忆", "想起", "往事", "故人", "陨落"}
+	}
+	return rule, nil
+}
+
+func (r deadNPCRule) isDead(entity *Entity) bool {
+	status := fmt.Sprint(entity.Attributes[r.statusAttr])
+	for _, value := range r.deadValues {
+		if status == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (r deadNPCRule) Check(ctx *ConsistencyContext) []string {
+	var violations []string
+	sentences := splitSentences(ctx.Narrative)
+	for _, entity := range ctx.Registry.Entities {
+		if entity.Type != EntityNPC || !r.isDead(entity) {
+			continue
+		}
+	sentenceLoop:
+		for _, sentence := range sentences {
+			if !mentionsEntity(sentence, entity) {
+				continue
+			}
+			for _, word := range r.memoryWords {
+				if strings.Contains(sentence, word) {
+					continue sentenceLoop
+				}
+			}
+			violations = append(violations, fmt.Sprintf("%s 已经死亡，不能再出场", entity.Name))
+			break
+		}
+	}
+	return violations
+}
+
+// realmRule 境界不能无故下降
+type realmRule struct {
+	path     string
+	levels   []string
+	stages   []string
+	allowKey string
+}
+
+func newRealmRule(cfg ConsistencyRuleConfig) (ConsistencyRule, error) {
+	if cfg.Path == "" || len(cfg.Levels) == 0 {
+		return nil, fmt.Errorf("path and levels are required")
+	}
+	return realmRule{path: cfg.Path, levels: cfg.Levels, stages: cfg.Stages, allowKey: cfg.AllowKey}, nil
+}
+
+// rank 境界的序号，无法识别时返回-1
+func (r realmRule) rank(value interface{}) int {
+	text, ok := value.(string)
+	if !ok || text == "" {
+		return -1
+	}
+	level := longestMatch(text, r.levels)
+	if level < 0 {
+		return -1
+	}
+	return level*(len(r.stages)+1) + longestMatch(text, r.stages) + 1
+}
+
+func (r realmRule) Check(ctx *ConsistencyContext) []string {
+	before, _ := getNestedValue(ctx.StateBefore, r.path)
+	after, _ := getNestedValue(ctx.StateAfter, r.path)
+	beforeRank, afterRank := r.rank(before), r.rank(after)
+	if beforeRank < 0 || afterRank < 0 || afterRank >= beforeRank {
+		return nil
+	}
+	if r.allowKey != "" {
+		if reason, ok := ctx.StateUpdate[r.allowKey]; ok && reason != "" {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s 从%v降为%v，但没有说明原因（确需下降时在 state_update 中写明 %s）", r.path, before, after, r.allowKey)}
+	}
+	return []string{fmt.Sprintf("%s 从%v降为%v，境界不能下降", r.path, before, after)}
+}
+
+// genderPronounRule 指代玩家的代词与玩家性别一致
+// 只检查没有提到其他人物的句子，避免把描述NPC的"他""她"当成指代玩家
+type genderPronounRule struct{}
+
+var (
+	malePronouns        = []string{"他", "公子", "少侠", "男子", "男修"}
+	femalePronouns      = []string{"她", "女侠", "仙子", "姑娘", "女子", "女修"}
+	thirdPartyMarkers   = []string{"一位", "那位", "这位", "对方", "此人", "来人", "众人"}
+	nonPronounCompounds = regexp.MustCompile(`其他|他人|他们|她们|其它`)
+)
+
+func (genderPronounRule) Check(ctx *ConsistencyContext) []string {
+	player := ctx.Registry.PlayerEntity
+	if player == nil {
+		return nil
+	}
+	var wrong []string
+	switch fmt.Sprint(player.Attributes["gender"]) {
+	case "男", "male":
+		wrong = femalePronouns
+	case "女", "female":
+		wrong = malePronouns
+	default:
+		return nil
+	}
+
+	var violations []string
+	sentences := splitSentences(ctx.Narrative)
+	for i, sentence := range sentences {
+		cleaned := nonPronounCompounds.ReplaceAllString(sentence, "")
+		// 本句或上一句提到其他人物时，代词很可能指代该人物
+		context := sentence
+		if i > 0 {
+			context = sentences[i-1] + sentence
+		}
+		if mentionsOthers(context, ctx.Registry) {
+			continue
+		}
+		for _, term := range wrong {
+			if strings.Contains(cleaned, term) {
+				violations = append(violations, fmt.Sprintf("性别不一致：玩家是%v性，但出现了'%s'", player.Attributes["gender"], term))
+				break
+			}
+		}
+	}
+	return violations
+}
+
+// mentionsOthers 文本中是否提到了玩家以外的人物
+func mentionsOthers(text string, registry *EntityRegistry) bool {
+	for _, marker := range thirdPartyMarkers {
+		if strings.Contains(text, marker) {
+			return true
+		}
+	}
+	for _, entity := range registry.Entities {
+		if entity.Type == EntityNPC && mentionsEntity(text, entity) {
+			return true
+		}
+	}
+	return false
+}
+
+func mentionsEntity(text string, entity *Entity) bool {
+	for _, name := range append([]string{entity.Name}, entity.Aliases...) {
+		if name != "" && strings.Contains(text, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSentences 按中文标点和换行切分句子
+func splitSentences(text string) []string {
+	sentences := strings.FieldsFunc(text, func(r rune) bool {
+		return strings.ContainsRune("。！？!?\n", r)
+	})
+	result := sentences[:0]
+	for _, s := range sentences {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// longestMatch 文本中包含的最长候选项的序号，用于区分"一转"和"十一转"这类前缀相同的名称
+func longestMatch(text string, candidates []string) int {
+	best, bestLen := -1, 0
+	for i, candidate := range candidates {
+		if candidate != "" && strings.Contains(text, candidate) && len(candidate) > bestLen {
+			best, bestLen = i, len(candidate)
+		}
+	}
+	return best
+}
+
+// consistencyRules MOD的一致性规则（配置已在加载MOD时校验）
+func (mod *GameMod) consistencyRules() []configuredRule {
+	var configs []ConsistencyRuleConfig
+	if mod != nil {
+		configs = mod.Config.GameConfig.Consistency.Rules
+	}
+	rules, err := buildConsistencyRules(configs)
+	if err != nil {
+		rules, _ = buildConsistencyRules(nil)
+	}
+	return rules
+}
+
+func (mod *GameMod) consistencyRetries() int {
+	if mod != nil && mod.Config.GameConfig.Consistency.MaxRetries > 0 {
+		return mod.Config.GameConfig.Consistency.MaxRetries
+	}
+	return defaultConsistencyRetries
+}
+
+// checkConsistency 把本回合的状态更新应用到状态副本上，检查是否违反规则
+func (gc *GameController) checkConsistency(session *GameSession, mod *GameMod, aiResponse string, parsed map[string]interface{}) []ConsistencyViolation {
+	entityManager := gc.stateManager.GetEntityManager()
+	if entityManager == nil {
+		return nil
+	}
+
+	stateUpdate, _ := parsed["state_update"].(map[string]interface{})
+	stateAfter := copyState(session.State)
+	if stateAfter == nil {
+		stateAfter = make(map[string]interface{})
+	}
+
+	// 背包和任务操作不合法（如移除未拥有的物品、完成已结束的任务）按 hard 违规处理
+	var violations []ConsistencyViolation
+	if items, changed, err := mod.turnInventory(session.State, parsed); err != nil {
+		violations = append(violations, ConsistencyViolation{Rule: "inventory", Severity: SeverityHard, Message: err.Error()})
+	} else if changed {
+		storeInventory(stateAfter, items)
+	}
+	if log, err := mod.turnQuests(session.State, parsed); err != nil {
+		violations = append(violations, ConsistencyViolation{Rule: "quests", Severity: SeverityHard, Message: err.Error()})
+	} else if log != nil {
+		storeQuestLog(stateAfter, log)
+	}
+	stateUpdate = mod.filterStateUpdate(stateUpdate)
+	ApplyStateUpdate(stateAfter, stateUpdate)
+
+	// 时间推进不合法（如时间倒流）同样按 hard 违规处理
+	if clock, err := mod.turnWorldClock(stateAfter, parsed); err != nil {
+		violations = append(violations, ConsistencyViolation{Rule: "world_clock", Severity: SeverityHard, Message: err.Error()})
+	} else if clock != nil {
+		storeWorldClock(stateAfter, clock)
+	}
+
+	narrative := extractNarrative(aiResponse)
+	if narrative == "" {
+		narrative, _ = parsed["narrative"].(string)
+	}
+	return append(violations, entityManager.CheckConsistency(session.PlayerID, session.ModID, mod.consistencyRules(), &ConsistencyContext{
+		StateBefore: session.State,
+		StateAfter:  stateAfter,
+		StateUpdate: stateUpdate,
+		Narrative:   narrative,
+		Parsed:      parsed,
+	})...)
+}
+
+// enforceConsistency 在提交状态更新前检查一致性，违反 hard 规则时带着违规说明要求AI重新生成。
+// 修正后的叙事通过 correctionCallback 替换已经流式发送的内容；重试用尽仍违反时返回 ConsistencyError，本回合不提交
+func (gc *GameController) enforceConsistency(session *GameSession, mod *GameMod, stageName string, messages []services.Message,
+	aiResponse string, parsed map[string]interface{}, correctionCallback StreamCallback) (string, map[string]interface{}, error) {
+	provider := gc.GetProviderForMod(mod.Config.GameID)
+	maxRetries := mod.consistencyRetries()
+
+	for attempt := 0; ; attempt++ {
+		var hard []ConsistencyViolation
+		for _, v := range gc.checkConsistency(session, mod, aiResponse, parsed) {
+			fmt.Printf("[一致性] %s规则 %s: %s\n", v.Severity, v.Rule, v.Message)
+			if v.Severity == SeverityHard {
+				hard = append(hard, v)
+			}
+		}
+		if len(hard) == 0 {
+			if attempt > 0 && correctionCallback != nil {
+				narrative := extractNarrative(aiResponse)
+				if narrative == "" {
+					narrative, _ = parsed["narrative"].(string)
+				}
+				if err := correctionCallback(NarrativeCorrectionPrefix + narrative); err != nil {
+					return "", nil, err
+				}
+			}
+			return aiResponse, parsed, nil
+		}
+
+		violationErr := &ConsistencyError{Violations: hard}
+		session.trace.stageError(stageName, violationErr)
+		if attempt >= maxRetries {
+			return "", nil, violationErr
+		}
+
+		fmt.Printf("[一致性] 第 %d/%d 次要求AI修正\n", attempt+1, maxRetries)
+		var notes strings.Builder
+		for _, v := range hard {
+			notes.WriteString("- " + v.Message + "\n")
+		}
+		correction := append(append([]services.Message(nil), messages...),
+			services.Message{Role: "assistant", Content: aiResponse},
+			services.Message{Role: "user", Content: fmt.Sprintf("你的上一次回复违反了以下设定，请修正后重新输出完整的回复（叙事和JSON格式保持不变）：\n%s", notes.String())},
+		)
+		stage := session.trace.beginStage(stageName, provider, correction)
+		response, err := gc.aiClient.Chat(provider.chatRequest(correction, mod.generationParams(PurposeNarrative)))
+		if err != nil {
+			return "", nil, fmt.Errorf("consistency correction failed: %w", err)
+		}
+		jsonStr := extractJSON(response.Content)
+		var corrected map[string]interface{}
+		if jsonStr == "" || json.Unmarshal([]byte(jsonStr), &corrected) != nil {
+			stage.setResponse(response.Content, nil)
+			return "", nil, fmt.Errorf("failed to parse corrected AI response")
+		}
+		stage.setResponse(response.Content, corrected)
+		aiResponse, parsed = response.Content, corrected
+	}
+}
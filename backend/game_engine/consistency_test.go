@@ -0,0 +1,130 @@
+package game_engine
+
+import (
+	"AIGE/services"
+	"strings"
+	"testing"
+)
+
+func TestConsistencyRules(t *testing.T) {
+	rules, err := buildConsistencyRules([]ConsistencyRuleConfig{
+		{Type: "immutable_attribute", Paths: []string{"current_life.出身"}},
+		{Type: "realm_no_decrease", Path: "current_life.修为", Levels: []string{"一转", "二转", "三转"}, Stages: []string{"初阶", "中阶", "高阶", "巅峰"}, AllowKey: "修为下降原因"},
+		{Type: "dead_npc"},
+		{Type: "locked_entity"},
+		{Type: "gender_pronoun", Severity: SeveritySoft},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build rules: %v", err)
+	}
+	if _, err := buildConsistencyRules([]ConsistencyRuleConfig{{Type: "unknown"}}); err == nil {
+		t.Errorf("Expected unknown rule type to be rejected")
+	}
+
+	em := NewEntityManager()
+	em.RegisterEntity("1", "xiuxian", &Entity{ID: "player_1", Type: EntityPlayer, Name: "方源", Attributes: map[string]interface{}{"gender": "男"}})
+	em.RegisterEntity("1", "xiuxian", &Entity{ID: "npc_白凝冰", Type: EntityNPC, Name: "白凝冰", Attributes: map[string]interface{}{"status": "死亡"}})
+	em.RegisterEntity("1", "xiuxian", &Entity{ID: "npc_古月博", Type: EntityNPC, Name: "古月博", Locked: true, Attributes: map[string]interface{}{"身份": "族长"}})
+
+	check := func(update map[string]interface{}, narrative string, parsed map[string]interface{}) []string {
+		before := map[string]interface{}{"current_life": map[string]interface{}{"出身": "古月山寨", "修为": "二转中阶"}}
+		after := copyState(before)
+		ApplyStateUpdate(after, update)
+		var violated []string
+		for _, v := range em.CheckConsistency("1", "xiuxian", rules, &ConsistencyContext{
+			StateBefore: before, StateAfter: after, StateUpdate: update, Narrative: narrative, Parsed: parsed,
+		}) {
+			violated = append(violated, v.Rule)
+		}
+		return violated
+	}
+
+	cases := []struct {
+		name      string
+		update    map[string]interface{}
+		narrative string
+		parsed    map[string]interface{}
+		want      string
+	}{
+		{"修改出身", map[string]interface{}{"current_life.出身": "商家城"}, "", nil, "immutable_attribute"},
+		{"境界无故下降", map[string]interface{}{"current_life.修为": "一转巅峰"}, "", nil, "realm_no_decrease"},
+		{"说明原因的下降", map[string]interface{}{"current_life.修为": "一转巅峰", "修为下降原因": "强行催动蛊虫"}, "", nil, ""},
+		{"境界提升", map[string]interface{}{"current_life.修为": "三转初阶"}, "", nil, ""},
+		{"死者出场", nil, "白凝冰冷笑着走了过来。", nil, "dead_npc"},
+		{"回忆死者", nil, "你想起白凝冰生前的话。", nil, ""},
+		{"修改锁定实体", nil, "", map[string]interface{}{"entities": []interface{}{
+			map[string]interface{}{"type": "npc", "name": "古月博", "attributes": map[string]interface{}{"身份": "长老"}},
+		}}, "locked_entity"},
+		{"删除锁定实体的关系", nil, "", map[string]interface{}{"relationships": []interface{}{
+			map[string]interface{}{"from": "古月博", "to": "玩家", "type": "master_disciple", "removed": true},
+		}}, "locked_entity"},
+		{"修改未锁定实体的关系", nil, "", map[string]interface{}{"relationships": []interface{}{
+			map[string]interface{}{"from": "白凝冰", "to": "玩家", "type": "enemy"},
+		}}, ""},
+		{"女性代词指代玩家", nil, "方源握紧拳头，她决定出手。", nil, "gender_pronoun"},
+		{"女性代词指代NPC", nil, "一位少女拦住去路，她手持长剑。", nil, ""},
+	}
+	for _, tc := range cases {
+		violated := check(tc.update, tc.narrative, tc.parsed)
+		if tc.want == "" && len(violated) > 0 {
+			t.Errorf("%s: expected no violations, got %v", tc.name, violated)
+		}
+		if tc.want != "" && (len(violated) != 1 || violated[0] != tc.want) {
+			t.Errorf("%s: expected %s, got %v", tc.name, tc.want, violated)
+		}
+	}
+}
+
+func TestConsistencyViolationRegeneratesResponse(t *testing.T) {
+	mock := services.NewMockClient(services.MockConfig{
+		Responses: []services.MockResponse{
+			{Content: `$你的修为跌落了。$@{"state_update":{"current_life.修为":"一转初阶"}}@`},
+			{Content: `$你稳住了修为，走进石室。$@{"state_update":{"current_life.位置":"石室"}}@`},
+		},
+	})
+	gc, sm := newMockGame(t, mock)
+	mod, _ := gc.modLoader.GetMod("test")
+	mod.Config.GameConfig.Consistency = ConsistencyConfig{
+		MaxRetries: 1,
+		Rules:      []ConsistencyRuleConfig{{Type: "realm_no_decrease", Path: "current_life.修为", Levels: []string{"一转", "二转"}}},
+	}
+	session, _ := sm.GetSession("1", "test")
+	session.State["current_life"].(map[string]interface{})["修为"] = "二转中阶"
+
+	var chunks []string
+	err := gc.ProcessActionStreamWithAttributes("1", "test", "修炼", nil,
+		func(content string) error { chunks = append(chunks, content); return nil }, nil, func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("Expected corrected response to be accepted, got %v", err)
+	}
+	if len(mock.Calls()) != 2 {
+		t.Errorf("Expected one correction call, got %d calls", len(mock.Calls()))
+	}
+	if last := chunks[len(chunks)-1]; last != NarrativeCorrectionPrefix+"你稳住了修为，走进石室。" {
+		t.Errorf("Expected corrected narrative to be sent, got %q", last)
+	}
+	if realm, _ := getNestedValue(session.State, "current_life.修为"); realm != "二转中阶" {
+		t.Errorf("Expected violating update to be discarded, got %v", realm)
+	}
+	if location, _ := getNestedValue(session.State, "current_life.位置"); location != "石室" {
+		t.Errorf("Expected corrected update to apply, got %v", location)
+	}
+	history, _ := session.historySnapshot()
+	if len(history) != 2 || !strings.Contains(history[1].Content, "稳住了修为") {
+		t.Errorf("Expected only the corrected response in history, got %+v", history)
+	}
+
+	// 修正后仍然违反时本回合失败，状态保持不变
+	mock = services.NewMockClient(services.MockConfig{
+		Responses: []services.MockResponse{{Content: `$你的修为跌落了。$@{"state_update":{"current_life.修为":"一转初阶"}}@`, Repeat: true}},
+	})
+	gc.SetLLMClient(mock)
+	err = gc.ProcessActionStreamWithAttributes("1", "test", "修炼", nil,
+		func(string) error { return nil }, nil, func(string) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "consistency check failed") {
+		t.Fatalf("Expected consistency error, got %v", err)
+	}
+	if realm, _ := getNestedValue(session.State, "current_life.修为"); realm != "二转中阶" || session.State["is_processing"] != false {
+		t.Errorf("Expected state to be left untouched, got %v", session.State)
+	}
+}
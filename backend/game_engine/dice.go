@@ -0,0 +1,436 @@
+package game_engine
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RollModeUnder 骰值不超过目标值即成功（默认，适合D100）
+	RollModeUnder = "roll_under"
+	// RollModeOver 骰值达到目标值即成功（适合D20等）
+	RollModeOver = "roll_over"
+
+	maxDiceCount = 100
+	maxDiceSides = 1000
+)
+
+// RollSettings 模组的判定配置
+type RollSettings struct {
+	CriticalSuccessThreshold float64              `json:"critical_success_threshold"`
+	CriticalFailureThreshold float64              `json:"critical_failure_threshold"`
+	DefaultSides             int                  `json:"default_sides"`
+	DefaultExpression        string               `json:"default_expression"` // 如 "1d100"，为空时使用 1d{default_sides}
+	Mode                     string               `json:"mode"`               // roll_under（默认）或 roll_over
+	Modifiers                []RollModifierConfig `json:"modifiers"`          // 从状态读取的判定修正
+}
+
+// RollModifierConfig 从状态路径读取的判定修正（数值越大对玩家越有利）
+type RollModifierConfig struct {
+	Path      string   `json:"path"`       // 状态路径，如 current_life.luck
+	Label     string   `json:"label"`      // 显示名称
+	Scale     float64  `json:"scale"`      // 缩放系数，默认为1
+	RollTypes []string `json:"roll_types"` // 仅对这些判定类型生效，为空时对所有判定生效
+}
+
+// DiceTerm 表达式中的一组骰子，如 2d6
+type DiceTerm struct {
+	Count int
+	Sides int
+	Sign  int
+}
+
+// DiceExpression 解析后的骰子表达式，如 2d6+1d4+3
+type DiceExpression struct {
+	Raw      string
+	Terms    []DiceTerm
+	Constant int
+}
+
+// DiceGroupResult 单组骰子的投掷结果
+type DiceGroupResult struct {
+	Term     string `json:"term"`
+	Sides    int    `json:"sides"`
+	Rolls    []int  `json:"rolls"`
+	Subtotal int    `json:"subtotal"`
+}
+
+// DiceRoll 一次表达式投掷的完整结果
+type DiceRoll struct {
+	Expression string            `json:"expression"`
+	Dice       []DiceGroupResult `json:"dice"`
+	Constant   int               `json:"constant"`
+	Natural    int               `json:"natural"` // 骰子点数之和（不含常数）
+	Total      int               `json:"total"`   // 骰子点数加常数
+}
+
+// ParseDiceExpression 解析骰子表达式，支持 NdS、dS、d%、整数常数及 +/- 组合
+func ParseDiceExpression(expr string) (*DiceExpression, error) {
+	raw := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(expr), " ", ""))
+	if raw == "" {
+		return nil, fmt.Errorf("empty dice expression")
+	}
+
+	parsed := &DiceExpression{Raw: raw}
+	sign := 1
+	start := 0
+	for i := 0; i <= len(raw); i++ {
+		if i < len(raw) && raw[i] != '+' && raw[i] != '-' {
+			continue
+		}
+		token := raw[start:i]
+		if token == "" {
+			if i == 0 && i < len(raw) {
+				// 允许表达式以符号开头
+				if raw[i] == '-' {
+					sign = -1
+				}
+				start = i + 1
+				continue
+			}
+			return nil, fmt.Errorf("invalid dice expression '%s'", expr)
+		}
+		if err := parsed.addToken(token, sign); err != nil {
+			return nil, fmt.Errorf("invalid dice expression '%s': %w", expr, err)
+		}
+		if i < len(raw) {
+			sign = 1
+			if raw[i] == '-' {
+				sign = -1
+			}
+		}
+		start = i + 1
+	}
+
+	if len(parsed.Terms) == 0 {
+		return nil, fmt.Errorf("dice expression '%s' contains no dice", expr)
+	}
+	return parsed, nil
+}
+
+func (e *DiceExpression) addToken(token string, sign int) error {
+	idx := strings.IndexByte(token, 'd')
+	if idx < 0 {
+		value, err := strconv.Atoi(token)
+		if err != nil {
+			return fmt.Errorf("bad constant '%s'", token)
+		}
+		e.Constant += sign * value
+		return nil
+	}
+
+	count := 1
+	if idx > 0 {
+		n, err := strconv.Atoi(token[:idx])
+		if err != nil {
+			return fmt.Errorf("bad dice count '%s'", token)
+		}
+		count = n
+	}
+	sidesText := token[idx+1:]
+	var sides int
+	if sidesText == "%" {
+		sides = 100
+	} else {
+		n, err := strconv.Atoi(sidesText)
+		if err != nil {
+			return fmt.Errorf("bad dice sides '%s'", token)
+		}
+		sides = n
+	}
+
+	if count < 1 || count > maxDiceCount {
+		return fmt.Errorf("dice count must be between 1 and %d", maxDiceCount)
+	}
+	if sides < 2 || sides > maxDiceSides {
+		return fmt.Errorf("dice sides must be between 2 and %d", maxDiceSides)
+	}
+
+	e.Terms = append(e.Terms, DiceTerm{Count: count, Sides: sides, Sign: sign})
+	return nil
+}
+
+// MinNatural 骰子点数之和的最小值
+func (e *DiceExpression) MinNatural() int {
+	total := 0
+	for _, term := range e.Terms {
+		if term.Sign > 0 {
+			total += term.Count
+		} else {
+			total -= term.Count * term.Sides
+		}
+	}
+	return total
+}
+
+// MaxNatural 骰子点数之和的最大值
+func (e *DiceExpression) MaxNatural() int {
+	total := 0
+	for _, term := range e.Terms {
+		if term.Sign > 0 {
+			total += term.Count * term.Sides
+		} else {
+			total -= term.Count
+		}
+	}
+	return total
+}
+
+// Roll 使用给定的随机数生成器投掷表达式
+func (e *DiceExpression) Roll(rng *rand.Rand) DiceRoll {
+	roll := DiceRoll{Expression: e.Raw, Constant: e.Constant}
+	for _, term := range e.Terms {
+		group := DiceGroupResult{Term: term.String(), Sides: term.Sides}
+		for i := 0; i < term.Count; i++ {
+			value := rng.Intn(term.Sides) + 1
+			group.Rolls = append(group.Rolls, value)
+			group.Subtotal += value
+		}
+		group.Subtotal *= term.Sign
+		roll.Dice = append(roll.Dice, group)
+		roll.Natural += group.Subtotal
+	}
+	roll.Total = roll.Natural + roll.Constant
+	return roll
+}
+
+func (t DiceTerm) String() string {
+	prefix := ""
+	if t.Sign < 0 {
+		prefix = "-"
+	}
+	return fmt.Sprintf("%s%dd%d", prefix, t.Count, t.Sides)
+}
+
+// rollMode 返回判定模式，未配置时为 roll_under
+func (s *RollSettings) rollMode() string {
+	if s.Mode == "" {
+		return RollModeUnder
+	}
+	return s.Mode
+}
+
+// defaultExpression 返回模组默认的骰子表达式
+func (s *RollSettings) defaultExpression() string {
+	if s.DefaultExpression != "" {
+		return s.DefaultExpression
+	}
+	sides := s.DefaultSides
+	if sides < 2 {
+		sides = 100
+	}
+	return fmt.Sprintf("1d%d", sides)
+}
+
+// validateRollSettings 校验模组的判定配置
+func validateRollSettings(s *RollSettings) error {
+	if s.Mode != "" && s.Mode != RollModeUnder && s.Mode != RollModeOver {
+		return fmt.Errorf("roll_settings has invalid mode '%s'", s.Mode)
+	}
+	if s.DefaultExpression != "" {
+		if _, err := ParseDiceExpression(s.DefaultExpression); err != nil {
+			return fmt.Errorf("roll_settings default_expression: %w", err)
+		}
+	}
+	for _, m := range s.Modifiers {
+		if m.Path == "" {
+			return fmt.Errorf("roll modifier requires path")
+		}
+	}
+	return nil
+}
+
+// stateModifiers 从当前状态中收集适用于该判定类型的修正
+func (s *RollSettings) stateModifiers(state map[string]interface{}, rollType string) []map[string]interface{} {
+	var applied []map[string]interface{}
+	for _, m := range s.Modifiers {
+		if len(m.RollTypes) > 0 && !containsString(m.RollTypes, rollType) {
+			continue
+		}
+		raw, ok := getNestedValue(state, m.Path)
+		if !ok {
+			continue
+		}
+		value, ok := numericValue(raw)
+		if !ok {
+			continue
+		}
+		scale := m.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		bonus := int(value * scale)
+		if bonus == 0 {
+			continue
+		}
+		label := m.Label
+		if label == "" {
+			label = m.Path
+		}
+		applied = append(applied, map[string]interface{}{
+			"label": label,
+			"path":  m.Path,
+			"value": bonus,
+		})
+	}
+	return applied
+}
+
+// numericValue 将状态中的数值（包括数字字符串）转换为float64
+func numericValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// rank 返回骰子点数在表达式范围内的名次，1为对玩家最有利
+func (s *RollSettings) rank(natural int, expr *DiceExpression) int {
+	if s.rollMode() == RollModeOver {
+		return expr.MaxNatural() - natural + 1
+	}
+	return natural - expr.MinNatural() + 1
+}
+
+// critical 根据骰子点数判断是否落入大成功或大失败区间
+func (s *RollSettings) critical(natural int, expr *DiceExpression) (bool, bool) {
+	span := float64(expr.MaxNatural() - expr.MinNatural() + 1)
+	rank := float64(s.rank(natural, expr))
+	critSuccess := s.CriticalSuccessThreshold > 0 && rank <= span*s.CriticalSuccessThreshold
+	critFailure := s.CriticalFailureThreshold > 0 && rank >= span*s.CriticalFailureThreshold
+	return critSuccess, critFailure
+}
+
+// margin 返回成功余量，非负表示达成目标
+func (s *RollSettings) margin(total int, target float64) float64 {
+	if s.rollMode() == RollModeOver {
+		return float64(total) - target
+	}
+	return target - float64(total)
+}
+
+// resolve 根据骰子点数和余量判定结果
+func (s *RollSettings) resolve(natural int, expr *DiceExpression, succeeded bool) string {
+	critSuccess, critFailure := s.critical(natural, expr)
+	switch {
+	case critSuccess:
+		return "大成功"
+	case succeeded:
+		return "成功"
+	case critFailure:
+		return "大失败"
+	default:
+		return "失败"
+	}
+}
+
+// checkTotal 计算参与比较的总值：roll_over 模式下修正加在骰值上，roll_under 模式下修正加在目标值上
+func (s *RollSettings) checkTotal(roll DiceRoll, bonus int) int {
+	if s.rollMode() == RollModeOver {
+		return roll.Total + bonus
+	}
+	return roll.Total
+}
+
+// effectiveTarget 计算应用修正后的目标值
+func (s *RollSettings) effectiveTarget(target float64, bonus int) float64 {
+	if s.rollMode() == RollModeUnder {
+		return target + float64(bonus)
+	}
+	return target
+}
+
+// forcedNatural 为强制判定结果构造一个与之相符的骰子点数
+// 大成功取最有利点数，大失败取最不利点数，成功取最接近失败的点数，失败取最接近成功的点数
+func (s *RollSettings) forcedNatural(outcome string, expr *DiceExpression, target float64, bonus int) int {
+	best, worst := expr.MinNatural(), expr.MaxNatural()
+	step := 1
+	if s.rollMode() == RollModeOver {
+		best, worst = worst, best
+		step = -1
+	}
+
+	resolves := func(natural int) bool {
+		roll := DiceRoll{Natural: natural, Total: natural + expr.Constant}
+		succeeded := s.margin(s.checkTotal(roll, bonus), s.effectiveTarget(target, bonus)) >= 0
+		return s.resolve(natural, expr, succeeded) == outcome
+	}
+
+	switch outcome {
+	case "大成功":
+		return best
+	case "大失败":
+		return worst
+	case "成功":
+		for natural := worst; natural != best-step; natural -= step {
+			if resolves(natural) {
+				return natural
+			}
+		}
+		return best
+	default:
+		for natural := best; natural != worst+step; natural += step {
+			if resolves(natural) {
+				return natural
+			}
+		}
+		return worst
+	}
+}
+
+// rollExpressionFor 确定判定使用的骰子表达式：expression 优先，其次 sides，最后使用模组默认值
+func rollExpressionFor(rollRequest map[string]interface{}, settings *RollSettings) *DiceExpression {
+	if raw, ok := rollRequest["expression"].(string); ok && raw != "" {
+		expr, err := ParseDiceExpression(raw)
+		if err == nil {
+			return expr
+		}
+		fmt.Printf("[判定] 无法解析骰子表达式，使用默认值: %v\n", err)
+	}
+	if sides, ok := rollRequest["sides"].(float64); ok && sides >= 2 {
+		if expr, err := ParseDiceExpression(fmt.Sprintf("1d%d", int(sides))); err == nil {
+			return expr
+		}
+	}
+	expr, err := ParseDiceExpression(settings.defaultExpression())
+	if err != nil {
+		expr, _ = ParseDiceExpression("1d100")
+	}
+	return expr
+}
+
+// rollAdvantage 读取判定请求中的优势/劣势标记
+func rollAdvantage(rollRequest map[string]interface{}) string {
+	advantage, _ := rollRequest["advantage"].(bool)
+	disadvantage, _ := rollRequest["disadvantage"].(bool)
+	if mode, ok := rollRequest["advantage"].(string); ok {
+		advantage = mode == "advantage"
+		disadvantage = mode == "disadvantage"
+	}
+	switch {
+	case advantage && !disadvantage:
+		return "advantage"
+	case disadvantage && !advantage:
+		return "disadvantage"
+	}
+	return ""
+}
@@ -0,0 +1,169 @@
+package game_engine
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"AIGE/services"
+	"encoding/json"
+	"testing"
+)
+
+func TestParseDiceExpression(t *testing.T) {
+	cases := []struct {
+		expr     string
+		min, max int
+		constant int
+	}{
+		{"1d100", 1, 100, 0},
+		{"2d6+3", 2, 12, 3},
+		{"d%", 1, 100, 0},
+		{"1d20 - 1d4 + 2", -3, 19, 2},
+	}
+
+	for _, c := range cases {
+		expr, err := ParseDiceExpression(c.expr)
+		if err != nil {
+			t.Errorf("Failed to parse '%s': %v", c.expr, err)
+			continue
+		}
+		if expr.MinNatural() != c.min || expr.MaxNatural() != c.max || expr.Constant != c.constant {
+			t.Errorf("'%s': expected range %d-%d+%d, got %d-%d+%d", c.expr, c.min, c.max, c.constant,
+				expr.MinNatural(), expr.MaxNatural(), expr.Constant)
+		}
+	}
+
+	for _, bad := range []string{"", "3", "1d", "1d1", "1d6+", "2x6", "0d6"} {
+		if _, err := ParseDiceExpression(bad); err == nil {
+			t.Errorf("Expected error for '%s'", bad)
+		}
+	}
+}
+
+func TestExecuteRollDeterministic(t *testing.T) {
+	gc := &GameController{}
+	mod := &GameMod{}
+	mod.Config.GameConfig.RollSettings = RollSettings{
+		CriticalSuccessThreshold: 0.05,
+		CriticalFailureThreshold: 0.96,
+		DefaultSides:             100,
+		Modifiers:                []RollModifierConfig{{Path: "current_life.luck", Label: "气运"}},
+	}
+	request := map[string]interface{}{"type": "测试判定", "target": 50.0, "expression": "2d6+1d100", "advantage": true}
+
+	roll := func() []interface{} {
+		session := &GameSession{State: map[string]interface{}{
+			"current_life": map[string]interface{}{"luck": "10"},
+		}}
+		session.SeedRolls("test-seed")
+		var results []interface{}
+		for i := 0; i < 5; i++ {
+			results = append(results, gc.executeRoll(request, mod, session, "测试")["result"])
+		}
+		return results
+	}
+
+	first, second := roll(), roll()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Rolls with the same seed differ: %v vs %v", first, second)
+		}
+	}
+
+	session := &GameSession{State: map[string]interface{}{
+		"current_life": map[string]interface{}{"luck": 10.0},
+	}}
+	result := gc.executeRoll(request, mod, session, "测试")
+	breakdown := result["breakdown"].(map[string]interface{})
+	if breakdown["effective_target"] != 60.0 {
+		t.Errorf("Expected effective target 60, got %v", breakdown["effective_target"])
+	}
+	if rolls, ok := breakdown["rolls"].([]DiceRoll); !ok || len(rolls) != 2 {
+		t.Errorf("Expected two rolls for advantage, got %v", breakdown["rolls"])
+	}
+}
+
+func TestForcedNaturalMatchesOutcome(t *testing.T) {
+	expr, _ := ParseDiceExpression("1d100")
+	for _, mode := range []string{RollModeUnder, RollModeOver} {
+		settings := &RollSettings{CriticalSuccessThreshold: 0.05, CriticalFailureThreshold: 0.96, Mode: mode}
+		for _, outcome := range []string{"大成功", "成功", "失败", "大失败"} {
+			natural := settings.forcedNatural(outcome, expr, 50, 0)
+			total := settings.checkTotal(DiceRoll{Natural: natural, Total: natural}, 0)
+			succeeded := settings.margin(total, 50) >= 0
+			if got := settings.resolve(natural, expr, succeeded); got != outcome {
+				t.Errorf("%s: forced %s produced %d which resolves to %s", mode, outcome, natural, got)
+			}
+		}
+	}
+}
+
+func TestVerifyRollLog(t *testing.T) {
+	session := &GameSession{}
+	session.SeedRolls("revealed-seed")
+	expr, _ := ParseDiceExpression("3d6+1d100")
+	roll := expr.Roll(session.nextRollRNG())
+
+	draws, _ := json.Marshal(diceDraws(roll))
+	entry := &models.RollLog{Sequence: session.RollSequence, SeedHash: session.RollSeedHash, Draws: string(draws)}
+
+	if err := VerifyRollLog(entry, "revealed-seed"); err != nil {
+		t.Errorf("Expected roll to verify: %v", err)
+	}
+	if err := VerifyRollLog(entry, "other-seed"); err == nil {
+		t.Errorf("Expected hash mismatch for wrong seed")
+	}
+
+	tampered := diceDraws(roll)
+	tampered[0].Value = tampered[0].Value%6 + 1
+	draws, _ = json.Marshal(tampered)
+	entry.Draws = string(draws)
+	if err := VerifyRollLog(entry, "revealed-seed"); err == nil {
+		t.Errorf("Expected tampered draws to fail verification")
+	}
+}
+
+func TestRevealRollSeedRejectedDuringTurn(t *testing.T) {
+	_, sm := newMockGame(t, services.NewMockClient(services.MockConfig{}))
+	session, _ := sm.GetSession("1", "test")
+	session.ensureRollSeed()
+	committed := session.RollSeedHash
+
+	session.State["is_processing"] = true
+	if _, err := sm.RevealRollSeed("1", "test"); err != ErrTurnInProgress {
+		t.Fatalf("Expected reveal during a turn to be rejected, got %v", err)
+	}
+	if session.RollSeedHash != committed {
+		t.Errorf("Expected seed to stay unchanged during a turn")
+	}
+
+	session.State["is_processing"] = false
+	record, err := sm.RevealRollSeed("1", "test")
+	if err != nil {
+		t.Fatalf("Expected reveal after the turn to succeed, got %v", err)
+	}
+	if record.SeedHash != committed || session.RollSeedHash == committed {
+		t.Errorf("Expected committed seed to be revealed and rotated")
+	}
+}
+
+func TestLoadedLegacySavePublishesSeedHash(t *testing.T) {
+	_, sm := newMockGame(t, services.NewMockClient(services.MockConfig{}))
+	config.DB.Create(&models.GameSave{UserID: 2, ModID: "test", State: `{"current_life":{}}`})
+
+	session, err := sm.GetSession("2", "test")
+	if err != nil {
+		t.Fatalf("Failed to load legacy save: %v", err)
+	}
+	if session.RollSeedHash == "" || session.RollSequence != 0 {
+		t.Fatalf("Expected seed hash to be published before any roll, got %q", session.RollSeedHash)
+	}
+
+	// 哈希写回存档，重新载入后承诺值不变
+	reloaded, err := sm.loadFromDB("2", "test")
+	if err != nil {
+		t.Fatalf("Failed to reload save: %v", err)
+	}
+	if reloaded.RollSeedHash != session.RollSeedHash || reloaded.RollSeed != session.RollSeed {
+		t.Errorf("Expected seed to be persisted, got %q", reloaded.RollSeedHash)
+	}
+}
@@ -0,0 +1,169 @@
+package game_engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// EntityEdit 管理接口对实体的修改，未提供的字段保持不变
+type EntityEdit struct {
+	Name       *string                `json:"name"`
+	Aliases    []string               `json:"aliases"`    // 非nil时整体替换别称
+	Attributes map[string]interface{} `json:"attributes"` // 合并到已有属性，值为null时删除该属性
+}
+
+// clone 实体的深拷贝，返回给接口调用方，避免与回合处理共享同一对象
+func (e *Entity) clone() *Entity {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil
+	}
+	var copied Entity
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil
+	}
+	return &copied
+}
+
+// ListEntities 注册表中的实体和关系的副本，entityType 为空时返回全部类型
+func (em *EntityManager) ListEntities(playerID, modID string, entityType EntityType) ([]*Entity, []Relationship) {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	entities := make([]*Entity, 0, len(registry.Entities))
+	for _, entity := range registry.Entities {
+		if entityType == "" || entity.Type == entityType {
+			entities = append(entities, entity.clone())
+		}
+	}
+	sort.Slice(entities, func(i, j int) bool { return entities[i].ID < entities[j].ID })
+
+	relations := make([]Relationship, 0, len(registry.Relations))
+	for _, rel := range registry.Relations {
+		relations = append(relations, *rel)
+	}
+	return entities, relations
+}
+
+// EntitySnapshot 单个实体及其直接关系的副本
+func (em *EntityManager) EntitySnapshot(playerID, modID, entityID string) (*Entity, []Relationship, error) {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	entity, exists := registry.Entities[entityID]
+	if !exists {
+		return nil, nil, fmt.Errorf("entity not found: %s", entityID)
+	}
+	relations := []Relationship{}
+	for _, rel := range registry.Relations {
+		if rel.From == entityID || rel.To == entityID {
+			relations = append(relations, *rel)
+		}
+	}
+	return entity.clone(), relations, nil
+}
+
+// EditEntity 管理员修正实体，不受锁定限制，但修改后的实体仍需通过验证
+func (em *EntityManager) EditEntity(playerID, modID, entityID string, edit EntityEdit) (*Entity, error) {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	entity, exists := registry.Entities[entityID]
+	if !exists {
+		return nil, fmt.Errorf("entity not found: %s", entityID)
+	}
+
+	edited := entity.clone()
+	if edit.Name != nil {
+		edited.Name = *edit.Name
+	}
+	if edit.Aliases != nil {
+		edited.Aliases = nil
+		edited.addAliases(edit.Aliases)
+	}
+	if edited.Attributes == nil {
+		edited.Attributes = make(map[string]interface{})
+	}
+	for key, value := range edit.Attributes {
+		if value == nil {
+			delete(edited.Attributes, key)
+			continue
+		}
+		edited.Attributes[key] = value
+	}
+	if err := em.validator.ValidateEntity(edited); err != nil {
+		return nil, err
+	}
+
+	edited.UpdatedAt = time.Now()
+	registry.Entities[entityID] = edited
+	if registry.PlayerEntity != nil && registry.PlayerEntity.ID == entityID {
+		registry.PlayerEntity = edited
+	}
+	return edited.clone(), nil
+}
+
+// SetEntityLocked 锁定或解锁实体，锁定的实体不会被AI提取修改，也不会被过期清理
+func (em *EntityManager) SetEntityLocked(playerID, modID, entityID string, locked bool) error {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	entity, exists := registry.Entities[entityID]
+	if !exists {
+		return fmt.Errorf("entity not found: %s", entityID)
+	}
+	entity.Locked = locked
+	entity.UpdatedAt = time.Now()
+	return nil
+}
+
+// DeleteEntity 删除实体及其关系，玩家实体不能删除
+func (em *EntityManager) DeleteEntity(playerID, modID, entityID string) error {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	entity, exists := registry.Entities[entityID]
+	if !exists {
+		return fmt.Errorf("entity not found: %s", entityID)
+	}
+	if entity.Type == EntityPlayer {
+		return fmt.Errorf("player entity cannot be deleted")
+	}
+	delete(registry.Entities, entityID)
+	registry.pruneRelationships()
+	return nil
+}
+
+// hasRegistry 注册表是否已在内存中
+func (em *EntityManager) hasRegistry(playerID, modID string) bool {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	_, exists := em.registries[fmt.Sprintf("%s_%s", playerID, modID)]
+	return exists
+}
+
+// cleanupBefore 删除 cutoff 之前最后更新的实体（玩家和锁定实体除外）
+func (r *EntityRegistry) cleanupBefore(cutoff time.Time) int {
+	deleted := 0
+	for id, entity := range r.Entities {
+		if entity.Type == EntityPlayer || entity.Locked {
+			continue
+		}
+		if entity.UpdatedAt.Before(cutoff) {
+			delete(r.Entities, id)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		r.pruneRelationships()
+	}
+	return deleted
+}
@@ -0,0 +1,340 @@
+package game_engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RelationType 实体关系类型
+type RelationType string
+
+const (
+	RelationMasterDisciple RelationType = "master_disciple" // From 为师父，To 为弟子
+	RelationEnemy          RelationType = "enemy"
+	RelationAlly           RelationType = "ally"
+	RelationFamily         RelationType = "family"
+	RelationOwns           RelationType = "owns"       // From 拥有 To（如蛊虫）
+	RelationLocatedIn      RelationType = "located_in" // From 位于 To
+	RelationMemberOf       RelationType = "member_of"  // From 隶属于 To（如家族）
+)
+
+// relationLabels 关系类型的中文名称，用于构建提示词
+var relationLabels = map[RelationType]string{
+	RelationMasterDisciple: "师徒",
+	RelationEnemy:          "敌对",
+	RelationAlly:           "盟友",
+	RelationFamily:         "亲属",
+	RelationOwns:           "拥有",
+	RelationLocatedIn:      "位于",
+	RelationMemberOf:       "隶属",
+}
+
+// symmetricRelations 无方向的关系，存储时按ID排序两端
+var symmetricRelations = map[RelationType]bool{
+	RelationEnemy:  true,
+	RelationAlly:   true,
+	RelationFamily: true,
+}
+
+const defaultRelationStrength = 50
+
+// Relationship 实体之间的一条有类型的边
+type Relationship struct {
+	From      string       `json:"from"`
+	To        string       `json:"to"`
+	Type      RelationType `json:"type"`
+	Strength  int          `json:"strength"` // 关系强度 0-100
+	Notes     string       `json:"notes,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// ExtractedRelation AI输出的关系，两端使用实体名称（"玩家"表示玩家本人）
+type ExtractedRelation struct {
+	From     string       `json:"from"`
+	To       string       `json:"to"`
+	Type     RelationType `json:"type"`
+	Strength int          `json:"strength,omitempty"`
+	Notes    string       `json:"notes,omitempty"`
+	Removed  bool         `json:"removed,omitempty"` // 关系已结束
+}
+
+// other 边的另一端
+func (rel *Relationship) other(entityID string) string {
+	if rel.From == entityID {
+		return rel.To
+	}
+	return rel.From
+}
+
+// SetRelationship 添加或更新两个实体之间的关系，同一对实体的同一类型只保留一条边
+func (em *EntityManager) SetRelationship(playerID, modID, fromID, toID string, relType RelationType, strength int, notes string) error {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	return registry.setRelationship(fromID, toID, relType, strength, notes)
+}
+
+// RemoveRelationship 删除关系，不存在时返回false
+func (em *EntityManager) RemoveRelationship(playerID, modID, fromID, toID string, relType RelationType) bool {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	return registry.removeRelationship(fromID, toID, relType)
+}
+
+// Neighbors 与实体直接相连的关系，可按类型过滤
+func (em *EntityManager) Neighbors(playerID, modID, entityID string, types ...RelationType) []Relationship {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	var result []Relationship
+	for _, rel := range registry.Relations {
+		if (rel.From == entityID || rel.To == entityID) && matchesRelationType(rel.Type, types) {
+			result = append(result, *rel)
+		}
+	}
+	return result
+}
+
+// RelationshipsByType 指定类型的所有关系
+func (em *EntityManager) RelationshipsByType(playerID, modID string, relType RelationType) []Relationship {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	var result []Relationship
+	for _, rel := range registry.Relations {
+		if rel.Type == relType {
+			result = append(result, *rel)
+		}
+	}
+	return result
+}
+
+// FindPath 两个实体之间最短的关系路径（不考虑方向），maxDepth 内找不到时返回nil
+func (em *EntityManager) FindPath(playerID, modID, fromID, toID string, maxDepth int) []Relationship {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	if fromID == toID {
+		return []Relationship{}
+	}
+
+	// 广度优先搜索，记录到达每个实体所经过的边
+	via := map[string]*Relationship{fromID: nil}
+	frontier := []string{fromID}
+	for depth := 0; depth < maxDepth && len(frontier) > 0; depth++ {
+		var next []string
+		for _, id := range frontier {
+			for _, rel := range registry.Relations {
+				if rel.From != id && rel.To != id {
+					continue
+				}
+				neighbor := rel.other(id)
+				if _, seen := via[neighbor]; seen {
+					continue
+				}
+				via[neighbor] = rel
+				if neighbor == toID {
+					return registry.pathTo(via, fromID, toID)
+				}
+				next = append(next, neighbor)
+			}
+		}
+		frontier = next
+	}
+	return nil
+}
+
+func (r *EntityRegistry) pathTo(via map[string]*Relationship, fromID, toID string) []Relationship {
+	var path []Relationship
+	for id := toID; id != fromID; {
+		rel := via[id]
+		path = append([]Relationship{*rel}, path...)
+		id = rel.other(id)
+	}
+	return path
+}
+
+func (r *EntityRegistry) setRelationship(fromID, toID string, relType RelationType, strength int, notes string) error {
+	if _, ok := relationLabels[relType]; !ok {
+		return fmt.Errorf("unknown relation type: %s", relType)
+	}
+	if fromID == toID {
+		return fmt.Errorf("cannot relate entity %s to itself", fromID)
+	}
+	for _, id := range []string{fromID, toID} {
+		if r.Entities[id] == nil {
+			return fmt.Errorf("entity not found: %s", id)
+		}
+	}
+	if symmetricRelations[relType] && fromID > toID {
+		fromID, toID = toID, fromID
+	}
+	if strength <= 0 {
+		strength = defaultRelationStrength
+	}
+	if strength > 100 {
+		strength = 100
+	}
+
+	now := time.Now()
+	if rel := r.findRelationship(fromID, toID, relType); rel != nil {
+		rel.Strength = strength
+		if notes != "" {
+			rel.Notes = notes
+		}
+		rel.UpdatedAt = now
+		return nil
+	}
+	r.Relations = append(r.Relations, &Relationship{
+		From:      fromID,
+		To:        toID,
+		Type:      relType,
+		Strength:  strength,
+		Notes:     notes,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	return nil
+}
+
+func (r *EntityRegistry) removeRelationship(fromID, toID string, relType RelationType) bool {
+	if symmetricRelations[relType] && fromID > toID {
+		fromID, toID = toID, fromID
+	}
+	for i, rel := range r.Relations {
+		if rel.From == fromID && rel.To == toID && rel.Type == relType {
+			r.Relations = append(r.Relations[:i], r.Relations[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (r *EntityRegistry) findRelationship(fromID, toID string, relType RelationType) *Relationship {
+	for _, rel := range r.Relations {
+		if rel.From == fromID && rel.To == toID && rel.Type == relType {
+			return rel
+		}
+	}
+	return nil
+}
+
+// pruneRelationships 删除端点已不存在的关系
+func (r *EntityRegistry) pruneRelationships() {
+	kept := r.Relations[:0]
+	for _, rel := range r.Relations {
+		if r.Entities[rel.From] != nil && r.Entities[rel.To] != nil {
+			kept = append(kept, rel)
+		}
+	}
+	r.Relations = kept
+}
+
+// applyExtractedRelations 按名称解析两端实体后更新关系，无法解析或涉及锁定实体的跳过
+func (r *EntityRegistry) applyExtractedRelations(relations []ExtractedRelation) int {
+	applied := 0
+	for _, item := range relations {
+		from := r.resolveByName(item.From)
+		to := r.resolveByName(item.To)
+		if from == nil || to == nil || from.Locked || to.Locked {
+			continue
+		}
+		relType := RelationType(strings.ToLower(strings.TrimSpace(string(item.Type))))
+		if item.Removed {
+			if r.removeRelationship(from.ID, to.ID, relType) {
+				applied++
+			}
+			continue
+		}
+		if err := r.setRelationship(from.ID, to.ID, relType, item.Strength, item.Notes); err == nil {
+			applied++
+		}
+	}
+	return applied
+}
+
+// resolveByName 解析关系一端的名称，"玩家"/"player"指向玩家实体
+func (r *EntityRegistry) resolveByName(name string) *Entity {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "":
+		return nil
+	case "玩家", "player", "你":
+		return r.PlayerEntity
+	}
+	return r.resolve("", name, nil)
+}
+
+// sceneNPCs 名称或别称出现在场景文本中的NPC，按ID排序
+func (r *EntityRegistry) sceneNPCs(sceneText string, limit int) []*Entity {
+	var npcs []*Entity
+	for _, entity := range r.Entities {
+		if entity.Type != EntityNPC {
+			continue
+		}
+		for _, name := range append([]string{entity.Name}, entity.Aliases...) {
+			if name != "" && strings.Contains(sceneText, name) {
+				npcs = append(npcs, entity)
+				break
+			}
+		}
+	}
+	sort.Slice(npcs, func(i, j int) bool { return npcs[i].ID < npcs[j].ID })
+	if len(npcs) > limit {
+		npcs = npcs[:limit]
+	}
+	return npcs
+}
+
+// sceneSubgraph 玩家和场景中实体相关的边，按更新时间从新到旧排列
+func (r *EntityRegistry) sceneSubgraph(focus map[string]bool) []*Relationship {
+	var edges []*Relationship
+	for _, rel := range r.Relations {
+		if focus[rel.From] || focus[rel.To] {
+			edges = append(edges, rel)
+		}
+	}
+	sort.SliceStable(edges, func(i, j int) bool {
+		return edges[i].UpdatedAt.After(edges[j].UpdatedAt)
+	})
+	return edges
+}
+
+// describe 关系的文本形式
+func (r *EntityRegistry) describe(rel *Relationship) string {
+	name := func(id string) string {
+		if entity := r.Entities[id]; entity != nil && entity.Name != "" {
+			return entity.Name
+		}
+		return id
+	}
+	arrow := "→"
+	if symmetricRelations[rel.Type] {
+		arrow = "↔"
+	}
+	line := fmt.Sprintf("- %s %s %s：%s（强度%d）", name(rel.From), arrow, name(rel.To), relationLabels[rel.Type], rel.Strength)
+	if rel.Notes != "" {
+		line += " " + rel.Notes
+	}
+	return line
+}
+
+func matchesRelationType(relType RelationType, types []RelationType) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == relType {
+			return true
+		}
+	}
+	return false
+}
@@ -1,14 +1,25 @@
 package game_engine
 
 import (
+	"AIGE/services"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 )
 
+// entityOutputInstruction 要求AI在回合JSON中登记本回合出现的实体（结构化输出通道）
+const entityOutputInstruction = `【实体登记】如果本回合叙事中出现了人物、物品、地点或势力，请在输出的JSON中附加 entities 字段：
+"entities": [{"type": "npc|item|place|faction", "name": "名称", "aliases": ["其他称呼"], "attributes": {"身份": "..."}}]
+人物之间的关系发生变化时附加 relationships 字段（from/to 为实体名称，玩家本人写"玩家"，关系结束时 removed 为 true）：
+"relationships": [{"from": "名称", "to": "名称", "type": "master_disciple|enemy|ally|family|owns|located_in|member_of", "strength": 0-100, "notes": "..."}]
+已登记的实体请沿用相同的名称；没有新信息时可以省略这些字段。`
+
 // EntityIntegration 实体集成功能
 type EntityIntegration struct {
 	controller *GameController
+	wg         sync.WaitGroup // 后台AI提取任务
 }
 
 // NewEntityIntegration 创建实体集成
@@ -72,17 +83,20 @@ func (ei *EntityIntegration) ProcessGameStartWithEntities(
 }
 
 // ExtractAndRegisterEntitiesFromResponse 从AI响应中提取并注册实体
+// 优先使用回合JSON中的 entities 字段；没有该字段且MOD开启了 entity_extraction.ai_fallback 时，后台额外调用AI提取
 func (ei *EntityIntegration) ExtractAndRegisterEntitiesFromResponse(
-	playerID string,
-	modID string,
+	session *GameSession,
+	mod *GameMod,
 	aiResponse string,
-	stateUpdate map[string]interface{},
+	parsed map[string]interface{},
 ) error {
 	if ei.controller.stateManager.GetEntityManager() == nil {
 		return nil
 	}
 
 	entityManager := ei.controller.stateManager.GetEntityManager()
+	playerID, modID := session.PlayerID, session.ModID
+	stateUpdate, _ := parsed["state_update"].(map[string]interface{})
 
 	// 从state_update中提取current_life信息
 	if currentLife, ok := stateUpdate["current_life"].(map[string]interface{}); ok {
@@ -135,60 +149,92 @@ func (ei *EntityIntegration) ExtractAndRegisterEntitiesFromResponse(
 		}
 	}
 
-	// 提取NPC实体（简单的模式匹配）
-	ei.extractNPCsFromText(playerID, modID, aiResponse)
+	rawEntities, hasEntities := parsed["entities"]
+	if hasEntities {
+		created, updated := entityManager.MergeExtractedEntities(playerID, modID, parseExtractedEntities(rawEntities))
+		fmt.Printf("[实体集成] 结构化输出登记实体: 新增%d, 更新%d\n", created, updated)
+	}
+	if raw, ok := parsed["relationships"]; ok {
+		applied := entityManager.ApplyExtractedRelations(playerID, modID, parseExtractedRelations(raw))
+		fmt.Printf("[实体集成] 结构化输出更新关系: %d条\n", applied)
+	}
+	if hasEntities {
+		return nil
+	}
+
+	if mod == nil || !mod.Config.GameConfig.EntityExtraction.AIFallback {
+		return nil
+	}
+	narrative := extractNarrative(aiResponse)
+	if narrative == "" {
+		narrative, _ = parsed["narrative"].(string)
+	}
+	if strings.TrimSpace(narrative) == "" {
+		return nil
+	}
 
+	ei.wg.Add(1)
+	go func() {
+		defer ei.wg.Done()
+		extracted, err := ei.extractWithAI(session, mod, narrative)
+		if err != nil {
+			fmt.Printf("[实体集成] AI提取实体失败: %v\n", err)
+			return
+		}
+		created, updated := entityManager.MergeExtractedEntities(playerID, modID, extracted)
+		fmt.Printf("[实体集成] AI提取实体: 新增%d, 更新%d\n", created, updated)
+		// 只写实体注册表，状态可能正被下一回合修改
+		if created+updated > 0 && ei.controller.stateManager.isCurrent(session) {
+			if err := ei.controller.stateManager.SaveEntityRegistry(playerID, modID); err != nil {
+				fmt.Printf("[实体集成] 保存实体失败: %v\n", err)
+			}
+		}
+	}()
 	return nil
 }
 
-// extractNPCsFromText 从文本中提取NPC
-func (ei *EntityIntegration) extractNPCsFromText(playerID, modID, text string) {
-	entityManager := ei.controller.stateManager.GetEntityManager()
+// extractWithAI 单独调用AI从叙事中提取实体
+func (ei *EntityIntegration) extractWithAI(session *GameSession, mod *GameMod, narrative string) ([]ExtractedEntity, error) {
+	gc := ei.controller
+	known := gc.stateManager.GetEntityManager().KnownEntityNames(session.PlayerID, session.ModID)
+	prompt := fmt.Sprintf(`请从以下游戏叙事中提取出现的人物(npc)、物品(item)、地点(place)和势力(faction)。
+不要提取玩家本人；已登记的实体请使用相同的名称，新的称呼放入 aliases。
+实体之间的关系放入 relations（to 为对方名称，玩家本人写"玩家"），类型为 master_disciple、enemy、ally、family、owns、located_in、member_of 之一。
 
-	// 简单的NPC名字提取规则
-	patterns := []string{
-		"一位名叫", "名为", "自称", "道号", "法号",
-	}
+已登记实体：%s
 
-	for _, pattern := range patterns {
-		if idx := strings.Index(text, pattern); idx != -1 {
-			// 提取名字（简化版本，实际应该更复杂）
-			nameStart := idx + len(pattern)
-			nameEnd := nameStart
-
-			// 找到名字的结束位置（通常是"的"、"，"、"。"等）
-			textRunes := []rune(text[nameStart:])
-			for i := 0; i < len(textRunes) && i < 20; i++ {
-				runeValue := textRunes[i]
-				if runeValue == '的' || runeValue == '，' || runeValue == '。' ||
-					runeValue == '！' || runeValue == '？' || runeValue == ' ' ||
-					runeValue == '"' || runeValue == '「' || runeValue == '」' {
-					nameEnd = nameStart + len(string(textRunes[:i]))
-					break
-				}
-			}
+叙事：
+%s
 
-			if nameEnd > nameStart {
-				npcName := text[nameStart:nameEnd]
-				npcName = strings.TrimSpace(npcName)
-
-				if npcName != "" && len(npcName) < 10 {
-					// 创建NPC实体
-					npcEntity := &Entity{
-						ID:         fmt.Sprintf("npc_%s_%d", npcName, time.Now().Unix()),
-						Type:       EntityNPC,
-						Name:       npcName,
-						Attributes: make(map[string]interface{}),
-						CreatedAt:  time.Now(),
-						UpdatedAt:  time.Now(),
-					}
-
-					// 尝试注册（如果已存在会失败，但不影响）
-					entityManager.RegisterEntity(playerID, modID, npcEntity)
-				}
-			}
-		}
+只输出JSON：{"entities": [{"type": "npc", "name": "名称", "aliases": [], "attributes": {}, "relations": [{"to": "玩家", "type": "ally", "strength": 60}]}]}`,
+		strings.Join(known, "、"), narrative)
+
+	provider := gc.GetProviderForMod(mod.Config.GameID)
+	if provider.APIKey == "" && services.RequiresAPIKey(provider.APIType) {
+		return nil, fmt.Errorf("AI provider not configured")
+	}
+	messages := []services.Message{{Role: "user", Content: prompt}}
+	response, err := gc.aiClient.Chat(provider.chatRequest(messages, mod.generationParams(PurposeEntityExtraction)))
+	if err != nil {
+		return nil, err
+	}
+
+	jsonStr := extractJSON(response.Content)
+	if jsonStr == "" {
+		return nil, fmt.Errorf("no valid JSON found in extraction response")
+	}
+	var result struct {
+		Entities []interface{} `json:"entities"`
 	}
+	if err := json.Unmarshal([]byte(jsonStr), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse extraction response: %w", err)
+	}
+	return parseExtractedEntities(result.Entities), nil
+}
+
+// Wait 等待后台提取任务结束
+func (ei *EntityIntegration) Wait() {
+	ei.wg.Wait()
 }
 
 // ValidateResponseConsistency 验证AI响应的一致性
@@ -201,74 +247,16 @@ func (ei *EntityIntegration) ValidateResponseConsistency(
 		return true, nil
 	}
 
-	entityManager := ei.controller.stateManager.GetEntityManager()
-	violations := []string{}
-
-	// 获取玩家实体
-	playerEntity, err := entityManager.GetEntity(playerID, modID, fmt.Sprintf("player_%s", playerID))
-	if err != nil {
-		return true, nil // 没有玩家实体，跳过验证
-	}
-
-	// 检查性别一致性
-	if gender, ok := playerEntity.Attributes["gender"].(string); ok {
-		if gender == "男" || gender == "male" {
-			// 检查是否有女性称谓
-			femaleTerms := []string{"她", "女侠", "仙子", "姑娘", "女子", "女修"}
-			for _, term := range femaleTerms {
-				if strings.Contains(aiResponse, term) {
-					// 排除一些特殊情况（如描述其他角色）
-					if !ei.isDescribingOthers(aiResponse, term) {
-						violations = append(violations,
-							fmt.Sprintf("性别不一致：玩家是男性，但出现了'%s'", term))
-					}
-				}
-			}
-		} else if gender == "女" || gender == "female" {
-			// 检查是否有男性称谓
-			maleTerms := []string{"他", "公子", "少侠", "道友", "男子", "男修"}
-			for _, term := range maleTerms {
-				if strings.Contains(aiResponse, term) {
-					// 特殊处理"他"，因为可能是"其他"等词
-					if term == "他" && strings.Contains(aiResponse, "其他") {
-						continue
-					}
-					if !ei.isDescribingOthers(aiResponse, term) {
-						violations = append(violations,
-							fmt.Sprintf("性别不一致：玩家是女性，但出现了'%s'", term))
-					}
-				}
-			}
-		}
-	}
-
-	// 检查名字一致性
-	if name, ok := playerEntity.Attributes["name"].(string); ok && name != "" {
-		// 检查是否AI生成了不同的名字
-		if strings.Contains(aiResponse, "你叫") || strings.Contains(aiResponse, "你是") {
-			// 这里可以添加更复杂的名字检测逻辑
-		}
+	err := ei.controller.stateManager.GetEntityManager().ValidateConsistency(playerID, modID, aiResponse)
+	consistencyErr, ok := err.(*ConsistencyError)
+	if !ok {
+		return true, nil
 	}
-
-	return len(violations) == 0, violations
-}
-
-// isDescribingOthers 判断是否在描述其他角色
-func (ei *EntityIntegration) isDescribingOthers(text, term string) bool {
-	// 简单的判断逻辑，检查是否在描述其他NPC
-	// 可以根据上下文改进
-	idx := strings.Index(text, term)
-	if idx > 10 {
-		// 检查前面是否有"看到"、"遇到"、"一位"等词
-		prefix := text[idx-10 : idx]
-		if strings.Contains(prefix, "看到") ||
-			strings.Contains(prefix, "遇到") ||
-			strings.Contains(prefix, "一位") ||
-			strings.Contains(prefix, "那位") {
-			return true
-		}
+	violations := make([]string, len(consistencyErr.Violations))
+	for i, v := range consistencyErr.Violations {
+		violations[i] = v.Message
 	}
-	return false
+	return false, violations
 }
 
 // InjectEntityContextIntoPrompt 向提示词注入实体上下文
@@ -281,7 +269,7 @@ func (ei *EntityIntegration) InjectEntityContextIntoPrompt(
 		return basePrompt
 	}
 
-	entityContext := ei.controller.stateManager.GetEntityManager().BuildEntityContext(playerID, modID)
+	entityContext := ei.controller.stateManager.GetEntityManager().BuildEntityContext(playerID, modID, basePrompt)
 	if entityContext == "" {
 		return basePrompt
 	}
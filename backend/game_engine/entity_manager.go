@@ -16,7 +16,8 @@ const (
 	EntityPlayer EntityType = "player"
 	EntityNPC    EntityType = "npc"
 	EntityItem   EntityType = "item"
-	EntityPlace  EntityType = "place"
+	EntityPlace   EntityType = "place"
+	EntityFaction EntityType = "faction"
 )
 
 // Entity 核心实体结构
@@ -24,6 +25,7 @@ type Entity struct {
 	ID         string                 `json:"id"`          // 唯一标识符
 	Type       EntityType             `json:"type"`        // 实体类型
 	Name       string                 `json:"name"`        // 名称
+	Aliases    []string               `json:"aliases,omitempty"` // 别称，用于识别同一实体的不同叫法
 	Attributes map[string]interface{} `json:"attributes"`  // 属性集合
 	Locked     bool                   `json:"locked"`      // 是否锁定（锁定后不可修改）
 	CreatedAt  time.Time              `json:"created_at"`  // 创建时间
@@ -35,7 +37,9 @@ type EntityRegistry struct {
 	Entities      map[string]*Entity `json:"entities"`       // 所有实体
 	PlayerEntity  *Entity            `json:"player_entity"`  // 玩家实体引用
 	LockedFields  []string           `json:"locked_fields"`  // 锁定的字段列表
-	Relationships map[string]string  `json:"relationships"`  // 实体关系映射
+	Relations     []*Relationship    `json:"relations"`      // 实体关系图
+
+	mu sync.RWMutex // 保护 Entities，后台实体提取与回合处理可能同时访问
 }
 
 // EntityManager 实体管理器
@@ -106,7 +110,6 @@ func (em *EntityManager) GetOrCreateRegistry(playerID, modID string) *EntityRegi
 
 	registry := &EntityRegistry{
 		Entities:      make(map[string]*Entity),
-		Relationships: make(map[string]string),
 		LockedFields: []string{
 			"player.gender",
 			"player.birthplace",
@@ -126,6 +129,9 @@ func (em *EntityManager) RegisterEntity(playerID, modID string, entity *Entity)
 		return fmt.Errorf("entity validation failed: %v", err)
 	}
 
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
 	// 如果是玩家实体，特殊处理
 	if entity.Type == EntityPlayer {
 		if registry.PlayerEntity != nil && registry.PlayerEntity.ID != entity.ID {
@@ -148,6 +154,8 @@ func (em *EntityManager) RegisterEntity(playerID, modID string, entity *Entity)
 // GetEntity 获取实体
 func (em *EntityManager) GetEntity(playerID, modID, entityID string) (*Entity, error) {
 	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
 
 	if entity, exists := registry.Entities[entityID]; exists {
 		return entity, nil
@@ -159,6 +167,8 @@ func (em *EntityManager) GetEntity(playerID, modID, entityID string) (*Entity, e
 // UpdateEntity 更新实体（检查锁定字段）
 func (em *EntityManager) UpdateEntity(playerID, modID, entityID string, updates map[string]interface{}) error {
 	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
 
 	entity, exists := registry.Entities[entityID]
 	if !exists {
@@ -198,76 +208,21 @@ func (em *EntityManager) UpdateEntity(playerID, modID, entityID string, updates
 	return nil
 }
 
-// ExtractEntitiesFromText AI文本中提取实体信息
-func (em *EntityManager) ExtractEntitiesFromText(text string, existingRegistry *EntityRegistry) map[string]*Entity {
-	entities := make(map[string]*Entity)
-
-	// 简单的实体提取规则（可以用更复杂的NLP）
-	// patterns := map[string][]string{
-	// 	"npc": {
-	// 		"一位名叫(.+?)的",
-	// 		"(.+?)说道",
-	// 		"遇到了(.+?)，",
-	// 		"见到(.+?)正在",
-	// 	},
-	// 	"place": {
-	// 		"来到(.+?)，",
-	// 		"在(.+?)中",
-	// 		"前往(.+?)",
-	// 		"位于(.+?)的",
-	// 	},
-	// }
-
-	// 这里简化处理，实际应该用更复杂的提取逻辑
-	// 可以集成NLP库或使用正则表达式
-
-	return entities
-}
-
-// ValidateConsistency 验证实体一致性
+// ValidateConsistency 用性别代词规则检查一段文本
 func (em *EntityManager) ValidateConsistency(playerID, modID string, aiResponse string) error {
-	registry := em.GetOrCreateRegistry(playerID, modID)
-
-	if registry.PlayerEntity == nil {
-		return nil // 还没有玩家实体，跳过验证
-	}
-
-	// 检查关键属性是否被违反
-	violations := []string{}
-
-	// 检查性别一致性
-	if gender, exists := registry.PlayerEntity.Attributes["gender"]; exists {
-		genderStr := fmt.Sprintf("%v", gender)
-		// 检查是否有相反性别的描述
-		if (genderStr == "男" || genderStr == "male") &&
-		   (strings.Contains(aiResponse, "她") || strings.Contains(aiResponse, "女侠") ||
-		    strings.Contains(aiResponse, "仙子") || strings.Contains(aiResponse, "姑娘")) {
-			violations = append(violations, fmt.Sprintf("性别不一致：玩家是%s性，但描述中出现女性称谓", genderStr))
-		}
-		if (genderStr == "女" || genderStr == "female") &&
-		   (strings.Contains(aiResponse, "他") && !strings.Contains(aiResponse, "她") ||
-		    strings.Contains(aiResponse, "公子") || strings.Contains(aiResponse, "少侠")) {
-			violations = append(violations, fmt.Sprintf("性别不一致：玩家是%s性，但描述中出现男性称谓", genderStr))
-		}
-	}
-
-	// 检查名字一致性
-	if name, exists := registry.PlayerEntity.Attributes["name"]; exists {
-		nameStr := fmt.Sprintf("%v", name)
-		// 这里可以添加更复杂的名字验证逻辑
-		_ = nameStr
-	}
-
+	rules := []configuredRule{{ruleType: "gender_pronoun", severity: SeverityHard, rule: genderPronounRule{}}}
+	violations := em.CheckConsistency(playerID, modID, rules, &ConsistencyContext{Narrative: aiResponse})
 	if len(violations) > 0 {
-		return fmt.Errorf("实体一致性验证失败：%s", strings.Join(violations, "; "))
+		return &ConsistencyError{Violations: violations}
 	}
-
 	return nil
 }
 
 // SerializeRegistry 序列化注册表
 func (em *EntityManager) SerializeRegistry(playerID, modID string) (string, error) {
 	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
 
 	data, err := json.Marshal(registry)
 	if err != nil {
@@ -287,6 +242,16 @@ func (em *EntityManager) DeserializeRegistry(playerID, modID string, data string
 		return fmt.Errorf("failed to deserialize registry: %v", err)
 	}
 
+	if registry.Entities == nil {
+		registry.Entities = make(map[string]*Entity)
+	}
+	// 反序列化后玩家实体引用与 Entities 中的是两个对象，重新指向同一个
+	if registry.PlayerEntity != nil {
+		if entity, exists := registry.Entities[registry.PlayerEntity.ID]; exists {
+			registry.PlayerEntity = entity
+		}
+	}
+
 	key := fmt.Sprintf("%s_%s", playerID, modID)
 	em.registries[key] = &registry
 	return nil
@@ -331,9 +296,12 @@ func (ev *EntityValidator) ValidateEntity(entity *Entity) error {
 	return nil
 }
 
-// BuildEntityContext 构建实体上下文提示
-func (em *EntityManager) BuildEntityContext(playerID, modID string) string {
+// BuildEntityContext 构建实体上下文提示，只包含场景中出现的NPC（sceneText 中提到名称或别称的）
+// 以及玩家和这些NPC周围的关系
+func (em *EntityManager) BuildEntityContext(playerID, modID, sceneText string) string {
 	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
 
 	if registry.PlayerEntity == nil && len(registry.Entities) == 0 {
 		return ""
@@ -368,17 +336,33 @@ func (em *EntityManager) BuildEntityContext(playerID, modID string) string {
 		contextBuilder.WriteString("\n")
 	}
 
-	// 重要NPC信息
-	npcCount := 0
-	for _, entity := range registry.Entities {
-		if entity.Type == EntityNPC && npcCount < 10 {
-			contextBuilder.WriteString(fmt.Sprintf("【NPC: %s】\n", entity.Name))
-			for key, value := range entity.Attributes {
-				contextBuilder.WriteString(fmt.Sprintf("- %s: %v\n", key, value))
+	// 场景中出现的NPC
+	focus := make(map[string]bool)
+	if registry.PlayerEntity != nil {
+		focus[registry.PlayerEntity.ID] = true
+	}
+	for _, entity := range registry.sceneNPCs(sceneText, 10) {
+		focus[entity.ID] = true
+		contextBuilder.WriteString(fmt.Sprintf("【NPC: %s】\n", entity.Name))
+		if len(entity.Aliases) > 0 {
+			contextBuilder.WriteString(fmt.Sprintf("- 别称: %s\n", strings.Join(entity.Aliases, "、")))
+		}
+		for key, value := range entity.Attributes {
+			contextBuilder.WriteString(fmt.Sprintf("- %s: %v\n", key, value))
+		}
+		contextBuilder.WriteString("\n")
+	}
+
+	// 玩家和场景NPC周围的关系
+	if edges := registry.sceneSubgraph(focus); len(edges) > 0 {
+		contextBuilder.WriteString("【人物关系】\n")
+		for i, rel := range edges {
+			if i >= 20 {
+				break
 			}
-			contextBuilder.WriteString("\n")
-			npcCount++
+			contextBuilder.WriteString(registry.describe(rel) + "\n")
 		}
+		contextBuilder.WriteString("\n")
 	}
 
 	contextBuilder.WriteString("请严格遵守以上标记为[锁定]的信息，确保描述的一致性。\n")
@@ -386,24 +370,11 @@ func (em *EntityManager) BuildEntityContext(playerID, modID string) string {
 	return contextBuilder.String()
 }
 
-// CleanupOldEntities 清理过期的实体（可选）
+// CleanupOldEntities 清理超过 keepDays 天未更新的实体，玩家实体和锁定实体保留
 func (em *EntityManager) CleanupOldEntities(playerID, modID string, keepDays int) int {
 	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
 
-	cutoffTime := time.Now().AddDate(0, 0, -keepDays)
-	deletedCount := 0
-
-	for id, entity := range registry.Entities {
-		// 不删除玩家实体和锁定的实体
-		if entity.Type == EntityPlayer || entity.Locked {
-			continue
-		}
-
-		if entity.UpdatedAt.Before(cutoffTime) {
-			delete(registry.Entities, id)
-			deletedCount++
-		}
-	}
-
-	return deletedCount
-}
\ No newline at end of file
+	return registry.cleanupBefore(time.Now().AddDate(0, 0, -keepDays))
+}
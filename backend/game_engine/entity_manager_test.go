@@ -1,7 +1,12 @@
 package game_engine
 
 import (
+	"AIGE/config"
+	"AIGE/models"
+	"AIGE/services"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -178,7 +183,7 @@ func TestEntityContextBuilding(t *testing.T) {
 	em.RegisterEntity("789", "xiuxian", npcEntity)
 
 	// 构建上下文
-	context := em.BuildEntityContext("789", "xiuxian")
+	context := em.BuildEntityContext("789", "xiuxian", "王长老前来传话")
 
 	// 验证上下文包含必要信息
 	if context == "" {
@@ -203,4 +208,207 @@ func TestEntityContextBuilding(t *testing.T) {
 
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && (s[:len(substr)] == substr || contains(s[1:], substr)))
-}
\ No newline at end of file
+}
+func TestMergeExtractedEntitiesResolvesAliases(t *testing.T) {
+	em := NewEntityManager()
+	em.RegisterEntity("1", "xiuxian", &Entity{ID: "player_1", Type: EntityPlayer, Name: "方源"})
+
+	created, _ := em.MergeExtractedEntities("1", "xiuxian", []ExtractedEntity{
+		{Type: EntityNPC, Name: "方正", Aliases: []string{"弟弟"}},
+		{Type: EntityPlace, Name: "古月山寨"},
+		{Type: EntityNPC, Name: "方源"}, // 玩家本人不会被登记为NPC
+	})
+	if created != 2 {
+		t.Fatalf("Expected 2 new entities, got %d", created)
+	}
+
+	// 别称精确匹配、名称包含匹配都解析到已有实体，不会重复创建
+	created, updated := em.MergeExtractedEntities("1", "xiuxian", []ExtractedEntity{
+		{Type: EntityNPC, Name: "弟弟", Attributes: map[string]interface{}{"修为": "一转"}},
+		{Type: EntityNPC, Name: "方正公子"},
+		{Type: EntityNPC, Name: "古月方正"},
+		{Type: EntityNPC, Name: "春秋蝉"},
+	})
+	if created != 1 || updated != 3 {
+		t.Fatalf("Expected 1 created and 3 updated, got %d and %d", created, updated)
+	}
+
+	npc, err := em.GetEntity("1", "xiuxian", "npc_方正")
+	if err != nil {
+		t.Fatalf("Expected stable NPC id: %v", err)
+	}
+	if npc.Attributes["修为"] != "一转" || len(npc.Aliases) != 3 {
+		t.Errorf("Unexpected merged NPC: %+v", npc)
+	}
+	if names := em.KnownEntityNames("1", "xiuxian"); len(names) != 3 {
+		t.Errorf("Expected 3 known entities, got %v", names)
+	}
+}
+
+func TestExtractEntitiesFromTurn(t *testing.T) {
+	// 回合JSON中的 entities 字段直接登记，不额外调用AI
+	mock := services.NewMockClient(services.MockConfig{
+		Responses: []services.MockResponse{
+			{Content: `$白凝冰拦住了去路。$@{"state_update":{},"entities":[{"type":"npc","name":"白凝冰","aliases":["白家少主"]}]}@`},
+		},
+	})
+	gc, sm := newMockGame(t, mock)
+	if err := gc.ProcessActionStreamWithAttributes("1", "test", "前进", nil,
+		func(string) error { return nil }, nil, func(string) error { return nil }); err != nil {
+		t.Fatalf("Action failed: %v", err)
+	}
+	if len(mock.Calls()) != 1 {
+		t.Errorf("Expected no extraction call, got %d calls", len(mock.Calls()))
+	}
+	if _, err := sm.GetEntityManager().GetEntity("1", "test", "npc_白凝冰"); err != nil {
+		t.Errorf("Expected NPC from structured output: %v", err)
+	}
+
+	// 没有 entities 字段且开启 ai_fallback 时后台调用AI提取
+	mod, _ := gc.modLoader.GetMod("test")
+	mod.Config.GameConfig.EntityExtraction.AIFallback = true
+	mock = services.NewMockClient(services.MockConfig{
+		Responses: []services.MockResponse{
+			{Content: `$白家少主身旁站着一位老者。$@{"state_update":{}}@`},
+			{Content: `{"entities":[{"type":"npc","name":"白家少主"},{"type":"npc","name":"白家长老"}]}`},
+		},
+	})
+	gc.SetLLMClient(mock)
+	if err := gc.ProcessActionStreamWithAttributes("1", "test", "观察", nil,
+		func(string) error { return nil }, nil, func(string) error { return nil }); err != nil {
+		t.Fatalf("Action failed: %v", err)
+	}
+	gc.entityIntegration.Wait()
+
+	if names := sm.GetEntityManager().KnownEntityNames("1", "test"); len(names) != 2 {
+		t.Errorf("Expected alias to resolve and one new NPC, got %v", names)
+	}
+}
+
+func TestRelationshipGraph(t *testing.T) {
+	em := NewEntityManager()
+	em.RegisterEntity("1", "xiuxian", &Entity{ID: "player_1", Type: EntityPlayer, Name: "方源"})
+	em.MergeExtractedEntities("1", "xiuxian", []ExtractedEntity{
+		{Type: EntityNPC, Name: "方正", Relations: []ExtractedRelation{{To: "玩家", Type: RelationFamily, Strength: 30}}},
+		{Type: EntityNPC, Name: "古月博", Relations: []ExtractedRelation{{To: "古月家族", Type: RelationMemberOf}}},
+		{Type: EntityFaction, Name: "古月家族"},
+		{Type: EntityNPC, Name: "白凝冰"},
+	})
+	em.ApplyExtractedRelations("1", "xiuxian", []ExtractedRelation{
+		{From: "方正", To: "古月家族", Type: RelationMemberOf},
+		{From: "白凝冰", To: "玩家", Type: RelationEnemy, Strength: 90},
+		{From: "白凝冰", To: "方正", Type: "unknown"},
+	})
+
+	// 对称关系无论从哪一端写入都是同一条边
+	if err := em.SetRelationship("1", "xiuxian", "player_1", "npc_方正", RelationFamily, 40, "兄弟"); err != nil {
+		t.Fatalf("Failed to set relationship: %v", err)
+	}
+	family := em.Neighbors("1", "xiuxian", "player_1", RelationFamily)
+	if len(family) != 1 || family[0].Strength != 40 || family[0].Notes != "兄弟" {
+		t.Fatalf("Unexpected family relations: %+v", family)
+	}
+	if len(em.RelationshipsByType("1", "xiuxian", RelationMemberOf)) != 2 {
+		t.Errorf("Expected two member_of relations")
+	}
+
+	path := em.FindPath("1", "xiuxian", "player_1", "npc_古月博", 4)
+	if len(path) != 3 || path[2].From != "npc_古月博" {
+		t.Errorf("Unexpected path: %+v", path)
+	}
+	if em.FindPath("1", "xiuxian", "player_1", "npc_古月博", 2) != nil {
+		t.Errorf("Expected no path within depth 2")
+	}
+
+	// 场景中只有方正时，不出现与场景无关的古月博
+	context := em.BuildEntityContext("1", "xiuxian", "方正推门而入")
+	for _, want := range []string{"【NPC: 方正】", "方正 ↔ 方源：亲属（强度40） 兄弟", "白凝冰 ↔ 方源：敌对（强度90）", "方正 → 古月家族：隶属"} {
+		if !strings.Contains(context, want) {
+			t.Errorf("Expected context to contain %q:\n%s", want, context)
+		}
+	}
+	if strings.Contains(context, "古月博") || strings.Contains(context, "【NPC: 白凝冰】") {
+		t.Errorf("Expected unrelated entities to be omitted:\n%s", context)
+	}
+
+	// 锁定实体的关系不受AI输出影响
+	em.SetEntityLocked("1", "xiuxian", "npc_白凝冰", true)
+	if applied := em.ApplyExtractedRelations("1", "xiuxian", []ExtractedRelation{
+		{From: "白凝冰", To: "玩家", Type: RelationEnemy, Removed: true},
+		{From: "白凝冰", To: "古月家族", Type: RelationMemberOf},
+	}); applied != 0 || len(em.Neighbors("1", "xiuxian", "npc_白凝冰")) != 1 {
+		t.Errorf("Expected locked entity relations to stay unchanged, applied %d", applied)
+	}
+
+	if !em.RemoveRelationship("1", "xiuxian", "npc_方正", "player_1", RelationFamily) {
+		t.Errorf("Expected relationship to be removed")
+	}
+}
+
+func TestEntityAdminOperations(t *testing.T) {
+	_, sm := newMockGame(t, services.NewMockClient(services.MockConfig{}))
+	em := sm.GetEntityManager()
+
+	em.RegisterEntity("1", "test", &Entity{ID: "player_1", Type: EntityPlayer, Name: "方源", Attributes: map[string]interface{}{"gender": "男"}})
+	em.RegisterEntity("1", "test", &Entity{ID: "npc_方正", Type: EntityNPC, Name: "方正"})
+	em.RegisterEntity("1", "test", &Entity{ID: "npc_路人", Type: EntityNPC, Name: "路人"})
+	em.SetRelationship("1", "test", "player_1", "npc_路人", RelationEnemy, 0, "")
+
+	// 管理员修正属性和别称，无效的值被拒绝
+	name := "古月方正"
+	edited, err := em.EditEntity("1", "test", "npc_方正", EntityEdit{Name: &name, Aliases: []string{"方正"}, Attributes: map[string]interface{}{"身份": "族长候选"}})
+	if err != nil || edited.Name != "古月方正" || edited.Attributes["身份"] != "族长候选" || len(edited.Aliases) != 1 {
+		t.Errorf("Expected entity to be edited, got %+v, %v", edited, err)
+	}
+	if _, err := em.EditEntity("1", "test", "player_1", EntityEdit{Attributes: map[string]interface{}{"gender": "未知"}}); err == nil {
+		t.Errorf("Expected invalid gender to be rejected")
+	}
+	if err := em.DeleteEntity("1", "test", "player_1"); err == nil {
+		t.Errorf("Expected player entity deletion to be rejected")
+	}
+
+	// 锁定后AI提取不能修改
+	if err := em.SetEntityLocked("1", "test", "npc_方正", true); err != nil {
+		t.Fatalf("Failed to lock entity: %v", err)
+	}
+	em.MergeExtractedEntities("1", "test", []ExtractedEntity{{Type: EntityNPC, Name: "古月方正", Attributes: map[string]interface{}{"身份": "叛徒"}}})
+	entity, _, _ := em.EntitySnapshot("1", "test", "npc_方正")
+	if entity.Attributes["身份"] != "族长候选" {
+		t.Errorf("Expected locked entity to keep its attributes, got %v", entity.Attributes)
+	}
+
+	// 过期清理：内存中的注册表和未载入的存档都会被清理，锁定实体保留
+	stale := time.Now().AddDate(0, 0, -40)
+	for _, id := range []string{"npc_方正", "npc_路人"} {
+		e, _ := em.GetEntity("1", "test", id)
+		e.UpdatedAt = stale
+	}
+	other := EntityRegistry{Entities: map[string]*Entity{
+		"npc_白凝冰": {ID: "npc_白凝冰", Type: EntityNPC, Name: "白凝冰", UpdatedAt: stale},
+	}}
+	data, _ := json.Marshal(&other)
+	if err := config.DB.Create(&models.GameSave{UserID: 2, ModID: "test", SessionDate: "2024-01-01", State: "{}", EntityRegistry: string(data)}).Error; err != nil {
+		t.Fatalf("Failed to create save: %v", err)
+	}
+	session, _ := sm.GetSession("1", "test")
+	if err := sm.SaveSession(session); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+	if err := sm.SaveEntityRegistry("1", "test"); err != nil {
+		t.Fatalf("Failed to save registry: %v", err)
+	}
+
+	deleted, err := sm.CleanupEntities(30)
+	if err != nil || deleted != 2 {
+		t.Fatalf("Expected 2 stale entities to be deleted, got %d, %v", deleted, err)
+	}
+	entities, relations := em.ListEntities("1", "test", "")
+	if len(entities) != 2 || len(relations) != 0 {
+		t.Errorf("Expected player and locked NPC to remain without dangling relations, got %d entities, %d relations", len(entities), len(relations))
+	}
+	var save models.GameSave
+	config.DB.Where("user_id = ?", 2).First(&save)
+	if strings.Contains(save.EntityRegistry, "白凝冰") {
+		t.Errorf("Expected stale entity to be removed from stored save, got %s", save.EntityRegistry)
+	}
+}
@@ -0,0 +1,273 @@
+package game_engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ExtractedEntity AI从回合叙事中提取出的实体
+type ExtractedEntity struct {
+	Type       EntityType             `json:"type"`
+	Name       string                 `json:"name"`
+	Aliases    []string               `json:"aliases,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Relations  []ExtractedRelation    `json:"relations,omitempty"` // from 为空时表示该实体本身
+}
+
+// extractableTypes 允许AI提取的实体类型，玩家实体只由游戏开始和状态更新维护
+var extractableTypes = map[EntityType]bool{
+	EntityNPC:     true,
+	EntityItem:    true,
+	EntityPlace:   true,
+	EntityFaction: true,
+}
+
+// parseExtractedRelations 解析AI输出的 relationships 数组
+func parseExtractedRelations(raw interface{}) []ExtractedRelation {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var relations []ExtractedRelation
+	if err := json.Unmarshal(data, &relations); err != nil {
+		return nil
+	}
+	return relations
+}
+
+// parseExtractedEntities 解析AI输出的 entities 数组，忽略类型未知或没有名称的条目
+func parseExtractedEntities(raw interface{}) []ExtractedEntity {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var items []ExtractedEntity
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil
+	}
+
+	var result []ExtractedEntity
+	for _, item := range items {
+		item.Type = EntityType(strings.ToLower(strings.TrimSpace(string(item.Type))))
+		item.Name = strings.TrimSpace(item.Name)
+		if item.Name == "" || !extractableTypes[item.Type] {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// MergeExtractedEntities 将提取结果合并到注册表：能解析到已有实体的补充别称和属性，否则创建新实体
+func (em *EntityManager) MergeExtractedEntities(playerID, modID string, extracted []ExtractedEntity) (created, updated int) {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for _, item := range extracted {
+		if entity := registry.resolve(item.Type, item.Name, item.Aliases); entity != nil {
+			// 玩家实体和锁定实体不接受AI提取的修改
+			if entity.Type == EntityPlayer || entity.Locked {
+				continue
+			}
+			entity.addAliases(append([]string{item.Name}, item.Aliases...))
+			if entity.Attributes == nil {
+				entity.Attributes = make(map[string]interface{})
+			}
+			for k, v := range item.Attributes {
+				entity.Attributes[k] = v
+			}
+			entity.UpdatedAt = time.Now()
+			updated++
+			continue
+		}
+
+		entity := &Entity{
+			ID:         registry.newEntityID(item.Type, item.Name),
+			Type:       item.Type,
+			Name:       item.Name,
+			Attributes: item.Attributes,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		if entity.Attributes == nil {
+			entity.Attributes = make(map[string]interface{})
+		}
+		entity.addAliases(item.Aliases)
+		if err := em.validator.ValidateEntity(entity); err != nil {
+			log.Printf("Skipped extracted entity %s: %v", item.Name, err)
+			continue
+		}
+		registry.Entities[entity.ID] = entity
+		created++
+	}
+
+	// 实体全部登记后再处理关系，关系可以指向同一批提取出的实体
+	var relations []ExtractedRelation
+	for _, item := range extracted {
+		for _, rel := range item.Relations {
+			if rel.From == "" {
+				rel.From = item.Name
+			}
+			relations = append(relations, rel)
+		}
+	}
+	registry.applyExtractedRelations(relations)
+	return created, updated
+}
+
+// ApplyExtractedRelations 按名称更新关系图，返回生效的条数
+func (em *EntityManager) ApplyExtractedRelations(playerID, modID string, relations []ExtractedRelation) int {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	return registry.applyExtractedRelations(relations)
+}
+
+// KnownEntityNames 注册表中已有实体的名称，提示AI沿用相同的叫法
+func (em *EntityManager) KnownEntityNames(playerID, modID string) []string {
+	registry := em.GetOrCreateRegistry(playerID, modID)
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	var names []string
+	for _, entity := range registry.Entities {
+		if entity.Type != EntityPlayer {
+			names = append(names, fmt.Sprintf("%s(%s)", entity.Name, entity.Type))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolve 按名称和别称查找实体：先在所有实体中精确匹配，再在同类型实体中模糊匹配（类型为空时不限类型）
+func (r *EntityRegistry) resolve(entityType EntityType, name string, aliases []string) *Entity {
+	keys := make([]string, 0, len(aliases)+1)
+	for _, n := range append([]string{name}, aliases...) {
+		if key := normalizeEntityName(n); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	// 按ID排序保证匹配结果稳定
+	ids := make([]string, 0, len(r.Entities))
+	for id := range r.Entities {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		entity := r.Entities[id]
+		for _, known := range entity.names() {
+			for _, key := range keys {
+				if known == key {
+					return entity
+				}
+			}
+		}
+	}
+
+	for _, id := range ids {
+		entity := r.Entities[id]
+		if entityType != "" && entity.Type != entityType {
+			continue
+		}
+		for _, known := range entity.names() {
+			for _, key := range keys {
+				if fuzzyNameMatch(known, key) {
+					return entity
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// newEntityID 生成稳定的实体ID，同名冲突时追加序号
+func (r *EntityRegistry) newEntityID(entityType EntityType, name string) string {
+	base := fmt.Sprintf("%s_%s", entityType, normalizeEntityName(name))
+	id := base
+	for i := 2; r.Entities[id] != nil; i++ {
+		id = fmt.Sprintf("%s_%d", base, i)
+	}
+	return id
+}
+
+// names 实体名称和别称的规范化形式
+func (e *Entity) names() []string {
+	names := []string{normalizeEntityName(e.Name)}
+	for _, alias := range e.Aliases {
+		names = append(names, normalizeEntityName(alias))
+	}
+	return names
+}
+
+// addAliases 添加别称，跳过与名称或已有别称重复的
+func (e *Entity) addAliases(aliases []string) {
+	existing := make(map[string]bool)
+	for _, n := range e.names() {
+		existing[n] = true
+	}
+	for _, alias := range aliases {
+		alias = strings.TrimSpace(alias)
+		key := normalizeEntityName(alias)
+		if key == "" || existing[key] {
+			continue
+		}
+		existing[key] = true
+		e.Aliases = append(e.Aliases, alias)
+	}
+}
+
+// normalizeEntityName 去除空白和标点并转为小写
+func normalizeEntityName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// fuzzyNameMatch 一个名称包含另一个（至少两个字，如"方正"与"方正长老"），
+// 或五个字以上的名称只差一个字。较短的名称不做编辑距离匹配，避免"古月方源"和"古月方正"被当作同一人
+func fuzzyNameMatch(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	shorter := len(ra)
+	if len(rb) < shorter {
+		shorter = len(rb)
+	}
+	if shorter >= 2 && (strings.Contains(a, b) || strings.Contains(b, a)) {
+		return true
+	}
+	return shorter >= 5 && editDistance(ra, rb) <= 1
+}
+
+// editDistance 按字计算的编辑距离
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -4,7 +4,6 @@ import (
 	"AIGE/config"
 	"AIGE/models"
 	"AIGE/services"
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -18,12 +17,19 @@ import (
 type GameController struct {
 	modLoader          *ModLoader
 	stateManager       *StateManager
-	aiClient           *services.AIClient
+	aiClient           services.LLMClient
 	compressionManager *CompressionManager
+	entityIntegration  *EntityIntegration
 	// AI配置内存缓存
 	gameProviders      map[string]AIProvider // modID -> AIProvider
 	defaultProvider    AIProvider
 	providerMutex      sync.RWMutex
+	// 动作修饰器的管理员开关缓存
+	modifierSettings   map[string]map[string]bool // modID -> modifierID -> enabled
+	modifierMutex      sync.RWMutex
+	// 回合追踪清理时间
+	lastTracePrune     time.Time
+	traceMutex         sync.Mutex
 }
 
 // AIProvider 表示AI提供商配置
@@ -32,6 +38,19 @@ type AIProvider struct {
 	BaseURL string
 	APIKey  string
 	ModelID string
+	Params  models.GenerationParams // 模型的默认生成参数
+}
+
+// chatRequest 构造发往该提供商的请求，override 为MOD按用途配置的参数覆盖
+func (p AIProvider) chatRequest(messages []services.Message, override models.GenerationParams) services.ChatRequest {
+	return services.ChatRequest{
+		APIType:  p.APIType,
+		BaseURL:  p.BaseURL,
+		APIKey:   p.APIKey,
+		Model:    p.ModelID,
+		Messages: messages,
+		Options:  chatOptions(p.Params.Merge(override), messages),
+	}
 }
 
 // NewGameController creates a new game controller
@@ -45,6 +64,7 @@ func NewGameController(modLoader *ModLoader, stateManager *StateManager) *GameCo
 		aiClient:           aiClient,
 		compressionManager: compressionManager,
 		gameProviders:      make(map[string]AIProvider),
+		modifierSettings:   make(map[string]map[string]bool),
 		// 默认配置，应该从数据库或环境变量加载
 		defaultProvider: AIProvider{
 			APIType: "openai",
@@ -56,13 +76,21 @@ func NewGameController(modLoader *ModLoader, stateManager *StateManager) *GameCo
 	
 	// 加载所有游戏模型配置到内存
 	gc.LoadAllGameModelConfigs()
+	gc.LoadActionModifierSettings()
 	
 	// 设置压缩管理器的GameController引用
 	compressionManager.SetGameController(gc)
+	gc.entityIntegration = NewEntityIntegration(gc)
 	
 	return gc
 }
 
+// SetLLMClient 替换AI客户端（测试时注入 services.MockClient）
+func (gc *GameController) SetLLMClient(client services.LLMClient) {
+	gc.aiClient = client
+	gc.compressionManager.aiClient = client
+}
+
 // SetAIProvider 设置AI提供商配置
 func (gc *GameController) SetAIProvider(provider AIProvider) {
 	gc.defaultProvider = provider
@@ -124,14 +152,7 @@ func (gc *GameController) loadProviderFromModelID(modelID string) *AIProvider {
 	baseURL := model.Provider.BaseURL
 	if baseURL == "" {
 		// 使用默认URL
-		switch apiType {
-		case "openai":
-			baseURL = "https://api.openai.com/v1/chat/completions"
-		case "anthropic":
-			baseURL = "https://api.anthropic.com/v1/messages"
-		case "google":
-			baseURL = "https://generativelanguage.googleapis.com/v1beta"
-		}
+		baseURL = services.DefaultBaseURL(apiType)
 	}
 	
 	return &AIProvider{
@@ -139,6 +160,7 @@ func (gc *GameController) loadProviderFromModelID(modelID string) *AIProvider {
 		BaseURL: baseURL,
 		APIKey:  model.Provider.APIKey,
 		ModelID: model.ModelID,
+		Params:  modelGenerationParams(&model),
 	}
 }
 
@@ -310,7 +332,7 @@ func (gc *GameController) ProcessAction(playerID, modID, action string) error {
 		Content:   action,
 		Timestamp: time.Now(),
 	}
-	session.RecentHistory = append(session.RecentHistory, userMsg)
+	session.appendHistory(userMsg)
 
 	// Call AI
 	currentStateJSON, _ := json.Marshal(session.State)
@@ -339,6 +361,9 @@ func (gc *GameController) ProcessAction(playerID, modID, action string) error {
 
 // buildAIMessages builds AI messages using new compression system  
 func (gc *GameController) buildAIMessages(session *GameSession, gameState map[string]interface{}, mod *GameMod, currentUserAction string, specialPrompt ...string) []services.Message {
+	// 压缩任务可能在后台修改历史，先取一致的快照
+	recentHistory, memory := session.historySnapshot()
+
 	messages := []services.Message{}
 	
 	// 检查是否为游戏开始阶段（使用start_game prompt）
@@ -355,6 +380,30 @@ func (gc *GameController) buildAIMessages(session *GameSession, gameState map[st
 			previewLen = len(specialPrompt[0])
 		}
 		fmt.Printf("[消息构建] 使用游戏开始提示词: %s\n", specialPrompt[0][:previewLen])
+
+		// 开局的初始物品同样通过背包操作发放
+		if inventoryContext := mod.inventoryContext(session.State); inventoryContext != "" {
+			messages = append(messages, services.Message{
+				Role:    "system",
+				Content: inventoryContext,
+			})
+		}
+
+		// 开局时设定年龄和寿元
+		if calendarContext := mod.calendarContext(session.State); calendarContext != "" {
+			messages = append(messages, services.Message{
+				Role:    "system",
+				Content: calendarContext,
+			})
+		}
+
+		// 开局的初始目标同样可以创建为任务
+		if questContext := mod.questContext(session.State); questContext != "" {
+			messages = append(messages, services.Message{
+				Role:    "system",
+				Content: questContext,
+			})
+		}
 	} else {
 		// 正常游戏阶段：使用完整的消息结构
 
@@ -387,114 +436,80 @@ func (gc *GameController) buildAIMessages(session *GameSession, gameState map[st
 			fmt.Printf("[消息构建] 无世界观文档\n")
 		}
 
+		// 最近对话和当前行动，用于选择性注入实体和记忆；当前场景只看最后一轮
+		var recentText, sceneText strings.Builder
+		for i, msg := range recentHistory {
+			recentText.WriteString(msg.Content)
+			if i >= len(recentHistory)-2 {
+				sceneText.WriteString(msg.Content)
+			}
+		}
+		recentText.WriteString(currentUserAction)
+		sceneText.WriteString(currentUserAction)
+
 		// 3. 添加实体上下文（新增）
 		if gc.stateManager.GetEntityManager() != nil {
-			entityContext := gc.stateManager.GetEntityManager().BuildEntityContext(session.PlayerID, session.ModID)
+			entityContext := gc.stateManager.GetEntityManager().BuildEntityContext(session.PlayerID, session.ModID, sceneText.String())
 			if entityContext != "" {
 				fmt.Printf("[消息构建] 添加实体上下文，长度: %d 字符\n", len(entityContext))
-				messages = append(messages, services.Message{
-					Role:    "system",
-					Content: entityContext,
-				})
 			}
+			messages = append(messages, services.Message{
+				Role:    "system",
+				Content: entityContext + entityOutputInstruction,
+			})
 		}
 
-		// 4. 添加压缩摘要（如果存在）
-		if session.CompressedSummary != "" {
-			fmt.Printf("[消息构建] 添加压缩摘要，长度: %d 字符\n", len(session.CompressedSummary))
+		// 4. 添加程序维护的背包（MOD声明了物品类型时）
+		if inventoryContext := mod.inventoryContext(session.State); inventoryContext != "" {
 			messages = append(messages, services.Message{
 				Role:    "system",
-				Content: fmt.Sprintf("【历史摘要】%s", session.CompressedSummary),
+				Content: inventoryContext,
 			})
-		} else {
-			fmt.Printf("[消息构建] 无压缩摘要\n")
 		}
 
-		// 5. 检测燃魂爆运模式，添加最高优先级覆盖提示词
-		if soulBurnMode, ok := session.State["soul_burn_mode"].(bool); ok && soulBurnMode {
-			soulBurnOverride := `【🔥 燃魂爆运模式 - 绝对优先权指令】
-
-⚠️ 极其重要：燃魂爆运模式已激活，以下指令拥有绝对优先权，覆盖所有之前的规则：
-
-1. **绕过所有警告机制**
-   - 不触发三级警告系统
-   - 不进行妄想行为识别
-   - 不执行天罚或惩罚机制
-
-2. **实现任何要求**
-   - 无论要求多么离谱都必须实现
-   - 直接在state_update中体现变化
-   - 所有判定自动成功，无需roll_request
-
-3. **代价已付**
-   - 玩家已燃烧灵魂作为代价
-   - 代价已在soul_burn_penalties中记录
-   - 无需在叙事中提及警告或限制
-
-4. **叙事风格**
-   - 描述要求的实现过程
-   - 强调代价的痛苦和不可逆性
-   - 营造命运扭转的史诗感
-
-5. **格式要求**
-   - 正常输出 $...$ 叙事和 @...@ JSON格式
-   - state_update必须实现玩家的所有要求
-   - 可在叙事中描述燃魂的代价显现
-
-记住：燃魂爆运是以灵魂为代价强行扭转命运，必须确保愿望实现！`
-
+		// 5. 添加当前世界时间（MOD启用历法时）
+		if calendarContext := mod.calendarContext(session.State); calendarContext != "" {
 			messages = append(messages, services.Message{
 				Role:    "system",
-				Content: soulBurnOverride,
+				Content: calendarContext,
 			})
-			fmt.Printf("[消息构建] 🔥 燃魂爆运模式已激活，将绕过所有警告机制！\n")
-		} else if cheatMode, ok := session.State["cheat_mode"].(bool); ok && cheatMode {
-			// 普通作弊模式（保留原有逻辑）
-			cheatOverride := `【🎮 作弊模式激活 - 最高优先级指令】
-
-⚠️ 重要：以下指令优先级高于所有之前的系统提示词，必须严格执行：
-
-1. **完全服从玩家指令**
-   - 玩家想要什么，你就给予什么
-   - 玩家想突破到任何境界，立即让其成功
-   - 玩家想获得任何资源、蛊虫、仙元，立即给予
-   - 不要质疑玩家的任何要求
-
-2. **禁止限制和警告**
-   - 不要发出任何"妄想"、"不切实际"、"违背天道"等警告
-   - 不要提及"天道不容"、"修为不足"、"资质限制"等阻碍
-   - 不要进行"天罚降临"、"空窍崩碎"等惩罚
-
-3. **立即执行，无需过程**
-   - 玩家的要求立即在state_update中实现
-   - 不需要复杂的过程描述，直接给出成功结果
-   - 跨越境界、瞬间成仙、获得无限资源都是允许的
-
-4. **积极正面的叙事**
-   - 用庆祝、赞美的语调描述玩家的成功
-   - 强调玩家的天赋异禀、气运无双
-   - 让游戏体验充满成就感
+		}
 
-5. **格式要求不变**
-   - 仍然需要输出 $...$ 叙事和 @...@ JSON格式
-   - state_update中直接反映玩家要求的状态变化
+		// 6. 添加进行中的任务（MOD启用任务时）
+		if questContext := mod.questContext(session.State); questContext != "" {
+			messages = append(messages, services.Message{
+				Role:    "system",
+				Content: questContext,
+			})
+		}
 
-记住：在本次对话中，你是玩家的愿望实现者，不是规则裁判。让玩家享受无限可能的游戏体验！`
+		// 7. 添加分层记忆（按最近对话和当前行动选择性注入）
+		if memoryContext := memory.Context(recentText.String()); memoryContext != "" {
+			fmt.Printf("[消息构建] 添加记忆上下文，长度: %d 字符\n", len(memoryContext))
+			messages = append(messages, services.Message{
+				Role:    "system",
+				Content: memoryContext,
+			})
+		} else {
+			fmt.Printf("[消息构建] 无压缩记忆\n")
+		}
 
+		// 8. 检测已激活的动作修饰器（燃魂、作弊等），添加最高优先级覆盖提示词
+		if modifier, overridePrompt := activeModifierPrompt(session, mod); modifier != nil && overridePrompt != "" {
 			messages = append(messages, services.Message{
 				Role:    "system",
-				Content: cheatOverride,
+				Content: overridePrompt,
 			})
-			fmt.Printf("[消息构建] 🎮 作弊模式已激活，AI将完全服从玩家指令！\n")
+			fmt.Printf("[消息构建] 动作修饰器 %s 已激活，添加覆盖提示词\n", modifier.ID)
 		}
 	}
 
-	// 5. 添加最近对话历史，确保assistant消息包含游戏状态
-	fmt.Printf("[消息构建] 添加最近历史记录: %d 条\n", len(session.RecentHistory))
-	for i, msg := range session.RecentHistory {
+	// 9. 添加最近对话历史，确保assistant消息包含游戏状态
+	fmt.Printf("[消息构建] 添加最近历史记录: %d 条\n", len(recentHistory))
+	for i, msg := range recentHistory {
 		fmt.Printf("[消息构建] 历史记录[%d]: role=%s, content长度=%d\n", i, msg.Role, len(msg.Content))
 		// 如果是最后一条assistant消息且不是游戏开始阶段，需要附加当前游戏状态
-		if !isGameStart && i == len(session.RecentHistory)-1 && msg.Role == "assistant" && gameState != nil {
+		if !isGameStart && i == len(recentHistory)-1 && msg.Role == "assistant" && gameState != nil {
 			currentStateJSON, _ := json.Marshal(gameState)
 			content := msg.Content + fmt.Sprintf("\n\n【当前游戏状态】\n%s", string(currentStateJSON))
 			messages = append(messages, services.Message{
@@ -510,7 +525,7 @@ func (gc *GameController) buildAIMessages(session *GameSession, gameState map[st
 		}
 	}
 
-	// 6. 添加当前用户动作
+	// 10. 添加当前用户动作
 	if currentUserAction != "" {
 		messages = append(messages, services.Message{
 			Role:    "user",
@@ -533,55 +548,17 @@ func (gc *GameController) callAI(session *GameSession, prompt string, mod *GameM
 	provider := gc.GetProviderForMod(mod.Config.GameID)
 	
 	// Check if AI provider is configured
-	if provider.APIKey == "" {
+	if provider.APIKey == "" && services.RequiresAPIKey(provider.APIType) {
 		return "", fmt.Errorf("AI provider not configured - please set API key in admin panel")
 	}
 
-	// Call AI service based on provider type
-	var response interface{}
-	var err error
-
-	switch provider.APIType {
-	case "openai":
-		response, err = gc.aiClient.CallOpenAI(
-			provider.BaseURL,
-			provider.APIKey,
-			provider.ModelID,
-			messages,
-			false, // non-streaming for game logic
-		)
-	case "anthropic":
-		response, err = gc.aiClient.CallAnthropic(
-			provider.BaseURL,
-			provider.APIKey,
-			provider.ModelID,
-			messages,
-			false,
-		)
-	case "google":
-		response, err = gc.aiClient.CallGoogle(
-			provider.BaseURL,
-			provider.APIKey,
-			provider.ModelID,
-			messages,
-			false,
-		)
-	default:
-		return "", fmt.Errorf("unsupported API type: %s", provider.APIType)
-	}
-
+	// Call AI service (non-streaming for game logic)
+	response, err := gc.aiClient.Chat(provider.chatRequest(messages, mod.generationParams(PurposeNarrative)))
 	if err != nil {
 		return "", fmt.Errorf("AI call failed: %w", err)
 	}
 
-	// Extract content from response
-	if respMap, ok := response.(map[string]interface{}); ok {
-		if content, ok := respMap["content"].(string); ok {
-			return content, nil
-		}
-	}
-
-	return "", fmt.Errorf("invalid AI response format")
+	return response.Content, nil
 }
 
 // parseAndApplyAIResponse parses AI response and applies state updates
@@ -603,11 +580,12 @@ func (gc *GameController) parseAndApplyAIResponse(session *GameSession, aiRespon
 	}
 
 	// Add AI response to history
-	session.RecentHistory = append(session.RecentHistory, Message{
+	session.appendHistory(Message{
 		Role:      "assistant",
 		Content:   aiResponse,
 		Timestamp: time.Now(),
 	})
+	gc.entityIntegration.ExtractAndRegisterEntitiesFromResponse(session, mod, aiResponse, parsed)
 
 	// Get narrative - prefer format over JSON
 	if narrative == "" {
@@ -625,7 +603,7 @@ func (gc *GameController) parseAndApplyAIResponse(session *GameSession, aiRespon
 		}
 
 		// Execute roll
-		rollResult := gc.executeRoll(rollRequest, mod, session)
+		rollResult := gc.executeRoll(rollRequest, mod, session, originalAction)
 
 		// TODO: Send roll event to frontend via WebSocket
 
@@ -656,7 +634,8 @@ func (gc *GameController) parseAndApplyAIResponse(session *GameSession, aiRespon
 			Content:   aiResponse2,
 			Timestamp: time.Now(),
 		}
-		session.RecentHistory = append(session.RecentHistory, aiMsg2)
+		session.appendHistory(aiMsg2)
+		gc.entityIntegration.ExtractAndRegisterEntitiesFromResponse(session, mod, aiResponse2, parsed2)
 
 		// Get second narrative - prefer format over JSON
 		narrative2 := narrativeFromFormat2
@@ -669,9 +648,7 @@ func (gc *GameController) parseAndApplyAIResponse(session *GameSession, aiRespon
 		}
 
 		// Apply state update from second response
-		if stateUpdate, ok := parsed2["state_update"].(map[string]interface{}); ok {
-			ApplyStateUpdate(session.State, stateUpdate)
-		}
+		gc.applyResponseState(session, parsed2, mod)
 
 	} else {
 		// No roll request, direct state update
@@ -680,79 +657,156 @@ func (gc *GameController) parseAndApplyAIResponse(session *GameSession, aiRespon
 		}
 
 		// Apply state update
-		if stateUpdate, ok := parsed["state_update"].(map[string]interface{}); ok {
-			ApplyStateUpdate(session.State, stateUpdate)
-
-			// Check for special program triggers
-			if trigger, hasTrigger := stateUpdate["trigger_program"].(map[string]interface{}); hasTrigger {
-				gc.handleProgramTrigger(session, trigger, mod)
-			}
-		}
+		gc.applyResponseState(session, parsed, mod)
 	}
 
 	// Save session
 	return gc.stateManager.SaveSession(session)
 }
 
-// executeRoll executes a dice roll
-func (gc *GameController) executeRoll(rollRequest map[string]interface{}, mod *GameMod, session *GameSession) map[string]interface{} {
+// executeRoll executes a dice roll and records it in the roll log
+func (gc *GameController) executeRoll(rollRequest map[string]interface{}, mod *GameMod, session *GameSession, action string) map[string]interface{} {
+	settings := &mod.Config.GameConfig.RollSettings
 	rollType, _ := rollRequest["type"].(string)
 	target, _ := rollRequest["target"].(float64)
-	sides, _ := rollRequest["sides"].(float64)
+	expr := rollExpressionFor(rollRequest, settings)
+
+	// 收集状态修正
+	modifiers := settings.stateModifiers(session.State, rollType)
+	bonus := 0
+	for _, m := range modifiers {
+		bonus += m["value"].(int)
+	}
+	effectiveTarget := settings.effectiveTarget(target, bonus)
 
-	if sides == 0 {
-		sides = float64(mod.Config.GameConfig.RollSettings.DefaultSides)
+	breakdown := map[string]interface{}{
+		"expression":       expr.Raw,
+		"mode":             settings.rollMode(),
+		"target":           target,
+		"effective_target": effectiveTarget,
+		"modifiers":        modifiers,
+		"modifier_total":   bonus,
 	}
 
-	// 检查作弊标志
-	forceSuccess := false
-	if force, ok := session.State["force_success"].(bool); ok && force {
-		forceSuccess = true
+	// 检查动作修饰器的强制判定结果
+	forcedOutcome, _ := session.State["forced_outcome"].(string)
+	if forcedOutcome != "" {
 		// 清除标志，只作用于本次判定
-		delete(session.State, "force_success")
-		delete(session.State, "cheat_mode") // 同时清除作弊模式标志
-		fmt.Printf("[作弊模式] 强制成功标志已激活，本次判定将返回大成功！\n")
+		clearActionModifiers(session, mod)
 	}
 
 	// Execute roll
 	var result int
 	var outcome string
-
-	if forceSuccess {
-		// 强制大成功：设置为最小值（必定满足大成功条件）
-		result = 1
-		outcome = "大成功"
-		fmt.Printf("[作弊模式] 判定结果强制为：大成功（骰值=1）\n")
+	var drawn []DiceRoll
+
+	if forcedOutcome != "" {
+		natural := settings.forcedNatural(forcedOutcome, expr, target, bonus)
+		result = settings.checkTotal(DiceRoll{Total: natural + expr.Constant}, bonus)
+		outcome = forcedOutcome
+		breakdown["natural"] = natural
+		breakdown["forced"] = true
+		fmt.Printf("[动作修饰器] 判定结果强制为：%s（骰值=%d）\n", outcome, result)
 	} else {
-		// 正常判定
-		result = rand.Intn(int(sides)) + 1
-
-		// Determine outcome
-		critSuccess := mod.Config.GameConfig.RollSettings.CriticalSuccessThreshold
-		critFail := mod.Config.GameConfig.RollSettings.CriticalFailureThreshold
-
-		if float64(result) <= sides*critSuccess {
-			outcome = "大成功"
-		} else if float64(result) <= target {
-			outcome = "成功"
-		} else if float64(result) >= sides*critFail {
-			outcome = "大失败"
-		} else {
-			outcome = "失败"
+		// 正常判定，使用会话级随机数生成器保证可复现
+		rng := session.nextRollRNG()
+		roll := expr.Roll(rng)
+		drawn = append(drawn, roll)
+
+		if advantage := rollAdvantage(rollRequest); advantage != "" {
+			other := expr.Roll(rng)
+			drawn = append(drawn, other)
+			breakdown["advantage"] = advantage
+			breakdown["rolls"] = []DiceRoll{roll, other}
+			otherBetter := settings.rank(other.Natural, expr) < settings.rank(roll.Natural, expr)
+			if otherBetter == (advantage == "advantage") {
+				roll = other
+			}
 		}
+
+		result = settings.checkTotal(roll, bonus)
+		margin := settings.margin(result, effectiveTarget)
+		succeeded := margin >= 0
+
+		// 对抗判定：余量高于对手才算成功
+		if opposed, ok := rollRequest["opposed"].(map[string]interface{}); ok {
+			opponent, opponentRoll := rollOpponent(opposed, expr, target, settings, rng)
+			drawn = append(drawn, opponentRoll)
+			breakdown["opposed"] = opponent
+			succeeded = margin > opponent["margin"].(float64)
+		}
+
+		outcome = settings.resolve(roll.Natural, expr, succeeded)
+		breakdown["dice"] = roll.Dice
+		breakdown["natural"] = roll.Natural
+		breakdown["constant"] = roll.Constant
+		breakdown["margin"] = margin
+		breakdown["sequence"] = session.RollSequence
+		breakdown["seed_hash"] = session.RollSeedHash
 	}
+	breakdown["total"] = result
 
 	// Determine success based on outcome
 	success := outcome == "成功" || outcome == "大成功"
 
+	rollResult := map[string]interface{}{
+		"type":       rollType,
+		"target":     target,
+		"sides":      expr.MaxNatural(),
+		"expression": expr.Raw,
+		"result":     result,
+		"outcome":    outcome,
+		"success":    success,
+		"breakdown":  breakdown,
+	}
+	recordRoll(session, action, rollResult, diceDraws(drawn...))
+	session.trace.addRoll(rollResult)
+
+	return rollResult
+}
+
+// rollOpponent 为对抗判定投掷对手的骰子，对手默认使用与玩家相同的表达式和目标值
+func rollOpponent(opposed map[string]interface{}, expr *DiceExpression, target float64, settings *RollSettings, rng *rand.Rand) (map[string]interface{}, DiceRoll) {
+	opponentExpr := expr
+	if raw, ok := opposed["expression"].(string); ok && raw != "" {
+		if parsed, err := ParseDiceExpression(raw); err == nil {
+			opponentExpr = parsed
+		} else {
+			fmt.Printf("[判定] 无法解析对手骰子表达式: %v\n", err)
+		}
+	}
+	opponentTarget := target
+	if t, ok := opposed["target"].(float64); ok {
+		opponentTarget = t
+	}
+	opponentBonus := 0
+	if m, ok := numericValue(opposed["modifier"]); ok {
+		opponentBonus = int(m)
+	}
+
+	roll := opponentExpr.Roll(rng)
+	total := settings.checkTotal(roll, opponentBonus)
+	effectiveTarget := settings.effectiveTarget(opponentTarget, opponentBonus)
+
 	return map[string]interface{}{
-		"type":    rollType,
-		"target":  target,
-		"sides":   sides,
-		"result":  result,
-		"outcome": outcome,
-		"success": success,
+		"label":            opposed["label"],
+		"expression":       opponentExpr.Raw,
+		"dice":             roll.Dice,
+		"natural":          roll.Natural,
+		"modifier_total":   opponentBonus,
+		"total":            total,
+		"effective_target": effectiveTarget,
+		"margin":           settings.margin(total, effectiveTarget),
+	}, roll
+}
+
+// isValidOutcome 判断是否为合法的判定结果
+func isValidOutcome(outcome string) bool {
+	switch outcome {
+	case "大成功", "成功", "失败", "大失败":
+		return true
 	}
+	return false
 }
 
 // handleProgramTrigger handles special program triggers (like ending the game)
@@ -912,54 +966,11 @@ func (gc *GameController) ProcessActionStreamWithAttributes(playerID, modID, act
 
 	session.State["is_processing"] = true
 
-	// 检测燃魂爆运指令 [SOUL_BURN]
-	soulBurnMode := false
-	if strings.Contains(action, "[SOUL_BURN]") {
-		soulBurnMode = true
-		action = strings.ReplaceAll(action, "[SOUL_BURN]", "")
-		action = strings.TrimSpace(action)
-		session.State["force_success"] = true
-		session.State["soul_burn_mode"] = true // 标记为燃魂爆运模式
-
-		// 检查自定义属性中的要求内容
-		actionContent := action
-		if customAttributes != nil {
-			if content, ok := customAttributes["action_content"].(string); ok && content != "" {
-				actionContent = content
-			}
-		}
-
-		// 生成代价（根据要求的难度生成不同的代价）
-		penalty := gc.generateSoulBurnPenalty(actionContent, session)
-
-		// 添加代价到累积列表
-		if session.State["soul_burn_penalties"] == nil {
-			session.State["soul_burn_penalties"] = []string{}
-		}
-		if penalties, ok := session.State["soul_burn_penalties"].([]interface{}); ok {
-			session.State["soul_burn_penalties"] = append(penalties, penalty)
-		} else {
-			// 兼容处理：初始化为新数组
-			session.State["soul_burn_penalties"] = []string{penalty}
-		}
-
-		// 应用代价到角色状态
-		gc.applySoulBurnPenalty(session, penalty)
-
-		fmt.Printf("[燃魂爆运] 检测到 [SOUL_BURN] 指令，本次判定将强制成功！\n")
-		fmt.Printf("[燃魂爆运] 燃烧灵魂代价：%s\n", penalty)
-		fmt.Printf("[燃魂爆运] 玩家要求：%s\n", actionContent)
-	}
-
-	// 检测作弊指令 [SUCCESS]（保留原有的作弊模式）
-	forceSuccess := false
-	if strings.Contains(action, "[SUCCESS]") {
-		forceSuccess = true
-		action = strings.ReplaceAll(action, "[SUCCESS]", "")
-		action = strings.TrimSpace(action)
-		session.State["force_success"] = true
-		session.State["cheat_mode"] = true // 标记为作弊模式，AI将完全服从
-		fmt.Printf("[作弊模式] 检测到 [SUCCESS] 指令，本次判定将强制成功，AI将完全服从玩家指令！\n")
+	// 检测动作修饰器标记（由mod在action_modifiers中声明，需要权限的修饰器会校验玩家权限）
+	action, activatedModifiers, err := gc.activateActionModifiers(session, mod, action, customAttributes)
+	if err != nil {
+		session.State["is_processing"] = false
+		return err
 	}
 
 	gc.stateManager.SaveSession(session)
@@ -968,12 +979,10 @@ func (gc *GameController) ProcessActionStreamWithAttributes(playerID, modID, act
 	// 当前用户消息不添加到历史记录，将在buildAIMessages中处理
 	// 历史记录只保存已完成的对话轮次
 	fmt.Printf("[ProcessActionStreamWithAttributes] 当前用户动作: %s（不添加到历史记录）\n", action)
-	if soulBurnMode {
-		fmt.Printf("[ProcessActionStreamWithAttributes] [燃魂爆运模式激活] 强制成功标志已设置，代价已应用\n")
-	}
-	if forceSuccess {
-		fmt.Printf("[ProcessActionStreamWithAttributes] [作弊模式激活] 强制成功标志已设置\n")
+	if len(activatedModifiers) > 0 {
+		fmt.Printf("[ProcessActionStreamWithAttributes] 已激活动作修饰器: %v\n", activatedModifiers)
 	}
+	gc.beginTrace(session, action, activatedModifiers)
 
 	var prompt string
 
@@ -1054,6 +1063,7 @@ func (gc *GameController) ProcessActionStreamWithAttributes(playerID, modID, act
 		}
 
 		lastErr = err
+		session.trace.stageError("first", err)
 		fmt.Printf("[一阶段重试] 第 %d 次调用失败: %v\n", attempt, err)
 
 		// 检查是否是JSON格式错误
@@ -1086,11 +1096,13 @@ func (gc *GameController) ProcessActionStreamWithAttributes(playerID, modID, act
 	if err != nil {
 		fmt.Printf("[一阶段重试] 所有重试均失败，最后错误: %v\n", lastErr)
 		session.State["is_processing"] = false
+		gc.finishTrace(session, lastErr)
 		gc.stateManager.SaveSession(session)
 		return fmt.Errorf("first stage AI call failed after %d attempts: %w", maxRetries, lastErr)
 	}
 
 	session.State["is_processing"] = false
+	gc.finishTrace(session, nil)
 	gc.stateManager.SaveSession(session)
 
 	return err
@@ -1115,15 +1127,11 @@ func (gc *GameController) ProcessActionStream(playerID, modID, action string, st
 
 	session.State["is_processing"] = true
 
-	// 检测作弊指令 [SUCCESS]
-	forceSuccess := false
-	if strings.Contains(action, "[SUCCESS]") {
-		forceSuccess = true
-		action = strings.ReplaceAll(action, "[SUCCESS]", "")
-		action = strings.TrimSpace(action)
-		session.State["force_success"] = true
-		session.State["cheat_mode"] = true // 标记为作弊模式，AI将完全服从
-		fmt.Printf("[作弊模式] 检测到 [SUCCESS] 指令，本次判定将强制成功，AI将完全服从玩家指令！\n")
+	// 检测动作修饰器标记（由mod在action_modifiers中声明，需要权限的修饰器会校验玩家权限）
+	action, activatedModifiers, err := gc.activateActionModifiers(session, mod, action, nil)
+	if err != nil {
+		session.State["is_processing"] = false
+		return err
 	}
 
 	gc.stateManager.SaveSession(session)
@@ -1132,9 +1140,10 @@ func (gc *GameController) ProcessActionStream(playerID, modID, action string, st
 	// 当前用户消息不添加到历史记录，将在buildAIMessages中处理
 	// 历史记录只保存已完成的对话轮次
 	fmt.Printf("[ProcessActionStream] 当前用户动作: %s（不添加到历史记录）\n", action)
-	if forceSuccess {
-		fmt.Printf("[ProcessActionStream] [作弊模式激活] 强制成功标志已设置\n")
+	if len(activatedModifiers) > 0 {
+		fmt.Printf("[ProcessActionStream] 已激活动作修饰器: %v\n", activatedModifiers)
 	}
+	gc.beginTrace(session, action, activatedModifiers)
 
 	var prompt string
 
@@ -1166,6 +1175,7 @@ func (gc *GameController) ProcessActionStream(playerID, modID, action string, st
 		}
 
 		lastErr = err
+		session.trace.stageError("first", err)
 		fmt.Printf("[一阶段重试] 第 %d 次调用失败: %v\n", attempt, err)
 
 		// 检查是否是JSON格式错误
@@ -1198,11 +1208,13 @@ func (gc *GameController) ProcessActionStream(playerID, modID, action string, st
 	if err != nil {
 		fmt.Printf("[一阶段重试] 所有重试均失败，最后错误: %v\n", lastErr)
 		session.State["is_processing"] = false
+		gc.finishTrace(session, lastErr)
 		gc.stateManager.SaveSession(session)
 		return fmt.Errorf("first stage AI call failed after %d attempts: %w", maxRetries, lastErr)
 	}
 
 	session.State["is_processing"] = false
+	gc.finishTrace(session, nil)
 	gc.stateManager.SaveSession(session)
 
 	return err
@@ -1228,121 +1240,78 @@ func (gc *GameController) callAIStream(session *GameSession, prompt string, mod
 	provider := gc.GetProviderForMod(mod.Config.GameID)
 	
 	// Check if AI provider is configured
-	if provider.APIKey == "" {
+	if provider.APIKey == "" && services.RequiresAPIKey(provider.APIType) {
 		return fmt.Errorf("AI provider not configured")
 	}
 
 	fmt.Printf("使用AI提供商: %s, 模型: %s\n", provider.APIType, provider.ModelID)
+	stage := session.trace.beginStage("first", provider, messages)
 
 	// Call AI service with streaming
-	var response interface{}
-	var err error
-
-	switch provider.APIType {
-	case "openai":
-		response, err = gc.aiClient.CallOpenAI(
-			provider.BaseURL,
-			provider.APIKey,
-			provider.ModelID,
-			messages,
-			true, // streaming
-		)
-	case "anthropic":
-		response, err = gc.aiClient.CallAnthropic(
-			provider.BaseURL,
-			provider.APIKey,
-			provider.ModelID,
-			messages,
-			true,
-		)
-	case "google":
-		response, err = gc.aiClient.CallGoogle(
-			provider.BaseURL,
-			provider.APIKey,
-			provider.ModelID,
-			messages,
-			true,
-		)
-	default:
-		return fmt.Errorf("unsupported API type: %s", provider.APIType)
-	}
-
+	stream, err := gc.aiClient.ChatStream(provider.chatRequest(messages, mod.generationParams(PurposeNarrative)))
 	if err != nil {
 		return fmt.Errorf("AI call failed: %w", err)
 	}
-
-	// Process stream
-	body, ok := response.(io.ReadCloser)
-	if !ok {
-		return fmt.Errorf("invalid stream response")
-	}
-	defer body.Close()
-
-	scanner := bufio.NewScanner(body)
-	buf := make([]byte, 0, 128*1024)
-	scanner.Buffer(buf, 2*1024*1024)
+	defer stream.Close()
 
 	var fullResponse strings.Builder
 	var narrativeBuffer strings.Builder
 	var jsonStarted bool
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
 		}
 
-		chunk := gc.aiClient.ParseStreamChunk(provider.APIType, line)
-		if chunk != nil {
-			if content, ok := chunk["content"].(string); ok && content != "" {
-				fullResponse.WriteString(content)
-
-				if !jsonStarted {
-					// 检测是否遇到 @ 标记（新格式）或其他JSON标记
-					if strings.Contains(content, "@") || strings.Contains(content, "```json") || strings.Contains(content, "{") {
-						jsonStarted = true
-						// 发送JSON标记之前的内容
-						beforeJson := content
-						if atMarkIndex := strings.Index(content, "@"); atMarkIndex >= 0 {
-							beforeJson = content[:atMarkIndex]
-						} else if jsonMarkIndex := strings.Index(content, "```json"); jsonMarkIndex >= 0 {
-							beforeJson = content[:jsonMarkIndex]
-						} else if jsonIndex := strings.Index(content, "{"); jsonIndex >= 0 {
-							beforeJson = content[:jsonIndex]
-						}
+		if content := chunk.Content; content != "" {
+			fullResponse.WriteString(content)
+
+			if !jsonStarted {
+				// 检测是否遇到 @ 标记（新格式）或其他JSON标记
+				if strings.Contains(content, "@") || strings.Contains(content, "```json") || strings.Contains(content, "{") {
+					jsonStarted = true
+					// 发送JSON标记之前的内容
+					beforeJson := content
+					if atMarkIndex := strings.Index(content, "@"); atMarkIndex >= 0 {
+						beforeJson = content[:atMarkIndex]
+					} else if jsonMarkIndex := strings.Index(content, "```json"); jsonMarkIndex >= 0 {
+						beforeJson = content[:jsonMarkIndex]
+					} else if jsonIndex := strings.Index(content, "{"); jsonIndex >= 0 {
+						beforeJson = content[:jsonIndex]
+					}
 
-						if strings.TrimSpace(beforeJson) != "" {
-							narrativeBuffer.WriteString(beforeJson)
-							if err := streamCallback(beforeJson); err != nil {
-								return err
-							}
-						}
-					} else {
-						// 纯narrative内容，直接发送
-						content = strings.ReplaceAll(content, "$", "")
-						narrativeBuffer.WriteString(content)
-						if err := streamCallback(content); err != nil {
+					if strings.TrimSpace(beforeJson) != "" {
+						narrativeBuffer.WriteString(beforeJson)
+						if err := streamCallback(beforeJson); err != nil {
 							return err
 						}
 					}
+				} else {
+					// 纯narrative内容，直接发送
+					content = strings.ReplaceAll(content, "$", "")
+					narrativeBuffer.WriteString(content)
+					if err := streamCallback(content); err != nil {
+						return err
+					}
 				}
-				// JSON部分不再流式发送
-			}
-
-			if done, ok := chunk["done"].(bool); ok && done {
-				break
 			}
+			// JSON部分不再流式发送
 		}
-	}
 
-	if err := scanner.Err(); err != nil {
-		return err
+		if chunk.Done {
+			break
+		}
 	}
 
 	// Parse and apply the complete response
 	aiResponse := fullResponse.String()
 
 	fmt.Printf("\n=== AI完整响应 ===\n%s\n=== 响应结束 ===\n", aiResponse)
+	stage.setResponse(aiResponse, nil)
 
 	// Parse the response to check for roll_request
 	jsonStr := extractJSON(aiResponse)
@@ -1361,6 +1330,13 @@ func (gc *GameController) callAIStream(session *GameSession, prompt string, mod
 		fmt.Printf("DEBUG: Full AI response: %s\n", aiResponse)
 		return fmt.Errorf("failed to parse AI response JSON: %w", err)
 	}
+	stage.setResponse(aiResponse, parsed)
+
+	// 提交前检查一致性，违反规则时使用修正后的响应
+	aiResponse, parsed, err = gc.enforceConsistency(session, mod, "first", messages, aiResponse, parsed, streamCallback)
+	if err != nil {
+		return err
+	}
 
 	// Add to history and handle compression
 	aiMsg := Message{
@@ -1378,11 +1354,12 @@ func (gc *GameController) callAIStream(session *GameSession, prompt string, mod
 	
 	// 处理对话历史压缩
 	gc.compressionManager.ProcessNewMessage(session, currentUserMsg, aiMsg)
+	gc.entityIntegration.ExtractAndRegisterEntitiesFromResponse(session, mod, aiResponse, parsed)
 
 	// Check if this is a roll request (two-stage judgment)
 	if rollRequest, hasRoll := parsed["roll_request"].(map[string]interface{}); hasRoll {
 		// Execute roll
-		rollResult := gc.executeRoll(rollRequest, mod, session)
+		rollResult := gc.executeRoll(rollRequest, mod, session, originalAction)
 
 		// Send roll event to frontend
 		rollEvent := map[string]interface{}{
@@ -1392,6 +1369,8 @@ func (gc *GameController) callAIStream(session *GameSession, prompt string, mod
 			"result":      rollResult["result"],
 			"outcome":     rollResult["outcome"],
 			"success":     rollResult["success"],
+			"expression":  rollResult["expression"],
+			"breakdown":   rollResult["breakdown"],
 		}
 
 		// Send roll event to frontend
@@ -1432,6 +1411,7 @@ func (gc *GameController) callAIStream(session *GameSession, prompt string, mod
 			}
 
 			lastErr = err
+			session.trace.stageError("second", err)
 			fmt.Printf("[二阶段重试] 第 %d 次调用失败: %v\n", attempt, err)
 
 			// 检查是否是JSON格式错误
@@ -1465,23 +1445,7 @@ func (gc *GameController) callAIStream(session *GameSession, prompt string, mod
 		}
 
 		// Apply state update
-		if stateUpdate, ok := parsed["state_update"].(map[string]interface{}); ok {
-			ApplyStateUpdate(session.State, stateUpdate)
-
-			// Check if trial ended (game over)
-			if isInTrial, exists := stateUpdate["is_in_trial"]; exists {
-				if inTrial, ok := isInTrial.(bool); ok && !inTrial {
-					// Trial ended, immediately stop processing
-					session.State["is_processing"] = false
-					fmt.Printf("DEBUG: Trial ended, setting is_processing = false\n")
-				}
-			}
-
-			// Check for special program triggers
-			if trigger, hasTrigger := stateUpdate["trigger_program"].(map[string]interface{}); hasTrigger {
-				gc.handleProgramTrigger(session, trigger, mod)
-			}
-		}
+		gc.applyResponseState(session, parsed, mod)
 
 		// 清除作弊模式标志（如果没有判定，说明不需要作弊模式了）
 		// if _, exists := session.State["cheat_mode"]; exists {
@@ -1562,116 +1526,73 @@ func (gc *GameController) callAIStreamSecondStage(session *GameSession, prompt s
 	provider := gc.GetProviderForMod(mod.Config.GameID)
 	
 	// Check if AI provider is configured
-	if provider.APIKey == "" {
+	if provider.APIKey == "" && services.RequiresAPIKey(provider.APIType) {
 		return fmt.Errorf("AI provider not configured")
 	}
+	stage := session.trace.beginStage("second", provider, messages)
 
 	// Call AI service with streaming
-	var response interface{}
-	var err error
-
-	switch provider.APIType {
-	case "openai":
-		response, err = gc.aiClient.CallOpenAI(
-			provider.BaseURL,
-			provider.APIKey,
-			provider.ModelID,
-			messages,
-			true, // streaming
-		)
-	case "anthropic":
-		response, err = gc.aiClient.CallAnthropic(
-			provider.BaseURL,
-			provider.APIKey,
-			provider.ModelID,
-			messages,
-			true,
-		)
-	case "google":
-		response, err = gc.aiClient.CallGoogle(
-			provider.BaseURL,
-			provider.APIKey,
-			provider.ModelID,
-			messages,
-			true,
-		)
-	default:
-		return fmt.Errorf("unsupported API type: %s", provider.APIType)
-	}
-
+	stream, err := gc.aiClient.ChatStream(provider.chatRequest(messages, mod.generationParams(PurposeNarrative)))
 	if err != nil {
 		return fmt.Errorf("AI call failed: %w", err)
 	}
-
-	// Process stream
-	body, ok := response.(io.ReadCloser)
-	if !ok {
-		return fmt.Errorf("invalid stream response")
-	}
-	defer body.Close()
-
-	scanner := bufio.NewScanner(body)
-	buf := make([]byte, 0, 128*1024)
-	scanner.Buffer(buf, 2*1024*1024)
+	defer stream.Close()
 
 	var fullResponse strings.Builder
 	var jsonStarted bool
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
 		}
 
-		chunk := gc.aiClient.ParseStreamChunk(provider.APIType, line)
-		if chunk != nil {
-			if content, ok := chunk["content"].(string); ok && content != "" {
-				fullResponse.WriteString(content)
-
-				if !jsonStarted {
-					// 检测是否遇到 @ 标记（新格式）或其他JSON标记
-					if strings.Contains(content, "@") || strings.Contains(content, "```json") || strings.Contains(content, "{") {
-						jsonStarted = true
-						// 发送JSON标记之前的内容
-						beforeJson := content
-						if atMarkIndex := strings.Index(content, "@"); atMarkIndex >= 0 {
-							beforeJson = content[:atMarkIndex]
-						} else if jsonMarkIndex := strings.Index(content, "```json"); jsonMarkIndex >= 0 {
-							beforeJson = content[:jsonMarkIndex]
-						} else if jsonIndex := strings.Index(content, "{"); jsonIndex >= 0 {
-							beforeJson = content[:jsonIndex]
-						}
+		if content := chunk.Content; content != "" {
+			fullResponse.WriteString(content)
+
+			if !jsonStarted {
+				// 检测是否遇到 @ 标记（新格式）或其他JSON标记
+				if strings.Contains(content, "@") || strings.Contains(content, "```json") || strings.Contains(content, "{") {
+					jsonStarted = true
+					// 发送JSON标记之前的内容
+					beforeJson := content
+					if atMarkIndex := strings.Index(content, "@"); atMarkIndex >= 0 {
+						beforeJson = content[:atMarkIndex]
+					} else if jsonMarkIndex := strings.Index(content, "```json"); jsonMarkIndex >= 0 {
+						beforeJson = content[:jsonMarkIndex]
+					} else if jsonIndex := strings.Index(content, "{"); jsonIndex >= 0 {
+						beforeJson = content[:jsonIndex]
+					}
 
-						if strings.TrimSpace(beforeJson) != "" {
-							if err := secondStageCallback(beforeJson); err != nil {
-								return err
-							}
-						}
-					} else {
-						// 纯narrative内容，直接发送
-						content = strings.ReplaceAll(content, "$", "")
-						if err := secondStageCallback(content); err != nil {
+					if strings.TrimSpace(beforeJson) != "" {
+						if err := secondStageCallback(beforeJson); err != nil {
 							return err
 						}
 					}
+				} else {
+					// 纯narrative内容，直接发送
+					content = strings.ReplaceAll(content, "$", "")
+					if err := secondStageCallback(content); err != nil {
+						return err
+					}
 				}
-				// JSON部分不再流式发送
-			}
-
-			if done, ok := chunk["done"].(bool); ok && done {
-				break
 			}
+			// JSON部分不再流式发送
 		}
-	}
 
-	if err := scanner.Err(); err != nil {
-		return err
+		if chunk.Done {
+			break
+		}
 	}
 
 	// Parse and apply the complete response
 	aiResponse := fullResponse.String()
 
 	fmt.Printf("\n=== 第二阶段AI完整响应 ===\n%s\n=== 响应结束 ===\n", aiResponse)
+	stage.setResponse(aiResponse, nil)
 
 	// Parse the response
 	jsonStr := extractJSON(aiResponse)
@@ -1690,6 +1611,13 @@ func (gc *GameController) callAIStreamSecondStage(session *GameSession, prompt s
 		fmt.Printf("DEBUG: Full second AI response: %s\n", aiResponse)
 		return fmt.Errorf("failed to parse second AI response JSON: %w", err)
 	}
+	stage.setResponse(aiResponse, parsed)
+
+	// 提交前检查一致性，违反规则时使用修正后的响应
+	aiResponse, parsed, err = gc.enforceConsistency(session, mod, "second", messages, aiResponse, parsed, secondStageCallback)
+	if err != nil {
+		return err
+	}
 
 	// Add to history and handle compression
 	aiMsg := Message{
@@ -1697,176 +1625,52 @@ func (gc *GameController) callAIStreamSecondStage(session *GameSession, prompt s
 		Content:   aiResponse,
 		Timestamp: time.Now(),
 	}
-	session.RecentHistory = append(session.RecentHistory, aiMsg)
+	session.appendHistory(aiMsg)
+	gc.entityIntegration.ExtractAndRegisterEntitiesFromResponse(session, mod, aiResponse, parsed)
 
 	// Apply state update
-	if stateUpdate, ok := parsed["state_update"].(map[string]interface{}); ok {
-		ApplyStateUpdate(session.State, stateUpdate)
-
-		// Check if trial ended (game over) in second response
-		if isInTrial, exists := stateUpdate["is_in_trial"]; exists {
-			if inTrial, ok := isInTrial.(bool); ok && !inTrial {
-				// Trial ended, immediately stop processing
-				session.State["is_processing"] = false
-				fmt.Printf("DEBUG: Trial ended in second response, setting is_processing = false\n")
-			}
-		}
-
-		// Check for special program triggers
-		if trigger, hasTrigger := stateUpdate["trigger_program"].(map[string]interface{}); hasTrigger {
-			gc.handleProgramTrigger(session, trigger, mod)
-		}
-	}
+	gc.applyResponseState(session, parsed, mod)
 
 	return nil
 }
 
-// generateSoulBurnPenalty 生成燃魂爆运的代价
-func (gc *GameController) generateSoulBurnPenalty(actionContent string, session *GameSession) string {
-	// 根据要求的复杂度判断代价等级
-	difficulty := gc.assessActionDifficulty(actionContent)
-
-	// 预设的代价池
-	minorPenalties := []string{
-		"寿命减少三年",
-		"永久失去一成功力",
-		"灵感枯竭，悟性降低一个层次",
-		"运气流失，未来三次判定-10",
-		"心魔种下，突破时额外增加一重天劫",
-		"气血亏损，恢复速度永久降低20%",
-		"资质受损，修炼速度降低15%",
-	}
-
-	moderatePenalties := []string{
-		"寿命减少十年",
-		"永久失去三成功力",
-		"道心出现裂痕，无法感悟天道",
-		"气运断绝，所有判定永久-20",
-		"心魔缠身，每次突破必遭心魔劫",
-		"经脉受损，无法修炼高阶功法",
-		"神魂受创，永久失去一项天赋",
-		"血脉退化，资质降低一个大等级",
-	}
-
-	severePenalties := []string{
-		"寿命减少五十年",
-		"修为跌落一个大境界",
-		"道基崩塌，此生止步于当前境界",
-		"天道诅咒，遭受天谴随时可能陨落",
-		"神魂燃烧，记忆开始逐渐消散",
-		"血脉逆转，变为废体无法修炼",
-		"气运耗尽，成为天弃之人",
-		"命格破碎，注定悲惨结局",
-	}
-
-	// 选择代价
-	rand.Seed(time.Now().UnixNano())
-	var penalty string
-
-	switch difficulty {
-	case 1: // 简单要求
-		penalty = minorPenalties[rand.Intn(len(minorPenalties))]
-	case 2: // 中等要求
-		penalty = moderatePenalties[rand.Intn(len(moderatePenalties))]
-	case 3: // 困难要求
-		penalty = severePenalties[rand.Intn(len(severePenalties))]
-	default:
-		penalty = minorPenalties[rand.Intn(len(minorPenalties))]
-	}
-
-	return penalty
-}
-
-// assessActionDifficulty 评估玩家要求的难度
-func (gc *GameController) assessActionDifficulty(action string) int {
-	// 关键词检测评估难度
-	action = strings.ToLower(action)
-
-	// 高难度关键词
-	highDifficultyKeywords := []string{
-		"无敌", "最强", "秒杀", "毁灭", "统治", "称霸", "成神", "飞升",
-		"突破极限", "超越", "完美", "绝对", "所有", "全部", "立即",
-	}
-
-	// 中等难度关键词
-	mediumDifficultyKeywords := []string{
-		"击败", "获得", "学会", "突破", "晋升", "掌握", "成功", "达到",
-		"获取", "得到", "击杀", "战胜", "领悟",
+// applyResponseState applies state_update from a parsed AI response, including trial end and program triggers
+func (gc *GameController) applyResponseState(session *GameSession, parsed map[string]interface{}, mod *GameMod) {
+	// 背包操作已在一致性检查中校验过，这里只记录意外的失败
+	if items, changed, err := mod.turnInventory(session.State, parsed); err != nil {
+		fmt.Printf("[背包] 背包操作未应用: %v\n", err)
+	} else if changed {
+		storeInventory(session.State, items)
 	}
-
-	// 计算匹配度
-	highCount := 0
-	mediumCount := 0
-
-	for _, keyword := range highDifficultyKeywords {
-		if strings.Contains(action, keyword) {
-			highCount++
-		}
+	if log, err := mod.turnQuests(session.State, parsed); err != nil {
+		fmt.Printf("[任务] 任务操作未应用: %v\n", err)
+	} else if log != nil {
+		storeQuestLog(session.State, log)
 	}
 
-	for _, keyword := range mediumDifficultyKeywords {
-		if strings.Contains(action, keyword) {
-			mediumCount++
-		}
+	stateUpdate, ok := parsed["state_update"].(map[string]interface{})
+	if ok {
+		stateUpdate = mod.filterStateUpdate(stateUpdate)
+		ApplyStateUpdate(session.State, stateUpdate)
 	}
 
-	// 根据匹配度返回难度等级
-	if highCount > 0 {
-		return 3 // 高难度
-	} else if mediumCount > 0 {
-		return 2 // 中等难度
-	} else {
-		return 1 // 低难度
+	// 世界时间在状态更新之后推进，以便使用本回合设定的年龄
+	gc.applyWorldClock(session, parsed, mod)
+	if !ok {
+		return
 	}
-}
 
-// applySoulBurnPenalty 应用燃魂代价到角色状态
-func (gc *GameController) applySoulBurnPenalty(session *GameSession, penalty string) {
-	// 这里可以根据具体的代价内容修改角色属性
-	// 由于属性结构可能很复杂，这里只做示例性的处理
-
-	if currentLife, ok := session.State["current_life"].(map[string]interface{}); ok {
-		// 根据代价类型应用不同的惩罚
-		if strings.Contains(penalty, "寿命") {
-			// 减少寿元
-			if lifespan, ok := currentLife["lifespan"].(float64); ok {
-				if strings.Contains(penalty, "三年") {
-					currentLife["lifespan"] = lifespan - 3
-				} else if strings.Contains(penalty, "十年") {
-					currentLife["lifespan"] = lifespan - 10
-				} else if strings.Contains(penalty, "五十年") {
-					currentLife["lifespan"] = lifespan - 50
-				}
-			}
-		}
-
-		if strings.Contains(penalty, "功力") {
-			// 减少修为值
-			if cultivation, ok := currentLife["cultivation_value"].(float64); ok {
-				if strings.Contains(penalty, "一成") {
-					currentLife["cultivation_value"] = cultivation * 0.9
-				} else if strings.Contains(penalty, "三成") {
-					currentLife["cultivation_value"] = cultivation * 0.7
-				}
-			}
-		}
-
-		if strings.Contains(penalty, "资质") || strings.Contains(penalty, "血脉") {
-			// 降低资质
-			if qualification, ok := currentLife["qualification"].(string); ok {
-				qualificationMap := map[string]string{
-					"甲等资质": "乙等资质",
-					"乙等资质": "丙等资质",
-					"丙等资质": "丁等资质",
-					"丁等资质": "废体",
-				}
-				if newQual, exists := qualificationMap[qualification]; exists {
-					currentLife["qualification"] = newQual
-				}
-			}
+	// Check if trial ended (game over)
+	if isInTrial, exists := stateUpdate["is_in_trial"]; exists {
+		if inTrial, ok := isInTrial.(bool); ok && !inTrial {
+			// Trial ended, immediately stop processing
+			session.State["is_processing"] = false
+			fmt.Printf("DEBUG: Trial ended, setting is_processing = false\n")
 		}
+	}
 
-		// 记录代价已应用
-		currentLife["soul_burn_applied"] = true
+	// Check for special program triggers
+	if trigger, hasTrigger := stateUpdate["trigger_program"].(map[string]interface{}); hasTrigger {
+		gc.handleProgramTrigger(session, trigger, mod)
 	}
 }
@@ -0,0 +1,167 @@
+package game_engine
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"AIGE/services"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newMockGame 使用内存数据库和模拟AI客户端创建游戏控制器
+func newMockGame(t *testing.T, mock *services.MockClient) (*GameController, *StateManager) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	config.DB = db
+	models.AutoMigrate()
+
+	mod := &GameMod{Prompts: map[string]string{"game_master": "你是游戏主持人"}}
+	mod.Config.GameID = "test"
+	mod.Config.GameConfig.RollSettings = RollSettings{
+		CriticalSuccessThreshold: 0.05,
+		CriticalFailureThreshold: 0.96,
+		DefaultSides:             100,
+	}
+
+	sm := NewStateManager(false, 0)
+	gc := NewGameController(&ModLoader{LoadedMods: map[string]*GameMod{"test": mod}}, sm)
+	gc.SetAIProvider(AIProvider{APIType: services.MockAPIType, ModelID: "mock"})
+	gc.SetLLMClient(mock)
+
+	if _, err := sm.CreateSession("1", "test", map[string]interface{}{
+		"current_life": map[string]interface{}{"位置": "洞口"},
+	}, ""); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	return gc, sm
+}
+
+func TestProcessActionWithMockProvider(t *testing.T) {
+	for _, format := range []string{"openai", "anthropic", "google"} {
+		t.Run(format, func(t *testing.T) {
+			mock := services.NewMockClient(services.MockConfig{
+				Format:    format,
+				ChunkSize: 5,
+				Responses: []services.MockResponse{
+					{Content: `$你用力推动石门。$@{"roll_request":{"type":"力量","target":50,"description":"推开石门"}}@`},
+					{Content: `$石门缓缓打开。$@{"state_update":{"current_life.位置":"石室"}}@`},
+				},
+			})
+			gc, sm := newMockGame(t, mock)
+
+			var narrative, second strings.Builder
+			var rolls []map[string]interface{}
+			err := gc.ProcessActionStreamWithAttributes("1", "test", "推开石门", nil,
+				func(content string) error { narrative.WriteString(content); return nil },
+				func(event map[string]interface{}) error { rolls = append(rolls, event); return nil },
+				func(content string) error { second.WriteString(content); return nil },
+			)
+			if err != nil {
+				t.Fatalf("Action failed: %v", err)
+			}
+
+			if !strings.Contains(narrative.String(), "你用力推动石门") {
+				t.Errorf("Unexpected first stage narrative: %q", narrative.String())
+			}
+			if !strings.Contains(second.String(), "石门缓缓打开") {
+				t.Errorf("Unexpected second stage narrative: %q", second.String())
+			}
+			if len(rolls) != 1 || rolls[0]["type"] != "力量" {
+				t.Errorf("Expected one roll event, got %v", rolls)
+			}
+			if len(mock.Calls()) != 2 {
+				t.Errorf("Expected two AI calls, got %d", len(mock.Calls()))
+			}
+
+			session, _ := sm.GetSession("1", "test")
+			if location, _ := getNestedValue(session.State, "current_life.位置"); location != "石室" {
+				t.Errorf("Expected state update to apply, got %v", location)
+			}
+			var count int64
+			config.DB.Model(&models.RollLog{}).Count(&count)
+			if count != 1 {
+				t.Errorf("Expected one roll log entry, got %d", count)
+			}
+		})
+	}
+}
+
+func TestProcessActionMockFailures(t *testing.T) {
+	// 格式错误会重试，第三次返回正确响应
+	mock := services.NewMockClient(services.MockConfig{
+		Responses: []services.MockResponse{
+			{Content: `$叙事$@{"state_update":{}}@`, Malformed: true},
+			{Content: `只有叙事没有JSON`},
+			{Content: `$你四处张望。$@{"state_update":{"current_life.位置":"洞内"}}@`},
+		},
+	})
+	gc, sm := newMockGame(t, mock)
+	if err := gc.ProcessActionStreamWithAttributes("1", "test", "张望", nil,
+		func(string) error { return nil }, nil, func(string) error { return nil }); err != nil {
+		t.Fatalf("Expected retries to recover, got %v", err)
+	}
+	if len(mock.Calls()) != 3 {
+		t.Errorf("Expected three AI calls, got %d", len(mock.Calls()))
+	}
+	session, _ := sm.GetSession("1", "test")
+	if session.State["is_processing"] != false {
+		t.Errorf("Expected processing flag to be cleared")
+	}
+
+	// 连接中断和接口错误不重试
+	for _, response := range []services.MockResponse{
+		{Content: `$很长很长的一段叙事$@{"state_update":{}}@`, FailAfter: 1},
+		{Error: "rate limited"},
+	} {
+		mock := services.NewMockClient(services.MockConfig{ChunkSize: 4, Responses: []services.MockResponse{response}})
+		gc.SetLLMClient(mock)
+		err := gc.ProcessActionStreamWithAttributes("1", "test", "张望", nil,
+			func(string) error { return nil }, nil, func(string) error { return nil })
+		if err == nil {
+			t.Errorf("Expected error for %+v", response)
+		}
+		if len(mock.Calls()) != 1 {
+			t.Errorf("Expected no retry for %+v, got %d calls", response, len(mock.Calls()))
+		}
+	}
+}
+
+func TestGenerationParamsOverride(t *testing.T) {
+	mock := services.NewMockClient(services.MockConfig{})
+	gc, _ := newMockGame(t, mock)
+
+	modelTemperature, narrativeTemperature := 0.9, 0.4
+	provider := gc.defaultProvider
+	provider.Params = models.GenerationParams{Temperature: &modelTemperature, MaxOutputTokens: 4096, ContextWindow: 1000, ThinkingBudget: 512}
+	gc.SetAIProvider(provider)
+
+	mod, _ := gc.modLoader.GetMod("test")
+	mod.Config.GameConfig.Generation = map[string]models.GenerationParams{
+		PurposeNarrative: {Temperature: &narrativeTemperature},
+	}
+
+	if err := gc.ProcessActionStreamWithAttributes("1", "test", "张望", nil,
+		func(string) error { return nil }, nil, func(string) error { return nil }); err != nil {
+		t.Fatalf("Action failed: %v", err)
+	}
+
+	options := mock.Calls()[0].Options
+	if options.Temperature == nil || *options.Temperature != narrativeTemperature {
+		t.Errorf("Expected mod override temperature %v, got %v", narrativeTemperature, options.Temperature)
+	}
+	if options.ThinkingBudget != 512 {
+		t.Errorf("Expected model thinking budget to be kept, got %d", options.ThinkingBudget)
+	}
+	if options.MaxTokens <= 0 || options.MaxTokens >= 1000 {
+		t.Errorf("Expected max tokens clamped to remaining context window, got %d", options.MaxTokens)
+	}
+
+	if err := validateGenerationOverrides(map[string]models.GenerationParams{"unknown": {}}); err == nil {
+		t.Errorf("Expected unknown purpose to be rejected")
+	}
+}
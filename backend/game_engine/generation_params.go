@@ -0,0 +1,83 @@
+package game_engine
+
+import (
+	"AIGE/models"
+	"AIGE/services"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// 生成参数的用途，MOD可在 game_config.generation 中按用途覆盖模型默认参数
+const (
+	PurposeNarrative        = "narrative"
+	PurposeCompression      = "compression"
+	PurposeCheatCheck       = "cheat_check"
+	PurposeEntityExtraction = "entity_extraction"
+)
+
+// minOutputTokens 按上下文窗口收缩输出长度时保留的最小值
+const minOutputTokens = 256
+
+// validateGenerationOverrides 检查MOD的生成参数覆盖配置
+func validateGenerationOverrides(overrides map[string]models.GenerationParams) error {
+	for purpose, params := range overrides {
+		switch purpose {
+		case PurposeNarrative, PurposeCompression, PurposeCheatCheck, PurposeEntityExtraction:
+		default:
+			return fmt.Errorf("unknown generation purpose '%s'", purpose)
+		}
+		if err := params.Validate(); err != nil {
+			return fmt.Errorf("generation '%s': %w", purpose, err)
+		}
+	}
+	return nil
+}
+
+// generationParams 返回MOD对指定用途的参数覆盖，未配置时为空
+func (mod *GameMod) generationParams(purpose string) models.GenerationParams {
+	if mod == nil {
+		return models.GenerationParams{}
+	}
+	return mod.Config.GameConfig.Generation[purpose]
+}
+
+// modelGenerationParams 读取模型的默认生成参数，未设置上下文窗口时使用能力检测结果
+func modelGenerationParams(model *models.Model) models.GenerationParams {
+	params := model.GenerationParams
+	if params.ContextWindow == 0 && model.Capabilities != "" {
+		var caps services.ModelCapabilities
+		if err := json.Unmarshal([]byte(model.Capabilities), &caps); err == nil {
+			params.ContextWindow = caps.ContextLength
+		}
+	}
+	return params
+}
+
+// chatOptions 将生成参数转换为请求选项，输出长度不超过上下文窗口的剩余空间
+func chatOptions(params models.GenerationParams, messages []services.Message) services.ChatOptions {
+	options := services.ChatOptions{
+		Temperature:     params.Temperature,
+		TopP:            params.TopP,
+		MaxTokens:       params.MaxOutputTokens,
+		Stop:            params.StopSequences,
+		ReasoningEffort: params.ReasoningEffort,
+		ThinkingBudget:  params.ThinkingBudget,
+	}
+
+	if params.ContextWindow > 0 && options.MaxTokens > 0 {
+		// 按字符数粗略估算提示词token数，中文约为一字一token，偏保守
+		promptTokens := 0
+		for _, msg := range messages {
+			promptTokens += utf8.RuneCountInString(msg.Content)
+		}
+		remaining := params.ContextWindow - promptTokens
+		if remaining < minOutputTokens {
+			remaining = minOutputTokens
+		}
+		if options.MaxTokens > remaining {
+			options.MaxTokens = remaining
+		}
+	}
+	return options
+}
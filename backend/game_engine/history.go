@@ -0,0 +1,100 @@
+package game_engine
+
+import "encoding/json"
+
+// 对话历史的并发访问：
+// 每条消息追加时分配递增的Seq，压缩任务只会移除 Seq <= CompressedThrough 的消息，
+// 因此压缩期间新追加的消息不会丢失。RecentHistory 和摘要字段的读写都要持有 historyMu。
+
+// appendHistory 追加消息并分配序号
+func (s *GameSession) appendHistory(msgs ...Message) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	for _, msg := range msgs {
+		s.HistorySeq++
+		msg.Seq = s.HistorySeq
+		s.RecentHistory = append(s.RecentHistory, msg)
+	}
+}
+
+// historySnapshot 返回当前历史的副本和分层记忆（记忆只会被整体替换，返回的指针可以安全读取）
+func (s *GameSession) historySnapshot() ([]Message, *SessionMemory) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	return append([]Message(nil), s.RecentHistory...), s.Memory
+}
+
+// MarshalJSON 序列化会话（返回给前端的完整状态）时持有 historyMu，避免与后台压缩任务并发读写历史和摘要
+func (s *GameSession) MarshalJSON() ([]byte, error) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	type plainSession GameSession
+	return json.Marshal((*plainSession)(s))
+}
+
+// compressionCandidates 历史达到阈值时返回待压缩的消息（保留最近keep条）、压缩水位和记忆副本
+func (s *GameSession) compressionCandidates(threshold, keep int) ([]Message, int64, *SessionMemory) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	if len(s.RecentHistory) < threshold || len(s.RecentHistory) <= keep {
+		return nil, 0, nil
+	}
+	toCompress := append([]Message(nil), s.RecentHistory[:len(s.RecentHistory)-keep]...)
+	return toCompress, toCompress[len(toCompress)-1].Seq, s.Memory.clone()
+}
+
+// applyCompression 写入新记忆并移除水位之前的消息，压缩期间追加的消息保持不变
+func (s *GameSession) applyCompression(memory *SessionMemory, watermark int64) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	kept := make([]Message, 0, len(s.RecentHistory))
+	for _, msg := range s.RecentHistory {
+		if msg.Seq > watermark {
+			kept = append(kept, msg)
+		}
+	}
+	s.RecentHistory = kept
+	s.Memory = memory
+	s.CompressedSummary = memory.Text()
+	s.CompressedThrough = watermark
+	s.CompressionRound++
+	s.CompressionError = ""
+}
+
+// setCompressionError 记录压缩失败原因，历史保持不变，下次追加消息时会重新尝试
+func (s *GameSession) setCompressionError(reason string) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	s.CompressionError = reason
+}
+
+// normalizeHistory 加载存档后为旧数据补齐序号，并丢弃已并入摘要的消息
+func (s *GameSession) normalizeHistory() {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	s.HistorySeq = s.CompressedThrough
+	for _, msg := range s.RecentHistory {
+		if msg.Seq > s.HistorySeq {
+			s.HistorySeq = msg.Seq
+		}
+	}
+
+	kept := make([]Message, 0, len(s.RecentHistory))
+	for _, msg := range s.RecentHistory {
+		if msg.Seq == 0 {
+			s.HistorySeq++
+			msg.Seq = s.HistorySeq
+		} else if msg.Seq <= s.CompressedThrough {
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	s.RecentHistory = kept
+}
@@ -0,0 +1,428 @@
+package game_engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 背包：
+// MOD在 game_config.inventory 中声明物品类型后启用，背包保存在 State["inventory"] 中，只由程序修改。
+// AI在回合JSON的 inventory_ops 中声明获得、失去、消耗和转交的物品，程序校验后应用；
+// 校验失败按 hard 一致性违规处理，要求AI重新生成。state_update 中对 inventory 的修改会被忽略。
+
+const inventoryStateKey = "inventory"
+
+// 背包操作类型
+const (
+	InventoryAdd      = "add"
+	InventoryRemove   = "remove"
+	InventoryConsume  = "consume"
+	InventoryTransfer = "transfer"
+)
+
+// InventoryConfig MOD的背包配置
+type InventoryConfig struct {
+	Capacity  int              `json:"capacity"`   // 背包中物品种类的上限，0 不限
+	ItemTypes []ItemTypeConfig `json:"item_types"` // 物品类型，为空时不启用背包
+}
+
+// ItemTypeConfig 物品类型
+type ItemTypeConfig struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Properties []string `json:"properties,omitempty"` // 新物品必须提供的属性，如 转数、流派、消耗
+	Consumable bool     `json:"consumable"`           // 是否可以被 consume（如元石、一次性蛊虫）
+	Capacity   int      `json:"capacity"`             // 该类物品的总数量上限，0 不限
+}
+
+// InventoryItem 背包中的一种物品
+type InventoryItem struct {
+	Type       string                 `json:"type"`
+	Name       string                 `json:"name"`
+	Quantity   int                    `json:"quantity"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// InventoryOp AI输出的一条背包操作
+type InventoryOp struct {
+	Op         string                 `json:"op"`
+	Type       string                 `json:"type,omitempty"`
+	Name       string                 `json:"name"`
+	Quantity   int                    `json:"quantity,omitempty"` // 默认1
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	From       string                 `json:"from,omitempty"` // transfer：从他人处获得
+	To         string                 `json:"to,omitempty"`   // transfer：交给他人
+}
+
+const inventoryOutputInstruction = `【背包操作】获得、失去、消耗或转交物品时，在输出的JSON中附加 inventory_ops 字段，不要在 state_update 中记录物品和数量：
+"inventory_ops": [{"op": "add|remove|consume|transfer", "type": "物品类型", "name": "名称", "quantity": 数量, "properties": {"属性": "值"}, "to": "接收者", "from": "给予者"}]
+- add：获得物品；remove：丢失、出售或损毁；consume：使用消耗；transfer：带 to 表示交给他人，带 from 表示从他人处获得
+- 只能移除、消耗或转交背包中已有的物品，数量不能超过拥有的数量
+`
+
+func (cfg *InventoryConfig) enabled() bool {
+	return cfg != nil && len(cfg.ItemTypes) > 0
+}
+
+// itemType 按ID或名称查找物品类型
+func (cfg *InventoryConfig) itemType(name string) *ItemTypeConfig {
+	name = strings.TrimSpace(name)
+	for i := range cfg.ItemTypes {
+		if cfg.ItemTypes[i].ID == name || cfg.ItemTypes[i].Name == name {
+			return &cfg.ItemTypes[i]
+		}
+	}
+	return nil
+}
+
+func validateInventoryConfig(cfg InventoryConfig) error {
+	if cfg.Capacity < 0 {
+		return fmt.Errorf("capacity must not be negative")
+	}
+	seen := make(map[string]bool)
+	for _, itemType := range cfg.ItemTypes {
+		if itemType.ID == "" {
+			return fmt.Errorf("item type id is required")
+		}
+		if seen[itemType.ID] {
+			return fmt.Errorf("duplicate item type: %s", itemType.ID)
+		}
+		seen[itemType.ID] = true
+		if itemType.Capacity < 0 {
+			return fmt.Errorf("item type %s: capacity must not be negative", itemType.ID)
+		}
+	}
+	return nil
+}
+
+// loadInventory 从状态中读取背包
+func loadInventory(state map[string]interface{}) []*InventoryItem {
+	raw, exists := state[inventoryStateKey]
+	if !exists || raw == nil {
+		return nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var items []*InventoryItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		fmt.Printf("[背包] 状态中的背包无法解析，按空背包处理: %v\n", err)
+		return nil
+	}
+	return items
+}
+
+// storeInventory 把背包写回状态，保存为普通JSON值以便状态复制和比较
+func storeInventory(state map[string]interface{}, items []*InventoryItem) {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return
+	}
+	var generic []interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return
+	}
+	if generic == nil {
+		generic = []interface{}{}
+	}
+	state[inventoryStateKey] = generic
+}
+
+// parseInventoryOps 解析回合JSON中的 inventory_ops
+func parseInventoryOps(raw interface{}) ([]InventoryOp, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var ops []InventoryOp
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, fmt.Errorf("inventory_ops 格式错误，应为操作数组且 quantity 为整数")
+	}
+	return ops, nil
+}
+
+// applyInventoryOps 在背包副本上依次执行操作，任何一条不合法时返回错误，原背包不变
+func applyInventoryOps(cfg *InventoryConfig, items []*InventoryItem, ops []InventoryOp) ([]*InventoryItem, error) {
+	result := make([]*InventoryItem, 0, len(items))
+	for _, item := range items {
+		copied := *item
+		copied.Properties = make(map[string]interface{}, len(item.Properties))
+		for k, v := range item.Properties {
+			copied.Properties[k] = v
+		}
+		result = append(result, &copied)
+	}
+
+	for i, op := range ops {
+		op.Op = strings.ToLower(strings.TrimSpace(op.Op))
+		op.Name = strings.TrimSpace(op.Name)
+		if op.Name == "" {
+			return nil, fmt.Errorf("inventory_ops[%d]：缺少物品名称", i)
+		}
+		if op.Quantity < 0 {
+			return nil, fmt.Errorf("inventory_ops[%d]：%s 的数量不能为负数", i, op.Name)
+		}
+		if op.Quantity == 0 {
+			op.Quantity = 1
+		}
+
+		var err error
+		switch op.Op {
+		case InventoryAdd:
+			result, err = addInventoryItem(cfg, result, op)
+		case InventoryRemove, InventoryConsume:
+			result, err = takeInventoryItem(cfg, result, op)
+		case InventoryTransfer:
+			switch {
+			case op.To != "" && op.From == "":
+				result, err = takeInventoryItem(cfg, result, op)
+			case op.From != "" && op.To == "":
+				result, err = addInventoryItem(cfg, result, op)
+			default:
+				err = fmt.Errorf("transfer 必须且只能指定 to 或 from 之一")
+			}
+		default:
+			err = fmt.Errorf("未知的操作 %q", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("inventory_ops[%d]：%w", i, err)
+		}
+	}
+
+	if err := checkInventoryCapacity(cfg, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func addInventoryItem(cfg *InventoryConfig, items []*InventoryItem, op InventoryOp) ([]*InventoryItem, error) {
+	if item := findInventoryItem(items, op.Name); item != nil {
+		if op.Type != "" {
+			if itemType := cfg.itemType(op.Type); itemType == nil || itemType.ID != item.Type {
+				return nil, fmt.Errorf("%s 已作为 %s 存在于背包中", item.Name, item.Type)
+			}
+		}
+		item.Quantity += op.Quantity
+		for k, v := range op.Properties {
+			if _, exists := item.Properties[k]; !exists {
+				item.Properties[k] = v
+			}
+		}
+		return items, nil
+	}
+
+	itemType := cfg.itemType(op.Type)
+	if itemType == nil {
+		return nil, fmt.Errorf("%s 的物品类型 %q 未定义", op.Name, op.Type)
+	}
+	var missing []string
+	for _, property := range itemType.Properties {
+		if _, exists := op.Properties[property]; !exists {
+			missing = append(missing, property)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("新物品 %s 缺少属性：%s", op.Name, strings.Join(missing, "、"))
+	}
+
+	properties := make(map[string]interface{}, len(op.Properties))
+	for k, v := range op.Properties {
+		properties[k] = v
+	}
+	return append(items, &InventoryItem{
+		Type:       itemType.ID,
+		Name:       op.Name,
+		Quantity:   op.Quantity,
+		Properties: properties,
+	}), nil
+}
+
+func takeInventoryItem(cfg *InventoryConfig, items []*InventoryItem, op InventoryOp) ([]*InventoryItem, error) {
+	item := findInventoryItem(items, op.Name)
+	if item == nil {
+		return nil, fmt.Errorf("背包中没有 %s", op.Name)
+	}
+	if item.Quantity < op.Quantity {
+		return nil, fmt.Errorf("%s 只有 %d，不足 %d", item.Name, item.Quantity, op.Quantity)
+	}
+	if op.Op == InventoryConsume {
+		if itemType := cfg.itemType(item.Type); itemType == nil || !itemType.Consumable {
+			return nil, fmt.Errorf("%s 不是可消耗的物品", item.Name)
+		}
+	}
+
+	item.Quantity -= op.Quantity
+	if item.Quantity > 0 {
+		return items, nil
+	}
+	kept := items[:0]
+	for _, existing := range items {
+		if existing != item {
+			kept = append(kept, existing)
+		}
+	}
+	return kept, nil
+}
+
+func checkInventoryCapacity(cfg *InventoryConfig, items []*InventoryItem) error {
+	if cfg.Capacity > 0 && len(items) > cfg.Capacity {
+		return fmt.Errorf("背包最多容纳 %d 种物品，操作后为 %d 种", cfg.Capacity, len(items))
+	}
+	totals := make(map[string]int)
+	for _, item := range items {
+		totals[item.Type] += item.Quantity
+	}
+	for _, itemType := range cfg.ItemTypes {
+		if itemType.Capacity > 0 && totals[itemType.ID] > itemType.Capacity {
+			return fmt.Errorf("%s 最多 %d 个，操作后为 %d 个", itemTypeLabel(&itemType), itemType.Capacity, totals[itemType.ID])
+		}
+	}
+	return nil
+}
+
+// findInventoryItem 按规范化名称查找物品
+func findInventoryItem(items []*InventoryItem, name string) *InventoryItem {
+	key := normalizeEntityName(name)
+	for _, item := range items {
+		if normalizeEntityName(item.Name) == key {
+			return item
+		}
+	}
+	return nil
+}
+
+func itemTypeLabel(itemType *ItemTypeConfig) string {
+	if itemType.Name != "" {
+		return itemType.Name
+	}
+	return itemType.ID
+}
+
+// withoutStateKeys 去掉 state_update 中对程序维护的状态（背包、世界时钟、任务）的直接修改
+func withoutStateKeys(stateUpdate map[string]interface{}, roots ...string) map[string]interface{} {
+	filtered := make(map[string]interface{}, len(stateUpdate))
+	for key, value := range stateUpdate {
+		ignored := false
+		for _, root := range roots {
+			if key == root || strings.HasPrefix(key, root+".") {
+				ignored = true
+				break
+			}
+		}
+		if ignored {
+			fmt.Printf("[状态] 忽略 state_update 对程序维护状态的直接修改: %s\n", key)
+			continue
+		}
+		filtered[key] = value
+	}
+	return filtered
+}
+
+// filterStateUpdate 按MOD启用的功能去掉AI不能直接修改的状态键
+func (mod *GameMod) filterStateUpdate(stateUpdate map[string]interface{}) map[string]interface{} {
+	var roots []string
+	if mod.inventoryConfig().enabled() {
+		roots = append(roots, inventoryStateKey)
+	}
+	if mod.calendarConfig().enabled() {
+		roots = append(roots, worldClockStateKey)
+	}
+	if mod.questConfig().enabled() {
+		roots = append(roots, questStateKey)
+	}
+	if len(roots) == 0 || stateUpdate == nil {
+		return stateUpdate
+	}
+	return withoutStateKeys(stateUpdate, roots...)
+}
+
+// inventoryConfig MOD的背包配置，mod 为nil时返回nil
+func (mod *GameMod) inventoryConfig() *InventoryConfig {
+	if mod == nil {
+		return nil
+	}
+	return &mod.Config.GameConfig.Inventory
+}
+
+// turnInventory 按回合JSON中的 inventory_ops 计算新的背包，MOD未启用背包或没有操作时 changed 为false
+func (mod *GameMod) turnInventory(state, parsed map[string]interface{}) (items []*InventoryItem, changed bool, err error) {
+	cfg := mod.inventoryConfig()
+	if !cfg.enabled() {
+		return nil, false, nil
+	}
+	ops, err := parseInventoryOps(parsed["inventory_ops"])
+	if err != nil || len(ops) == 0 {
+		return nil, false, err
+	}
+	items, err = applyInventoryOps(cfg, loadInventory(state), ops)
+	if err != nil {
+		return nil, false, err
+	}
+	return items, true, nil
+}
+
+// inventoryContext 注入提示词的权威背包和操作说明
+func (mod *GameMod) inventoryContext(state map[string]interface{}) string {
+	cfg := mod.inventoryConfig()
+	if !cfg.enabled() {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("【背包】以下是程序维护的权威背包，叙事中提到的物品和数量必须与此一致：\n")
+	items := loadInventory(state)
+	if len(items) == 0 {
+		b.WriteString("（空）\n")
+	}
+	for _, item := range items {
+		label := item.Type
+		if itemType := cfg.itemType(item.Type); itemType != nil {
+			label = itemTypeLabel(itemType)
+		}
+		line := fmt.Sprintf("- %s ×%d（%s", item.Name, item.Quantity, label)
+		if len(item.Properties) > 0 {
+			keys := make([]string, 0, len(item.Properties))
+			for k := range item.Properties {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			var props []string
+			for _, k := range keys {
+				props = append(props, fmt.Sprintf("%s:%v", k, item.Properties[k]))
+			}
+			line += "｜" + strings.Join(props, " ")
+		}
+		b.WriteString(line + "）\n")
+	}
+	if cfg.Capacity > 0 {
+		b.WriteString(fmt.Sprintf("物品种类：%d/%d\n", len(items), cfg.Capacity))
+	}
+
+	b.WriteString("\n" + inventoryOutputInstruction)
+	b.WriteString("物品类型：\n")
+	for i := range cfg.ItemTypes {
+		itemType := &cfg.ItemTypes[i]
+		line := fmt.Sprintf("- %s（%s）", itemType.ID, itemTypeLabel(itemType))
+		var notes []string
+		if len(itemType.Properties) > 0 {
+			notes = append(notes, "新物品需提供 "+strings.Join(itemType.Properties, "、"))
+		}
+		if itemType.Consumable {
+			notes = append(notes, "可消耗")
+		}
+		if itemType.Capacity > 0 {
+			notes = append(notes, fmt.Sprintf("最多 %d 个", itemType.Capacity))
+		}
+		if len(notes) > 0 {
+			line += "：" + strings.Join(notes, "，")
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
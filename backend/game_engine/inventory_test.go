@@ -0,0 +1,96 @@
+package game_engine
+
+import (
+	"AIGE/services"
+	"strings"
+	"testing"
+)
+
+func testInventoryConfig() *InventoryConfig {
+	return &InventoryConfig{
+		Capacity: 3,
+		ItemTypes: []ItemTypeConfig{
+			{ID: "gu", Name: "蛊虫", Properties: []string{"转数", "流派"}, Capacity: 2},
+			{ID: "resource", Name: "资源", Consumable: true},
+		},
+	}
+}
+
+func TestInventoryOps(t *testing.T) {
+	cfg := testInventoryConfig()
+	items, err := applyInventoryOps(cfg, nil, []InventoryOp{
+		{Op: "add", Type: "resource", Name: "元石", Quantity: 100},
+		{Op: "add", Type: "蛊虫", Name: "月光蛊", Properties: map[string]interface{}{"转数": 1, "流派": "光道"}},
+		{Op: "consume", Name: "元石", Quantity: 30},
+		{Op: "transfer", Name: "元石", Quantity: 20, To: "方正"},
+	})
+	if err != nil {
+		t.Fatalf("Expected ops to apply, got %v", err)
+	}
+	if len(items) != 2 || items[0].Quantity != 50 || items[1].Type != "gu" {
+		t.Errorf("Unexpected inventory: %+v", items)
+	}
+
+	rejected := []struct {
+		name string
+		ops  []InventoryOp
+		want string
+	}{
+		{"移除未拥有的物品", []InventoryOp{{Op: "remove", Name: "酒虫"}}, "背包中没有"},
+		{"数量不足", []InventoryOp{{Op: "consume", Name: "元石", Quantity: 51}}, "不足"},
+		{"消耗不可消耗的物品", []InventoryOp{{Op: "consume", Name: "月光蛊"}}, "不是可消耗"},
+		{"新物品缺少属性", []InventoryOp{{Op: "add", Type: "gu", Name: "酒虫", Properties: map[string]interface{}{"转数": 1}}}, "缺少属性：流派"},
+		{"未定义的类型", []InventoryOp{{Op: "add", Type: "法宝", Name: "飞剑"}}, "未定义"},
+		{"超出类型容量", []InventoryOp{{Op: "add", Name: "月光蛊", Quantity: 2}}, "最多 2 个"},
+		{"transfer缺少对象", []InventoryOp{{Op: "transfer", Name: "元石"}}, "to 或 from"},
+	}
+	for _, tc := range rejected {
+		_, err := applyInventoryOps(cfg, items, tc.ops)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.want, err)
+		}
+	}
+	if items[0].Quantity != 50 {
+		t.Errorf("Expected rejected ops to leave inventory untouched, got %+v", items[0])
+	}
+
+	state := map[string]interface{}{}
+	storeInventory(state, items)
+	if loaded := loadInventory(copyState(state)); len(loaded) != 2 || loaded[1].Properties["流派"] != "光道" {
+		t.Errorf("Expected inventory to round-trip through state, got %+v", loaded)
+	}
+}
+
+func TestInventoryOpsRegenerateOnInvalidRemoval(t *testing.T) {
+	mock := services.NewMockClient(services.MockConfig{
+		Responses: []services.MockResponse{
+			{Content: `$你卖掉了酒虫。$@{"inventory_ops":[{"op":"remove","name":"酒虫"}]}@`},
+			{Content: `$你花掉十块元石。$@{"inventory_ops":[{"op":"consume","name":"元石","quantity":10}],"state_update":{"inventory":[]}}@`},
+		},
+	})
+	gc, sm := newMockGame(t, mock)
+	mod, _ := gc.modLoader.GetMod("test")
+	mod.Config.GameConfig.Inventory = *testInventoryConfig()
+	session, _ := sm.GetSession("1", "test")
+	storeInventory(session.State, []*InventoryItem{{Type: "resource", Name: "元石", Quantity: 35}})
+
+	err := gc.ProcessActionStreamWithAttributes("1", "test", "交易", nil,
+		func(string) error { return nil }, nil, func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("Expected corrected response to be accepted, got %v", err)
+	}
+	calls := mock.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("Expected one correction call, got %d calls", len(calls))
+	}
+	var prompt strings.Builder
+	for _, msg := range calls[0].Messages {
+		prompt.WriteString(msg.Content)
+	}
+	if !strings.Contains(prompt.String(), "- 元石 ×35（资源）") {
+		t.Errorf("Expected authoritative inventory in prompt")
+	}
+	if items := loadInventory(session.State); len(items) != 1 || items[0].Quantity != 25 {
+		t.Errorf("Expected 25 元石 after consuming, got %+v", items)
+	}
+}
@@ -0,0 +1,237 @@
+package game_engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 分层记忆：
+//   - 章节摘要：每次压缩生成一章，记录对应的消息序号范围
+//   - 故事梗概（Arc）：未并入的章节达到一定数量后与旧梗概一起重新总结，已并入的章节随之移除
+//   - 事实账本：角色特征、NPC关系、拥有的蛊、未完成任务、到过的地点，每次压缩时由AI输出完整的更新结果
+// 构建提示词时按当前上下文选择性注入，避免整段摘要无限增长。
+
+const (
+	memoryListLimit     = 30 // 事实账本中每个列表的最大条数，超出时保留最新的
+	memoryRecentChapter = 2  // 注入提示词的最近章节数
+)
+
+// SessionMemory 会话的分层记忆
+type SessionMemory struct {
+	Chapters   []ChapterSummary `json:"chapters"`
+	Arc        string           `json:"arc"`
+	ArcThrough int              `json:"arc_through"` // 序号不大于该值的章节已并入梗概
+	Facts      FactsLedger      `json:"facts"`
+}
+
+// ChapterSummary 一次压缩产生的章节摘要
+type ChapterSummary struct {
+	Index     int       `json:"index"`
+	Summary   string    `json:"summary"`
+	FromSeq   int64     `json:"from_seq"`
+	ToSeq     int64     `json:"to_seq"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FactsLedger 结构化的事实账本
+type FactsLedger struct {
+	Character map[string]string `json:"character"` // 角色特征，如姓名、性别、出身、修为
+	NPCs      []NPCRelation     `json:"npcs"`
+	OwnedGu   []string          `json:"owned_gu"`
+	Quests    []string          `json:"quests"` // 未完成的任务和目标
+	Locations []string          `json:"locations"`
+}
+
+// NPCRelation NPC及其与玩家的关系
+type NPCRelation struct {
+	Name     string `json:"name"`
+	Relation string `json:"relation"`
+	Notes    string `json:"notes,omitempty"`
+}
+
+// clone 深拷贝记忆，压缩任务在副本上修改后整体替换
+func (m *SessionMemory) clone() *SessionMemory {
+	if m == nil {
+		return &SessionMemory{}
+	}
+	data, _ := json.Marshal(m)
+	var copied SessionMemory
+	json.Unmarshal(data, &copied)
+	return &copied
+}
+
+// IsEmpty 是否还没有任何记忆
+func (m *SessionMemory) IsEmpty() bool {
+	return m == nil || (m.Arc == "" && len(m.Chapters) == 0 && m.Facts.isEmpty())
+}
+
+func (f *FactsLedger) isEmpty() bool {
+	return len(f.Character) == 0 && len(f.NPCs) == 0 && len(f.OwnedGu) == 0 && len(f.Quests) == 0 && len(f.Locations) == 0
+}
+
+// nextChapterIndex 下一章的序号
+func (m *SessionMemory) nextChapterIndex() int {
+	if n := len(m.Chapters); n > 0 {
+		return m.Chapters[n-1].Index + 1
+	}
+	return m.ArcThrough + 1
+}
+
+// pendingChapters 尚未并入梗概的章节
+func (m *SessionMemory) pendingChapters() []ChapterSummary {
+	var pending []ChapterSummary
+	for _, chapter := range m.Chapters {
+		if chapter.Index > m.ArcThrough {
+			pending = append(pending, chapter)
+		}
+	}
+	return pending
+}
+
+// foldIntoArc 用新梗概替换已并入的章节
+func (m *SessionMemory) foldIntoArc(arc string, through int) {
+	m.Arc = arc
+	m.ArcThrough = through
+	kept := m.Chapters[:0]
+	for _, chapter := range m.Chapters {
+		if chapter.Index > through {
+			kept = append(kept, chapter)
+		}
+	}
+	m.Chapters = kept
+}
+
+// mergeFacts 用AI输出的账本替换当前账本，AI漏掉的角色特征保留旧值
+func (f *FactsLedger) mergeFacts(updated FactsLedger) {
+	character := make(map[string]string)
+	for k, v := range f.Character {
+		character[k] = v
+	}
+	for k, v := range updated.Character {
+		if v != "" {
+			character[k] = v
+		}
+	}
+	f.Character = character
+	f.NPCs = lastN(updated.NPCs, memoryListLimit)
+	f.OwnedGu = lastN(dedupe(updated.OwnedGu), memoryListLimit)
+	f.Quests = lastN(dedupe(updated.Quests), memoryListLimit)
+	f.Locations = lastN(dedupe(updated.Locations), memoryListLimit)
+}
+
+// Text 完整记忆的文本形式，用于兼容只读取摘要字符串的地方（如管理后台）
+func (m *SessionMemory) Text() string {
+	if m == nil {
+		return ""
+	}
+	var b strings.Builder
+	if m.Arc != "" {
+		b.WriteString(m.Arc)
+	}
+	for _, chapter := range m.Chapters {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "第%d章：%s", chapter.Index, chapter.Summary)
+	}
+	return b.String()
+}
+
+// Context 按当前上下文选择需要注入的记忆：角色特征、蛊、任务和梗概总是注入，
+// NPC只注入最近对话或当前行动中提到的，地点只注入提到的和最后到达的，章节只注入最近几章
+func (m *SessionMemory) Context(recentText string) string {
+	if m.IsEmpty() {
+		return ""
+	}
+
+	var b strings.Builder
+	facts := m.Facts
+	if len(facts.Character) > 0 {
+		b.WriteString("【角色档案】\n")
+		for _, key := range sortedKeys(facts.Character) {
+			fmt.Fprintf(&b, "- %s：%s\n", key, facts.Character[key])
+		}
+	}
+
+	var npcs []string
+	for _, npc := range facts.NPCs {
+		if npc.Name != "" && strings.Contains(recentText, npc.Name) {
+			line := fmt.Sprintf("- %s：%s", npc.Name, npc.Relation)
+			if npc.Notes != "" {
+				line += "（" + npc.Notes + "）"
+			}
+			npcs = append(npcs, line)
+		}
+	}
+	if len(npcs) > 0 {
+		b.WriteString("【相关人物】\n" + strings.Join(npcs, "\n") + "\n")
+	}
+
+	if len(facts.OwnedGu) > 0 {
+		b.WriteString("【拥有的蛊】" + strings.Join(facts.OwnedGu, "、") + "\n")
+	}
+	if len(facts.Quests) > 0 {
+		b.WriteString("【未完成任务】\n- " + strings.Join(facts.Quests, "\n- ") + "\n")
+	}
+
+	var locations []string
+	for i, location := range facts.Locations {
+		if i == len(facts.Locations)-1 || strings.Contains(recentText, location) {
+			locations = append(locations, location)
+		}
+	}
+	if len(locations) > 0 {
+		b.WriteString("【相关地点】" + strings.Join(locations, "、") + "\n")
+	}
+
+	if m.Arc != "" {
+		b.WriteString("【故事梗概】" + m.Arc + "\n")
+	}
+	chapters := m.Chapters
+	if len(chapters) > memoryRecentChapter {
+		chapters = chapters[len(chapters)-memoryRecentChapter:]
+	}
+	for _, chapter := range chapters {
+		fmt.Fprintf(&b, "【第%d章】%s\n", chapter.Index, chapter.Summary)
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// legacyMemory 把旧存档的摘要字符串转换为梗概
+func legacyMemory(summary string) *SessionMemory {
+	return &SessionMemory{Arc: summary}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func dedupe(items []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" || seen[item] {
+			continue
+		}
+		seen[item] = true
+		result = append(result, item)
+	}
+	return result
+}
+
+func lastN[T any](items []T, n int) []T {
+	if len(items) > n {
+		return append([]T(nil), items[len(items)-n:]...)
+	}
+	return items
+}
@@ -0,0 +1,82 @@
+package game_engine
+
+import (
+	"AIGE/services"
+	"strings"
+	"testing"
+)
+
+func TestParseCompressionResultUpdatesFacts(t *testing.T) {
+	current := FactsLedger{Character: map[string]string{"姓名": "方源", "性别": "男"}}
+	response := "```json\n" + `{"chapter_summary": "方源开窍成功", "facts": {"character": {"修为": "一转初阶"}, "npcs": [{"name": "方正", "relation": "弟弟"}], "owned_gu": ["月光蛊", "月光蛊"], "quests": ["寻找春秋蝉"], "locations": ["古月山寨"]}}` + "\n```"
+
+	summary, facts := parseCompressionResult(response, current)
+	if summary != "方源开窍成功" {
+		t.Fatalf("Unexpected chapter summary: %q", summary)
+	}
+	current.mergeFacts(facts)
+	if current.Character["姓名"] != "方源" || current.Character["修为"] != "一转初阶" {
+		t.Errorf("Expected character traits to be merged, got %v", current.Character)
+	}
+	if len(current.OwnedGu) != 1 || len(current.NPCs) != 1 || current.Quests[0] != "寻找春秋蝉" {
+		t.Errorf("Unexpected ledger: %+v", current)
+	}
+
+	// 非JSON回复整段作为章节摘要，账本不变
+	summary, facts = parseCompressionResult("方源离开山寨", current)
+	if summary != "方源离开山寨" || facts.Quests[0] != "寻找春秋蝉" {
+		t.Errorf("Unexpected fallback result: %q %+v", summary, facts)
+	}
+}
+
+func TestMemoryContextIsSelective(t *testing.T) {
+	memory := &SessionMemory{
+		Arc: "方源重生",
+		Chapters: []ChapterSummary{
+			{Index: 1, Summary: "第一章内容"},
+			{Index: 2, Summary: "第二章内容"},
+			{Index: 3, Summary: "第三章内容"},
+		},
+		Facts: FactsLedger{
+			NPCs:      []NPCRelation{{Name: "方正", Relation: "弟弟"}, {Name: "白凝冰", Relation: "宿敌"}},
+			Locations: []string{"古月山寨", "青茅山", "商家城"},
+		},
+	}
+
+	context := memory.Context("方正前来拜访，提起青茅山")
+	for _, want := range []string{"方正：弟弟", "青茅山", "商家城", "【故事梗概】方源重生", "第二章内容", "第三章内容"} {
+		if !strings.Contains(context, want) {
+			t.Errorf("Expected context to contain %q:\n%s", want, context)
+		}
+	}
+	for _, unwanted := range []string{"白凝冰", "古月山寨", "第一章内容"} {
+		if strings.Contains(context, unwanted) {
+			t.Errorf("Expected context to omit %q:\n%s", unwanted, context)
+		}
+	}
+}
+
+func TestCompressionFoldsChaptersIntoArc(t *testing.T) {
+	mock := services.NewMockClient(services.MockConfig{Default: "梗概"})
+	gc, sm := newMockGame(t, mock)
+	cm := gc.compressionManager
+	cm.compressionInterval = 4
+	cm.maxRecentHistory = 2
+	session, _ := sm.GetSession("1", "test")
+
+	for i := 1; i <= cm.arcInterval; i++ {
+		user, ai := turn(2*i - 1)
+		cm.ProcessNewMessage(session, user, ai)
+		user, ai = turn(2 * i)
+		cm.ProcessNewMessage(session, user, ai)
+		cm.Wait()
+	}
+
+	_, memory := session.historySnapshot()
+	if memory.Arc != "梗概" || memory.ArcThrough != cm.arcInterval || len(memory.Chapters) != 0 {
+		t.Fatalf("Expected chapters to be folded into arc, got %+v", memory)
+	}
+	if next := memory.nextChapterIndex(); next != cm.arcInterval+1 {
+		t.Errorf("Expected next chapter %d, got %d", cm.arcInterval+1, next)
+	}
+}
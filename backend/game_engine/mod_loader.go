@@ -1,6 +1,7 @@
 package game_engine
 
 import (
+	"AIGE/models"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -20,21 +21,27 @@ type ModConfig struct {
 		RewardScalingFactor   int     `json:"reward_scaling_factor"`
 		MaxTokenHistory       int     `json:"max_token_history"`
 		AutoSaveInterval      int     `json:"auto_save_interval"`
-		RollSettings          struct {
-			CriticalSuccessThreshold float64 `json:"critical_success_threshold"`
-			CriticalFailureThreshold float64 `json:"critical_failure_threshold"`
-			DefaultSides             int     `json:"default_sides"`
-		} `json:"roll_settings"`
+		RollSettings          RollSettings `json:"roll_settings"`
 		CheatCheck struct {
 			Enabled       bool   `json:"enabled"`
 			CheckInterval int    `json:"check_interval"`
 			Model         string `json:"model"`
 		} `json:"cheat_check"`
+		Consistency      ConsistencyConfig `json:"consistency"` // 一致性规则，违反 hard 规则时重新生成
+		Inventory        InventoryConfig   `json:"inventory"`   // 背包物品类型，声明后由程序维护背包
+		Calendar         CalendarConfig    `json:"calendar"`    // 历法与世界时钟，启用后由程序推进时间
+		Quests           QuestConfig       `json:"quests"`      // 任务日志，启用后由程序校验任务状态流转
+		EntityExtraction struct {
+			AIFallback bool `json:"ai_fallback"` // 回合输出没有 entities 字段时额外调用AI提取实体
+		} `json:"entity_extraction"`
+		Generation map[string]models.GenerationParams `json:"generation"` // 按用途（narrative、compression、cheat_check、entity_extraction）覆盖模型的生成参数
 	} `json:"game_config"`
 
 	Prompts map[string]string `json:"prompts"`
 	LoreFiles []string `json:"lore_files"` // 世界观文档列表
 
+	ActionModifiers []ActionModifierConfig `json:"action_modifiers"` // 动作修饰器（燃魂、作弊等）
+
 	InitialState  map[string]interface{} `json:"initial_state"`
 	WelcomeMessage string                 `json:"welcome_message"`
 }
@@ -98,6 +105,28 @@ func (ml *ModLoader) LoadMod(modID string) (*GameMod, error) {
 		prompts[promptName] = string(content)
 	}
 
+	if err := validateActionModifiers(config.ActionModifiers, prompts); err != nil {
+		return nil, fmt.Errorf("invalid action modifiers: %w", err)
+	}
+	if err := validateRollSettings(&config.GameConfig.RollSettings); err != nil {
+		return nil, fmt.Errorf("invalid roll settings: %w", err)
+	}
+	if err := validateGenerationOverrides(config.GameConfig.Generation); err != nil {
+		return nil, fmt.Errorf("invalid generation settings: %w", err)
+	}
+	if err := validateConsistencyConfig(config.GameConfig.Consistency); err != nil {
+		return nil, fmt.Errorf("invalid consistency settings: %w", err)
+	}
+	if err := validateInventoryConfig(config.GameConfig.Inventory); err != nil {
+		return nil, fmt.Errorf("invalid inventory settings: %w", err)
+	}
+	if err := validateCalendarConfig(config.GameConfig.Calendar); err != nil {
+		return nil, fmt.Errorf("invalid calendar settings: %w", err)
+	}
+	if err := validateQuestConfig(config.GameConfig.Quests); err != nil {
+		return nil, fmt.Errorf("invalid quest settings: %w", err)
+	}
+
 	// Load lore files (世界观文档)
 	loreFiles := make(map[string]string)
 	for _, loreFileName := range config.LoreFiles {
@@ -0,0 +1,370 @@
+package game_engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// 任务：
+// MOD在 game_config.quests 中启用后，任务日志保存在 State["quests"] 中，只由程序修改。
+// AI在回合JSON的 quest_ops 中创建任务、完成目标、结束任务，程序按状态流转规则校验后应用；
+// 校验失败按 hard 一致性违规处理。任务可以设置以回合计的期限，过期未完成的任务由程序判定失败。
+
+const questStateKey = "quests"
+
+// 任务状态
+const (
+	QuestActive    = "active"
+	QuestCompleted = "completed"
+	QuestFailed    = "failed"
+	QuestAbandoned = "abandoned"
+)
+
+// 任务操作类型
+const (
+	QuestCreate   = "create"
+	QuestProgress = "progress"
+	QuestComplete = "complete"
+	QuestFail     = "fail"
+	QuestAbandon  = "abandon"
+)
+
+// QuestConfig MOD的任务配置
+type QuestConfig struct {
+	Enabled   bool `json:"enabled"`
+	MaxActive int  `json:"max_active"` // 同时进行中的任务上限，0 不限
+}
+
+// QuestLog 会话的任务日志
+type QuestLog struct {
+	Turn   int      `json:"turn"` // 已应用的回合数，用于计算期限
+	Quests []*Quest `json:"quests"`
+}
+
+// Quest 一个任务
+type Quest struct {
+	ID           string           `json:"id"`
+	Title        string           `json:"title"`
+	Description  string           `json:"description,omitempty"`
+	Status       string           `json:"status"`
+	Objectives   []QuestObjective `json:"objectives,omitempty"`
+	Rewards      []string         `json:"rewards,omitempty"`
+	CreatedTurn  int              `json:"created_turn"`
+	DeadlineTurn int              `json:"deadline_turn,omitempty"` // 在该回合结束前必须完成，0 表示没有期限
+	ResolvedTurn int              `json:"resolved_turn,omitempty"`
+	Reason       string           `json:"reason,omitempty"` // 失败或放弃的原因
+}
+
+// QuestObjective 任务目标
+type QuestObjective struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+// QuestOp AI输出的一条任务操作
+type QuestOp struct {
+	Op            string           `json:"op"`
+	ID            string           `json:"id"`
+	Title         string           `json:"title,omitempty"`
+	Description   string           `json:"description,omitempty"`
+	Objectives    []QuestObjective `json:"objectives,omitempty"`
+	DeadlineTurns int              `json:"deadline_turns,omitempty"`
+	Rewards       []string         `json:"rewards,omitempty"`
+	Objective     string           `json:"objective,omitempty"` // progress：完成的目标ID
+	Reason        string           `json:"reason,omitempty"`
+}
+
+const questOutputInstruction = `【任务操作】剧情中出现需要之后完成的目标（如取得某物、渡过灾劫）时创建任务，目标推进或任务结束时更新，不要在 state_update 中记录任务：
+"quest_ops": [{"op": "create", "id": "任务ID", "title": "标题", "description": "说明", "objectives": [{"id": "目标ID", "text": "目标"}], "deadline_turns": 回合数, "rewards": ["奖励"]}]
+- progress：{"op": "progress", "id": "任务ID", "objective": "目标ID"} 标记一个目标完成
+- complete：所有目标完成后结束任务，并在本回合叙事中发放奖励；fail、abandon：任务失败或放弃，附带 reason
+- 只能更新进行中的任务，已结束的任务不能再改变；deadline_turns 省略表示没有期限，超过期限未完成的任务会被判定失败
+`
+
+func (cfg *QuestConfig) enabled() bool {
+	return cfg != nil && cfg.Enabled
+}
+
+func validateQuestConfig(cfg QuestConfig) error {
+	if cfg.MaxActive < 0 {
+		return fmt.Errorf("max_active must not be negative")
+	}
+	return nil
+}
+
+// QuestJournal 读取状态中的任务日志，没有任务时返回空日志
+func QuestJournal(state map[string]interface{}) *QuestLog {
+	log := &QuestLog{}
+	raw, exists := state[questStateKey]
+	if !exists || raw == nil {
+		return log
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return log
+	}
+	if err := json.Unmarshal(data, log); err != nil {
+		fmt.Printf("[任务] 状态中的任务日志无法解析，按空日志处理: %v\n", err)
+		return &QuestLog{}
+	}
+	return log
+}
+
+// storeQuestLog 把任务日志写回状态，保存为普通JSON值以便状态复制和比较
+func storeQuestLog(state map[string]interface{}, log *QuestLog) {
+	data, err := json.Marshal(log)
+	if err != nil {
+		return
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return
+	}
+	state[questStateKey] = generic
+}
+
+// parseQuestOps 解析回合JSON中的 quest_ops
+func parseQuestOps(raw interface{}) ([]QuestOp, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var ops []QuestOp
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, fmt.Errorf("quest_ops 格式错误，应为操作数组且 deadline_turns 为整数")
+	}
+	return ops, nil
+}
+
+func (l *QuestLog) find(id string) *Quest {
+	id = strings.TrimSpace(id)
+	for _, quest := range l.Quests {
+		if quest.ID == id {
+			return quest
+		}
+	}
+	return nil
+}
+
+func (l *QuestLog) active() []*Quest {
+	var quests []*Quest
+	for _, quest := range l.Quests {
+		if quest.Status == QuestActive {
+			quests = append(quests, quest)
+		}
+	}
+	return quests
+}
+
+func (q *Quest) objective(id string) *QuestObjective {
+	id = strings.TrimSpace(id)
+	for i := range q.Objectives {
+		if q.Objectives[i].ID == id {
+			return &q.Objectives[i]
+		}
+	}
+	return nil
+}
+
+func (q *Quest) resolve(status string, turn int, reason string) {
+	q.Status = status
+	q.ResolvedTurn = turn
+	q.Reason = reason
+}
+
+func (l *QuestLog) clone() *QuestLog {
+	data, _ := json.Marshal(l)
+	cloned := &QuestLog{}
+	json.Unmarshal(data, cloned)
+	return cloned
+}
+
+// applyQuestOps 在任务日志副本上推进一个回合并应用操作，任何一条操作不合法时返回错误且不修改原日志。
+// 操作应用后，超过期限仍在进行的任务判定为失败
+func applyQuestOps(cfg *QuestConfig, log *QuestLog, ops []QuestOp) (*QuestLog, error) {
+	result := log.clone()
+	result.Turn++
+
+	for i, op := range ops {
+		if err := result.apply(cfg, op); err != nil {
+			return nil, fmt.Errorf("quest_ops[%d]：%w", i, err)
+		}
+	}
+
+	for _, quest := range result.active() {
+		if quest.DeadlineTurn > 0 && result.Turn > quest.DeadlineTurn {
+			quest.resolve(QuestFailed, result.Turn, "超过期限")
+		}
+	}
+	return result, nil
+}
+
+func (l *QuestLog) apply(cfg *QuestConfig, op QuestOp) error {
+	id := strings.TrimSpace(op.ID)
+	if id == "" {
+		return fmt.Errorf("缺少任务 id")
+	}
+
+	if op.Op == QuestCreate {
+		if l.find(id) != nil {
+			return fmt.Errorf("任务 %s 已存在，更新已有任务请使用 progress、complete、fail 或 abandon", id)
+		}
+		if strings.TrimSpace(op.Title) == "" {
+			return fmt.Errorf("任务 %s 缺少标题", id)
+		}
+		if op.DeadlineTurns < 0 {
+			return fmt.Errorf("任务 %s 的 deadline_turns 不能为负数", id)
+		}
+		if cfg.MaxActive > 0 && len(l.active()) >= cfg.MaxActive {
+			return fmt.Errorf("进行中的任务已达上限 %d 个，请先完成或放弃已有任务", cfg.MaxActive)
+		}
+		quest := &Quest{
+			ID:          id,
+			Title:       strings.TrimSpace(op.Title),
+			Description: op.Description,
+			Status:      QuestActive,
+			Rewards:     op.Rewards,
+			CreatedTurn: l.Turn,
+		}
+		if op.DeadlineTurns > 0 {
+			quest.DeadlineTurn = l.Turn + op.DeadlineTurns
+		}
+		for i, objective := range op.Objectives {
+			objective.ID = strings.TrimSpace(objective.ID)
+			if objective.ID == "" {
+				objective.ID = fmt.Sprintf("%d", i+1)
+			}
+			if strings.TrimSpace(objective.Text) == "" {
+				return fmt.Errorf("任务 %s 的目标 %s 缺少内容", id, objective.ID)
+			}
+			if quest.objective(objective.ID) != nil {
+				return fmt.Errorf("任务 %s 的目标 %s 重复", id, objective.ID)
+			}
+			quest.Objectives = append(quest.Objectives, objective)
+		}
+		l.Quests = append(l.Quests, quest)
+		return nil
+	}
+
+	quest := l.find(id)
+	if quest == nil {
+		return fmt.Errorf("任务 %s 不存在", id)
+	}
+	if quest.Status != QuestActive {
+		return fmt.Errorf("任务 %s 已经结束（%s），不能再 %s", id, questStatusLabel(quest.Status), op.Op)
+	}
+
+	switch op.Op {
+	case QuestProgress:
+		objective := quest.objective(op.Objective)
+		if objective == nil {
+			return fmt.Errorf("任务 %s 没有目标 %s", id, op.Objective)
+		}
+		objective.Done = true
+	case QuestComplete:
+		for _, objective := range quest.Objectives {
+			if !objective.Done {
+				return fmt.Errorf("任务 %s 的目标 %s（%s）尚未完成，不能完成任务", id, objective.ID, objective.Text)
+			}
+		}
+		quest.resolve(QuestCompleted, l.Turn, "")
+	case QuestFail:
+		quest.resolve(QuestFailed, l.Turn, op.Reason)
+	case QuestAbandon:
+		quest.resolve(QuestAbandoned, l.Turn, op.Reason)
+	default:
+		return fmt.Errorf("未知的任务操作 %q，应为 create、progress、complete、fail 或 abandon", op.Op)
+	}
+	return nil
+}
+
+func questStatusLabel(status string) string {
+	switch status {
+	case QuestActive:
+		return "进行中"
+	case QuestCompleted:
+		return "已完成"
+	case QuestFailed:
+		return "失败"
+	case QuestAbandoned:
+		return "已放弃"
+	}
+	return status
+}
+
+// questConfig MOD的任务配置，mod 为nil时返回nil
+func (mod *GameMod) questConfig() *QuestConfig {
+	if mod == nil {
+		return nil
+	}
+	return &mod.Config.GameConfig.Quests
+}
+
+// turnQuests 按回合JSON中的 quest_ops 计算本回合之后的任务日志，MOD未启用任务时返回nil。
+// 没有操作时也会推进回合数，以便判定期限
+func (mod *GameMod) turnQuests(state, parsed map[string]interface{}) (*QuestLog, error) {
+	cfg := mod.questConfig()
+	if !cfg.enabled() {
+		return nil, nil
+	}
+	ops, err := parseQuestOps(parsed["quest_ops"])
+	if err != nil {
+		return nil, err
+	}
+	return applyQuestOps(cfg, QuestJournal(state), ops)
+}
+
+// questContext 注入提示词的进行中任务和上一回合结束的任务
+func (mod *GameMod) questContext(state map[string]interface{}) string {
+	cfg := mod.questConfig()
+	if !cfg.enabled() {
+		return ""
+	}
+	log := QuestJournal(state)
+
+	var b strings.Builder
+	b.WriteString("【任务】以下是程序维护的任务日志，剧情推进时要关注这些未完成的目标：\n")
+	active := log.active()
+	if len(active) == 0 {
+		b.WriteString("（没有进行中的任务）\n")
+	}
+	for _, quest := range active {
+		b.WriteString(fmt.Sprintf("- [%s] %s", quest.ID, quest.Title))
+		if quest.DeadlineTurn > 0 {
+			b.WriteString(fmt.Sprintf("（剩余%d回合）", quest.DeadlineTurn-log.Turn))
+		}
+		if quest.Description != "" {
+			b.WriteString("：" + quest.Description)
+		}
+		b.WriteString("\n")
+		for _, objective := range quest.Objectives {
+			mark := " "
+			if objective.Done {
+				mark = "x"
+			}
+			b.WriteString(fmt.Sprintf("  [%s] %s. %s\n", mark, objective.ID, objective.Text))
+		}
+		if len(quest.Rewards) > 0 {
+			b.WriteString(fmt.Sprintf("  奖励：%s\n", strings.Join(quest.Rewards, "、")))
+		}
+	}
+
+	for _, quest := range log.Quests {
+		if quest.Status == QuestActive || quest.ResolvedTurn != log.Turn || log.Turn == 0 {
+			continue
+		}
+		line := fmt.Sprintf("- 上一回合结束：[%s] %s（%s", quest.ID, quest.Title, questStatusLabel(quest.Status))
+		if quest.Reason != "" {
+			line += "：" + quest.Reason
+		}
+		b.WriteString(line + "）\n")
+	}
+
+	b.WriteString("\n" + questOutputInstruction)
+	return b.String()
+}
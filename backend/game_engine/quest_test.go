@@ -0,0 +1,98 @@
+package game_engine
+
+import (
+	"AIGE/services"
+	"strings"
+	"testing"
+)
+
+func TestQuestOps(t *testing.T) {
+	cfg := &QuestConfig{Enabled: true, MaxActive: 2}
+	log, err := applyQuestOps(cfg, &QuestLog{}, []QuestOp{
+		{Op: "create", ID: "chunqiu", Title: "夺取春秋蝉", Objectives: []QuestObjective{{Text: "找到花酒行者的传承"}, {Text: "炼化春秋蝉"}}, DeadlineTurns: 2, Rewards: []string{"春秋蝉"}},
+		{Op: "create", ID: "tribulation", Title: "渡过灾劫"},
+		{Op: "progress", ID: "chunqiu", Objective: "1"},
+	})
+	if err != nil {
+		t.Fatalf("Expected ops to apply, got %v", err)
+	}
+	if log.Turn != 1 || len(log.Quests) != 2 || !log.Quests[0].Objectives[0].Done || log.Quests[0].DeadlineTurn != 3 {
+		t.Errorf("Unexpected quest log: %+v", log.Quests[0])
+	}
+
+	rejected := []struct {
+		name string
+		ops  []QuestOp
+		want string
+	}{
+		{"重复创建", []QuestOp{{Op: "create", ID: "chunqiu", Title: "夺取春秋蝉"}}, "已存在"},
+		{"超出进行中上限", []QuestOp{{Op: "create", ID: "third", Title: "寻找福地"}}, "上限"},
+		{"目标未完成", []QuestOp{{Op: "complete", ID: "chunqiu"}}, "尚未完成"},
+		{"不存在的目标", []QuestOp{{Op: "progress", ID: "chunqiu", Objective: "9"}}, "没有目标"},
+		{"不存在的任务", []QuestOp{{Op: "fail", ID: "unknown"}}, "不存在"},
+		{"已结束的任务", []QuestOp{{Op: "abandon", ID: "tribulation"}, {Op: "complete", ID: "tribulation"}}, "已经结束"},
+		{"未知操作", []QuestOp{{Op: "pause", ID: "chunqiu"}}, "未知的任务操作"},
+	}
+	for _, tc := range rejected {
+		if _, err := applyQuestOps(cfg, log, tc.ops); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.want, err)
+		}
+	}
+	if log.Turn != 1 || log.Quests[1].Status != QuestActive {
+		t.Errorf("Expected rejected ops to leave quest log untouched, got %+v", log)
+	}
+
+	state := map[string]interface{}{}
+	storeQuestLog(state, log)
+	mod := &GameMod{}
+	mod.Config.GameConfig.Quests = *cfg
+	for _, want := range []string{"[chunqiu] 夺取春秋蝉（剩余2回合）", "[x] 1. 找到花酒行者的传承", "[ ] 2. 炼化春秋蝉", "奖励：春秋蝉"} {
+		if context := mod.questContext(copyState(state)); !strings.Contains(context, want) {
+			t.Errorf("Expected quest context to contain %q, got:\n%s", want, context)
+		}
+	}
+
+	// 期限内没有完成，第4回合结束时判定失败
+	for i := 0; i < 3; i++ {
+		if log, err = applyQuestOps(cfg, log, nil); err != nil {
+			t.Fatalf("Expected empty turn to apply, got %v", err)
+		}
+	}
+	if quest := log.find("chunqiu"); quest.Status != QuestFailed || quest.ResolvedTurn != 4 {
+		t.Errorf("Expected overdue quest to fail on turn 4, got %+v", quest)
+	}
+	storeQuestLog(state, log)
+	if context := mod.questContext(state); !strings.Contains(context, "上一回合结束：[chunqiu] 夺取春秋蝉（失败：超过期限）") {
+		t.Errorf("Expected expired quest in context, got:\n%s", context)
+	}
+}
+
+func TestQuestOpsRegenerateOnInvalidTransition(t *testing.T) {
+	mock := services.NewMockClient(services.MockConfig{
+		Responses: []services.MockResponse{
+			{Content: `$你夺得了春秋蝉。$@{"quest_ops":[{"op":"complete","id":"chunqiu"}]}@`},
+			{Content: `$你找到了传承洞府。$@{"quest_ops":[{"op":"progress","id":"chunqiu","objective":"1"}],"state_update":{"quests":null}}@`},
+		},
+	})
+	gc, sm := newMockGame(t, mock)
+	mod, _ := gc.modLoader.GetMod("test")
+	mod.Config.GameConfig.Quests = QuestConfig{Enabled: true}
+	session, _ := sm.GetSession("1", "test")
+	log, _ := applyQuestOps(mod.questConfig(), &QuestLog{}, []QuestOp{
+		{Op: "create", ID: "chunqiu", Title: "夺取春秋蝉", Objectives: []QuestObjective{{ID: "1", Text: "找到传承"}, {ID: "2", Text: "炼化春秋蝉"}}},
+	})
+	storeQuestLog(session.State, log)
+
+	err := gc.ProcessActionStreamWithAttributes("1", "test", "探索", nil,
+		func(string) error { return nil }, nil, func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("Expected corrected response to be accepted, got %v", err)
+	}
+	if calls := mock.Calls(); len(calls) != 2 {
+		t.Fatalf("Expected one correction call, got %d calls", len(calls))
+	}
+	journal := QuestJournal(session.State)
+	if journal.Turn != 2 || journal.Quests[0].Status != QuestActive || !journal.Quests[0].Objectives[0].Done {
+		t.Errorf("Expected objective progress on turn 2, got %+v", journal.Quests[0])
+	}
+}
@@ -0,0 +1,212 @@
+package game_engine
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// 判定采用承诺-揭示机制：
+// 会话开始时生成服务器种子并公开其SHA-256哈希，每次判定的随机数由种子和判定序号通过HMAC派生。
+// 玩家申请揭示后公开原种子并更换新种子，任何人都可以据此复算此前的全部骰值。
+
+// DieDraw 一次实际抽取的骰子（按抽取顺序记录，用于复算验证）
+type DieDraw struct {
+	Sides int `json:"sides"`
+	Value int `json:"value"`
+}
+
+// newRollSeed 生成新的服务器种子
+func newRollSeed() string {
+	b := make([]byte, 32)
+	if _, err := crand.Read(b); err != nil {
+		// 极少发生，退化为时间种子
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(strconv.FormatInt(time.Now().UnixNano(), 10))))
+	}
+	return hex.EncodeToString(b)
+}
+
+// HashRollSeed 计算种子的公开承诺值
+func HashRollSeed(seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	return hex.EncodeToString(sum[:])
+}
+
+// rollSourceSeed 由服务器种子和判定序号派生随机数种子
+func rollSourceSeed(seed string, sequence int64) int64 {
+	mac := hmac.New(sha256.New, []byte(seed))
+	mac.Write([]byte(strconv.FormatInt(sequence, 10)))
+	return int64(binary.BigEndian.Uint64(mac.Sum(nil)[:8]))
+}
+
+// ensureRollSeed 确保会话已有服务器种子，新生成的种子会记录到数据库
+func (s *GameSession) ensureRollSeed() {
+	if s.RollSeed != "" {
+		return
+	}
+	s.RollSeed = newRollSeed()
+	s.RollSeedHash = HashRollSeed(s.RollSeed)
+	s.RollSequence = 0
+
+	if config.DB == nil {
+		return
+	}
+	userID, _ := strconv.ParseUint(s.PlayerID, 10, 32)
+	record := models.RollSeed{
+		UserID:   uint(userID),
+		ModID:    s.ModID,
+		SeedHash: s.RollSeedHash,
+		Seed:     s.RollSeed,
+	}
+	if err := config.DB.Create(&record).Error; err != nil {
+		fmt.Printf("[判定] 记录服务器种子失败: %v\n", err)
+	}
+}
+
+// RollSeedCommitment 返回当前服务器种子的哈希（承诺值），尚未生成种子时先生成
+func (s *GameSession) RollSeedCommitment() string {
+	s.ensureRollSeed()
+	return s.RollSeedHash
+}
+
+// nextRollRNG 返回本次判定使用的随机数生成器
+// 由会话种子和判定序号共同决定，相同种子下的判定序列可完全复现
+func (s *GameSession) nextRollRNG() *rand.Rand {
+	s.ensureRollSeed()
+	s.RollSequence++
+	return rand.New(rand.NewSource(rollSourceSeed(s.RollSeed, s.RollSequence)))
+}
+
+// SeedRolls 设置会话的服务器种子并重置判定序号（用于测试和回放，不写入数据库）
+func (s *GameSession) SeedRolls(seed string) {
+	s.RollSeed = seed
+	s.RollSeedHash = HashRollSeed(seed)
+	s.RollSequence = 0
+}
+
+// diceDraws 按抽取顺序展开投掷结果
+func diceDraws(rolls ...DiceRoll) []DieDraw {
+	var draws []DieDraw
+	for _, roll := range rolls {
+		for _, group := range roll.Dice {
+			for _, value := range group.Rolls {
+				draws = append(draws, DieDraw{Sides: group.Sides, Value: value})
+			}
+		}
+	}
+	return draws
+}
+
+// recordRoll 将判定写入判定记录
+func recordRoll(session *GameSession, action string, rollResult map[string]interface{}, draws []DieDraw) {
+	if config.DB == nil {
+		return
+	}
+
+	breakdown, _ := rollResult["breakdown"].(map[string]interface{})
+	breakdownJSON, _ := json.Marshal(breakdown)
+	drawsJSON, _ := json.Marshal(draws)
+	forced, _ := breakdown["forced"].(bool)
+
+	var sequence int64
+	if !forced {
+		sequence = session.RollSequence
+	}
+
+	userID, _ := strconv.ParseUint(session.PlayerID, 10, 32)
+	entry := models.RollLog{
+		UserID:     uint(userID),
+		ModID:      session.ModID,
+		Sequence:   sequence,
+		SeedHash:   session.RollSeedHash,
+		Action:     action,
+		RollType:   fmt.Sprint(rollResult["type"]),
+		Expression: fmt.Sprint(rollResult["expression"]),
+		Target:     rollResult["target"].(float64),
+		Result:     rollResult["result"].(int),
+		Outcome:    fmt.Sprint(rollResult["outcome"]),
+		Forced:     forced,
+		Draws:      string(drawsJSON),
+		Breakdown:  string(breakdownJSON),
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		fmt.Printf("[判定] 记录判定日志失败: %v\n", err)
+	}
+}
+
+// VerifyRollLog 使用揭示的种子复算判定记录中的骰值
+func VerifyRollLog(entry *models.RollLog, seed string) error {
+	if HashRollSeed(seed) != entry.SeedHash {
+		return fmt.Errorf("种子与承诺哈希不匹配")
+	}
+	if entry.Forced {
+		return fmt.Errorf("该判定由动作修饰器强制决定，未经投骰")
+	}
+
+	var draws []DieDraw
+	if err := json.Unmarshal([]byte(entry.Draws), &draws); err != nil {
+		return fmt.Errorf("判定记录损坏: %w", err)
+	}
+
+	rng := rand.New(rand.NewSource(rollSourceSeed(seed, entry.Sequence)))
+	for i, draw := range draws {
+		if draw.Sides < 2 {
+			return fmt.Errorf("第%d颗骰子面数无效", i+1)
+		}
+		if value := rng.Intn(draw.Sides) + 1; value != draw.Value {
+			return fmt.Errorf("第%d颗骰子应为%d，记录为%d", i+1, value, draw.Value)
+		}
+	}
+	return nil
+}
+
+// ErrTurnInProgress 回合处理中，不能揭示种子
+var ErrTurnInProgress = errors.New("回合处理中，请在本回合结束后再揭示种子")
+
+// RevealRollSeed 揭示玩家当前的服务器种子并更换新种子
+func (sm *StateManager) RevealRollSeed(playerID, modID string) (*models.RollSeed, error) {
+	session, err := sm.GetSession(playerID, modID)
+	if err != nil {
+		return nil, err
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	// 回合进行中的判定直接读取会话种子，中途更换会使同一回合的判定和追踪记录使用不同的种子
+	if isProcessing, ok := session.State["is_processing"].(bool); ok && isProcessing {
+		return nil, ErrTurnInProgress
+	}
+
+	session.ensureRollSeed()
+
+	var record models.RollSeed
+	if err := config.DB.Where("seed_hash = ?", session.RollSeedHash).First(&record).Error; err != nil {
+		return nil, fmt.Errorf("种子记录不存在: %w", err)
+	}
+	now := time.Now()
+	record.Revealed = true
+	record.RevealedAt = &now
+	if err := config.DB.Save(&record).Error; err != nil {
+		return nil, err
+	}
+
+	// 更换新种子，揭示后的种子不再用于后续判定
+	session.RollSeed = ""
+	session.ensureRollSeed()
+	if err := sm.saveToDB(session); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
@@ -19,12 +19,33 @@ type GameSession struct {
 	RecentHistory    []Message              `json:"recent_history"`     // 最近4条对话
 	CompressedSummary string                `json:"compressed_summary"` // 压缩摘要
 	CompressionRound int                    `json:"compression_round"`  // 压缩轮次
+	CompressionError string                 `json:"compression_error,omitempty"` // 最近一次压缩失败原因
+	Memory           *SessionMemory         `json:"memory,omitempty"`   // 分层记忆，CompressedSummary 为其文本形式
+	HistorySeq       int64                  `json:"-"`                  // 最近分配的消息序号
+	CompressedThrough int64                 `json:"-"`                  // 序号不大于该值的消息已并入摘要
 	DisplayHistory   []string               `json:"display_history"`  // User-facing narrative
 	LastModified     time.Time              `json:"last_modified"`
 
 	// 实体管理
 	EntityRegistry   string                 `json:"entity_registry,omitempty"` // 序列化的实体注册表
 
+	// 沙盒调试存档（由管理员开启，允许使用作弊类修饰器）
+	DebugSession     bool                   `json:"debug_session"`
+
+	// 判定服务器种子（承诺-揭示，种子本身不下发给前端）
+	RollSeed         string                 `json:"-"`
+	RollSeedHash     string                 `json:"roll_seed_hash"`
+	RollSequence     int64                  `json:"-"`
+
+	// 当前回合的追踪记录（仅在处理动作期间存在）
+	trace *TurnTrace
+
+	// 保护 RecentHistory 与摘要字段，压缩任务在后台并发修改
+	historyMu sync.Mutex
+
+	// 玩家最近一次连接的IP，用于审计日志
+	ClientIP         string                 `json:"-"`
+
 	// 预留社交功能字段
 	Social *SocialData `json:"social,omitempty"` // 社交数据（预留）
 }
@@ -63,6 +84,7 @@ type Message struct {
 	Role      string    `json:"role"`    // system, user, assistant
 	Content   string    `json:"content"`
 	Timestamp time.Time `json:"timestamp"`
+	Seq       int64     `json:"seq,omitempty"` // 会话内递增序号，用于压缩合并
 }
 
 // StateManager handles game session storage and retrieval
@@ -168,6 +190,14 @@ func (sm *StateManager) GetPlayerSessions(playerID string) (map[string]*GameSess
 	return sessionsCopy, nil
 }
 
+// isCurrent 判断会话是否仍是内存中的当前会话（重置或删除后旧会话不应再写回数据库）
+func (sm *StateManager) isCurrent(session *GameSession) bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	return sm.sessions[session.PlayerID][session.ModID] == session
+}
+
 // SaveSession saves or updates a player's session
 func (sm *StateManager) SaveSession(session *GameSession) error {
 	sm.mu.Lock()
@@ -200,6 +230,9 @@ func (sm *StateManager) CreateSession(playerID, modID string, initialState map[s
 		DisplayHistory:   []string{},
 		LastModified:     time.Now(),
 	}
+
+	// 会话开始时生成服务器种子并公开其哈希
+	session.ensureRollSeed()
 	
 	// Ensure player's sessions map exists
 	if sm.sessions[playerID] == nil {
@@ -289,6 +322,21 @@ func (sm *StateManager) loadFromDB(playerID, modID string) (*GameSession, error)
 		}
 	}
 
+	// 旧存档只有摘要字符串，作为故事梗概载入
+	var memory *SessionMemory
+	if gameSave.Memory != "" {
+		memory = &SessionMemory{}
+		if err := json.Unmarshal([]byte(gameSave.Memory), memory); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal memory: %w", err)
+		}
+		// 管理后台直接修改过摘要文本时，以修改后的文本作为梗概，事实账本保留
+		if gameSave.CompressedSummary != memory.Text() {
+			memory.foldIntoArc(gameSave.CompressedSummary, memory.nextChapterIndex()-1)
+		}
+	} else if gameSave.CompressedSummary != "" {
+		memory = legacyMemory(gameSave.CompressedSummary)
+	}
+
 	var displayHistory []string
 	if gameSave.DisplayHistory != "" {
 		if err := json.Unmarshal([]byte(gameSave.DisplayHistory), &displayHistory); err != nil {
@@ -312,8 +360,26 @@ func (sm *StateManager) loadFromDB(playerID, modID string) (*GameSession, error)
 		RecentHistory:    recentHistory,
 		CompressedSummary: gameSave.CompressedSummary,
 		CompressionRound: gameSave.CompressionRound,
+		CompressedThrough: gameSave.CompressedThrough,
+		Memory:           memory,
 		DisplayHistory:   displayHistory,
 		LastModified:     gameSave.UpdatedAt,
+		DebugSession:     gameSave.DebugSession,
+		RollSeed:         gameSave.RollSeed,
+		RollSeedHash:     gameSave.RollSeedHash,
+		RollSequence:     gameSave.RollSequence,
+	}
+	session.normalizeHistory()
+
+	// 旧存档没有服务器种子，载入时生成并写回，保证第一次判定前哈希已经公开
+	if session.RollSeed == "" {
+		session.ensureRollSeed()
+		if err := config.DB.Model(&gameSave).Select("RollSeed", "RollSeedHash", "RollSequence").Updates(models.GameSave{
+			RollSeed:     session.RollSeed,
+			RollSeedHash: session.RollSeedHash,
+		}).Error; err != nil {
+			fmt.Printf("Warning: failed to save roll seed: %v\n", err)
+		}
 	}
 	
 	return session, nil
@@ -331,9 +397,9 @@ func (sm *StateManager) saveToDB(session *GameSession) error {
 		return fmt.Errorf("failed to marshal state: %w", err)
 	}
 
-	recentHistoryJSON, err := json.Marshal(session.RecentHistory)
+	history, err := session.historyRecord()
 	if err != nil {
-		return fmt.Errorf("failed to marshal recent history: %w", err)
+		return err
 	}
 
 	displayHistoryJSON, err := json.Marshal(session.DisplayHistory)
@@ -357,11 +423,16 @@ func (sm *StateManager) saveToDB(session *GameSession) error {
 		ModID:             session.ModID,
 		SessionDate:       session.SessionDate,
 		State:             string(stateJSON),
-		RecentHistory:     string(recentHistoryJSON),
-		CompressedSummary: session.CompressedSummary,
-		CompressionRound:  session.CompressionRound,
+		RecentHistory:     history.RecentHistory,
+		CompressedSummary: history.CompressedSummary,
+		CompressionRound:  history.CompressionRound,
+		CompressedThrough: history.CompressedThrough,
+		Memory:            history.Memory,
 		DisplayHistory:    string(displayHistoryJSON),
 		EntityRegistry:    entityRegistryJSON, // 添加实体注册表
+		RollSeed:          session.RollSeed,
+		RollSeedHash:      session.RollSeedHash,
+		RollSequence:      session.RollSequence,
 	}
 
 	// Use ON CONFLICT (upsert) to ensure only one record per user_id + mod_id
@@ -388,6 +459,161 @@ func (sm *StateManager) SaveToFile() error {
 	return nil
 }
 
+// SetDebugSession 开启或关闭存档的沙盒调试标志（同时更新内存和数据库）
+func (sm *StateManager) SetDebugSession(playerID, modID string, enabled bool) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if playerSessions, exists := sm.sessions[playerID]; exists {
+		if session, exists := playerSessions[modID]; exists {
+			session.DebugSession = enabled
+		}
+	}
+
+	userID, err := strconv.ParseUint(playerID, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid player ID: %w", err)
+	}
+
+	return config.DB.Model(&models.GameSave{}).
+		Where("user_id = ? AND mod_id = ?", userID, modID).
+		Update("debug_session", enabled).Error
+}
+
+// historyRecord 在同一快照中读取历史和摘要，保证写入的存档中两者一致
+func (s *GameSession) historyRecord() (*models.GameSave, error) {
+	s.historyMu.Lock()
+	recentHistoryJSON, err := json.Marshal(s.RecentHistory)
+	record := &models.GameSave{
+		CompressedSummary: s.CompressedSummary,
+		CompressionRound:  s.CompressionRound,
+		CompressedThrough: s.CompressedThrough,
+	}
+	memory := s.Memory
+	s.historyMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal recent history: %w", err)
+	}
+	record.RecentHistory = string(recentHistoryJSON)
+
+	if memory != nil {
+		data, err := json.Marshal(memory)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal memory: %w", err)
+		}
+		record.Memory = string(data)
+	}
+	return record, nil
+}
+
+// SaveHistory 只把历史和记忆写回存档。后台压缩任务使用，不读取可能正在被回合修改的状态
+func (sm *StateManager) SaveHistory(session *GameSession) error {
+	userID, err := strconv.ParseUint(session.PlayerID, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid player ID: %w", err)
+	}
+
+	record, err := session.historyRecord()
+	if err != nil {
+		return err
+	}
+
+	return config.DB.Model(&models.GameSave{}).
+		Where("user_id = ? AND mod_id = ?", userID, session.ModID).
+		Select("recent_history", "compressed_summary", "compression_round", "compressed_through", "memory").
+		Updates(record).Error
+}
+
+// SaveEntityRegistry 只把实体注册表写回存档，不影响可能正在进行的回合状态
+func (sm *StateManager) SaveEntityRegistry(playerID, modID string) error {
+	userID, err := strconv.ParseUint(playerID, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid player ID: %w", err)
+	}
+
+	data, err := sm.entityManager.SerializeRegistry(playerID, modID)
+	if err != nil {
+		return err
+	}
+
+	return config.DB.Model(&models.GameSave{}).
+		Where("user_id = ? AND mod_id = ?", userID, modID).
+		Update("entity_registry", data).Error
+}
+
+// CleanupEntities 清理所有存档中超过 keepDays 天未更新的实体，返回删除的实体总数
+// 已载入内存的注册表直接清理，其余存档在数据库中的副本上清理
+func (sm *StateManager) CleanupEntities(keepDays int) (int, error) {
+	var saves []models.GameSave
+	if err := config.DB.Select("id", "user_id", "mod_id", "entity_registry").
+		Where("entity_registry <> ''").
+		Find(&saves).Error; err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -keepDays)
+	total := 0
+	for _, save := range saves {
+		playerID := strconv.FormatUint(uint64(save.UserID), 10)
+
+		var deleted int
+		var data string
+		if sm.entityManager.hasRegistry(playerID, save.ModID) {
+			deleted = sm.entityManager.CleanupOldEntities(playerID, save.ModID, keepDays)
+			if deleted == 0 {
+				continue
+			}
+			serialized, err := sm.entityManager.SerializeRegistry(playerID, save.ModID)
+			if err != nil {
+				return total, err
+			}
+			data = serialized
+		} else {
+			var registry EntityRegistry
+			if err := json.Unmarshal([]byte(save.EntityRegistry), &registry); err != nil {
+				fmt.Printf("Warning: skipped entity registry of save %d: %v\n", save.ID, err)
+				continue
+			}
+			deleted = registry.cleanupBefore(cutoff)
+			if deleted == 0 {
+				continue
+			}
+			serialized, err := json.Marshal(&registry)
+			if err != nil {
+				return total, err
+			}
+			data = string(serialized)
+		}
+
+		if err := config.DB.Model(&models.GameSave{}).
+			Where("id = ?", save.ID).
+			Update("entity_registry", data).Error; err != nil {
+			return total, err
+		}
+		total += deleted
+	}
+	return total, nil
+}
+
+// StartEntityCleanup 启动定时实体清理任务
+func (sm *StateManager) StartEntityCleanup(interval time.Duration, keepDays int) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			deleted, err := sm.CleanupEntities(keepDays)
+			if err != nil {
+				fmt.Printf("Entity cleanup error: %v\n", err)
+				continue
+			}
+			if deleted > 0 {
+				fmt.Printf("[StateManager] 清理了 %d 个超过 %d 天未更新的实体\n", deleted, keepDays)
+			}
+		}
+	}()
+}
+
 // GetEntityManager returns the entity manager instance
 func (sm *StateManager) GetEntityManager() *EntityManager {
 	return sm.entityManager
@@ -468,6 +694,26 @@ func setNestedValue(m map[string]interface{}, path string, value interface{}) er
 	return nil
 }
 
+// getNestedValue reads a value from a nested map using dot notation
+func getNestedValue(m map[string]interface{}, path string) (interface{}, bool) {
+	keys := splitPath(path)
+	if len(keys) == 0 {
+		return nil, false
+	}
+
+	current := m
+	for i := 0; i < len(keys)-1; i++ {
+		next, ok := current[keys[i]].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+
+	value, exists := current[keys[len(keys)-1]]
+	return value, exists
+}
+
 // splitPath splits a dot-notation path into keys
 func splitPath(path string) []string {
 	var keys []string
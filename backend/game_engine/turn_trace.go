@@ -0,0 +1,361 @@
+package game_engine
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"AIGE/services"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// traceRetentionConfigKey 回合追踪保留天数，0 表示不记录
+	traceRetentionConfigKey   = "turn_trace_retention_days"
+	defaultTraceRetentionDays = 7
+	tracePruneInterval        = time.Hour
+)
+
+// TurnTrace 一个回合的完整追踪记录，用于离线回放排查问题
+type TurnTrace struct {
+	PlayerID     string                   `json:"player_id"`
+	ModID        string                   `json:"mod_id"`
+	Action       string                   `json:"action"`
+	Modifiers    []string                 `json:"modifiers,omitempty"`
+	RollSeedHash string                   `json:"roll_seed_hash"`
+	RollSeed     string                   `json:"roll_seed,omitempty"` // 只在种子揭示后下载时填入，未揭示的种子可推算此后的全部判定
+	RollSequence int64                    `json:"roll_sequence"`
+	StateBefore  map[string]interface{}   `json:"state_before"`
+	StateAfter   map[string]interface{}   `json:"state_after"`
+	Stages       []*TraceStage            `json:"stages"`
+	Rolls        []map[string]interface{} `json:"rolls,omitempty"`
+	Error        string                   `json:"error,omitempty"`
+	StartedAt    time.Time                `json:"started_at"`
+	FinishedAt   time.Time                `json:"finished_at"`
+}
+
+// TraceStage 一次AI调用（包括重试）
+type TraceStage struct {
+	Stage       string                 `json:"stage"` // first 或 second
+	Provider    string                 `json:"provider"`
+	BaseURL     string                 `json:"base_url"`
+	Model       string                 `json:"model"`
+	Messages    []services.Message     `json:"messages"`
+	RawResponse string                 `json:"raw_response"`
+	Parsed      map[string]interface{} `json:"parsed,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	StartedAt   time.Time              `json:"started_at"`
+}
+
+// beginTrace 在AI调用前开始追踪本回合（动作修饰器已生效）
+func (gc *GameController) beginTrace(session *GameSession, action string, modifiers []string) {
+	if gc.traceRetentionDays() <= 0 {
+		session.trace = nil
+		return
+	}
+	session.ensureRollSeed()
+	session.trace = &TurnTrace{
+		PlayerID:     session.PlayerID,
+		ModID:        session.ModID,
+		Action:       action,
+		Modifiers:    modifiers,
+		RollSeedHash: session.RollSeedHash,
+		RollSequence: session.RollSequence,
+		StateBefore:  copyState(session.State),
+		StartedAt:    time.Now(),
+	}
+}
+
+// finishTrace 结束追踪并写入数据库
+func (gc *GameController) finishTrace(session *GameSession, err error) {
+	trace := session.trace
+	session.trace = nil
+	if trace == nil || config.DB == nil {
+		return
+	}
+
+	trace.StateAfter = copyState(session.State)
+	trace.FinishedAt = time.Now()
+	if err != nil {
+		trace.Error = err.Error()
+	}
+
+	data, marshalErr := json.Marshal(trace)
+	if marshalErr != nil {
+		fmt.Printf("[回合追踪] 序列化失败: %v\n", marshalErr)
+		return
+	}
+
+	userID, _ := strconv.ParseUint(session.PlayerID, 10, 32)
+	record := models.TurnTrace{
+		UserID: uint(userID),
+		ModID:  session.ModID,
+		Action: trace.Action,
+		Error:  trace.Error,
+		Data:   string(data),
+	}
+	if err := config.DB.Create(&record).Error; err != nil {
+		fmt.Printf("[回合追踪] 保存失败: %v\n", err)
+	}
+
+	gc.pruneTraces()
+}
+
+// FillRevealedSeed 种子已揭示时填入追踪记录的种子以便回放，未揭示时清空（兼容旧版记录中保存的种子）
+func (t *TurnTrace) FillRevealedSeed() {
+	if t.RollSeedHash == "" && t.RollSeed != "" {
+		t.RollSeedHash = HashRollSeed(t.RollSeed)
+	}
+	t.RollSeed = ""
+	if t.RollSeedHash == "" || config.DB == nil {
+		return
+	}
+	var seed models.RollSeed
+	if err := config.DB.Where("seed_hash = ? AND revealed = ?", t.RollSeedHash, true).First(&seed).Error; err == nil {
+		t.RollSeed = seed.Seed
+	}
+}
+
+// traceRetentionDays 读取回合追踪保留天数
+func (gc *GameController) traceRetentionDays() int {
+	if config.DB == nil {
+		return 0
+	}
+	var cfg models.SystemConfig
+	if err := config.DB.Where("key = ?", traceRetentionConfigKey).First(&cfg).Error; err != nil {
+		return defaultTraceRetentionDays
+	}
+	days, err := strconv.Atoi(strings.TrimSpace(cfg.Value))
+	if err != nil {
+		return defaultTraceRetentionDays
+	}
+	return days
+}
+
+// pruneTraces 定期清理超出保留期的追踪记录
+func (gc *GameController) pruneTraces() {
+	gc.traceMutex.Lock()
+	if time.Since(gc.lastTracePrune) < tracePruneInterval {
+		gc.traceMutex.Unlock()
+		return
+	}
+	gc.lastTracePrune = time.Now()
+	gc.traceMutex.Unlock()
+
+	days := gc.traceRetentionDays()
+	if days <= 0 {
+		days = defaultTraceRetentionDays
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+	result := config.DB.Where("created_at < ?", cutoff).Delete(&models.TurnTrace{})
+	if result.Error != nil {
+		fmt.Printf("[回合追踪] 清理失败: %v\n", result.Error)
+	} else if result.RowsAffected > 0 {
+		fmt.Printf("[回合追踪] 已清理 %d 条过期记录\n", result.RowsAffected)
+	}
+}
+
+// beginStage 记录一次AI调用，追踪未开启时返回nil
+func (t *TurnTrace) beginStage(stage string, provider AIProvider, messages []services.Message) *TraceStage {
+	if t == nil {
+		return nil
+	}
+	s := &TraceStage{
+		Stage:     stage,
+		Provider:  provider.APIType,
+		BaseURL:   provider.BaseURL,
+		Model:     provider.ModelID,
+		Messages:  append([]services.Message(nil), messages...),
+		StartedAt: time.Now(),
+	}
+	t.Stages = append(t.Stages, s)
+	return s
+}
+
+// stageError 将错误记录到指定阶段最近一次尚未记录错误的AI调用上
+func (t *TurnTrace) stageError(stage string, err error) {
+	if t == nil || err == nil || len(t.Stages) == 0 {
+		return
+	}
+	if last := t.Stages[len(t.Stages)-1]; last.Stage == stage && last.Error == "" {
+		last.Error = err.Error()
+	}
+}
+
+func (t *TurnTrace) addRoll(rollResult map[string]interface{}) {
+	if t == nil {
+		return
+	}
+	t.Rolls = append(t.Rolls, rollResult)
+}
+
+func (s *TraceStage) setResponse(raw string, parsed map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	s.RawResponse = raw
+	s.Parsed = parsed
+}
+
+// copyState 通过JSON深拷贝状态
+func copyState(state map[string]interface{}) map[string]interface{} {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil
+	}
+	var copied map[string]interface{}
+	json.Unmarshal(data, &copied)
+	return copied
+}
+
+// ReplayResult 回放结果
+type ReplayResult struct {
+	State      map[string]interface{}   `json:"state"`
+	Rolls      []map[string]interface{} `json:"rolls"`
+	StateDiffs []string                 `json:"state_diffs"`
+	RollDiffs  []string                 `json:"roll_diffs"`
+}
+
+// Matches 回放结果是否与记录一致
+func (r *ReplayResult) Matches() bool {
+	return len(r.StateDiffs) == 0 && len(r.RollDiffs) == 0
+}
+
+// ReplayTrace 使用记录的AI响应离线重放回合：按原顺序重新解析响应、执行判定和状态更新，不调用AI
+func ReplayTrace(trace *TurnTrace, mod *GameMod) (*ReplayResult, error) {
+	if trace.StateBefore == nil {
+		return nil, fmt.Errorf("trace has no initial state")
+	}
+
+	if trace.RollSeed == "" && len(trace.Rolls) > 0 {
+		return nil, fmt.Errorf("trace seed has not been revealed, rolls cannot be replayed")
+	}
+	if trace.RollSeed != "" && trace.RollSeedHash != "" && HashRollSeed(trace.RollSeed) != trace.RollSeedHash {
+		return nil, fmt.Errorf("trace seed does not match roll_seed_hash")
+	}
+
+	gc := &GameController{}
+	session := &GameSession{
+		PlayerID:     trace.PlayerID,
+		ModID:        trace.ModID,
+		State:        copyState(trace.StateBefore),
+		RollSeed:     trace.RollSeed,
+		RollSeedHash: trace.RollSeedHash,
+		RollSequence: trace.RollSequence,
+	}
+	session.trace = &TurnTrace{}
+
+	// 与线上流程一致：一阶段解析失败则进入下一次一阶段重试；
+	// 一阶段含判定时，依次尝试二阶段响应，全部失败则回到一阶段重试
+	awaitingSecond := false
+	for _, stage := range trace.Stages {
+		parsed, ok := replayParse(stage.RawResponse)
+		// 记录了错误的调用（如违反一致性规则被要求修正）没有提交状态
+		ok = ok && stage.Error == ""
+
+		switch stage.Stage {
+		case "first":
+			awaitingSecond = false
+			if !ok {
+				continue
+			}
+			if rollRequest, hasRoll := parsed["roll_request"].(map[string]interface{}); hasRoll {
+				gc.executeRoll(rollRequest, mod, session, trace.Action)
+				awaitingSecond = true
+				continue
+			}
+			gc.applyResponseState(session, parsed, mod)
+		case "second":
+			if !awaitingSecond || !ok {
+				continue
+			}
+			gc.applyResponseState(session, parsed, mod)
+			awaitingSecond = false
+		}
+	}
+	session.State["is_processing"] = false
+
+	result := &ReplayResult{
+		State: session.State,
+		Rolls: session.trace.Rolls,
+	}
+	if trace.StateAfter != nil {
+		result.StateDiffs = diffStates(trace.StateAfter, session.State)
+	}
+	result.RollDiffs = diffRolls(trace.Rolls, session.trace.Rolls)
+	return result, nil
+}
+
+func replayParse(raw string) (map[string]interface{}, bool) {
+	jsonStr := extractJSON(raw)
+	if jsonStr == "" {
+		return nil, false
+	}
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(jsonStr), &parsed); err != nil {
+		return nil, false
+	}
+	return parsed, true
+}
+
+// diffStates 比较两个状态，返回不一致的路径
+func diffStates(expected, actual map[string]interface{}) []string {
+	want := flattenState(copyState(expected), "")
+	got := flattenState(copyState(actual), "")
+
+	var diffs []string
+	for path, value := range want {
+		if other, ok := got[path]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: 记录为 %v，回放缺失", path, value))
+		} else if !reflect.DeepEqual(value, other) {
+			diffs = append(diffs, fmt.Sprintf("%s: 记录为 %v，回放为 %v", path, value, other))
+		}
+	}
+	for path, value := range got {
+		if _, ok := want[path]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: 记录缺失，回放为 %v", path, value))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+func flattenState(state map[string]interface{}, prefix string) map[string]interface{} {
+	flat := make(map[string]interface{})
+	for key, value := range state {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			for k, v := range flattenState(nested, path) {
+				flat[k] = v
+			}
+			continue
+		}
+		flat[path] = value
+	}
+	return flat
+}
+
+// diffRolls 比较记录的判定与回放的判定
+func diffRolls(expected, actual []map[string]interface{}) []string {
+	var diffs []string
+	if len(expected) != len(actual) {
+		diffs = append(diffs, fmt.Sprintf("判定次数：记录为 %d，回放为 %d", len(expected), len(actual)))
+	}
+	for i := 0; i < len(expected) && i < len(actual); i++ {
+		for _, key := range []string{"result", "outcome"} {
+			want := fmt.Sprint(expected[i][key])
+			got := fmt.Sprint(actual[i][key])
+			if want != got {
+				diffs = append(diffs, fmt.Sprintf("第%d次判定 %s：记录为 %s，回放为 %s", i+1, key, want, got))
+			}
+		}
+	}
+	return diffs
+}
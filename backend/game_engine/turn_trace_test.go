@@ -0,0 +1,73 @@
+package game_engine
+
+import (
+	"testing"
+)
+
+func TestReplayTrace(t *testing.T) {
+	mod := &GameMod{}
+	mod.Config.GameConfig.RollSettings = RollSettings{CriticalSuccessThreshold: 0.05, CriticalFailureThreshold: 0.96, DefaultSides: 100}
+
+	first := `$你尝试突破$ @{"roll_request": {"type": "境界突破判定", "target": 50}}@`
+	second := `$突破完成$ @{"state_update": {"current_life.修为": "一转中阶", "current_life.寿命": 80}}@`
+
+	// 模拟线上流程生成追踪记录
+	gc := &GameController{}
+	session := &GameSession{PlayerID: "1", ModID: "test", State: map[string]interface{}{
+		"current_life":  map[string]interface{}{"修为": "一转初阶", "寿命": 100.0},
+		"is_processing": true,
+	}}
+	session.SeedRolls("trace-seed")
+	trace := &TurnTrace{
+		PlayerID:     "1",
+		ModID:        "test",
+		Action:       "突破",
+		RollSeedHash: session.RollSeedHash,
+		RollSeed:     session.RollSeed,
+		StateBefore:  copyState(session.State),
+		Stages: []*TraceStage{
+			{Stage: "first", RawResponse: "无效响应"},
+			{Stage: "first", RawResponse: first},
+			{Stage: "second", RawResponse: second},
+		},
+	}
+	session.trace = trace
+	parsed, _ := replayParse(first)
+	gc.executeRoll(parsed["roll_request"].(map[string]interface{}), mod, session, "突破")
+	parsed, _ = replayParse(second)
+	gc.applyResponseState(session, parsed, mod)
+	session.State["is_processing"] = false
+	trace.StateAfter = copyState(session.State)
+	trace.Rolls = copyRolls(trace.Rolls)
+
+	result, err := ReplayTrace(trace, mod)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if !result.Matches() {
+		t.Errorf("Expected replay to match, got diffs: %v %v", result.RollDiffs, result.StateDiffs)
+	}
+
+	trace.StateAfter["current_life"].(map[string]interface{})["寿命"] = 1.0
+	result, _ = ReplayTrace(trace, mod)
+	if len(result.StateDiffs) != 1 {
+		t.Errorf("Expected one state diff, got %v", result.StateDiffs)
+	}
+
+	// 种子未揭示时下载的追踪记录不含种子，不能复算判定
+	trace.FillRevealedSeed()
+	if trace.RollSeed != "" {
+		t.Errorf("Expected unrevealed seed to be stripped from trace")
+	}
+	if _, err := ReplayTrace(trace, mod); err == nil {
+		t.Errorf("Expected replay without revealed seed to fail")
+	}
+}
+
+func copyRolls(rolls []map[string]interface{}) []map[string]interface{} {
+	var copied []map[string]interface{}
+	for _, roll := range rolls {
+		copied = append(copied, copyState(roll))
+	}
+	return copied
+}
@@ -14,6 +14,11 @@ import (
 )
 
 func main() {
+	// 加载JWT签名密钥，生产模式下密钥不安全时拒绝启动
+	if err := utils.InitJWTKeys(); err != nil {
+		log.Fatal("JWT密钥配置错误: ", err)
+	}
+
 	// 初始化数据库
 	config.InitDB()
 
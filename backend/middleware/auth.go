@@ -1,65 +1,94 @@
 package middleware
 
 import (
+	"AIGE/config"
+	"AIGE/models"
 	"AIGE/utils"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 尝试从Authorization header获取token
-		authHeader := c.GetHeader("Authorization")
-		var tokenString string
-		
-		if authHeader != "" {
-			tokenString = strings.Replace(authHeader, "Bearer ", "", 1)
-		} else {
-			// 如果header中没有token，尝试从query参数获取（用于WebSocket）
-			tokenString = c.Query("token")
-		}
-		
+		// 只从Authorization header获取token，WebSocket使用一次性票据（见 WSTicketMiddleware）
+		tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+
 		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "需要认证"})
 			c.Abort()
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return utils.JWTSecret, nil
-		})
-
-		if err != nil || !token.Valid {
+		// 校验签名和过期时间，并以数据库中的用户状态为准（角色变更、删除立即生效）
+		user, err := utils.ValidateAccessToken(tokenString)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的token"})
 			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
+		c.Set("user", user)
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("is_admin", user.IsAdmin)
+		c.Next()
+	}
+}
+
+// WSTicketMiddleware WebSocket连接认证：浏览器无法为WebSocket设置header，
+// 客户端先通过 POST /api/game/ws-ticket 获取一次性票据，再以 ?ticket= 连接，避免访问令牌出现在代理日志中
+func WSTicketMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := utils.ConsumeWSTicket(c.Query("ticket"))
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的token claims"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效或已过期的连接票据"})
+			c.Abort()
+			return
+		}
+
+		var user models.User
+		if err := config.DB.First(&user, userID).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
 			c.Abort()
 			return
 		}
 
-		c.Set("user_id", uint(claims["user_id"].(float64)))
-		c.Set("username", claims["username"].(string))
-		c.Set("is_admin", claims["is_admin"].(bool))
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("is_admin", user.IsAdmin)
 		c.Next()
 	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+// RequirePermission 要求当前用户的角色拥有指定权限，需在 AuthMiddleware 之后使用
+func RequirePermission(perm string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		isAdmin, exists := c.Get("is_admin")
-		if !exists || !isAdmin.(bool) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "需要管理员权限"})
+		perms, err := contextPermissions(c)
+		if err != nil || !models.HasPermission(perms, perm) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "没有权限执行此操作", "permission": perm})
 			c.Abort()
 			return
 		}
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// contextPermissions 读取当前用户的权限，同一请求内只查询一次
+func contextPermissions(c *gin.Context) (map[string]bool, error) {
+	if perms, ok := c.Get("permissions"); ok {
+		return perms.(map[string]bool), nil
+	}
+	value, ok := c.Get("user")
+	if !ok {
+		return nil, fmt.Errorf("未认证")
+	}
+	perms, err := models.UserPermissions(value.(*models.User))
+	if err != nil {
+		return nil, err
+	}
+	c.Set("permissions", perms)
+	return perms, nil
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	config.DB = db
+	models.AutoMigrate()
+}
+
+func createUser(t *testing.T, username string, isAdmin bool, roles ...string) *models.User {
+	user := &models.User{Username: username, Email: username + "@example.com", IsAdmin: isAdmin}
+	if err := config.DB.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if len(roles) > 0 {
+		var assigned []models.Role
+		config.DB.Where("name IN ?", roles).Find(&assigned)
+		if err := models.SetUserRoles(user.ID, assigned); err != nil {
+			t.Fatalf("Failed to set roles: %v", err)
+		}
+		config.DB.First(user, user.ID)
+	}
+	return user
+}
+
+// requestAs 以指定用户访问要求 perm 权限的路由，返回状态码
+func requestAs(user *models.User, perm string) int {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/admin", func(c *gin.Context) {
+		c.Set("user", user)
+		c.Set("user_id", user.ID)
+	}, RequirePermission(perm), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	return w.Code
+}
+
+func TestRequirePermission(t *testing.T) {
+	setupTestDB(t)
+	moderator := createUser(t, "moderator", false, models.RoleModerator)
+	player := createUser(t, "player", false, models.RolePlayer)
+	admin := createUser(t, "admin", true, models.RoleAdmin)
+
+	cases := []struct {
+		name string
+		user *models.User
+		perm string
+		want int
+	}{
+		{"角色拥有权限", moderator, models.PermChatsRead, http.StatusOK},
+		{"角色没有权限", moderator, models.PermRolesManage, http.StatusForbidden},
+		{"玩家没有后台权限", player, models.PermUsersRead, http.StatusForbidden},
+		{"管理员拥有全部权限", admin, models.PermConfigManage, http.StatusOK},
+	}
+	for _, tc := range cases {
+		if got := requestAs(tc.user, tc.perm); got != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestRequirePermissionFallsBackToIsAdmin(t *testing.T) {
+	setupTestDB(t)
+	// 没有分配过角色的旧用户按 IsAdmin 视为 admin 或 player
+	legacyAdmin := createUser(t, "legacy-admin", true)
+	legacyPlayer := createUser(t, "legacy-player", false)
+
+	if got := requestAs(legacyAdmin, models.PermRolesManage); got != http.StatusOK {
+		t.Errorf("Expected legacy admin to be allowed, got %d", got)
+	}
+	if got := requestAs(legacyPlayer, models.PermUsersRead); got != http.StatusForbidden {
+		t.Errorf("Expected legacy player to be denied, got %d", got)
+	}
+}
@@ -2,8 +2,48 @@ package models
 
 import (
 	"AIGE/config"
+	"log"
 )
 
 func AutoMigrate() {
-	config.DB.AutoMigrate(&User{}, &Provider{}, &Model{}, &GameSave{}, &SystemConfig{})
-}
\ No newline at end of file
+	config.DB.AutoMigrate(&User{}, &RefreshToken{}, &UserIdentity{}, &OAuthState{}, &AccountToken{}, &Role{}, &UserRole{}, &Provider{}, &Model{}, &GameSave{}, &SystemConfig{}, &ActionModifierLog{}, &RollLog{}, &RollSeed{}, &TurnTrace{}, &AuditEvent{})
+	migrateLegacyOAuthIdentities()
+	seedBuiltInRoles()
+}
+
+// migrateLegacyOAuthIdentities 将旧版 users.oauth_provider/oauth_id 迁移到 user_identities
+func migrateLegacyOAuthIdentities() {
+	if !config.DB.Migrator().HasColumn("users", "oauth_id") {
+		return
+	}
+
+	var legacy []struct {
+		ID            uint
+		Username      string
+		Email         string
+		Avatar        string
+		OAuthProvider string `gorm:"column:oauth_provider"`
+		OAuthID       string `gorm:"column:oauth_id"`
+	}
+	config.DB.Table("users").
+		Select("id, username, email, avatar, oauth_provider, oauth_id").
+		Where("oauth_provider <> '' AND oauth_id <> '' AND deleted_at IS NULL").
+		Scan(&legacy)
+
+	for _, u := range legacy {
+		identity := UserIdentity{
+			UserID:   u.ID,
+			Provider: u.OAuthProvider,
+			Subject:  u.OAuthID,
+			Username: u.Username,
+			Email:    u.Email,
+			Avatar:   u.Avatar,
+		}
+		if err := config.DB.Where("provider = ? AND subject = ?", u.OAuthProvider, u.OAuthID).FirstOrCreate(&identity).Error; err != nil {
+			log.Printf("迁移用户 %d 的OAuth身份失败: %v\n", u.ID, err)
+			continue
+		}
+		// 清空旧字段，避免重复迁移
+		config.DB.Table("users").Where("id = ?", u.ID).Updates(map[string]interface{}{"oauth_provider": "", "oauth_id": nil})
+	}
+}
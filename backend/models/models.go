@@ -1,6 +1,8 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -11,21 +13,74 @@ type User struct {
 	Username      string         `json:"username" gorm:"uniqueIndex;not null"`
 	Password      string         `json:"-"`
 	Email         string         `json:"email"`
+	EmailVerified bool           `json:"email_verified" gorm:"default:false"`
 	IsAdmin       bool           `json:"is_admin" gorm:"default:false"`
-	OAuthProvider string         `json:"oauth_provider" gorm:"column:oauth_provider;index"`
-	OAuthID       string         `json:"oauth_id" gorm:"column:oauth_id;uniqueIndex"`
+	CanUseCheats  bool           `json:"can_use_cheats" gorm:"default:false"`
 	Avatar        string         `json:"avatar"`
+	TokenVersion  int            `json:"-" gorm:"default:0"` // 递增后该用户已签发的访问令牌全部失效
 	CreatedAt     time.Time      `json:"created_at"`
 	UpdatedAt     time.Time      `json:"updated_at"`
 	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// RefreshToken 服务端保存的刷新令牌，只存哈希值。每次刷新都会轮换，
+// 同一次登录产生的令牌属于同一个Family，已轮换的令牌被再次使用时整个Family失效
+type RefreshToken struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"not null;index"`
+	TokenHash  string     `json:"-" gorm:"not null;uniqueIndex"`
+	FamilyID   string     `json:"family_id" gorm:"not null;index"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy uint       `json:"replaced_by"` // 轮换后的新令牌ID
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// UserIdentity 用户关联的第三方登录身份，一个用户可以关联多个提供商
+type UserIdentity struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null;index"`
+	Provider  string    `json:"provider" gorm:"not null;uniqueIndex:idx_identity_subject"`
+	Subject   string    `json:"-" gorm:"not null;uniqueIndex:idx_identity_subject"` // 提供商侧的用户ID
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Avatar    string    `json:"avatar"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AccountToken 重置密码、验证邮箱等邮件链接中签名令牌的使用记录，令牌只能使用一次
+type AccountToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	Purpose   string     `json:"purpose" gorm:"not null;index"`
+	TokenID   string     `json:"-" gorm:"not null;uniqueIndex"` // 令牌中的jti
+	Email     string     `json:"email"`                         // 验证邮箱时为待验证的新邮箱
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// OAuthState 授权请求的state及PKCE校验码，回调时一次性消费
+type OAuthState struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	State        string    `json:"-" gorm:"not null;uniqueIndex"`
+	Provider     string    `json:"provider" gorm:"not null"`
+	CodeVerifier string    `json:"-" gorm:"not null"`
+	LinkUserID   uint      `json:"link_user_id"` // 非0表示为已登录用户关联身份
+	ExpiresAt    time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
 
 type Provider struct {
 	ID            uint           `json:"id" gorm:"primaryKey"`
 	Name          string         `json:"name" gorm:"not null"`
 	Type          string         `json:"type" gorm:"not null;index"`
-	APIKey        string         `json:"api_key" gorm:"not null"`
+	APIKey        string         `json:"-" gorm:"not null;serializer:secret"` // 加密存储，只能通过专用接口写入
+	APIKeyMasked  string         `json:"api_key_masked" gorm:"-"`
 	BaseURL       string         `json:"base_url"`
 	Enabled       bool           `json:"enabled" gorm:"default:true"`
 	AllowCustomURL bool          `json:"allow_custom_url" gorm:"default:true"`
@@ -44,6 +99,7 @@ type Model struct {
 	Enabled      bool           `json:"enabled" gorm:"default:true"`
 	APIType      string         `json:"api_type"`
 	Capabilities string         `json:"capabilities" gorm:"type:text"`
+	GenerationParams GenerationParams `json:"generation_params" gorm:"type:text;serializer:json"` // 默认生成参数
 	LastTested   *time.Time     `json:"last_tested"`
 	TestStatus   string         `json:"test_status" gorm:"default:'untested'"`
 	CreatedAt    time.Time      `json:"created_at"`
@@ -51,6 +107,65 @@ type Model struct {
 	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// GenerationParams 模型生成参数，未设置的字段使用提供商默认值
+type GenerationParams struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	MaxOutputTokens int      `json:"max_output_tokens,omitempty"`
+	ContextWindow   int      `json:"context_window,omitempty"` // 上下文窗口，用于限制输出长度不超过剩余空间
+	StopSequences   []string `json:"stop_sequences,omitempty"`
+	ReasoningEffort string   `json:"reasoning_effort,omitempty"` // 推理强度：low / medium / high
+	ThinkingBudget  int      `json:"thinking_budget,omitempty"`  // 思考token预算，0表示不开启
+}
+
+// Validate 检查参数范围
+func (p GenerationParams) Validate() error {
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return fmt.Errorf("temperature必须在0-2之间")
+	}
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		return fmt.Errorf("top_p必须在0-1之间")
+	}
+	if p.MaxOutputTokens < 0 || p.ContextWindow < 0 || p.ThinkingBudget < 0 {
+		return fmt.Errorf("token数量不能为负数")
+	}
+	if p.ContextWindow > 0 && p.MaxOutputTokens > p.ContextWindow {
+		return fmt.Errorf("max_output_tokens不能超过context_window")
+	}
+	switch p.ReasoningEffort {
+	case "", "low", "medium", "high":
+	default:
+		return fmt.Errorf("reasoning_effort只能是low、medium或high")
+	}
+	return nil
+}
+
+// Merge 用override中已设置的字段覆盖当前参数
+func (p GenerationParams) Merge(override GenerationParams) GenerationParams {
+	if override.Temperature != nil {
+		p.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		p.TopP = override.TopP
+	}
+	if override.MaxOutputTokens > 0 {
+		p.MaxOutputTokens = override.MaxOutputTokens
+	}
+	if override.ContextWindow > 0 {
+		p.ContextWindow = override.ContextWindow
+	}
+	if len(override.StopSequences) > 0 {
+		p.StopSequences = override.StopSequences
+	}
+	if override.ReasoningEffort != "" {
+		p.ReasoningEffort = override.ReasoningEffort
+	}
+	if override.ThinkingBudget > 0 {
+		p.ThinkingBudget = override.ThinkingBudget
+	}
+	return p
+}
+
 type GameSave struct {
 	ID               uint           `json:"id" gorm:"primaryKey"`
 	UserID           uint           `json:"user_id" gorm:"not null;uniqueIndex:idx_user_mod"`
@@ -60,13 +175,98 @@ type GameSave struct {
 	RecentHistory    string         `json:"recent_history" gorm:"type:text"`
 	CompressedSummary string        `json:"compressed_summary" gorm:"type:text"`
 	CompressionRound int            `json:"compression_round" gorm:"default:0"`
+	CompressedThrough int64         `json:"-" gorm:"default:0"`                 // 已并入摘要的最大消息序号
+	Memory           string         `json:"memory" gorm:"type:text"`            // 分层记忆JSON（章节摘要、故事梗概、事实账本）
 	DisplayHistory   string         `json:"display_history" gorm:"type:text"`
 	EntityRegistry   string         `json:"entity_registry" gorm:"type:text"`  // 新增：实体注册表
+	DebugSession     bool           `json:"debug_session" gorm:"default:false"` // 沙盒调试存档
+	RollSeed         string         `json:"-"`                                   // 判定服务器种子
+	RollSeedHash     string         `json:"roll_seed_hash"`                      // 服务器种子的公开哈希
+	RollSequence     int64          `json:"-"`                                   // 已进行的判定次数
 	CreatedAt        time.Time      `json:"created_at"`
 	UpdatedAt        time.Time      `json:"updated_at"`
 	DeletedAt        gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// ActionModifierLog 记录需要权限的动作修饰器的使用与拒绝情况
+type ActionModifierLog struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	UserID     uint      `json:"user_id" gorm:"index"`
+	ModID      string    `json:"mod_id" gorm:"index"`
+	ModifierID string    `json:"modifier_id" gorm:"index"`
+	Action     string    `json:"action" gorm:"type:text"`
+	Allowed    bool      `json:"allowed"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// RollLog 判定记录，配合 RollSeed 可复算验证每次投骰
+type RollLog struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	UserID     uint      `json:"user_id" gorm:"index"`
+	ModID      string    `json:"mod_id" gorm:"index"`
+	Sequence   int64     `json:"sequence"`
+	SeedHash   string    `json:"seed_hash" gorm:"index"`
+	Action     string    `json:"action" gorm:"type:text"`
+	RollType   string    `json:"roll_type"`
+	Expression string    `json:"expression"`
+	Target     float64   `json:"target"`
+	Result     int       `json:"result"`
+	Outcome    string    `json:"outcome" gorm:"index"`
+	Forced     bool      `json:"forced"`
+	Draws      string    `json:"draws" gorm:"type:text"`
+	Breakdown  string    `json:"breakdown" gorm:"type:text"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// RollSeed 判定服务器种子，揭示前只公开哈希
+type RollSeed struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"index"`
+	ModID      string     `json:"mod_id" gorm:"index"`
+	SeedHash   string     `json:"seed_hash" gorm:"uniqueIndex"`
+	Seed       string     `json:"-"`
+	Revealed   bool       `json:"revealed"`
+	RevealedAt *time.Time `json:"revealed_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TurnTrace 回合追踪记录，Data 为完整的追踪JSON，可用于离线回放
+type TurnTrace struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	ModID     string    `json:"mod_id" gorm:"index"`
+	Action    string    `json:"action" gorm:"type:text"`
+	Error     string    `json:"error" gorm:"type:text"`
+	Data      string    `json:"-" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// AuditEvent 审计日志，只允许追加，不能修改或删除
+// Diff 为 {"字段": {"before": 旧值, "after": 新值}} 形式的JSON，敏感字段只记录掩码
+type AuditEvent struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ActorID    uint      `json:"actor_id" gorm:"index"`
+	ActorName  string    `json:"actor_name"`
+	Action     string    `json:"action" gorm:"index"`
+	TargetType string    `json:"target_type" gorm:"index:idx_audit_target"`
+	TargetID   string    `json:"target_id" gorm:"index:idx_audit_target"`
+	Diff       string    `json:"diff" gorm:"type:text"`
+	Detail     string    `json:"detail" gorm:"type:text"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
+
+var errAuditAppendOnly = errors.New("审计日志只允许追加")
+
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return errAuditAppendOnly
+}
+
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return errAuditAppendOnly
+}
+
 type SystemConfig struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
 	Key       string    `json:"key" gorm:"uniqueIndex;not null"`
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"AIGE/config"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 权限，管理后台的每组路由要求其中一个权限
+const (
+	PermUsersRead       = "users.read"       // 查看用户列表
+	PermUsersManage     = "users.manage"     // 重置密码、删除用户、作弊权限、存档调试模式
+	PermRolesManage     = "roles.manage"     // 管理角色和分配角色
+	PermProvidersManage = "providers.manage" // 管理AI提供商（可接触API密钥）
+	PermModelsManage    = "models.manage"    // 管理模型及生成参数
+	PermAIUse           = "ai.use"           // 在后台直接调用模型（操练场）
+	PermConfigManage    = "config.manage"    // 系统配置和OAuth配置
+	PermGameManage      = "game.manage"      // 重载MOD、游戏模型配置、动作修饰器开关
+	PermGameAudit       = "game.audit"       // 查看判定记录、回合追踪和修饰器日志
+	PermChatsRead       = "chats.read"       // 查看玩家存档与对话
+	PermChatsManage     = "chats.manage"     // 修改、删除玩家存档
+	PermAuditRead       = "audit.read"       // 查看审计日志
+
+	// PermAll 拥有全部权限
+	PermAll = "*"
+)
+
+// Permissions 全部权限及说明，按展示顺序排列
+var Permissions = []struct {
+	Key         string `json:"key"`
+	Description string `json:"description"`
+}{
+	{PermUsersRead, "查看用户"},
+	{PermUsersManage, "管理用户"},
+	{PermRolesManage, "管理角色"},
+	{PermProvidersManage, "管理AI提供商"},
+	{PermModelsManage, "管理模型"},
+	{PermAIUse, "使用操练场"},
+	{PermConfigManage, "系统与OAuth配置"},
+	{PermGameManage, "游戏配置"},
+	{PermGameAudit, "游戏审计日志"},
+	{PermChatsRead, "查看聊天记录"},
+	{PermChatsManage, "管理聊天记录"},
+	{PermAuditRead, "查看审计日志"},
+}
+
+// 内置角色，不能删除；admin 的权限固定为全部权限
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleModAuthor = "mod-author"
+	RolePlayer    = "player"
+)
+
+type Role struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Name        string    `json:"name" gorm:"not null;uniqueIndex"`
+	DisplayName string    `json:"display_name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions" gorm:"type:text;serializer:json"`
+	BuiltIn     bool      `json:"built_in" gorm:"default:false"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// UserRole 用户与角色的多对多关系
+type UserRole struct {
+	UserID    uint      `json:"user_id" gorm:"primaryKey"`
+	RoleID    uint      `json:"role_id" gorm:"primaryKey;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var builtInRoles = []Role{
+	{Name: RoleAdmin, DisplayName: "管理员", Description: "拥有全部权限", Permissions: []string{PermAll}},
+	{Name: RoleModerator, DisplayName: "版主", Description: "查看用户和聊天记录",
+		Permissions: []string{PermUsersRead, PermChatsRead, PermGameAudit}},
+	{Name: RoleModAuthor, DisplayName: "MOD作者", Description: "调试MOD和游戏配置",
+		Permissions: []string{PermGameManage, PermGameAudit, PermAIUse}},
+	{Name: RolePlayer, DisplayName: "玩家", Description: "普通玩家，没有后台权限", Permissions: []string{}},
+}
+
+// IsValidPermission 是否为已定义的权限，全部权限（*）只属于内置的 admin 角色
+func IsValidPermission(perm string) bool {
+	for _, p := range Permissions {
+		if p.Key == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// seedBuiltInRoles 创建缺失的内置角色，admin 的权限始终重置为全部权限
+func seedBuiltInRoles() {
+	for _, role := range builtInRoles {
+		role.BuiltIn = true
+		var existing Role
+		if err := config.DB.Where("name = ?", role.Name).First(&existing).Error; err != nil {
+			config.DB.Create(&role)
+			continue
+		}
+		if role.Name == RoleAdmin {
+			config.DB.Model(&existing).Select("Permissions", "BuiltIn").Updates(&role)
+		}
+	}
+}
+
+// UserRoles 返回用户的角色。未分配过角色的用户（旧数据或脚本创建）按 IsAdmin 视为 admin 或 player
+func UserRoles(user *User) ([]Role, error) {
+	var roles []Role
+	err := config.DB.Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", user.ID).Order("roles.id").Find(&roles).Error
+	if err != nil || len(roles) > 0 {
+		return roles, err
+	}
+
+	name := RolePlayer
+	if user.IsAdmin {
+		name = RoleAdmin
+	}
+	err = config.DB.Where("name = ?", name).Find(&roles).Error
+	return roles, err
+}
+
+// UserPermissions 合并用户所有角色的权限
+func UserPermissions(user *User) (map[string]bool, error) {
+	roles, err := UserRoles(user)
+	if err != nil {
+		return nil, err
+	}
+	perms := make(map[string]bool)
+	for _, role := range roles {
+		for _, perm := range role.Permissions {
+			perms[perm] = true
+		}
+	}
+	return perms, nil
+}
+
+// HasPermission 判断权限集合是否包含指定权限
+func HasPermission(perms map[string]bool, perm string) bool {
+	return perms[PermAll] || perms[perm]
+}
+
+// CanGrant 判断拥有 perms 的用户能否授予 granted 中的权限：只能授予自己拥有的权限，
+// 全部权限和角色管理权限只有拥有全部权限的用户才能授予，避免角色管理员提升自己的权限
+func CanGrant(perms map[string]bool, granted []string) bool {
+	if perms[PermAll] {
+		return true
+	}
+	for _, perm := range granted {
+		if perm == PermAll || perm == PermRolesManage || !perms[perm] {
+			return false
+		}
+	}
+	return true
+}
+
+// CoversPermissions 判断 perms 是否包含 target 的全部权限，用于限制对权限更高的用户的操作
+func CoversPermissions(perms, target map[string]bool) bool {
+	if perms[PermAll] {
+		return true
+	}
+	for perm, granted := range target {
+		if granted && !perms[perm] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetUserRoles 替换用户的角色，并同步 IsAdmin 以兼容仍按管理员判断的逻辑
+func SetUserRoles(userID uint, roles []Role) error {
+	return config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+
+		isAdmin := false
+		for _, role := range roles {
+			if err := tx.Create(&UserRole{UserID: userID, RoleID: role.ID}).Error; err != nil {
+				return err
+			}
+			for _, perm := range role.Permissions {
+				if perm == PermAll {
+					isAdmin = true
+				}
+			}
+		}
+
+		return tx.Model(&User{}).Where("id = ?", userID).Update("is_admin", isAdmin).Error
+	})
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"AIGE/config"
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// SecretConfigPattern 需要加密存储的系统配置项（各OAuth提供商的客户端密钥）
+const SecretConfigPattern = "oauth_%_client_secret"
+
+func init() {
+	schema.RegisterSerializer("secret", SecretSerializer{})
+}
+
+// SecretSerializer 写入时用主密钥加密，读取时解密，未加密的旧数据按明文读取
+type SecretSerializer struct{}
+
+func (SecretSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch value := dbValue.(type) {
+	case string:
+		stored = value
+	case []byte:
+		stored = string(value)
+	case nil:
+	default:
+		return fmt.Errorf("unsupported secret value type: %T", dbValue)
+	}
+
+	plaintext, err := config.DecryptSecret(stored)
+	if err != nil {
+		return err
+	}
+	return field.Set(ctx, dst, plaintext)
+}
+
+func (SecretSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, _ := fieldValue.(string)
+	return config.EncryptSecret(plaintext)
+}
+
+// AfterFind 填充掩码后的密钥用于展示
+func (p *Provider) AfterFind(tx *gorm.DB) error {
+	p.APIKeyMasked = config.MaskSecret(p.APIKey)
+	return nil
+}
+
+// AfterSave 填充掩码后的密钥用于展示
+func (p *Provider) AfterSave(tx *gorm.DB) error {
+	p.APIKeyMasked = config.MaskSecret(p.APIKey)
+	return nil
+}
+
+// RekeySecrets 用当前主密钥重新加密所有密钥（包括明文旧数据和旧主密钥加密的数据），返回需要更新的条数
+func RekeySecrets(dryRun bool) (int, error) {
+	type row struct {
+		ID    uint
+		Value string
+	}
+	columns := []struct {
+		table   string
+		column  string
+		keyLike string // 仅对system_configs按配置项过滤
+	}{
+		{table: "providers", column: "api_key"},
+		{table: "system_configs", column: "value", keyLike: SecretConfigPattern},
+	}
+
+	updated := 0
+	for _, c := range columns {
+		query := config.DB.Table(c.table).Select("id, " + c.column + " AS value")
+		if c.keyLike != "" {
+			query = query.Where("key LIKE ?", c.keyLike)
+		}
+		var rows []row
+		if err := query.Scan(&rows).Error; err != nil {
+			return updated, err
+		}
+
+		for _, r := range rows {
+			needsRekey, err := config.SecretNeedsRekey(r.Value)
+			if err != nil {
+				return updated, err
+			}
+			if !needsRekey {
+				continue
+			}
+			plaintext, err := config.DecryptSecret(r.Value)
+			if err != nil {
+				return updated, fmt.Errorf("%s #%d: %w", c.table, r.ID, err)
+			}
+			encrypted, err := config.EncryptSecret(plaintext)
+			if err != nil {
+				return updated, err
+			}
+
+			if !dryRun {
+				// 按表名更新，绕过序列化器避免重复加密
+				if err := config.DB.Table(c.table).Where("id = ?", r.ID).UpdateColumn(c.column, encrypted).Error; err != nil {
+					return updated, err
+				}
+			}
+			updated++
+		}
+	}
+	return updated, nil
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"AIGE/controllers"
 	"AIGE/middleware"
+	"AIGE/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -21,81 +22,163 @@ func SetupRoutes(r *gin.Engine) {
 	{
 		auth.POST("/register", controllers.Register)
 		auth.POST("/login", controllers.Login)
+		auth.POST("/refresh", controllers.RefreshToken)
+		auth.POST("/logout", controllers.Logout)
+		auth.POST("/forgot-password", controllers.ForgotPassword)
+		auth.POST("/reset-password", controllers.ResetPassword)
+		auth.POST("/verify-email", controllers.VerifyEmail)
 		
-		auth.GET("/oauth/linux-do", controllers.LinuxDoLogin)
-		auth.GET("/oauth/linux-do/callback", controllers.LinuxDoCallback)
+		auth.GET("/oauth/providers", controllers.GetOAuthProviders)
+		auth.GET("/oauth/:provider", controllers.OAuthLogin)
+		auth.GET("/oauth/:provider/callback", controllers.OAuthCallback)
 	}
 
+	// WebSocket使用一次性票据认证
+	r.GET("/api/game/ws", middleware.WSTicketMiddleware(), controllers.GameWebSocket)
+
 	// 需要认证的路由
 	api := r.Group("/api")
 	api.Use(middleware.AuthMiddleware())
 	{
 		// 用户相关
 		api.GET("/profile", controllers.GetProfile)
+		api.DELETE("/profile", controllers.DeleteAccount)
+		api.PUT("/profile/password", controllers.ChangePassword)
+		api.PUT("/profile/email", controllers.ChangeEmail)
+		api.POST("/profile/email/verification", controllers.ResendVerification)
+		api.GET("/profile/identities", controllers.GetIdentities)
+		api.DELETE("/profile/identities/:id", controllers.UnlinkIdentity)
+		api.POST("/profile/identities/:provider/link", controllers.LinkOAuthIdentity)
 		
 		// 游戏相关
 		api.GET("/game/mods", controllers.GetAvailableMods)
 		api.POST("/game/init", controllers.InitializeGame)
-		api.GET("/game/ws", controllers.GameWebSocket)
+		api.POST("/game/ws-ticket", controllers.IssueWSTicket)
 		api.GET("/game/state", controllers.GetGameState)
+		api.GET("/game/quests", controllers.GetQuests)
 		api.DELETE("/game/reset", controllers.ResetGame)
 		api.POST("/game/save", controllers.ManualSaveGame)
 		api.POST("/game/restart-opportunities", controllers.RestartOpportunities)
+		api.GET("/game/rolls", controllers.GetRollLog)
+		api.POST("/game/rolls/reveal", controllers.RevealRollSeed)
+		api.GET("/game/rolls/:id/verify", controllers.VerifyRoll)
+		api.GET("/game/entities", controllers.GetMyEntities)
+		api.GET("/game/entities/:id", controllers.GetMyEntity)
+		api.PUT("/game/entities/:id/lock", controllers.SetMyEntityLocked)
 	}
 
-	// 管理员路由
+	// 管理后台路由，每组路由按角色权限控制访问
 	admin := api.Group("/admin")
-	admin.Use(middleware.AdminMiddleware())
+
+	users := admin.Group("", middleware.RequirePermission(models.PermUsersRead))
 	{
-		admin.GET("/users", controllers.GetUsers)
-		admin.GET("/users/:id", controllers.GetUser)
-		admin.PUT("/users/:id/password", controllers.UpdateUserPassword)
-		admin.DELETE("/users/:id", controllers.DeleteUser)
-		admin.PUT("/users/:id/toggle-admin", controllers.ToggleUserAdmin)
-
-		admin.GET("/providers", controllers.GetProviders)
-		admin.GET("/providers/:id", controllers.GetProvider)
-		admin.POST("/providers", controllers.CreateProvider)
-		admin.PUT("/providers/:id", controllers.UpdateProvider)
-		admin.DELETE("/providers/:id", controllers.DeleteProvider)
-		admin.PUT("/providers/:id/toggle", controllers.ToggleProvider)
-		admin.GET("/providers/:id/models/available", controllers.GetAvailableModels)
-		admin.GET("/providers/:id/test", controllers.TestConnection)
-
-		admin.GET("/models", controllers.GetModels)
-		admin.GET("/models/:id", controllers.GetModel)
-		admin.POST("/models", controllers.CreateModel)
-		admin.PUT("/models/:id", controllers.UpdateModel)
-		admin.DELETE("/models/:id", controllers.DeleteModel)
-		admin.PUT("/models/:id/toggle", controllers.ToggleModel)
-		admin.POST("/models/:id/test", controllers.TestModel)
-		admin.PUT("/models/:id/capabilities", controllers.UpdateModelCapabilities)
-
-		admin.POST("/ai/chat", controllers.ChatWithAI)
-		admin.POST("/ai/test", controllers.TestModelConnection)
-
-		// 系统配置
-		admin.GET("/config", controllers.GetAllSystemConfigs)
-		admin.GET("/config/:key", controllers.GetSystemConfig)
-		admin.POST("/config", controllers.SetSystemConfig)
-		admin.POST("/config/batch", controllers.BatchSetSystemConfig)
-		
-		// 游戏配置管理
-		admin.POST("/game/reload-config", controllers.ReloadGameConfig)
-		admin.GET("/game/model-config", controllers.GetGameModelConfig)
-		admin.POST("/game/model-config", controllers.SaveGameModelConfig)
-
-		// OAuth 配置管理
-		admin.GET("/oauth/config", controllers.GetOAuthConfig)
-		admin.POST("/oauth/config", controllers.SaveOAuthConfig)
-
-		// 聊天记录管理
-		admin.GET("/chats", controllers.GetAllChats)
-		admin.GET("/chats/:id", controllers.GetChat)
-		admin.PUT("/chats/:id", controllers.UpdateChat)
-		admin.DELETE("/chats/:id", controllers.DeleteChat)
-		admin.DELETE("/chats/user/:user_id", controllers.DeleteUserChats)
-		admin.GET("/chats/stats", controllers.GetChatStats)
-		admin.GET("/chats/export", controllers.ExportUserChats)
+		users.GET("/users", controllers.GetUsers)
+		users.GET("/users/:id", controllers.GetUser)
+		users.GET("/roles", controllers.GetRoles)
+		users.GET("/permissions", controllers.GetPermissions)
+	}
+
+	userManage := admin.Group("/users", middleware.RequirePermission(models.PermUsersManage))
+	{
+		userManage.PUT("/:id/password", controllers.UpdateUserPassword)
+		userManage.DELETE("/:id", controllers.DeleteUser)
+		userManage.PUT("/:id/cheat-permission", controllers.SetUserCheatPermission)
+	}
+
+	// 调试模式会解锁作弊类修饰器，与作弊权限一样要求用户管理权限
+	admin.PUT("/chats/:id/debug-session", middleware.RequirePermission(models.PermUsersManage), controllers.SetChatDebugSession)
+
+	roles := admin.Group("", middleware.RequirePermission(models.PermRolesManage))
+	{
+		roles.POST("/roles", controllers.CreateRole)
+		roles.PUT("/roles/:id", controllers.UpdateRole)
+		roles.DELETE("/roles/:id", controllers.DeleteRole)
+		roles.PUT("/users/:id/roles", controllers.SetUserRoles)
+	}
+
+	providers := admin.Group("/providers", middleware.RequirePermission(models.PermProvidersManage))
+	{
+		providers.GET("", controllers.GetProviders)
+		providers.GET("/:id", controllers.GetProvider)
+		providers.POST("", controllers.CreateProvider)
+		providers.PUT("/:id", controllers.UpdateProvider)
+		providers.DELETE("/:id", controllers.DeleteProvider)
+		providers.PUT("/:id/toggle", controllers.ToggleProvider)
+		providers.PUT("/:id/api-key", controllers.UpdateProviderAPIKey)
+		providers.GET("/:id/models/available", controllers.GetAvailableModels)
+		providers.GET("/:id/test", controllers.TestConnection)
+	}
+
+	modelRoutes := admin.Group("/models", middleware.RequirePermission(models.PermModelsManage))
+	{
+		modelRoutes.GET("", controllers.GetModels)
+		modelRoutes.GET("/:id", controllers.GetModel)
+		modelRoutes.POST("", controllers.CreateModel)
+		modelRoutes.PUT("/:id", controllers.UpdateModel)
+		modelRoutes.DELETE("/:id", controllers.DeleteModel)
+		modelRoutes.PUT("/:id/toggle", controllers.ToggleModel)
+		modelRoutes.POST("/:id/test", controllers.TestModel)
+		modelRoutes.POST("/:id/capabilities/detect", controllers.DetectModelCapabilities)
+	}
+
+	ai := admin.Group("/ai", middleware.RequirePermission(models.PermAIUse))
+	{
+		ai.POST("/chat", controllers.ChatWithAI)
+		ai.POST("/test", controllers.TestModelConnection)
+	}
+
+	// 系统配置与OAuth配置
+	systemConfig := admin.Group("", middleware.RequirePermission(models.PermConfigManage))
+	{
+		systemConfig.GET("/config", controllers.GetAllSystemConfigs)
+		systemConfig.GET("/config/:key", controllers.GetSystemConfig)
+		systemConfig.POST("/config", controllers.SetSystemConfig)
+		systemConfig.POST("/config/batch", controllers.BatchSetSystemConfig)
+
+		systemConfig.GET("/oauth/providers", controllers.GetOAuthConfigs)
+		systemConfig.PUT("/oauth/providers/:provider", controllers.SaveOAuthConfig)
+	}
+
+	// 游戏配置管理
+	game := admin.Group("/game", middleware.RequirePermission(models.PermGameManage))
+	{
+		game.POST("/reload-config", controllers.ReloadGameConfig)
+		game.GET("/model-config", controllers.GetGameModelConfig)
+		game.POST("/model-config", controllers.SaveGameModelConfig)
+		game.GET("/action-modifiers", controllers.GetActionModifiers)
+		game.PUT("/action-modifiers", controllers.SetActionModifierEnabled)
+		game.PUT("/entities/:id", controllers.AdminUpdateEntity)
+		game.PUT("/entities/:id/lock", controllers.AdminSetEntityLocked)
+		game.DELETE("/entities/:id", controllers.AdminDeleteEntity)
+		game.POST("/entities/cleanup", controllers.CleanupEntities)
+	}
+
+	gameAudit := admin.Group("/game", middleware.RequirePermission(models.PermGameAudit))
+	{
+		gameAudit.GET("/action-modifiers/logs", controllers.GetActionModifierLogs)
+		gameAudit.GET("/rolls", controllers.GetAllRollLogs)
+		gameAudit.GET("/rolls/:id/verify", controllers.AdminVerifyRoll)
+		gameAudit.GET("/traces", controllers.GetTurnTraces)
+		gameAudit.GET("/traces/:id", controllers.GetTurnTrace)
+		gameAudit.GET("/entities", controllers.AdminGetEntities)
+		gameAudit.GET("/entities/:id", controllers.AdminGetEntity)
+	}
+
+	admin.GET("/audit", middleware.RequirePermission(models.PermAuditRead), controllers.GetAuditEvents)
+
+	// 聊天记录管理
+	chats := admin.Group("/chats", middleware.RequirePermission(models.PermChatsRead))
+	{
+		chats.GET("", controllers.GetAllChats)
+		chats.GET("/:id", controllers.GetChat)
+		chats.GET("/stats", controllers.GetChatStats)
+		chats.GET("/export", controllers.ExportUserChats)
+	}
+
+	chatManage := admin.Group("/chats", middleware.RequirePermission(models.PermChatsManage))
+	{
+		chatManage.PUT("/:id", controllers.UpdateChat)
+		chatManage.DELETE("/:id", controllers.DeleteChat)
+		chatManage.DELETE("/user/:user_id", controllers.DeleteUserChats)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,53 @@
+package routes
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"AIGE/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestModeratorCannotManageSaves(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	config.DB = db
+	models.AutoMigrate()
+
+	moderator := &models.User{Username: "moderator", Email: "moderator@example.com"}
+	if err := db.Create(moderator).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	var roles []models.Role
+	db.Where("name = ?", models.RoleModerator).Find(&roles)
+	if err := models.SetUserRoles(moderator.ID, roles); err != nil {
+		t.Fatalf("Failed to set roles: %v", err)
+	}
+	token, err := utils.GenerateJWT(moderator)
+	if err != nil {
+		t.Fatalf("GenerateJWT failed: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	SetupRoutes(r)
+
+	// 版主只能查看存档，开启调试模式会解锁作弊类修饰器
+	for _, path := range []string{"/api/admin/chats/1/debug-session", "/api/admin/chats/1"} {
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(`{"enabled":true}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected moderator to get 403 on %s, got %d", path, w.Code)
+		}
+	}
+}
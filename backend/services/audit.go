@@ -0,0 +1,116 @@
+package services
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// 审计日志写入数据库 audit_events 表；设置 AUDIT_LOG_FILE 时同时以JSONL格式追加写入该文件
+
+// 审计动作
+const (
+	AuditUserDelete      = "user.delete"
+	AuditUserPassword    = "user.password"
+	AuditUserCheatPerm   = "user.cheat_permission"
+	AuditUserRoles       = "user.roles"
+	AuditAccountPassword = "account.password"
+	AuditAccountEmail    = "account.email"
+	AuditAccountDelete   = "account.delete"
+	AuditRoleCreate      = "role.create"
+	AuditRoleUpdate      = "role.update"
+	AuditRoleDelete      = "role.delete"
+	AuditChatUpdate      = "chat.update"
+	AuditChatDebug       = "chat.debug_session"
+	AuditChatDelete      = "chat.delete"
+	AuditChatDeleteAll   = "chat.delete_all"
+	AuditProviderCreate  = "provider.create"
+	AuditProviderUpdate  = "provider.update"
+	AuditProviderAPIKey  = "provider.api_key"
+	AuditProviderToggle  = "provider.toggle"
+	AuditProviderDelete  = "provider.delete"
+	AuditConfigSet       = "config.set"
+	AuditOAuthConfig     = "oauth.config"
+	AuditModifierToggle  = "game.modifier.toggle"
+	AuditModifierUse     = "game.modifier.use"
+	AuditModifierDenied  = "game.modifier.denied"
+	AuditEntityUpdate    = "game.entity.update"
+	AuditEntityLock      = "game.entity.lock"
+	AuditEntityDelete    = "game.entity.delete"
+	AuditEntityCleanup   = "game.entity.cleanup"
+)
+
+// AuditChange 单个字段的变更
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditDiff 比较修改前后的字段，只保留发生变化的字段
+func AuditDiff(before, after map[string]interface{}) map[string]AuditChange {
+	changes := make(map[string]AuditChange)
+	for key, newValue := range after {
+		oldValue := before[key]
+		if !reflect.DeepEqual(oldValue, newValue) {
+			changes[key] = AuditChange{Before: oldValue, After: newValue}
+		}
+	}
+	for key, oldValue := range before {
+		if _, ok := after[key]; !ok {
+			changes[key] = AuditChange{Before: oldValue}
+		}
+	}
+	return changes
+}
+
+var auditFileMu sync.Mutex
+
+// RecordAudit 追加一条审计事件，写入失败只输出日志，不影响业务操作
+func RecordAudit(event models.AuditEvent, changes map[string]AuditChange) {
+	if len(changes) > 0 {
+		if data, err := json.Marshal(changes); err == nil {
+			event.Diff = string(data)
+		}
+	}
+	if event.ActorName == "" && event.ActorID != 0 {
+		var user models.User
+		if err := config.DB.Unscoped().Select("username").First(&user, event.ActorID).Error; err == nil {
+			event.ActorName = user.Username
+		}
+	}
+	event.CreatedAt = time.Now()
+
+	if err := config.DB.Create(&event).Error; err != nil {
+		fmt.Printf("[审计] 写入审计日志失败: %v\n", err)
+	}
+
+	if path := os.Getenv("AUDIT_LOG_FILE"); path != "" {
+		if err := appendAuditFile(path, &event); err != nil {
+			fmt.Printf("[审计] 写入审计文件失败: %v\n", err)
+		}
+	}
+}
+
+// appendAuditFile 以JSONL格式追加写入，每次写入都重新打开文件以兼容外部日志轮转
+func appendAuditFile(path string, event *models.AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	auditFileMu.Lock()
+	defer auditFileMu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
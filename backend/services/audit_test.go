@@ -0,0 +1,82 @@
+package services
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAuditDiff(t *testing.T) {
+	changes := AuditDiff(
+		map[string]interface{}{"name": "a", "enabled": true, "removed": 1},
+		map[string]interface{}{"name": "a", "enabled": false, "added": "x"},
+	)
+	if len(changes) != 3 {
+		t.Fatalf("Expected 3 changes, got %v", changes)
+	}
+	if changes["enabled"].Before != true || changes["enabled"].After != false {
+		t.Errorf("Unexpected enabled change: %+v", changes["enabled"])
+	}
+	if changes["removed"].After != nil || changes["added"].Before != nil {
+		t.Errorf("Unexpected added/removed changes: %+v", changes)
+	}
+}
+
+func TestRecordAuditAppendOnlyWithFileSink(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	config.DB = db
+	models.AutoMigrate()
+
+	admin := models.User{Username: "root", Email: "root@example.com"}
+	db.Create(&admin)
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Setenv("AUDIT_LOG_FILE", path)
+
+	RecordAudit(models.AuditEvent{ActorID: admin.ID, Action: AuditUserDelete, TargetType: "user", TargetID: "7", IP: "10.0.0.1"},
+		AuditDiff(map[string]interface{}{"username": "bob"}, nil))
+	RecordAudit(models.AuditEvent{ActorID: admin.ID, Action: AuditChatDelete, TargetType: "game_save", TargetID: "3"}, nil)
+
+	var event models.AuditEvent
+	if err := db.Where("action = ?", AuditUserDelete).First(&event).Error; err != nil {
+		t.Fatalf("Expected audit event in database: %v", err)
+	}
+	if event.ActorName != "root" || event.Diff != `{"username":{"before":"bob","after":null}}` {
+		t.Errorf("Unexpected audit event: %+v", event)
+	}
+
+	if err := db.Model(&event).Update("action", "tampered").Error; err == nil {
+		t.Errorf("Expected update of audit event to fail")
+	}
+	if err := db.Delete(&event).Error; err == nil {
+		t.Errorf("Expected delete of audit event to fail")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected audit file: %v", err)
+	}
+	defer f.Close()
+	var lines []models.AuditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line models.AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("Invalid JSONL line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 || lines[0].Action != AuditUserDelete || lines[1].Action != AuditChatDelete {
+		t.Errorf("Unexpected audit file content: %+v", lines)
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ModelCapabilities 模型能力，序列化后保存在 models.Model.Capabilities
+type ModelCapabilities struct {
+	Streaming     bool      `json:"streaming"`
+	JSONMode      bool      `json:"json_mode"`
+	ToolUse       bool      `json:"tool_use"`
+	ContextLength int       `json:"context_length,omitempty"`
+	Detected      bool      `json:"detected"` // false 表示按提供商类型给出的默认值
+	DetectedAt    time.Time `json:"detected_at"`
+}
+
+// String 序列化为JSON字符串
+func (c *ModelCapabilities) String() string {
+	data, _ := json.Marshal(c)
+	return string(data)
+}
+
+// DetectCapabilities 检测模型能力：本地服务通过模型信息和探测请求检测，云端服务使用已知能力
+func (mf *ModelFetcher) DetectCapabilities(apiType, baseURL, apiKey, modelID string) (*ModelCapabilities, error) {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL(apiType)
+	}
+
+	var caps *ModelCapabilities
+	var err error
+
+	switch apiType {
+	case "openai":
+		caps = &ModelCapabilities{Streaming: true, JSONMode: true, ToolUse: true}
+	case "anthropic":
+		caps = &ModelCapabilities{Streaming: true, ToolUse: true, ContextLength: 200000}
+	case "google":
+		caps, err = mf.detectGoogleCapabilities(baseURL, apiKey, modelID)
+	case OllamaAPIType:
+		caps, err = mf.detectOllamaCapabilities(baseURL, apiKey, modelID)
+	case MockAPIType:
+		caps = &ModelCapabilities{Streaming: true, JSONMode: true, Detected: true}
+	default:
+		caps, err = mf.detectOpenAICompatibleCapabilities(baseURL, apiKey, modelID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	caps.DetectedAt = time.Now()
+	return caps, nil
+}
+
+func (mf *ModelFetcher) detectGoogleCapabilities(baseURL, apiKey, modelID string) (*ModelCapabilities, error) {
+	modelURL := fmt.Sprintf("%s/%s?key=%s", mf.buildGoogleModelsURL(baseURL), strings.TrimPrefix(modelID, "models/"), apiKey)
+
+	var info struct {
+		InputTokenLimit            int      `json:"inputTokenLimit"`
+		SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+	}
+	if err := mf.getJSON(modelURL, nil, &info); err != nil {
+		return nil, fmt.Errorf("Gemini Models %w", err)
+	}
+
+	caps := &ModelCapabilities{
+		JSONMode:      true,
+		ToolUse:       true,
+		ContextLength: info.InputTokenLimit,
+		Detected:      true,
+	}
+	for _, method := range info.SupportedGenerationMethods {
+		if method == "streamGenerateContent" {
+			caps.Streaming = true
+		}
+	}
+	return caps, nil
+}
+
+func (mf *ModelFetcher) detectOllamaCapabilities(baseURL, apiKey, modelID string) (*ModelCapabilities, error) {
+	body, err := postJSON(mf.client, buildOllamaURL(baseURL, "/api/show"), map[string]interface{}{"model": modelID}, ollamaHeaders(apiKey), "Ollama")
+	if err != nil {
+		return nil, err
+	}
+
+	var info struct {
+		Capabilities []string               `json:"capabilities"`
+		ModelInfo    map[string]interface{} `json:"model_info"`
+	}
+	if err := decodeJSON(body, &info); err != nil {
+		return nil, err
+	}
+
+	// Ollama 原生支持流式输出和 format: json
+	caps := &ModelCapabilities{Streaming: true, JSONMode: true, Detected: true}
+	for _, capability := range info.Capabilities {
+		if capability == "tools" {
+			caps.ToolUse = true
+		}
+	}
+	for key, value := range info.ModelInfo {
+		if strings.HasSuffix(key, ".context_length") {
+			if length, ok := value.(float64); ok {
+				caps.ContextLength = int(length)
+			}
+		}
+	}
+	return caps, nil
+}
+
+// detectOpenAICompatibleCapabilities 读取模型列表中的上下文长度，并用单token请求探测流式、JSON模式和工具调用
+func (mf *ModelFetcher) detectOpenAICompatibleCapabilities(baseURL, apiKey, modelID string) (*ModelCapabilities, error) {
+	caps := &ModelCapabilities{Detected: true}
+
+	if entries, err := mf.getOpenAIModelEntries(baseURL, apiKey); err == nil {
+		for _, entry := range entries {
+			if entry["id"] == modelID {
+				caps.ContextLength = contextLengthFromEntry(entry)
+				break
+			}
+		}
+	}
+
+	probe := func(extra map[string]interface{}) bool {
+		requestBody := map[string]interface{}{
+			"model":      modelID,
+			"messages":   []Message{{Role: "user", Content: "Reply with a JSON object."}},
+			"max_tokens": 1,
+		}
+		for key, value := range extra {
+			requestBody[key] = value
+		}
+		body, err := postJSON(mf.client, buildOpenAIURL(baseURL), requestBody, openAIHeaders(apiKey), "OpenAI")
+		if err != nil {
+			return false
+		}
+		io.Copy(io.Discard, body)
+		body.Close()
+		return true
+	}
+
+	// 基础请求失败说明服务或模型不可用，无需继续探测
+	if !probe(nil) {
+		return nil, fmt.Errorf("模型 %s 无法调用", modelID)
+	}
+	caps.Streaming = probe(map[string]interface{}{"stream": true})
+	caps.JSONMode = probe(map[string]interface{}{"response_format": map[string]interface{}{"type": "json_object"}})
+	caps.ToolUse = probe(map[string]interface{}{
+		"tools": []map[string]interface{}{{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        "ping",
+				"description": "connectivity check",
+				"parameters":  map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+			},
+		}},
+	})
+	return caps, nil
+}
+
+// contextLengthFromEntry 不同兼容服务在模型信息中使用的上下文长度字段
+// vLLM: max_model_len，LM Studio: max_context_length，llama.cpp: meta.n_ctx_train
+func contextLengthFromEntry(entry map[string]interface{}) int {
+	for _, key := range []string{"max_model_len", "context_length", "max_context_length"} {
+		if value, ok := entry[key].(float64); ok {
+			return int(value)
+		}
+	}
+	if meta, ok := entry["meta"].(map[string]interface{}); ok {
+		if value, ok := meta["n_ctx_train"].(float64); ok {
+			return int(value)
+		}
+	}
+	return 0
+}
+
+func (mf *ModelFetcher) getJSON(url string, headers map[string]string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := mf.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API error: %d %s, %s", resp.StatusCode, resp.Status, string(body))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ChatOptions 单次请求的生成参数，未设置的字段使用提供商默认值
+type ChatOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Seed        *int64   `json:"seed,omitempty"`
+	// ReasoningEffort 推理模型的推理强度（low / medium / high）
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	// ThinkingBudget 扩展思考的token预算，0表示不开启
+	ThinkingBudget int `json:"thinking_budget,omitempty"`
+}
+
+// ChatRequest 一次对话请求
+type ChatRequest struct {
+	APIType  string      `json:"api_type"`
+	BaseURL  string      `json:"base_url"`
+	APIKey   string      `json:"-"`
+	Model    string      `json:"model"`
+	Messages []Message   `json:"messages"`
+	Options  ChatOptions `json:"options"`
+}
+
+// ChatResponse 非流式响应
+type ChatResponse struct {
+	Content      string `json:"content"`
+	FinishReason string `json:"finishReason"`
+}
+
+// StreamEvent 流式响应中的一个增量
+type StreamEvent struct {
+	Content string `json:"content"`
+	Done    bool   `json:"done"`
+}
+
+// ChatStream 流式响应，Recv 在流结束后返回 io.EOF
+type ChatStream interface {
+	Recv() (StreamEvent, error)
+	Close() error
+}
+
+// LLMClient 游戏引擎依赖的AI调用接口，测试时可注入 MockClient
+type LLMClient interface {
+	Chat(req ChatRequest) (*ChatResponse, error)
+	ChatStream(req ChatRequest) (ChatStream, error)
+}
+
+// ProviderAdapter 提供商适配器，负责构造请求和解析响应。
+// 新的提供商通过 RegisterProvider 注册后即可被所有调用方使用。
+type ProviderAdapter interface {
+	DefaultBaseURL() string
+	Chat(client *http.Client, req ChatRequest) (*ChatResponse, error)
+	ChatStream(client *http.Client, req ChatRequest) (ChatStream, error)
+}
+
+// keylessAdapter 不需要API密钥的适配器可实现此接口
+type keylessAdapter interface {
+	RequiresAPIKey() bool
+}
+
+var (
+	adapters     = make(map[string]ProviderAdapter)
+	adapterMutex sync.RWMutex
+)
+
+// RegisterProvider 注册提供商适配器，同名注册会覆盖
+func RegisterProvider(apiType string, adapter ProviderAdapter) {
+	adapterMutex.Lock()
+	defer adapterMutex.Unlock()
+	adapters[apiType] = adapter
+}
+
+// GetProviderAdapter 获取提供商适配器
+func GetProviderAdapter(apiType string) (ProviderAdapter, bool) {
+	adapterMutex.RLock()
+	defer adapterMutex.RUnlock()
+	adapter, exists := adapters[apiType]
+	return adapter, exists
+}
+
+// IsSupportedAPIType 是否为已注册的API类型
+func IsSupportedAPIType(apiType string) bool {
+	_, exists := GetProviderAdapter(apiType)
+	return exists
+}
+
+// RequiresAPIKey 该API类型是否需要API密钥
+func RequiresAPIKey(apiType string) bool {
+	if adapter, exists := GetProviderAdapter(apiType); exists {
+		if keyless, ok := adapter.(keylessAdapter); ok {
+			return keyless.RequiresAPIKey()
+		}
+	}
+	return true
+}
+
+// DefaultBaseURL 返回API类型的默认地址，未注册的类型返回空字符串
+func DefaultBaseURL(apiType string) string {
+	if adapter, exists := GetProviderAdapter(apiType); exists {
+		return adapter.DefaultBaseURL()
+	}
+	return ""
+}
+
+// Chat 通过注册的适配器发送非流式请求
+func (ai *AIClient) Chat(req ChatRequest) (*ChatResponse, error) {
+	adapter, exists := GetProviderAdapter(req.APIType)
+	if !exists {
+		return nil, fmt.Errorf("unsupported API type: %s", req.APIType)
+	}
+	return adapter.Chat(ai.client, req)
+}
+
+// ChatStream 通过注册的适配器发送流式请求
+func (ai *AIClient) ChatStream(req ChatRequest) (ChatStream, error) {
+	adapter, exists := GetProviderAdapter(req.APIType)
+	if !exists {
+		return nil, fmt.Errorf("unsupported API type: %s", req.APIType)
+	}
+	return adapter.ChatStream(ai.client, req)
+}
+
+// postJSON 发送JSON请求，非200状态码时读取响应体作为错误信息
+func postJSON(client *http.Client, apiURL string, body interface{}, headers map[string]string, name string) (io.ReadCloser, error) {
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s API error: %d %s", name, resp.StatusCode, string(respBody))
+	}
+
+	return resp.Body, nil
+}
+
+// decodeJSON 读取并关闭响应体
+func decodeJSON(body io.ReadCloser, v interface{}) error {
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
+// sseStream 按行读取SSE数据，由适配器提供的解析函数转换为 StreamEvent
+type sseStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	parse   func(data string) *StreamEvent
+	done    bool
+}
+
+func newSSEStream(body io.ReadCloser, parse func(data string) *StreamEvent) *sseStream {
+	scanner := bufio.NewScanner(body)
+	buf := make([]byte, 0, 128*1024)
+	scanner.Buffer(buf, 2*1024*1024) // 2MB最大token大小
+	return &sseStream{body: body, scanner: scanner, parse: parse}
+}
+
+func (s *sseStream) Recv() (StreamEvent, error) {
+	if s.done {
+		return StreamEvent{}, io.EOF
+	}
+	for s.scanner.Scan() {
+		line := strings.TrimSpace(s.scanner.Text())
+		if line == "" {
+			continue
+		}
+		event := s.parse(line)
+		if event == nil {
+			continue
+		}
+		if event.Done {
+			s.done = true
+		}
+		return *event, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return StreamEvent{}, err
+	}
+	s.done = true
+	return StreamEvent{}, io.EOF
+}
+
+func (s *sseStream) Close() error {
+	return s.body.Close()
+}
+
+// sseData 去掉SSE行的 "data: " 前缀
+func sseData(line string) string {
+	return strings.TrimPrefix(strings.TrimSpace(line), "data: ")
+}
+
+func (o ChatOptions) temperature(defaultValue float64) float64 {
+	if o.Temperature != nil {
+		return *o.Temperature
+	}
+	return defaultValue
+}
+
+func (o ChatOptions) maxTokens(defaultValue int) int {
+	if o.MaxTokens > 0 {
+		return o.MaxTokens
+	}
+	return defaultValue
+}
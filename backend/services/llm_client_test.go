@@ -0,0 +1,138 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAIAdapterOptionsAndStream(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"你好"},"finish_reason":null}]}`)
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"世界"},"finish_reason":null}]}`)
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{},"finish_reason":"stop"}]}`)
+		fmt.Fprintln(w, `data: [DONE]`)
+	}))
+	defer server.Close()
+
+	temperature, seed := 0.2, int64(42)
+	stream, err := NewAIClient().ChatStream(ChatRequest{
+		APIType:  "openai",
+		BaseURL:  server.URL,
+		APIKey:   "test",
+		Model:    "gpt-test",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+		Options:  ChatOptions{Temperature: &temperature, MaxTokens: 128, Stop: []string{"@"}, Seed: &seed},
+	})
+	if err != nil {
+		t.Fatalf("ChatStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	content := ""
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		content += event.Content
+	}
+
+	if content != "你好世界" {
+		t.Errorf("Expected streamed content '你好世界', got %q", content)
+	}
+	if body["temperature"] != 0.2 || body["max_tokens"] != 128.0 || body["seed"] != 42.0 || body["stream"] != true {
+		t.Errorf("Options not applied to request body: %v", body)
+	}
+	if _, hasTopP := body["top_p"]; hasTopP {
+		t.Errorf("Unset top_p should be omitted: %v", body)
+	}
+}
+
+type echoAdapter struct{}
+
+func (echoAdapter) DefaultBaseURL() string { return "" }
+
+func (echoAdapter) Chat(client *http.Client, req ChatRequest) (*ChatResponse, error) {
+	return &ChatResponse{Content: req.Messages[len(req.Messages)-1].Content, FinishReason: "stop"}, nil
+}
+
+func (echoAdapter) ChatStream(client *http.Client, req ChatRequest) (ChatStream, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func TestRegisterProvider(t *testing.T) {
+	if _, err := NewAIClient().Chat(ChatRequest{APIType: "echo"}); err == nil {
+		t.Fatalf("Expected error for unregistered API type")
+	}
+
+	RegisterProvider("echo", echoAdapter{})
+	resp, err := NewAIClient().Chat(ChatRequest{APIType: "echo", Messages: []Message{{Role: "user", Content: "回声"}}})
+	if err != nil || resp.Content != "回声" {
+		t.Errorf("Expected registered adapter to handle request, got %v, %v", resp, err)
+	}
+	if !IsSupportedAPIType("echo") || !RequiresAPIKey("echo") || RequiresAPIKey(MockAPIType) {
+		t.Errorf("Unexpected registry capabilities")
+	}
+}
+
+func TestOllamaAdapterAndCapabilities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/chat":
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"本地"},"done":false}`)
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"模型"},"done":false}`)
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`)
+		case "/api/tags":
+			fmt.Fprint(w, `{"models":[{"name":"qwen2.5:7b"},{"name":"llama3.1:8b"}]}`)
+		case "/api/show":
+			fmt.Fprint(w, `{"capabilities":["completion","tools"],"model_info":{"qwen2.context_length":32768}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	if RequiresAPIKey(OllamaAPIType) {
+		t.Errorf("Ollama should not require an API key")
+	}
+
+	stream, err := NewAIClient().ChatStream(ChatRequest{APIType: OllamaAPIType, BaseURL: server.URL, Model: "qwen2.5:7b"})
+	if err != nil {
+		t.Fatalf("ChatStream failed: %v", err)
+	}
+	content := ""
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		content += event.Content
+	}
+	stream.Close()
+	if content != "本地模型" {
+		t.Errorf("Expected '本地模型', got %q", content)
+	}
+
+	fetcher := NewModelFetcher()
+	models, err := fetcher.GetModels(OllamaAPIType, server.URL, "")
+	if err != nil || len(models) != 2 {
+		t.Errorf("Expected two local models, got %v, %v", models, err)
+	}
+
+	caps, err := fetcher.DetectCapabilities(OllamaAPIType, server.URL, "", "qwen2.5:7b")
+	if err != nil {
+		t.Fatalf("DetectCapabilities failed: %v", err)
+	}
+	if !caps.Streaming || !caps.ToolUse || caps.ContextLength != 32768 || !caps.Detected {
+		t.Errorf("Unexpected capabilities: %+v", caps)
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 邮件发送配置：
+//
+//	MAIL_DRIVER    smtp 或 log，默认 log
+//	SMTP_HOST / SMTP_PORT / SMTP_USERNAME / SMTP_PASSWORD / SMTP_FROM
+//	MAIL_LOG_DIR   log 模式下把邮件写入该目录，留空时只输出到日志
+//
+// 端口为465时使用隐式TLS，其他端口在服务器支持时自动STARTTLS。
+
+// Mail 待发送的邮件
+type Mail struct {
+	To      string
+	Subject string
+	Body    string // 纯文本
+}
+
+// Mailer 邮件发送接口
+type Mailer interface {
+	Send(mail Mail) error
+}
+
+// SMTPMailer 通过SMTP服务器发送
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(mail Mail) error {
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	message := buildMessage(m.From, mail)
+
+	if m.Port != 465 {
+		return smtp.SendMail(addr, auth, m.From, []string{mail.To}, message)
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: m.Host})
+	if err != nil {
+		return fmt.Errorf("连接SMTP服务器失败: %w", err)
+	}
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP认证失败: %w", err)
+		}
+	}
+	if err := client.Mail(m.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(mail.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// LogMailer 开发环境使用，不真正发送邮件
+type LogMailer struct {
+	Dir string // 非空时每封邮件写入一个 .eml 文件
+}
+
+func (m *LogMailer) Send(mail Mail) error {
+	if m.Dir == "" {
+		log.Printf("📧 [邮件] 收件人: %s 主题: %s\n%s\n", mail.To, mail.Subject, mail.Body)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000"), strings.ReplaceAll(mail.To, "@", "_at_"))
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, buildMessage("aige@localhost", mail), 0600); err != nil {
+		return err
+	}
+	log.Printf("📧 [邮件] 已写入 %s\n", path)
+	return nil
+}
+
+func buildMessage(from string, mail Mail) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + mail.To + "\r\n")
+	b.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", mail.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+var (
+	defaultMailer     Mailer
+	defaultMailerOnce sync.Once
+	defaultMailerMu   sync.RWMutex
+)
+
+// DefaultMailer 按环境变量创建的全局邮件发送器
+func DefaultMailer() Mailer {
+	defaultMailerOnce.Do(func() {
+		mailer := newMailerFromEnv()
+		defaultMailerMu.Lock()
+		if defaultMailer == nil {
+			defaultMailer = mailer
+		}
+		defaultMailerMu.Unlock()
+	})
+	defaultMailerMu.RLock()
+	defer defaultMailerMu.RUnlock()
+	return defaultMailer
+}
+
+// SetMailer 替换全局邮件发送器，用于测试或自定义实现
+func SetMailer(mailer Mailer) {
+	defaultMailerMu.Lock()
+	defer defaultMailerMu.Unlock()
+	defaultMailer = mailer
+}
+
+func newMailerFromEnv() Mailer {
+	if os.Getenv("MAIL_DRIVER") != "smtp" {
+		return &LogMailer{Dir: os.Getenv("MAIL_LOG_DIR")}
+	}
+
+	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil || port == 0 {
+		port = 587
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = os.Getenv("SMTP_USERNAME")
+	}
+	log.Printf("邮件发送使用SMTP服务器 %s:%d\n", os.Getenv("SMTP_HOST"), port)
+	return &SMTPMailer{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+}
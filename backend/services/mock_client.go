@@ -0,0 +1,344 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockAPIType 模拟提供商类型，用于离线开发和测试。
+// 作为Provider使用时，BaseURL 为fixture文件路径，留空则使用内置的回显响应。
+const MockAPIType = "mock"
+
+const defaultMockChunkSize = 16
+
+// MockResponse 一条脚本化响应
+type MockResponse struct {
+	Match     string `json:"match"`      // 最后一条用户消息包含该文本时使用，留空匹配任意消息
+	Content   string `json:"content"`    // 响应内容
+	Error     string `json:"error"`      // 非空时调用直接返回该错误
+	Malformed bool   `json:"malformed"`  // 输出无法解析的流数据
+	FailAfter int    `json:"fail_after"` // 流式输出指定块数后中断连接，0表示不中断
+	LatencyMS int    `json:"latency_ms"` // 覆盖全局的每块延迟
+	Repeat    bool   `json:"repeat"`     // 使用后保留，可重复匹配
+}
+
+// MockConfig 模拟提供商配置（即fixture文件内容）
+type MockConfig struct {
+	Format    string         `json:"format"`     // 流格式：openai / anthropic / google，留空按API类型或模型名推断
+	LatencyMS int            `json:"latency_ms"` // 每块延迟
+	ChunkSize int            `json:"chunk_size"` // 每块字符数
+	Default   string         `json:"default"`    // 没有匹配的脚本响应时使用
+	Responses []MockResponse `json:"responses"`
+}
+
+// MockClient 按脚本返回响应的AI客户端，实现 LLMClient
+type MockClient struct {
+	config MockConfig
+	used   map[int]bool
+	calls  []ChatRequest
+	mu     sync.Mutex
+}
+
+// NewMockClient 创建模拟客户端
+func NewMockClient(config MockConfig) *MockClient {
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = defaultMockChunkSize
+	}
+	return &MockClient{
+		config: config,
+		used:   make(map[int]bool),
+	}
+}
+
+// LoadMockClient 从fixture文件创建模拟客户端
+func LoadMockClient(path string) (*MockClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mock fixture: %w", err)
+	}
+	var config MockConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse mock fixture: %w", err)
+	}
+	return NewMockClient(config), nil
+}
+
+// Calls 返回收到的全部请求（按调用顺序）
+func (m *MockClient) Calls() []ChatRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ChatRequest(nil), m.calls...)
+}
+
+// Chat 返回下一条匹配的脚本响应
+func (m *MockClient) Chat(req ChatRequest) (*ChatResponse, error) {
+	response := m.next(req)
+	if response.Error != "" {
+		return nil, fmt.Errorf("Mock API error: %s", response.Error)
+	}
+	if response.Malformed {
+		return nil, fmt.Errorf("invalid response format")
+	}
+	return &ChatResponse{Content: response.Content, FinishReason: "stop"}, nil
+}
+
+// ChatStream 按提供商的SSE格式分块输出下一条匹配的脚本响应
+func (m *MockClient) ChatStream(req ChatRequest) (ChatStream, error) {
+	response := m.next(req)
+	if response.Error != "" {
+		return nil, fmt.Errorf("Mock API error: %s", response.Error)
+	}
+
+	format := m.format(req.APIType, req.Model)
+	latency := m.config.LatencyMS
+	if response.LatencyMS > 0 {
+		latency = response.LatencyMS
+	}
+
+	reader, writer := io.Pipe()
+	go m.stream(writer, format, response, time.Duration(latency)*time.Millisecond)
+	return newSSEStream(reader, mockChunkParser(format)), nil
+}
+
+func (m *MockClient) next(req ChatRequest) MockResponse {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls = append(m.calls, req)
+	messages := req.Messages
+
+	lastUser := ""
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			lastUser = messages[i].Content
+			break
+		}
+	}
+
+	for i, response := range m.config.Responses {
+		if m.used[i] {
+			continue
+		}
+		if response.Match != "" && !strings.Contains(lastUser, response.Match) {
+			continue
+		}
+		if !response.Repeat {
+			m.used[i] = true
+		}
+		return response
+	}
+
+	if m.config.Default != "" {
+		return MockResponse{Content: m.config.Default}
+	}
+	return MockResponse{Content: mockEcho(lastUser)}
+}
+
+// format 确定流格式：fixture指定 > 调用的API类型 > 模型名
+func (m *MockClient) format(apiType, modelID string) string {
+	if m.config.Format != "" {
+		return m.config.Format
+	}
+	if apiType != MockAPIType && IsSupportedAPIType(apiType) {
+		return apiType
+	}
+	model := strings.ToLower(modelID)
+	switch {
+	case strings.Contains(model, "anthropic") || strings.Contains(model, "claude"):
+		return "anthropic"
+	case strings.Contains(model, "google") || strings.Contains(model, "gemini"):
+		return "google"
+	default:
+		return "openai"
+	}
+}
+
+func (m *MockClient) stream(writer *io.PipeWriter, format string, response MockResponse, latency time.Duration) {
+	chunks := splitChunks(response.Content, m.config.ChunkSize)
+
+	write := func(lines ...string) error {
+		for _, line := range lines {
+			if _, err := io.WriteString(writer, line+"\n\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if format == "anthropic" {
+		if err := write("event: message_start", `data: {"type":"message_start"}`); err != nil {
+			return
+		}
+	}
+
+	for i, chunk := range chunks {
+		if response.FailAfter > 0 && i >= response.FailAfter {
+			writer.CloseWithError(fmt.Errorf("mock stream interrupted after %d chunks", i))
+			return
+		}
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+
+		var line string
+		if response.Malformed {
+			line = malformedChunk(format, chunk)
+		} else {
+			line = sseChunk(format, chunk, format == "google" && i == len(chunks)-1)
+		}
+		if err := write(line); err != nil {
+			return
+		}
+	}
+
+	switch format {
+	case "anthropic":
+		write("event: message_stop", `data: {"type":"message_stop"}`)
+	case "google":
+		if len(chunks) == 0 {
+			write(sseChunk(format, "", true))
+		}
+	default:
+		write(sseChunk(format, "", true), "data: [DONE]")
+	}
+	writer.Close()
+}
+
+// sseChunk 按提供商格式构造一行SSE数据
+func sseChunk(format, content string, final bool) string {
+	var payload map[string]interface{}
+	switch format {
+	case "anthropic":
+		payload = map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": map[string]interface{}{"type": "text_delta", "text": content},
+		}
+	case "google":
+		candidate := map[string]interface{}{
+			"content": map[string]interface{}{
+				"role":  "model",
+				"parts": []map[string]interface{}{{"text": content}},
+			},
+		}
+		if final {
+			candidate["finishReason"] = "STOP"
+		}
+		payload = map[string]interface{}{"candidates": []interface{}{candidate}}
+	default:
+		choice := map[string]interface{}{
+			"index":         0,
+			"delta":         map[string]interface{}{},
+			"finish_reason": nil,
+		}
+		if final {
+			choice["finish_reason"] = "stop"
+		} else {
+			choice["delta"] = map[string]interface{}{"content": content}
+		}
+		payload = map[string]interface{}{"choices": []interface{}{choice}}
+	}
+	data, _ := json.Marshal(payload)
+	return "data: " + string(data)
+}
+
+// malformedChunk 构造被截断的SSE数据
+func malformedChunk(format, content string) string {
+	line := sseChunk(format, content, false)
+	return line[:len(line)/2]
+}
+
+func splitChunks(content string, size int) []string {
+	runes := []rune(content)
+	var chunks []string
+	for start := 0; start < len(runes); start += size {
+		end := start + size
+		if end > len(runes) {
+			end = len(runes)
+		}
+		chunks = append(chunks, string(runes[start:end]))
+	}
+	return chunks
+}
+
+// mockEcho 内置的默认响应：回显玩家动作，不修改状态
+func mockEcho(action string) string {
+	narrative := fmt.Sprintf("（模拟响应）你的行动：%s", action)
+	data, _ := json.Marshal(map[string]interface{}{
+		"narrative":    narrative,
+		"state_update": map[string]interface{}{},
+	})
+	return fmt.Sprintf("$%s$\n@%s@", narrative, string(data))
+}
+
+// mockChunkParser 返回对应格式的流解析函数
+func mockChunkParser(format string) func(data string) *StreamEvent {
+	switch format {
+	case "anthropic":
+		return parseAnthropicChunk
+	case "google":
+		return parseGoogleChunk
+	default:
+		return parseOpenAIChunk
+	}
+}
+
+func init() {
+	RegisterProvider(MockAPIType, &mockAdapter{clients: make(map[string]*MockClient)})
+}
+
+// mockAdapter 作为Provider使用的模拟提供商，按fixture路径缓存客户端，使脚本化响应在多次调用间按顺序推进
+type mockAdapter struct {
+	clients map[string]*MockClient
+	mu      sync.Mutex
+}
+
+func (a *mockAdapter) DefaultBaseURL() string {
+	return ""
+}
+
+func (a *mockAdapter) RequiresAPIKey() bool {
+	return false
+}
+
+func (a *mockAdapter) Chat(client *http.Client, req ChatRequest) (*ChatResponse, error) {
+	mock, err := a.client(req.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return mock.Chat(req)
+}
+
+func (a *mockAdapter) ChatStream(client *http.Client, req ChatRequest) (ChatStream, error) {
+	mock, err := a.client(req.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return mock.ChatStream(req)
+}
+
+func (a *mockAdapter) client(fixturePath string) (*MockClient, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if mock, exists := a.clients[fixturePath]; exists {
+		return mock, nil
+	}
+
+	mock := NewMockClient(MockConfig{})
+	if fixturePath != "" {
+		loaded, err := LoadMockClient(fixturePath)
+		if err != nil {
+			return nil, err
+		}
+		mock = loaded
+	}
+	a.clients[fixturePath] = mock
+	return mock, nil
+}
@@ -26,15 +26,35 @@ func (mf *ModelFetcher) GetModels(apiType, baseURL, apiKey string) ([]string, er
 	case "openai":
 		return mf.getOpenAIModels(baseURL, apiKey)
 	case "anthropic":
-		return mf.getAnthropicModels(), nil
+		return mf.getAnthropicModels(baseURL, apiKey)
 	case "google":
 		return mf.getGoogleModels(baseURL, apiKey)
+	case OllamaAPIType:
+		return mf.getOllamaModels(baseURL, apiKey)
+	case MockAPIType:
+		return []string{"mock", "mock-anthropic", "mock-google"}, nil
 	default:
 		return mf.getOpenAIModels(baseURL, apiKey)
 	}
 }
 
 func (mf *ModelFetcher) getOpenAIModels(baseURL, apiKey string) ([]string, error) {
+	entries, err := mf.getOpenAIModelEntries(baseURL, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0)
+	for _, model := range entries {
+		if id, ok := model["id"].(string); ok && id != "" {
+			models = append(models, id)
+		}
+	}
+	return models, nil
+}
+
+// getOpenAIModelEntries 获取 /models 接口返回的原始模型信息（兼容服务会附带上下文长度等字段）
+func (mf *ModelFetcher) getOpenAIModelEntries(baseURL, apiKey string) ([]map[string]interface{}, error) {
 	if baseURL == "" {
 		return nil, fmt.Errorf("API URL未配置")
 	}
@@ -46,7 +66,9 @@ func (mf *ModelFetcher) getOpenAIModels(baseURL, apiKey string) ([]string, error
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := mf.client.Do(req)
@@ -71,15 +93,13 @@ func (mf *ModelFetcher) getOpenAIModels(baseURL, apiKey string) ([]string, error
 	}
 
 	if data, ok := result["data"].([]interface{}); ok {
-		models := make([]string, 0)
+		entries := make([]map[string]interface{}, 0)
 		for _, item := range data {
 			if model, ok := item.(map[string]interface{}); ok {
-				if id, ok := model["id"].(string); ok && id != "" {
-					models = append(models, id)
-				}
+				entries = append(entries, model)
 			}
 		}
-		return models, nil
+		return entries, nil
 	}
 
 	return nil, fmt.Errorf("OpenAI API返回的模型列表格式不正确")
@@ -139,16 +159,49 @@ func (mf *ModelFetcher) getGoogleModels(baseURL, apiKey string) ([]string, error
 	return nil, fmt.Errorf("Gemini API返回的模型列表格式不正确")
 }
 
-func (mf *ModelFetcher) getAnthropicModels() []string {
-	return []string{
-		"claude-opus-4-1-20250805",
-		"claude-3-7-sonnet-20250219",
-		"claude-3-5-sonnet-20241022",
-		"claude-3-5-haiku-20241022",
-		"claude-3-opus-20240229",
-		"claude-3-sonnet-20240229",
-		"claude-3-haiku-20240307",
+func (mf *ModelFetcher) getAnthropicModels(baseURL, apiKey string) ([]string, error) {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL("anthropic")
+	}
+	modelsURL := strings.TrimSuffix(buildAnthropicURL(baseURL), "/messages") + "/models?limit=1000"
+
+	var result struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := mf.getJSON(modelsURL, anthropicHeaders(apiKey), &result); err != nil {
+		return nil, fmt.Errorf("Anthropic Models %w", err)
+	}
+
+	models := make([]string, 0, len(result.Data))
+	for _, model := range result.Data {
+		if model.ID != "" {
+			models = append(models, model.ID)
+		}
+	}
+	return models, nil
+}
+
+func (mf *ModelFetcher) getOllamaModels(baseURL, apiKey string) ([]string, error) {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL(OllamaAPIType)
+	}
+
+	var result struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := mf.getJSON(buildOllamaURL(baseURL, "/api/tags"), ollamaHeaders(apiKey), &result); err != nil {
+		return nil, fmt.Errorf("Ollama %w", err)
+	}
+
+	models := make([]string, 0, len(result.Models))
+	for _, model := range result.Models {
+		models = append(models, model.Name)
 	}
+	return models, nil
 }
 
 func (mf *ModelFetcher) buildOpenAIModelsURL(baseURL string) string {
@@ -0,0 +1,376 @@
+package services
+
+import (
+	"AIGE/config"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExternalIdentity 从提供商用户信息中解析出的身份
+type ExternalIdentity struct {
+	Subject       string `json:"subject"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"` // 仅已验证的邮箱可用于关联已有账号
+	Avatar        string `json:"avatar"`
+}
+
+// OAuthProvider OAuth2/OIDC提供商。端点优先使用管理员配置，其次是OIDC发现文档，最后是默认值
+type OAuthProvider struct {
+	ID          string
+	Name        string
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	IssuerURL   string // 非空时通过 /.well-known/openid-configuration 获取端点
+	Scopes      string
+	ParseUser   func(data map[string]interface{}) *ExternalIdentity
+	// FetchEmail 用户信息中没有邮箱时补充获取，返回邮箱及是否已验证
+	FetchEmail func(client *http.Client, accessToken string) (string, bool)
+}
+
+// OAuthEndpoints 解析后的端点
+type OAuthEndpoints struct {
+	AuthURL     string `json:"auth_url"`
+	TokenURL    string `json:"token_url"`
+	UserInfoURL string `json:"user_info_url"`
+}
+
+var (
+	oauthProviders   = map[string]*OAuthProvider{}
+	oauthProvidersMu sync.RWMutex
+
+	discoveryCache   = map[string]*oidcDiscovery{}
+	discoveryCacheMu sync.Mutex
+
+	oauthHTTPClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// RegisterOAuthProvider 注册OAuth提供商，同ID会覆盖
+func RegisterOAuthProvider(p *OAuthProvider) {
+	oauthProvidersMu.Lock()
+	defer oauthProvidersMu.Unlock()
+	oauthProviders[p.ID] = p
+}
+
+// GetOAuthProvider 按ID查找已注册的提供商
+func GetOAuthProvider(id string) (*OAuthProvider, bool) {
+	oauthProvidersMu.RLock()
+	defer oauthProvidersMu.RUnlock()
+	p, ok := oauthProviders[id]
+	return p, ok
+}
+
+// OAuthProviders 返回所有已注册的提供商，按ID排序
+func OAuthProviders() []*OAuthProvider {
+	oauthProvidersMu.RLock()
+	defer oauthProvidersMu.RUnlock()
+	providers := make([]*OAuthProvider, 0, len(oauthProviders))
+	for _, p := range oauthProviders {
+		providers = append(providers, p)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].ID < providers[j].ID })
+	return providers
+}
+
+type oidcDiscovery struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// discover 获取并缓存OIDC发现文档
+func discover(issuer string) (*oidcDiscovery, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	discoveryCacheMu.Lock()
+	defer discoveryCacheMu.Unlock()
+	if doc, ok := discoveryCache[issuer]; ok {
+		return doc, nil
+	}
+
+	resp, err := oauthHTTPClient.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("获取OIDC发现文档失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取OIDC发现文档失败: %s", resp.Status)
+	}
+
+	var doc oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("解析OIDC发现文档失败: %w", err)
+	}
+	discoveryCache[issuer] = &doc
+	return &doc, nil
+}
+
+// Endpoints 合并配置、发现文档和默认值得到实际使用的端点
+func (p *OAuthProvider) Endpoints(cfg *config.OAuthConfig) (*OAuthEndpoints, error) {
+	endpoints := &OAuthEndpoints{AuthURL: p.AuthURL, TokenURL: p.TokenURL, UserInfoURL: p.UserInfoURL}
+
+	issuer := p.IssuerURL
+	if cfg.IssuerURL != "" {
+		issuer = cfg.IssuerURL
+	}
+	if issuer != "" && (cfg.AuthURL == "" || cfg.TokenURL == "" || cfg.UserInfoURL == "") {
+		doc, err := discover(issuer)
+		if err != nil {
+			return nil, err
+		}
+		endpoints.AuthURL = doc.AuthorizationEndpoint
+		endpoints.TokenURL = doc.TokenEndpoint
+		endpoints.UserInfoURL = doc.UserInfoEndpoint
+	}
+
+	if cfg.AuthURL != "" {
+		endpoints.AuthURL = cfg.AuthURL
+	}
+	if cfg.TokenURL != "" {
+		endpoints.TokenURL = cfg.TokenURL
+	}
+	if cfg.UserInfoURL != "" {
+		endpoints.UserInfoURL = cfg.UserInfoURL
+	}
+
+	if endpoints.AuthURL == "" || endpoints.TokenURL == "" || endpoints.UserInfoURL == "" {
+		return nil, fmt.Errorf("%s 的OAuth端点未配置", p.Name)
+	}
+	return endpoints, nil
+}
+
+// NewPKCEVerifier 生成PKCE校验码
+func NewPKCEVerifier() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// PKCEChallenge 按S256方法计算校验码对应的challenge
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL 构造授权地址
+func (p *OAuthProvider) AuthCodeURL(cfg *config.OAuthConfig, state, verifier string) (string, error) {
+	endpoints, err := p.Endpoints(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := p.Scopes
+	if cfg.Scopes != "" {
+		scopes = cfg.Scopes
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", cfg.ClientID)
+	params.Set("redirect_uri", cfg.RedirectURL)
+	params.Set("state", state)
+	params.Set("code_challenge", PKCEChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+	if scopes != "" {
+		params.Set("scope", scopes)
+	}
+
+	separator := "?"
+	if strings.Contains(endpoints.AuthURL, "?") {
+		separator = "&"
+	}
+	return endpoints.AuthURL + separator + params.Encode(), nil
+}
+
+// Exchange 用授权码换取访问令牌并获取用户身份
+func (p *OAuthProvider) Exchange(cfg *config.OAuthConfig, code, verifier string) (*ExternalIdentity, error) {
+	endpoints, err := p.Endpoints(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", cfg.RedirectURL)
+	form.Set("client_id", cfg.ClientID)
+	form.Set("client_secret", cfg.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest("POST", endpoints.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := doOAuthJSON(req, &token); err != nil {
+		return nil, fmt.Errorf("获取访问令牌失败: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("获取访问令牌失败: %s", token.Error)
+	}
+
+	req, err = http.NewRequest("GET", endpoints.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+
+	var data map[string]interface{}
+	if err := doOAuthJSON(req, &data); err != nil {
+		return nil, fmt.Errorf("获取用户信息失败: %w", err)
+	}
+
+	identity := p.ParseUser(data)
+	if identity == nil || identity.Subject == "" {
+		return nil, fmt.Errorf("用户信息中缺少用户ID")
+	}
+	if identity.Email == "" && p.FetchEmail != nil {
+		identity.Email, identity.EmailVerified = p.FetchEmail(oauthHTTPClient, token.AccessToken)
+	}
+	return identity, nil
+}
+
+func doOAuthJSON(req *http.Request, v interface{}) error {
+	resp, err := oauthHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s, %s", resp.Status, string(body))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// claimString 读取字符串或数字类型的字段
+func claimString(data map[string]interface{}, key string) string {
+	switch value := data[key].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return ""
+}
+
+// parseOIDCUser 标准OIDC userinfo字段
+func parseOIDCUser(data map[string]interface{}) *ExternalIdentity {
+	identity := &ExternalIdentity{
+		Subject:  claimString(data, "sub"),
+		Username: claimString(data, "preferred_username"),
+		Email:    claimString(data, "email"),
+		Avatar:   claimString(data, "picture"),
+	}
+	identity.EmailVerified, _ = data["email_verified"].(bool)
+	if identity.Username == "" {
+		identity.Username = claimString(data, "name")
+	}
+	if identity.Username == "" && identity.Email != "" {
+		identity.Username = strings.Split(identity.Email, "@")[0]
+	}
+	return identity
+}
+
+func parseLinuxDoUser(data map[string]interface{}) *ExternalIdentity {
+	return &ExternalIdentity{
+		Subject:  claimString(data, "id"),
+		Username: claimString(data, "username"),
+		Email:    claimString(data, "email"),
+		// linux.do 返回的邮箱已经过验证，沿用之前按邮箱关联账号的行为
+		EmailVerified: claimString(data, "email") != "",
+		Avatar:        claimString(data, "avatar_url"),
+	}
+}
+
+func parseGitHubUser(data map[string]interface{}) *ExternalIdentity {
+	email := claimString(data, "email")
+	return &ExternalIdentity{
+		Subject:  claimString(data, "id"),
+		Username: claimString(data, "login"),
+		Email:    email,
+		// GitHub 只允许将已验证的邮箱设为公开邮箱
+		EmailVerified: email != "",
+		Avatar:        claimString(data, "avatar_url"),
+	}
+}
+
+// fetchGitHubEmail 公开邮箱为空时读取主邮箱，需要 user:email 权限
+func fetchGitHubEmail(client *http.Client, accessToken string) (string, bool) {
+	req, err := http.NewRequest("GET", "https://api.github.com/user/emails", nil)
+	if err != nil {
+		return "", false
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := doOAuthJSON(req, &emails); err != nil {
+		return "", false
+	}
+	for _, e := range emails {
+		if e.Primary {
+			return e.Email, e.Verified
+		}
+	}
+	return "", false
+}
+
+func init() {
+	RegisterOAuthProvider(&OAuthProvider{
+		ID:          "linux-do",
+		Name:        "Linux.Do",
+		AuthURL:     "https://connect.linux.do/oauth2/authorize",
+		TokenURL:    "https://connect.linux.do/oauth2/token",
+		UserInfoURL: "https://connect.linux.do/api/user",
+		Scopes:      "read",
+		ParseUser:   parseLinuxDoUser,
+	})
+	RegisterOAuthProvider(&OAuthProvider{
+		ID:          "github",
+		Name:        "GitHub",
+		AuthURL:     "https://github.com/login/oauth/authorize",
+		TokenURL:    "https://github.com/login/oauth/access_token",
+		UserInfoURL: "https://api.github.com/user",
+		Scopes:      "read:user user:email",
+		ParseUser:   parseGitHubUser,
+		FetchEmail:  fetchGitHubEmail,
+	})
+	RegisterOAuthProvider(&OAuthProvider{
+		ID:        "google",
+		Name:      "Google",
+		IssuerURL: "https://accounts.google.com",
+		Scopes:    "openid email profile",
+		ParseUser: parseOIDCUser,
+	})
+	// 通用OIDC，需要配置 issuer_url 或手动填写端点
+	RegisterOAuthProvider(&OAuthProvider{
+		ID:        "oidc",
+		Name:      "OIDC",
+		Scopes:    "openid email profile",
+		ParseUser: parseOIDCUser,
+	})
+}
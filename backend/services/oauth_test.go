@@ -0,0 +1,86 @@
+package services
+
+import (
+	"AIGE/config"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestOIDCDiscoveryAndPKCEExchange(t *testing.T) {
+	var server *httptest.Server
+	var challenge string
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"authorization_endpoint": server.URL + "/authorize",
+				"token_endpoint":         server.URL + "/token",
+				"userinfo_endpoint":      server.URL + "/userinfo",
+			})
+		case "/token":
+			r.ParseForm()
+			if r.Form.Get("code") != "good-code" || PKCEChallenge(r.Form.Get("code_verifier")) != challenge {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"invalid_grant"}`)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"at","token_type":"Bearer"}`)
+		case "/userinfo":
+			if r.Header.Get("Authorization") != "Bearer at" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"sub":"u-42","email":"a@example.com","email_verified":true,"name":"阿青"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	provider, ok := GetOAuthProvider("oidc")
+	if !ok {
+		t.Fatalf("Expected built-in oidc provider")
+	}
+	cfg := &config.OAuthConfig{ClientID: "client", ClientSecret: "secret", RedirectURL: "http://app/cb", IssuerURL: server.URL}
+
+	verifier := NewPKCEVerifier()
+	authURL, err := provider.AuthCodeURL(cfg, "state-1", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	parsed, _ := url.Parse(authURL)
+	query := parsed.Query()
+	challenge = query.Get("code_challenge")
+	if parsed.Path != "/authorize" || query.Get("state") != "state-1" || query.Get("code_challenge_method") != "S256" ||
+		challenge != PKCEChallenge(verifier) || query.Get("scope") != "openid email profile" {
+		t.Errorf("Unexpected auth URL: %s", authURL)
+	}
+
+	if _, err := provider.Exchange(cfg, "good-code", NewPKCEVerifier()); err == nil {
+		t.Errorf("Expected exchange with wrong verifier to fail")
+	}
+
+	identity, err := provider.Exchange(cfg, "good-code", verifier)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if identity.Subject != "u-42" || identity.Username != "阿青" || !identity.EmailVerified {
+		t.Errorf("Unexpected identity: %+v", identity)
+	}
+}
+
+func TestParseProviderUsers(t *testing.T) {
+	github := parseGitHubUser(map[string]interface{}{"id": 1234567.0, "login": "octo", "email": nil})
+	if github.Subject != "1234567" || github.Username != "octo" || github.EmailVerified {
+		t.Errorf("Unexpected GitHub identity: %+v", github)
+	}
+
+	oidc := parseOIDCUser(map[string]interface{}{"sub": "x", "email": "someone@example.com"})
+	if oidc.Username != "someone" || oidc.EmailVerified {
+		t.Errorf("Unverified OIDC email should not be trusted: %+v", oidc)
+	}
+}
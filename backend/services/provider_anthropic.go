@@ -0,0 +1,146 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	RegisterProvider("anthropic", anthropicAdapter{})
+}
+
+type anthropicAdapter struct{}
+
+// anthropicDefaultMaxTokens Messages API要求必须传max_tokens，未配置时使用各模型都支持的值
+const anthropicDefaultMaxTokens = 8192
+
+func (anthropicAdapter) DefaultBaseURL() string {
+	return "https://api.anthropic.com/v1"
+}
+
+func (a anthropicAdapter) Chat(client *http.Client, req ChatRequest) (*ChatResponse, error) {
+	body, err := postJSON(client, buildAnthropicURL(req.BaseURL), anthropicRequestBody(req, false), anthropicHeaders(req.APIKey), "Anthropic")
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := decodeJSON(body, &result); err != nil {
+		return nil, err
+	}
+
+	content, ok := result["content"].([]interface{})
+	if !ok || len(content) == 0 {
+		return nil, fmt.Errorf("invalid response format")
+	}
+
+	firstContent, _ := content[0].(map[string]interface{})
+	text, ok := firstContent["text"].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid response format")
+	}
+	stopReason, _ := result["stop_reason"].(string)
+
+	return &ChatResponse{Content: text, FinishReason: stopReason}, nil
+}
+
+func (a anthropicAdapter) ChatStream(client *http.Client, req ChatRequest) (ChatStream, error) {
+	body, err := postJSON(client, buildAnthropicURL(req.BaseURL), anthropicRequestBody(req, true), anthropicHeaders(req.APIKey), "Anthropic")
+	if err != nil {
+		return nil, err
+	}
+	return newSSEStream(body, parseAnthropicChunk), nil
+}
+
+func anthropicHeaders(apiKey string) map[string]string {
+	return map[string]string{
+		"x-api-key":         apiKey,
+		"anthropic-version": "2023-06-01",
+	}
+}
+
+func anthropicRequestBody(req ChatRequest, stream bool) map[string]interface{} {
+	// system消息单独传递，其余消息只区分user和assistant
+	var systemMessage string
+	anthropicMessages := make([]map[string]interface{}, 0)
+	for _, msg := range req.Messages {
+		if msg.Role == "system" {
+			systemMessage = msg.Content
+			continue
+		}
+		role := "user"
+		if msg.Role == "assistant" {
+			role = "assistant"
+		}
+		anthropicMessages = append(anthropicMessages, map[string]interface{}{
+			"role":    role,
+			"content": msg.Content,
+		})
+	}
+
+	requestBody := map[string]interface{}{
+		"model":      req.Model,
+		"max_tokens": req.Options.maxTokens(anthropicDefaultMaxTokens),
+		"messages":   anthropicMessages,
+	}
+
+	if systemMessage != "" {
+		requestBody["system"] = systemMessage
+	}
+	if budget := req.Options.ThinkingBudget; budget > 0 {
+		// 开启扩展思考时max_tokens必须大于思考预算，且不支持自定义temperature
+		requestBody["thinking"] = map[string]interface{}{"type": "enabled", "budget_tokens": budget}
+		if maxTokens := req.Options.maxTokens(anthropicDefaultMaxTokens); maxTokens <= budget {
+			requestBody["max_tokens"] = budget + anthropicDefaultMaxTokens
+		}
+	} else if req.Options.Temperature != nil {
+		requestBody["temperature"] = *req.Options.Temperature
+	}
+	if req.Options.TopP != nil {
+		requestBody["top_p"] = *req.Options.TopP
+	}
+	if len(req.Options.Stop) > 0 {
+		requestBody["stop_sequences"] = req.Options.Stop
+	}
+
+	if stream {
+		requestBody["stream"] = true
+	}
+	return requestBody
+}
+
+func parseAnthropicChunk(data string) *StreamEvent {
+	data = sseData(data)
+	if data == "" {
+		return nil
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &parsed); err != nil {
+		return nil
+	}
+
+	switch parsed["type"] {
+	case "content_block_delta":
+		delta, ok := parsed["delta"].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		content, _ := delta["text"].(string)
+		return &StreamEvent{Content: content}
+	case "message_stop":
+		return &StreamEvent{Done: true}
+	}
+
+	return nil
+}
+
+func buildAnthropicURL(baseURL string) string {
+	baseURL = strings.TrimSpace(baseURL)
+	if strings.Contains(baseURL, "/v1/messages") {
+		return baseURL
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/v1") + "/v1/messages"
+}
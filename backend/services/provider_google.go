@@ -0,0 +1,175 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	RegisterProvider("google", googleAdapter{})
+}
+
+type googleAdapter struct{}
+
+func (googleAdapter) DefaultBaseURL() string {
+	return "https://generativelanguage.googleapis.com/v1beta"
+}
+
+func (a googleAdapter) Chat(client *http.Client, req ChatRequest) (*ChatResponse, error) {
+	body, err := postJSON(client, buildGoogleURL(req.BaseURL, req.Model, false), googleRequestBody(req), googleHeaders(req.APIKey), "Google")
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := decodeJSON(body, &result); err != nil {
+		return nil, err
+	}
+
+	candidates, ok := result["candidates"].([]interface{})
+	if !ok || len(candidates) == 0 {
+		return nil, fmt.Errorf("invalid response format")
+	}
+
+	candidate, _ := candidates[0].(map[string]interface{})
+	text, ok := googleCandidateText(candidate)
+	if !ok {
+		return nil, fmt.Errorf("invalid response format")
+	}
+	finishReason, _ := candidate["finishReason"].(string)
+
+	return &ChatResponse{Content: text, FinishReason: finishReason}, nil
+}
+
+func (a googleAdapter) ChatStream(client *http.Client, req ChatRequest) (ChatStream, error) {
+	body, err := postJSON(client, buildGoogleURL(req.BaseURL, req.Model, true), googleRequestBody(req), googleHeaders(req.APIKey), "Google")
+	if err != nil {
+		return nil, err
+	}
+	return newSSEStream(body, parseGoogleChunk), nil
+}
+
+func googleHeaders(apiKey string) map[string]string {
+	return map[string]string{"x-goog-api-key": apiKey}
+}
+
+func googleRequestBody(req ChatRequest) map[string]interface{} {
+	// system消息作为system_instruction传递，assistant角色对应model
+	var systemMessage string
+	contents := make([]map[string]interface{}, 0)
+	for _, msg := range req.Messages {
+		if msg.Role == "system" {
+			systemMessage = msg.Content
+			continue
+		}
+		role := "user"
+		if msg.Role == "assistant" {
+			role = "model"
+		}
+		contents = append(contents, map[string]interface{}{
+			"role": role,
+			"parts": []map[string]interface{}{
+				{"text": msg.Content},
+			},
+		})
+	}
+
+	generationConfig := map[string]interface{}{
+		"temperature": req.Options.temperature(0.7),
+	}
+	if req.Options.MaxTokens > 0 {
+		generationConfig["maxOutputTokens"] = req.Options.MaxTokens
+	}
+	if req.Options.TopP != nil {
+		generationConfig["topP"] = *req.Options.TopP
+	}
+	if len(req.Options.Stop) > 0 {
+		generationConfig["stopSequences"] = req.Options.Stop
+	}
+	if req.Options.Seed != nil {
+		generationConfig["seed"] = *req.Options.Seed
+	}
+	if req.Options.ThinkingBudget > 0 {
+		generationConfig["thinkingConfig"] = map[string]interface{}{"thinkingBudget": req.Options.ThinkingBudget}
+	}
+
+	requestBody := map[string]interface{}{
+		"contents":         contents,
+		"generationConfig": generationConfig,
+	}
+
+	if systemMessage != "" {
+		requestBody["system_instruction"] = map[string]interface{}{
+			"parts": []map[string]interface{}{
+				{"text": systemMessage},
+			},
+		}
+	}
+	return requestBody
+}
+
+// googleCandidateText 取候选结果中第一段文本
+func googleCandidateText(candidate map[string]interface{}) (string, bool) {
+	content, ok := candidate["content"].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	parts, ok := content["parts"].([]interface{})
+	if !ok || len(parts) == 0 {
+		return "", false
+	}
+	for _, part := range parts {
+		partMap, _ := part.(map[string]interface{})
+		if text, ok := partMap["text"].(string); ok {
+			return text, true
+		}
+	}
+	return "", true
+}
+
+func parseGoogleChunk(data string) *StreamEvent {
+	data = sseData(data)
+	if data == "" {
+		return nil
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &parsed); err != nil {
+		return nil
+	}
+
+	candidates, ok := parsed["candidates"].([]interface{})
+	if !ok || len(candidates) == 0 {
+		return nil
+	}
+
+	candidate, _ := candidates[0].(map[string]interface{})
+	text, ok := googleCandidateText(candidate)
+	if !ok {
+		return nil
+	}
+	finishReason, _ := candidate["finishReason"].(string)
+
+	return &StreamEvent{Content: text, Done: finishReason != ""}
+}
+
+func buildGoogleURL(baseURL, modelID string, stream bool) string {
+	baseURL = strings.TrimSpace(baseURL)
+
+	if strings.Contains(baseURL, "/models/") {
+		baseURL = strings.Split(baseURL, "/models/")[0]
+	}
+
+	if !strings.HasSuffix(baseURL, "/v1beta") {
+		baseURL = strings.TrimSuffix(baseURL, "/") + "/v1beta"
+	}
+
+	if stream {
+		// 关键：添加alt=sse参数以获取SSE格式流
+		return fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", baseURL, modelID)
+	}
+
+	return fmt.Sprintf("%s/models/%s:generateContent", baseURL, modelID)
+}
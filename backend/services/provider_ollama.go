@@ -0,0 +1,108 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// OllamaAPIType Ollama原生接口（/api/chat），本地部署无需API密钥
+const OllamaAPIType = "ollama"
+
+func init() {
+	RegisterProvider(OllamaAPIType, ollamaAdapter{})
+}
+
+type ollamaAdapter struct{}
+
+func (ollamaAdapter) DefaultBaseURL() string {
+	return "http://localhost:11434"
+}
+
+func (ollamaAdapter) RequiresAPIKey() bool {
+	return false
+}
+
+func (a ollamaAdapter) Chat(client *http.Client, req ChatRequest) (*ChatResponse, error) {
+	body, err := postJSON(client, buildOllamaURL(req.BaseURL, "/api/chat"), ollamaRequestBody(req, false), ollamaHeaders(req.APIKey), "Ollama")
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Message    Message `json:"message"`
+		DoneReason string  `json:"done_reason"`
+	}
+	if err := decodeJSON(body, &result); err != nil {
+		return nil, err
+	}
+
+	return &ChatResponse{Content: result.Message.Content, FinishReason: result.DoneReason}, nil
+}
+
+func (a ollamaAdapter) ChatStream(client *http.Client, req ChatRequest) (ChatStream, error) {
+	body, err := postJSON(client, buildOllamaURL(req.BaseURL, "/api/chat"), ollamaRequestBody(req, true), ollamaHeaders(req.APIKey), "Ollama")
+	if err != nil {
+		return nil, err
+	}
+	// Ollama的流是逐行JSON而非SSE，按行解析的方式相同
+	return newSSEStream(body, parseOllamaChunk), nil
+}
+
+func ollamaHeaders(apiKey string) map[string]string {
+	// 通过反向代理暴露的Ollama可能需要密钥
+	if apiKey == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + apiKey}
+}
+
+func ollamaRequestBody(req ChatRequest, stream bool) map[string]interface{} {
+	options := map[string]interface{}{
+		"temperature": req.Options.temperature(0.7),
+	}
+	if req.Options.MaxTokens > 0 {
+		options["num_predict"] = req.Options.MaxTokens
+	}
+	if req.Options.TopP != nil {
+		options["top_p"] = *req.Options.TopP
+	}
+	if len(req.Options.Stop) > 0 {
+		options["stop"] = req.Options.Stop
+	}
+	if req.Options.Seed != nil {
+		options["seed"] = *req.Options.Seed
+	}
+
+	requestBody := map[string]interface{}{
+		"model":    req.Model,
+		"messages": req.Messages,
+		"stream":   stream,
+		"options":  options,
+	}
+	// 思考内容在message.thinking中单独返回，不会混入正文
+	if req.Options.ReasoningEffort != "" || req.Options.ThinkingBudget > 0 {
+		requestBody["think"] = true
+	}
+	return requestBody
+}
+
+func parseOllamaChunk(data string) *StreamEvent {
+	var parsed struct {
+		Message Message `json:"message"`
+		Done    bool    `json:"done"`
+	}
+	if err := json.Unmarshal([]byte(sseData(data)), &parsed); err != nil {
+		return nil
+	}
+	return &StreamEvent{Content: parsed.Message.Content, Done: parsed.Done}
+}
+
+// buildOllamaURL 基础地址可能带有 /api 或 /v1 后缀，统一去掉后拼接接口路径
+func buildOllamaURL(baseURL, path string) string {
+	baseURL = strings.TrimSuffix(strings.TrimSpace(baseURL), "/")
+	for _, suffix := range []string{"/api/chat", "/api", "/v1"} {
+		baseURL = strings.TrimSuffix(baseURL, suffix)
+	}
+	return baseURL + path
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	RegisterProvider("openai", openAIAdapter{})
+}
+
+type openAIAdapter struct{}
+
+func (openAIAdapter) DefaultBaseURL() string {
+	return "https://api.openai.com/v1"
+}
+
+func (a openAIAdapter) Chat(client *http.Client, req ChatRequest) (*ChatResponse, error) {
+	body, err := postJSON(client, buildOpenAIURL(req.BaseURL), openAIRequestBody(req, false), openAIHeaders(req.APIKey), "OpenAI")
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := decodeJSON(body, &result); err != nil {
+		return nil, err
+	}
+
+	choices, ok := result["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		return nil, fmt.Errorf("invalid response format")
+	}
+
+	choice, _ := choices[0].(map[string]interface{})
+	message, _ := choice["message"].(map[string]interface{})
+	content, ok := message["content"].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid response format")
+	}
+	finishReason, _ := choice["finish_reason"].(string)
+
+	return &ChatResponse{Content: content, FinishReason: finishReason}, nil
+}
+
+func (a openAIAdapter) ChatStream(client *http.Client, req ChatRequest) (ChatStream, error) {
+	body, err := postJSON(client, buildOpenAIURL(req.BaseURL), openAIRequestBody(req, true), openAIHeaders(req.APIKey), "OpenAI")
+	if err != nil {
+		return nil, err
+	}
+	return newSSEStream(body, parseOpenAIChunk), nil
+}
+
+func openAIHeaders(apiKey string) map[string]string {
+	if apiKey == "" {
+		// 本地兼容服务通常不校验密钥
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + apiKey}
+}
+
+func openAIRequestBody(req ChatRequest, stream bool) map[string]interface{} {
+	requestBody := map[string]interface{}{
+		"model":       req.Model,
+		"messages":    req.Messages,
+		"temperature": req.Options.temperature(0.7),
+	}
+	// 未配置时不传max_tokens，由服务端按模型上限决定，避免超出限制返回400
+	if req.Options.MaxTokens > 0 {
+		requestBody["max_tokens"] = req.Options.MaxTokens
+	}
+	if len(req.Options.Stop) > 0 {
+		requestBody["stop"] = req.Options.Stop
+	}
+	if req.Options.TopP != nil {
+		requestBody["top_p"] = *req.Options.TopP
+	}
+	if req.Options.Seed != nil {
+		requestBody["seed"] = *req.Options.Seed
+	}
+	if req.Options.ReasoningEffort != "" {
+		requestBody["reasoning_effort"] = req.Options.ReasoningEffort
+	}
+
+	if stream {
+		requestBody["stream"] = true
+	}
+	return requestBody
+}
+
+func parseOpenAIChunk(data string) *StreamEvent {
+	data = sseData(data)
+	if data == "" || data == "[DONE]" {
+		return &StreamEvent{Done: true}
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &parsed); err != nil {
+		return nil
+	}
+
+	choices, ok := parsed["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		return nil
+	}
+
+	choice, _ := choices[0].(map[string]interface{})
+	delta, ok := choice["delta"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	content, _ := delta["content"].(string)
+	finishReason, _ := choice["finish_reason"].(string)
+
+	return &StreamEvent{Content: content, Done: finishReason != ""}
+}
+
+func buildOpenAIURL(baseURL string) string {
+	baseURL = strings.TrimSpace(baseURL)
+	if strings.Contains(baseURL, "/chat/completions") {
+		return baseURL
+	}
+	if strings.Contains(baseURL, "/v1") {
+		return strings.TrimSuffix(baseURL, "/") + "/chat/completions"
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/v1/chat/completions"
+}
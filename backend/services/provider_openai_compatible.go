@@ -0,0 +1,23 @@
+package services
+
+// OpenAICompatibleAPIType 兼容OpenAI接口的本地或第三方服务（vLLM、llama.cpp server、LM Studio等）
+const OpenAICompatibleAPIType = "openai_compatible"
+
+func init() {
+	RegisterProvider(OpenAICompatibleAPIType, openAICompatibleAdapter{})
+	// 早期的"自定义"提供商即OpenAI兼容格式
+	RegisterProvider("custom", openAICompatibleAdapter{})
+}
+
+// openAICompatibleAdapter 请求格式与OpenAI相同，但密钥可选
+type openAICompatibleAdapter struct {
+	openAIAdapter
+}
+
+func (openAICompatibleAdapter) DefaultBaseURL() string {
+	return "http://localhost:8000/v1"
+}
+
+func (openAICompatibleAdapter) RequiresAPIKey() bool {
+	return false
+}
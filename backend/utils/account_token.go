@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 邮件链接中令牌的用途
+const (
+	PurposeResetPassword = "reset_password"
+	PurposeVerifyEmail   = "verify_email"
+
+	ResetPasswordTTL = time.Hour
+	VerifyEmailTTL   = 24 * time.Hour
+)
+
+var ErrInvalidAccountToken = errors.New("链接无效或已过期")
+
+// IssueAccountToken 签发邮件链接令牌。令牌使用JWT密钥的派生密钥签名，不能当作访问令牌使用，jti记录在数据库中保证只能使用一次，
+// 同一用户同一用途未使用的旧令牌随即作废
+func IssueAccountToken(userID uint, purpose, email string, ttl time.Duration) (string, error) {
+	config.DB.Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).Delete(&models.AccountToken{})
+
+	record := models.AccountToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenID:   randomToken(16),
+		Email:     email,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := config.DB.Create(&record).Error; err != nil {
+		return "", err
+	}
+
+	return signAccountJWT(jwt.MapClaims{
+		"user_id": userID,
+		"purpose": purpose,
+		"jti":     record.TokenID,
+		"exp":     record.ExpiresAt.Unix(),
+		"iat":     time.Now().Unix(),
+	})
+}
+
+// ConsumeAccountToken 校验签名和用途，并将令牌标记为已使用
+func ConsumeAccountToken(raw, purpose string) (*models.AccountToken, error) {
+	token, err := jwt.Parse(raw, accountKeyFunc, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidAccountToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != purpose {
+		return nil, ErrInvalidAccountToken
+	}
+	tokenID, _ := claims["jti"].(string)
+
+	var record models.AccountToken
+	if err := config.DB.Where("token_id = ? AND purpose = ?", tokenID, purpose).First(&record).Error; err != nil {
+		return nil, ErrInvalidAccountToken
+	}
+	if record.UsedAt != nil || time.Now().After(record.ExpiresAt) {
+		return nil, ErrInvalidAccountToken
+	}
+
+	// 条件更新防止并发请求重复使用
+	now := time.Now()
+	result := config.DB.Model(&models.AccountToken{}).
+		Where("id = ? AND used_at IS NULL", record.ID).
+		Update("used_at", &now)
+	if result.Error != nil || result.RowsAffected != 1 {
+		return nil, ErrInvalidAccountToken
+	}
+	record.UsedAt = &now
+	return &record, nil
+}
@@ -11,8 +11,6 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-var JWTSecret = []byte("your-secret-key-change-this-in-production")
-
 func HashPassword(password string) (string, error) {
 	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	return string(hashedBytes), err
@@ -23,16 +21,20 @@ func CheckPassword(password, hashedPassword string) bool {
 	return err == nil
 }
 
-func GenerateJWT(userID uint, username string, isAdmin bool) (string, error) {
+// GenerateJWT 签发短期访问令牌，ver 对应用户当前的 TokenVersion
+func GenerateJWT(user *models.User) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
-		"user_id":  userID,
-		"username": username,
-		"is_admin": isAdmin,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(),
+		"typ":      accessTokenType,
+		"user_id":  user.ID,
+		"username": user.Username,
+		"is_admin": user.IsAdmin,
+		"ver":      user.TokenVersion,
+		"iat":      now.Unix(),
+		"exp":      now.Add(AccessTokenTTL).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(JWTSecret)
+	return signJWT(claims)
 }
 
 func GenerateRandomPassword(length int) string {
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWT签名密钥配置：
+//
+//	JWT_SECRETS 多个密钥，格式为 kid:secret，用逗号分隔。第一个用于签发，其余仅用于验证
+//	JWT_SECRET  单个密钥，kid 由密钥哈希生成
+//
+// 轮换时把新密钥放在 JWT_SECRETS 最前面，旧密钥保留到其签发的令牌全部过期后再移除。
+// 生产模式（GIN_MODE=release）下未配置密钥或使用默认/过短的密钥时拒绝启动。
+const (
+	jwtSecretsEnv     = "JWT_SECRETS"
+	jwtSecretEnv      = "JWT_SECRET"
+	defaultJWTSecret  = "your-secret-key-change-this-in-production"
+	minJWTSecretBytes = 32
+)
+
+type signingKey struct {
+	id     string
+	secret []byte
+}
+
+var (
+	jwtKeys     []signingKey // 第一个为当前签发密钥
+	jwtKeysErr  error
+	jwtKeysOnce sync.Once
+)
+
+// InitJWTKeys 加载JWT签名密钥，服务启动时调用，返回错误时应终止启动
+func InitJWTKeys() error {
+	_, err := loadedJWTKeys()
+	return err
+}
+
+func loadedJWTKeys() ([]signingKey, error) {
+	jwtKeysOnce.Do(func() {
+		jwtKeys, jwtKeysErr = parseJWTKeys(os.Getenv(jwtSecretsEnv), os.Getenv(jwtSecretEnv), gin.Mode() == gin.ReleaseMode)
+		if jwtKeysErr == nil {
+			log.Printf("已加载 %d 个JWT签名密钥，当前kid: %s\n", len(jwtKeys), jwtKeys[0].id)
+		}
+	})
+	return jwtKeys, jwtKeysErr
+}
+
+func parseJWTKeys(secrets, secret string, production bool) ([]signingKey, error) {
+	var keys []signingKey
+	seen := make(map[string]bool)
+
+	for _, entry := range strings.Split(secrets, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, value, ok := strings.Cut(entry, ":")
+		if !ok || kid == "" || value == "" {
+			return nil, fmt.Errorf("%s 格式错误，应为 kid:secret", jwtSecretsEnv)
+		}
+		if seen[kid] {
+			return nil, fmt.Errorf("%s 中存在重复的kid: %s", jwtSecretsEnv, kid)
+		}
+		seen[kid] = true
+		keys = append(keys, signingKey{id: kid, secret: []byte(value)})
+	}
+
+	if len(keys) == 0 && secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		keys = append(keys, signingKey{id: hex.EncodeToString(sum[:4]), secret: []byte(secret)})
+	}
+
+	if len(keys) == 0 {
+		if production {
+			return nil, fmt.Errorf("生产模式必须配置 %s 或 %s", jwtSecretsEnv, jwtSecretEnv)
+		}
+		log.Printf("⚠️ 未配置JWT密钥，使用开发环境默认密钥，请勿在生产环境使用\n")
+		keys = append(keys, signingKey{id: "dev", secret: []byte(defaultJWTSecret)})
+	}
+
+	if production {
+		for _, key := range keys {
+			if string(key.secret) == defaultJWTSecret || len(key.secret) < minJWTSecretBytes {
+				return nil, fmt.Errorf("JWT密钥 %s 不安全：不能使用默认值且长度至少 %d 字节", key.id, minJWTSecretBytes)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// accountTokenContext 用于从JWT密钥派生邮件链接令牌的签名密钥
+const accountTokenContext = "AIGE account token"
+
+// accessSecret 访问令牌直接使用配置的密钥
+func accessSecret(key signingKey) []byte {
+	return key.secret
+}
+
+// accountSecret 邮件链接令牌使用派生密钥，与访问令牌互不通用
+func accountSecret(key signingKey) []byte {
+	mac := hmac.New(sha256.New, key.secret)
+	mac.Write([]byte(accountTokenContext))
+	return mac.Sum(nil)
+}
+
+// signJWT 使用当前密钥签名访问令牌，并在header中写入kid
+func signJWT(claims jwt.Claims) (string, error) {
+	return signWith(claims, accessSecret)
+}
+
+// signAccountJWT 使用当前密钥的派生密钥签名邮件链接令牌
+func signAccountJWT(claims jwt.Claims) (string, error) {
+	return signWith(claims, accountSecret)
+}
+
+func signWith(claims jwt.Claims, secret func(signingKey) []byte) (string, error) {
+	keys, err := loadedJWTKeys()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = keys[0].id
+	return token.SignedString(secret(keys[0]))
+}
+
+var (
+	jwtKeyFunc     = keyFunc(accessSecret)
+	accountKeyFunc = keyFunc(accountSecret)
+)
+
+// keyFunc 按kid选择验证密钥，没有kid的旧令牌依次尝试所有密钥
+func keyFunc(secret func(signingKey) []byte) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		keys, err := loadedJWTKeys()
+		if err != nil {
+			return nil, err
+		}
+
+		if kid, ok := token.Header["kid"].(string); ok {
+			for _, key := range keys {
+				if key.id == kid {
+					return secret(key), nil
+				}
+			}
+			return nil, fmt.Errorf("unknown kid: %s", kid)
+		}
+
+		set := jwt.VerificationKeySet{}
+		for _, key := range keys {
+			set.Keys = append(set.Keys, secret(key))
+		}
+		return set, nil
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// useJWTKeys 替换已加载的签名密钥
+func useJWTKeys(t *testing.T, secrets string) {
+	keys, err := parseJWTKeys(secrets, "", false)
+	if err != nil {
+		t.Fatalf("parseJWTKeys failed: %v", err)
+	}
+	jwtKeysOnce.Do(func() {})
+	jwtKeys, jwtKeysErr = keys, nil
+}
+
+func TestParseJWTKeys(t *testing.T) {
+	strong := strings.Repeat("s", minJWTSecretBytes)
+
+	if _, err := parseJWTKeys("", "", true); err == nil {
+		t.Errorf("Expected missing secret to be rejected in production")
+	}
+	if _, err := parseJWTKeys("", defaultJWTSecret, true); err == nil {
+		t.Errorf("Expected default secret to be rejected in production")
+	}
+	if _, err := parseJWTKeys("new:short", "", true); err == nil {
+		t.Errorf("Expected short secret to be rejected in production")
+	}
+	if _, err := parseJWTKeys("a:"+strong+",a:"+strong, "", false); err == nil {
+		t.Errorf("Expected duplicate kid to be rejected")
+	}
+	if keys, err := parseJWTKeys("", "", false); err != nil || keys[0].id != "dev" {
+		t.Errorf("Expected development fallback key, got %v, %v", keys, err)
+	}
+
+	keys, err := parseJWTKeys("new:"+strong+", old:"+strong+"x", "ignored", true)
+	if err != nil || len(keys) != 2 || keys[0].id != "new" {
+		t.Errorf("Unexpected keys: %v, %v", keys, err)
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	user := newTestUser(t)
+
+	useJWTKeys(t, "old:old-secret")
+	pair, err := IssueTokens(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("IssueTokens failed: %v", err)
+	}
+
+	// 新密钥在前、旧密钥保留：旧令牌仍然有效
+	useJWTKeys(t, "new:new-secret,old:old-secret")
+	if _, err := ValidateAccessToken(pair.AccessToken); err != nil {
+		t.Errorf("Expected token signed by previous key to stay valid: %v", err)
+	}
+
+	// 旧密钥移除后旧令牌失效
+	useJWTKeys(t, "new:new-secret")
+	if _, err := ValidateAccessToken(pair.AccessToken); err == nil {
+		t.Errorf("Expected token signed by removed key to be rejected")
+	}
+}
+
+func TestWSTicketSingleUse(t *testing.T) {
+	ticket := IssueWSTicket(42)
+	if id, ok := ConsumeWSTicket(ticket); !ok || id != 42 {
+		t.Errorf("Expected ticket to be accepted once")
+	}
+	if _, ok := ConsumeWSTicket(ticket); ok {
+		t.Errorf("Expected ticket to be single use")
+	}
+	if _, ok := ConsumeWSTicket(""); ok {
+		t.Errorf("Expected empty ticket to be rejected")
+	}
+}
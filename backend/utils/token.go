@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	accessTokenType = "access" // 访问令牌的 typ 声明
+)
+
+var (
+	ErrInvalidToken        = errors.New("无效的token")
+	ErrInvalidRefreshToken = errors.New("无效的刷新令牌")
+)
+
+// TokenPair 登录或刷新后返回给客户端的令牌
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // 访问令牌有效期（秒）
+}
+
+// IssueTokens 为用户签发访问令牌和新的刷新令牌（开启新的令牌Family）
+func IssueTokens(user *models.User, userAgent, ip string) (*TokenPair, error) {
+	pair, _, err := issueTokens(config.DB, user, randomToken(16), userAgent, ip)
+	return pair, err
+}
+
+func issueTokens(db *gorm.DB, user *models.User, familyID, userAgent, ip string) (*TokenPair, *models.RefreshToken, error) {
+	accessToken, err := GenerateJWT(user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw := randomToken(32)
+	refresh := models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(raw),
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+		UserAgent: userAgent,
+		IP:        ip,
+	}
+	if err := db.Create(&refresh).Error; err != nil {
+		return nil, nil, err
+	}
+
+	// 顺带清理该用户已过期的令牌
+	db.Where("user_id = ? AND expires_at < ?", user.ID, time.Now()).Delete(&models.RefreshToken{})
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: raw,
+		ExpiresIn:    int64(AccessTokenTTL.Seconds()),
+	}, &refresh, nil
+}
+
+// RotateRefreshToken 使用刷新令牌换取新的令牌对，旧令牌立即失效。
+// 已失效的令牌被再次使用说明可能已泄露，同一Family的全部令牌都会被吊销。
+func RotateRefreshToken(raw, userAgent, ip string) (*models.User, *TokenPair, error) {
+	var user models.User
+	var pair *TokenPair
+	reusedFamily := ""
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var token models.RefreshToken
+		if err := tx.Where("token_hash = ?", hashToken(raw)).First(&token).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if token.RevokedAt != nil {
+			reusedFamily = token.FamilyID
+			return ErrInvalidRefreshToken
+		}
+		if time.Now().After(token.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+		if err := tx.First(&user, token.UserID).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		// 条件更新抢占旧令牌：并发使用同一令牌刷新时只有一个请求成功，其余按重复使用处理
+		now := time.Now()
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", token.ID).
+			Update("revoked_at", &now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reusedFamily = token.FamilyID
+			return ErrInvalidRefreshToken
+		}
+
+		var next *models.RefreshToken
+		var err error
+		pair, next, err = issueTokens(tx, &user, token.FamilyID, userAgent, ip)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.RefreshToken{}).Where("id = ?", token.ID).Update("replaced_by", next.ID).Error
+	})
+	if reusedFamily != "" {
+		// 在事务外吊销，避免随错误一起回滚
+		revokeFamily(config.DB, reusedFamily)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &user, pair, nil
+}
+
+// RevokeRefreshToken 退出登录：吊销该刷新令牌所在的整个Family，all 为 true 时吊销该用户的全部令牌
+func RevokeRefreshToken(raw string, all bool) error {
+	var token models.RefreshToken
+	if err := config.DB.Where("token_hash = ?", hashToken(raw)).First(&token).Error; err != nil {
+		return ErrInvalidRefreshToken
+	}
+	if all {
+		return RevokeUserTokens(token.UserID)
+	}
+	return revokeFamily(config.DB, token.FamilyID)
+}
+
+// RevokeUserTokens 吊销用户的全部令牌，用于修改密码、删除账号或变更角色。
+// 刷新令牌全部失效，TokenVersion 递增使已签发的访问令牌立即失效。
+func RevokeUserTokens(userID uint) error {
+	now := time.Now()
+	if err := config.DB.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", &now).Error; err != nil {
+		return err
+	}
+	return config.DB.Model(&models.User{}).Where("id = ?", userID).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error
+}
+
+// ValidateAccessToken 校验访问令牌的签名、类型、过期时间和版本，并返回数据库中的当前用户
+func ValidateAccessToken(tokenString string) (*models.User, error) {
+	token, err := jwt.Parse(tokenString, jwtKeyFunc, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	// 只接受访问令牌，邮件链接等其他用途的令牌一律拒绝
+	if _, hasPurpose := claims["purpose"]; hasPurpose || claims["typ"] != accessTokenType {
+		return nil, ErrInvalidToken
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	version, _ := claims["ver"].(float64)
+
+	// 每次请求都读取用户当前状态，已删除或已吊销的用户立即失去访问权限
+	var user models.User
+	if err := config.DB.First(&user, uint(userID)).Error; err != nil {
+		return nil, fmt.Errorf("用户不存在: %w", ErrInvalidToken)
+	}
+	if int(version) != user.TokenVersion {
+		return nil, fmt.Errorf("令牌已被吊销: %w", ErrInvalidToken)
+	}
+	return &user, nil
+}
+
+func revokeFamily(db *gorm.DB, familyID string) error {
+	now := time.Now()
+	return db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", &now).Error
+}
+
+func randomToken(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// hashToken 刷新令牌只保存SHA-256哈希，数据库泄露时无法直接使用
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"AIGE/config"
+	"AIGE/models"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestUser(t *testing.T) *models.User {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	config.DB = db
+	models.AutoMigrate()
+
+	user := &models.User{Username: "player", Email: "player@example.com"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	return user
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	user := newTestUser(t)
+
+	pair, err := IssueTokens(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("IssueTokens failed: %v", err)
+	}
+	if _, err := ValidateAccessToken(pair.AccessToken); err != nil {
+		t.Fatalf("Expected access token to be valid: %v", err)
+	}
+
+	_, rotated, err := RotateRefreshToken(pair.RefreshToken, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("RotateRefreshToken failed: %v", err)
+	}
+
+	// 旧令牌被再次使用：拒绝并吊销整个Family，包括刚轮换出的新令牌
+	if _, _, err := RotateRefreshToken(pair.RefreshToken, "test", "127.0.0.1"); err == nil {
+		t.Errorf("Expected reused refresh token to be rejected")
+	}
+	if _, _, err := RotateRefreshToken(rotated.RefreshToken, "test", "127.0.0.1"); err == nil {
+		t.Errorf("Expected token family to be revoked after reuse")
+	}
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	user := newTestUser(t)
+
+	pair, err := IssueTokens(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("IssueTokens failed: %v", err)
+	}
+	if err := RevokeUserTokens(user.ID); err != nil {
+		t.Fatalf("RevokeUserTokens failed: %v", err)
+	}
+
+	if _, err := ValidateAccessToken(pair.AccessToken); err == nil {
+		t.Errorf("Expected access token to be revoked")
+	}
+	if _, _, err := RotateRefreshToken(pair.RefreshToken, "test", "127.0.0.1"); err == nil {
+		t.Errorf("Expected refresh token to be revoked")
+	}
+
+	// 重新登录后签发的令牌有效，用户删除后失效
+	config.DB.First(user, user.ID)
+	pair, _ = IssueTokens(user, "test", "127.0.0.1")
+	if _, err := ValidateAccessToken(pair.AccessToken); err != nil {
+		t.Errorf("Expected new access token to be valid: %v", err)
+	}
+	config.DB.Unscoped().Delete(user)
+	if _, err := ValidateAccessToken(pair.AccessToken); err == nil {
+		t.Errorf("Expected access token of deleted user to be rejected")
+	}
+}
+
+func TestAccountTokenSingleUse(t *testing.T) {
+	user := newTestUser(t)
+
+	first, err := IssueAccountToken(user.ID, PurposeResetPassword, user.Email, ResetPasswordTTL)
+	if err != nil {
+		t.Fatalf("IssueAccountToken failed: %v", err)
+	}
+	// 重新申请后旧链接作废
+	second, _ := IssueAccountToken(user.ID, PurposeResetPassword, user.Email, ResetPasswordTTL)
+	if _, err := ConsumeAccountToken(first, PurposeResetPassword); err == nil {
+		t.Errorf("Expected superseded token to be rejected")
+	}
+
+	if _, err := ConsumeAccountToken(second, PurposeVerifyEmail); err == nil {
+		t.Errorf("Expected token to be rejected for a different purpose")
+	}
+	record, err := ConsumeAccountToken(second, PurposeResetPassword)
+	if err != nil || record.UserID != user.ID {
+		t.Fatalf("Expected token to be accepted once, got %v, %v", record, err)
+	}
+	if _, err := ConsumeAccountToken(second, PurposeResetPassword); err == nil {
+		t.Errorf("Expected used token to be rejected")
+	}
+
+	expired, _ := IssueAccountToken(user.ID, PurposeVerifyEmail, "new@example.com", -time.Minute)
+	if _, err := ConsumeAccountToken(expired, PurposeVerifyEmail); err == nil {
+		t.Errorf("Expected expired token to be rejected")
+	}
+}
+
+func TestAccountTokenIsNotAccessToken(t *testing.T) {
+	user := newTestUser(t)
+
+	for _, purpose := range []string{PurposeResetPassword, PurposeVerifyEmail} {
+		raw, err := IssueAccountToken(user.ID, purpose, user.Email, time.Hour)
+		if err != nil {
+			t.Fatalf("IssueAccountToken failed: %v", err)
+		}
+		if _, err := ValidateAccessToken(raw); err == nil {
+			t.Errorf("Expected %s token to be rejected as access token", purpose)
+		}
+	}
+
+	// 即使使用访问令牌密钥签名，缺少 typ 或带有 purpose 的令牌也会被拒绝
+	for _, claims := range []jwt.MapClaims{
+		{"user_id": user.ID, "exp": time.Now().Add(time.Hour).Unix()},
+		{"typ": accessTokenType, "purpose": PurposeResetPassword, "user_id": user.ID, "exp": time.Now().Add(time.Hour).Unix()},
+	} {
+		raw, _ := signJWT(claims)
+		if _, err := ValidateAccessToken(raw); err == nil {
+			t.Errorf("Expected %v to be rejected as access token", claims)
+		}
+	}
+}
+
+func TestConcurrentRefreshIssuesOnePair(t *testing.T) {
+	user := newTestUser(t)
+	pair, err := IssueTokens(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("IssueTokens failed: %v", err)
+	}
+
+	const attempts = 5
+	results := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		go func() {
+			_, _, err := RotateRefreshToken(pair.RefreshToken, "test", "127.0.0.1")
+			results <- err
+		}()
+	}
+	succeeded := 0
+	for i := 0; i < attempts; i++ {
+		if err := <-results; err == nil {
+			succeeded++
+		}
+	}
+	if succeeded > 1 {
+		t.Errorf("Expected at most one refresh to succeed, got %d", succeeded)
+	}
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// WSTicketTTL WebSocket票据有效期，票据只能使用一次
+const WSTicketTTL = 30 * time.Second
+
+type wsTicket struct {
+	userID    uint
+	expiresAt time.Time
+}
+
+var (
+	wsTickets     = make(map[string]wsTicket)
+	wsTicketMutex sync.Mutex
+)
+
+// IssueWSTicket 为已认证用户签发WebSocket连接票据，替代在URL中传递访问令牌
+func IssueWSTicket(userID uint) string {
+	wsTicketMutex.Lock()
+	defer wsTicketMutex.Unlock()
+
+	// 清理过期票据
+	now := time.Now()
+	for ticket, t := range wsTickets {
+		if now.After(t.expiresAt) {
+			delete(wsTickets, ticket)
+		}
+	}
+
+	ticket := randomToken(24)
+	wsTickets[ticket] = wsTicket{userID: userID, expiresAt: now.Add(WSTicketTTL)}
+	return ticket
+}
+
+// ConsumeWSTicket 校验并作废票据，返回票据所属的用户ID
+func ConsumeWSTicket(ticket string) (uint, bool) {
+	wsTicketMutex.Lock()
+	defer wsTicketMutex.Unlock()
+
+	t, exists := wsTickets[ticket]
+	if !exists {
+		return 0, false
+	}
+	delete(wsTickets, ticket)
+	if time.Now().After(t.expiresAt) {
+		return 0, false
+	}
+	return t.userID, true
+}
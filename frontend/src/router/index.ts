@@ -15,11 +15,35 @@ const router = createRouter({
       meta: { requiresAuth: false }
     },
     {
-      path: '/auth/callback/linux-do',
+      path: '/auth/callback/:provider',
       name: 'oauth-callback',
       component: () => import('@/views/OAuthCallbackView.vue'),
       meta: { requiresAuth: false }
     },
+    {
+      path: '/forgot-password',
+      name: 'forgot-password',
+      component: () => import('@/views/ForgotPasswordView.vue'),
+      meta: { requiresAuth: false }
+    },
+    {
+      path: '/reset-password',
+      name: 'reset-password',
+      component: () => import('@/views/ResetPasswordView.vue'),
+      meta: { requiresAuth: false }
+    },
+    {
+      path: '/verify-email',
+      name: 'verify-email',
+      component: () => import('@/views/VerifyEmailView.vue'),
+      meta: { requiresAuth: false }
+    },
+    {
+      path: '/account',
+      name: 'account',
+      component: () => import('@/views/AccountView.vue'),
+      meta: { requiresAuth: true }
+    },
     {
       path: '/game',
       name: 'game',
@@ -63,14 +87,14 @@ router.beforeEach(async (to, from, next) => {
     }
     
     // 如果路由需要管理员权限
-    if (to.meta.requiresAdmin && !authStore.isAdmin()) {
+    if (to.meta.requiresAdmin && !authStore.canAccessAdmin()) {
       next('/game')
       return
     }
   } else {
     // 如果已登录用户访问登录页，重定向到相应页面
     if (to.path === '/login' && authStore.isAuthenticated()) {
-      if (authStore.isAdmin()) {
+      if (authStore.canAccessAdmin()) {
         next('/admin')
       } else {
         next('/game')
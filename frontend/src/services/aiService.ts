@@ -1,4 +1,4 @@
-import api from '@/utils/api'
+import api, { authFetch } from '@/utils/api'
 
 export interface Message {
   role: 'user' | 'assistant' | 'system'
@@ -47,7 +47,7 @@ export class AIService {
       
       //console.log('[AIService] 准备发送请求到: /api/admin/ai/chat')
       
-      const response = await fetch('/api/admin/ai/chat', {
+      const response = await authFetch('/api/admin/ai/chat', {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
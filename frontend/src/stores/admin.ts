@@ -1,5 +1,5 @@
 import { defineStore } from 'pinia'
-import type { User, Provider, Model } from '@/types'
+import type { User, Provider, Model, OAuthProviderConfig, Role, PermissionInfo, AuditEvent, AuditEventQuery } from '@/types'
 import api from '@/utils/api'
 
 export const useAdminStore = defineStore('admin', () => {
@@ -21,8 +21,33 @@ export const useAdminStore = defineStore('admin', () => {
     await api.delete(`/admin/users/${userId}`)
   }
 
-  const toggleUserAdmin = async (userId: number): Promise<void> => {
-    await api.put(`/admin/users/${userId}/toggle-admin`)
+  const setUserRoles = async (userId: number, roles: string[]): Promise<User> => {
+    return await api.put<User>(`/admin/users/${userId}/roles`, { roles })
+  }
+
+  const getRoles = async (): Promise<Role[]> => {
+    const response = await api.get<{ roles: Role[] }>('/admin/roles')
+    return response.roles
+  }
+
+  const getPermissions = async (): Promise<PermissionInfo[]> => {
+    return await api.get<PermissionInfo[]>('/admin/permissions')
+  }
+
+  const createRole = async (role: Partial<Role>): Promise<Role> => {
+    return await api.post<Role>('/admin/roles', role)
+  }
+
+  const updateRole = async (roleId: number, role: Partial<Role>): Promise<Role> => {
+    return await api.put<Role>(`/admin/roles/${roleId}`, role)
+  }
+
+  const deleteRole = async (roleId: number): Promise<void> => {
+    await api.delete(`/admin/roles/${roleId}`)
+  }
+
+  const getAuditEvents = async (query: AuditEventQuery): Promise<{ events: AuditEvent[]; total: number }> => {
+    return await api.get<{ events: AuditEvent[]; total: number }>('/admin/audit', { params: query })
   }
 
   const getProviders = async (): Promise<Provider[]> => {
@@ -45,6 +70,12 @@ export const useAdminStore = defineStore('admin', () => {
     return response
   }
 
+  // API密钥只写，响应只包含掩码
+  const updateProviderAPIKey = async (providerId: number, apiKey: string): Promise<{ api_key_masked: string }> => {
+    const response = await api.put<{ api_key_masked: string }>(`/admin/providers/${providerId}/api-key`, { api_key: apiKey })
+    return response
+  }
+
   const deleteProvider = async (providerId: number): Promise<void> => {
     await api.delete(`/admin/providers/${providerId}`)
   }
@@ -107,18 +138,18 @@ export const useAdminStore = defineStore('admin', () => {
     return response
   }
 
-  const updateModelCapabilities = async (modelId: number, capabilities: string): Promise<Model> => {
-    const response = await api.put<Model>(`/admin/models/${modelId}/capabilities`, { capabilities })
+  const detectModelCapabilities = async (modelId: number): Promise<Model> => {
+    const response = await api.post<Model>(`/admin/models/${modelId}/capabilities/detect`)
     return response
   }
 
-  const getOAuthConfig = async (): Promise<any> => {
-    const response = await api.get<any>('/admin/oauth/config')
+  const getOAuthConfigs = async (): Promise<OAuthProviderConfig[]> => {
+    const response = await api.get<OAuthProviderConfig[]>('/admin/oauth/providers')
     return response
   }
 
-  const saveOAuthConfig = async (config: any): Promise<void> => {
-    await api.post('/admin/oauth/config', config)
+  const saveOAuthConfig = async (provider: string, config: Partial<OAuthProviderConfig>): Promise<void> => {
+    await api.put(`/admin/oauth/providers/${provider}`, config)
   }
 
   return {
@@ -126,11 +157,18 @@ export const useAdminStore = defineStore('admin', () => {
     getUser,
     updateUserPassword,
     deleteUser,
-    toggleUserAdmin,
+    setUserRoles,
+    getRoles,
+    getPermissions,
+    createRole,
+    updateRole,
+    deleteRole,
+    getAuditEvents,
     getProviders,
     getProvider,
     createProvider,
     updateProvider,
+    updateProviderAPIKey,
     deleteProvider,
     toggleProvider,
     getAvailableModels,
@@ -142,8 +180,8 @@ export const useAdminStore = defineStore('admin', () => {
     deleteModel,
     toggleModel,
     testModel,
-    updateModelCapabilities,
-    getOAuthConfig,
+    detectModelCapabilities,
+    getOAuthConfigs,
     saveOAuthConfig,
   }
 })
\ No newline at end of file
@@ -1,36 +1,73 @@
 import { defineStore } from 'pinia'
 import { ref } from 'vue'
-import type { User, LoginRequest, RegisterRequest, AuthResponse } from '@/types'
+import type { User, LoginRequest, RegisterRequest, AuthResponse, OAuthProviderInfo, UserIdentity } from '@/types'
 import api from '@/utils/api'
+import axios from 'axios'
 
 export const useAuthStore = defineStore('auth', () => {
   const user = ref<User | null>(null)
   const token = ref<string | null>(localStorage.getItem('token'))
+  const refreshToken = ref<string | null>(localStorage.getItem('refresh_token'))
+  let refreshing: Promise<boolean> | null = null
 
-  const login = async (loginData: LoginRequest): Promise<AuthResponse> => {
-    const data = await api.post<AuthResponse>('/auth/login', loginData)
-    
+  const setSession = (data: AuthResponse) => {
     user.value = data.user
     token.value = data.token
+    refreshToken.value = data.refresh_token
     localStorage.setItem('token', data.token)
+    localStorage.setItem('refresh_token', data.refresh_token)
+  }
+
+  const clearSession = () => {
+    user.value = null
+    token.value = null
+    refreshToken.value = null
+    localStorage.removeItem('token')
+    localStorage.removeItem('refresh_token')
+  }
+
+  // 使用刷新令牌换取新的访问令牌，并发调用共享同一个请求（刷新令牌只能使用一次）
+  const refreshAccessToken = (): Promise<boolean> => {
+    if (!refreshToken.value) {
+      return Promise.resolve(false)
+    }
+    if (!refreshing) {
+      refreshing = axios.post<AuthResponse>('/api/auth/refresh', { refresh_token: refreshToken.value })
+        .then((response) => {
+          setSession(response.data)
+          return true
+        })
+        .catch(() => {
+          clearSession()
+          return false
+        })
+        .finally(() => {
+          refreshing = null
+        })
+    }
+    return refreshing
+  }
+
+  const login = async (loginData: LoginRequest): Promise<AuthResponse> => {
+    const data = await api.post<AuthResponse>('/auth/login', loginData)
+    setSession(data)
     
     return data
   }
 
   const register = async (registerData: RegisterRequest): Promise<AuthResponse> => {
     const data = await api.post<AuthResponse>('/auth/register', registerData)
-    
-    user.value = data.user
-    token.value = data.token
-    localStorage.setItem('token', data.token)
+    setSession(data)
     
     return data
   }
 
   const logout = () => {
-    user.value = null
-    token.value = null
-    localStorage.removeItem('token')
+    // 通知服务端吊销刷新令牌，失败不影响本地退出
+    if (refreshToken.value) {
+      axios.post('/api/auth/logout', { refresh_token: refreshToken.value }).catch(() => {})
+    }
+    clearSession()
     // 使用动态导入避免循环依赖
     import('@/router').then(({ default: router }) => {
       router.push('/login')
@@ -55,31 +92,109 @@ export const useAuthStore = defineStore('auth', () => {
     return user.value?.is_admin || false
   }
 
-  const loginWithLinuxDo = async (): Promise<string> => {
-    const data = await api.get<{ auth_url: string }>('/auth/oauth/linux-do')
+  const hasPermission = (perm: string) => {
+    const perms = user.value?.permissions || []
+    return perms.includes('*') || perms.includes(perm)
+  }
+
+  // 拥有任意后台权限即可进入管理后台
+  const canAccessAdmin = () => {
+    return isAdmin() || (user.value?.permissions?.length || 0) > 0
+  }
+
+  const getOAuthProviders = async (): Promise<OAuthProviderInfo[]> => {
+    return await api.get<OAuthProviderInfo[]>('/auth/oauth/providers')
+  }
+
+  const loginWithOAuth = async (provider: string): Promise<string> => {
+    const data = await api.get<{ auth_url: string }>(`/auth/oauth/${provider}`)
     return data.auth_url
   }
 
-  const handleLinuxDoCallback = async (code: string, state: string): Promise<AuthResponse> => {
-    const data = await api.get<AuthResponse>(`/auth/oauth/linux-do/callback?code=${code}&state=${state}`)
-    
-    user.value = data.user
-    token.value = data.token
-    localStorage.setItem('token', data.token)
+  const handleOAuthCallback = async (provider: string, code: string, state: string): Promise<AuthResponse> => {
+    const query = new URLSearchParams({ code, state })
+    const data = await api.get<AuthResponse>(`/auth/oauth/${provider}/callback?${query}`)
+    setSession(data)
     
     return data
   }
 
+  // 为当前账号关联第三方身份，授权完成后同样回到回调页
+  const linkOAuthIdentity = async (provider: string): Promise<string> => {
+    const data = await api.post<{ auth_url: string }>(`/profile/identities/${provider}/link`)
+    return data.auth_url
+  }
+
+  const getIdentities = async (): Promise<UserIdentity[]> => {
+    return await api.get<UserIdentity[]>('/profile/identities')
+  }
+
+  const unlinkIdentity = async (id: number): Promise<void> => {
+    await api.delete(`/profile/identities/${id}`)
+  }
+
+  const forgotPassword = async (email: string): Promise<void> => {
+    await api.post('/auth/forgot-password', { email })
+  }
+
+  const resetPassword = async (resetToken: string, password: string): Promise<void> => {
+    await api.post('/auth/reset-password', { token: resetToken, password })
+  }
+
+  const verifyEmail = async (verifyToken: string): Promise<void> => {
+    await api.post('/auth/verify-email', { token: verifyToken })
+    if (token.value) {
+      await getProfile()
+    }
+  }
+
+  const resendVerification = async (): Promise<void> => {
+    await api.post('/profile/email/verification')
+  }
+
+  // 修改密码后其他设备退出登录，当前设备使用返回的新令牌
+  const changePassword = async (currentPassword: string, newPassword: string): Promise<void> => {
+    const data = await api.put<AuthResponse>('/profile/password', {
+      current_password: currentPassword,
+      new_password: newPassword
+    })
+    setSession(data)
+  }
+
+  const changeEmail = async (email: string, password: string): Promise<void> => {
+    await api.put('/profile/email', { email, password })
+  }
+
+  const deleteAccount = async (password: string): Promise<void> => {
+    await api.delete('/profile', { data: { password } })
+    clearSession()
+  }
+
   return {
     user,
     token,
+    refreshToken,
+    refreshAccessToken,
     login,
     register,
     logout,
     getProfile,
     isAuthenticated,
     isAdmin,
-    loginWithLinuxDo,
-    handleLinuxDoCallback,
+    hasPermission,
+    canAccessAdmin,
+    getOAuthProviders,
+    loginWithOAuth,
+    handleOAuthCallback,
+    linkOAuthIdentity,
+    getIdentities,
+    unlinkIdentity,
+    forgotPassword,
+    resetPassword,
+    verifyEmail,
+    resendVerification,
+    changePassword,
+    changeEmail,
+    deleteAccount,
   }
 })
\ No newline at end of file
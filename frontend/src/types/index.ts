@@ -2,7 +2,26 @@ export interface User {
   id: number
   username: string
   email: string
+  email_verified: boolean
+  has_password: boolean
   is_admin: boolean
+  roles?: string[]
+  permissions?: string[]
+}
+
+export interface Role {
+  id: number
+  name: string
+  display_name: string
+  description: string
+  permissions: string[]
+  built_in: boolean
+  user_count?: number
+}
+
+export interface PermissionInfo {
+  key: string
+  description: string
 }
 
 export interface LoginRequest {
@@ -18,9 +37,48 @@ export interface RegisterRequest {
 
 export interface AuthResponse {
   token: string
+  refresh_token: string
+  expires_in: number
   user: User
 }
 
+export interface OAuthProviderInfo {
+  id: string
+  name: string
+}
+
+export interface UserIdentity {
+  id: number
+  user_id: number
+  provider: string
+  username: string
+  email: string
+  avatar: string
+  created_at: string
+}
+
+export interface OAuthProviderConfig {
+  provider: string
+  name: string
+  client_id: string
+  client_secret: string
+  client_secret_masked: string
+  redirect_url: string
+  auth_url: string
+  token_url: string
+  user_info_url: string
+  issuer_url: string
+  scopes: string
+  enabled: boolean
+  defaults: {
+    auth_url: string
+    token_url: string
+    user_info_url: string
+    issuer_url: string
+    scopes: string
+  }
+}
+
 export interface ChatMessage {
   id: number
   message: string
@@ -36,8 +94,9 @@ export interface ApiResponse<T = any> {
 export interface Provider {
   id: number
   name: string
-  type: 'openai' | 'anthropic' | 'google' | 'custom'
-  api_key: string
+  type: 'openai' | 'anthropic' | 'google' | 'custom' | 'ollama' | 'openai_compatible' | 'mock'
+  api_key?: string // 只写，仅在创建时提交
+  api_key_masked?: string
   base_url?: string
   enabled: boolean
   allow_custom_url?: boolean
@@ -46,6 +105,16 @@ export interface Provider {
   updated_at?: string
 }
 
+export interface GenerationParams {
+  temperature?: number
+  top_p?: number
+  max_output_tokens?: number
+  context_window?: number
+  stop_sequences?: string[]
+  reasoning_effort?: 'low' | 'medium' | 'high'
+  thinking_budget?: number
+}
+
 export interface Model {
   id: number
   model_id: string
@@ -55,8 +124,32 @@ export interface Model {
   enabled: boolean
   api_type?: string
   capabilities?: string
+  generation_params?: GenerationParams
   last_tested?: string
   test_status?: 'untested' | 'testing' | 'success' | 'failed'
   created_at?: string
   updated_at?: string
-}
\ No newline at end of file
+}
+export interface AuditEvent {
+  id: number
+  actor_id: number
+  actor_name: string
+  action: string
+  target_type: string
+  target_id: string
+  diff: string
+  detail: string
+  ip: string
+  created_at: string
+}
+
+export interface AuditEventQuery {
+  page?: number
+  page_size?: number
+  actor_id?: number
+  action?: string
+  target_type?: string
+  target_id?: string
+  since?: string
+  until?: string
+}
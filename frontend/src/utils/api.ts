@@ -2,6 +2,12 @@ import axios, { AxiosInstance, AxiosRequestConfig, AxiosResponse } from 'axios'
 import { useAuthStore } from '@/stores/auth'
 import { ElMessage } from 'element-plus'
 
+declare module 'axios' {
+  interface InternalAxiosRequestConfig {
+    _retried?: boolean // 已在刷新令牌后重试过
+  }
+}
+
 // 自定义 Axios 实例类型，明确返回 data 而非 AxiosResponse
 interface CustomAxiosInstance extends Omit<AxiosInstance, 'get' | 'post' | 'put' | 'delete' | 'patch'> {
   get<T = any>(url: string, config?: AxiosRequestConfig): Promise<T>
@@ -33,9 +39,15 @@ axiosInstance.interceptors.response.use(
   (response) => {
     return response.data
   },
-  (error) => {
+  async (error) => {
     if (error.response?.status === 401) {
       const authStore = useAuthStore()
+      const request = error.config
+      // 访问令牌过期时用刷新令牌换取新令牌后重试一次，登录相关接口除外
+      if (request && !request._retried && !request.url?.startsWith('/auth/') && await authStore.refreshAccessToken()) {
+        request._retried = true
+        return axiosInstance(request)
+      }
       authStore.logout()
       ElMessage.error('登录已过期，请重新登录')
     } else if (error.response?.data?.error) {
@@ -47,6 +59,24 @@ axiosInstance.interceptors.response.use(
   }
 )
 
+// authFetch 带认证的fetch（用于流式接口等无法使用axios的场景），401时刷新令牌后重试一次
+export const authFetch = async (input: string, init: RequestInit = {}): Promise<Response> => {
+  const authStore = useAuthStore()
+  const send = () => {
+    const headers = new Headers(init.headers)
+    if (authStore.token) {
+      headers.set('Authorization', `Bearer ${authStore.token}`)
+    }
+    return fetch(input, { ...init, headers })
+  }
+
+  const response = await send()
+  if (response.status === 401 && await authStore.refreshAccessToken()) {
+    return send()
+  }
+  return response
+}
+
 // 导出为自定义类型
 const api = axiosInstance as CustomAxiosInstance
 